@@ -17,10 +17,14 @@ package main
 
 import (
 	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/apis/v1beta1"
 	"github.com/azure/gpu-provisioner/pkg/cloudprovider"
 	"github.com/azure/gpu-provisioner/pkg/controllers"
 	"github.com/azure/gpu-provisioner/pkg/operator"
+	"github.com/azure/gpu-provisioner/pkg/webhooks"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/client-go/kubernetes/scheme"
+	"knative.dev/pkg/logging"
 	"sigs.k8s.io/karpenter/pkg/cloudprovider/metrics"
 	karpentercontrollers "sigs.k8s.io/karpenter/pkg/controllers"
 	karpenteroperator "sigs.k8s.io/karpenter/pkg/operator"
@@ -29,17 +33,33 @@ import (
 func init() {
 	// karpenter use scheme.Scheme by default
 	v1alpha1.SchemeBuilder.AddToScheme(scheme.Scheme)
+	v1beta1.SchemeBuilder.AddToScheme(scheme.Scheme)
+	apiextensionsv1.AddToScheme(scheme.Scheme)
 }
 
 func main() {
 	ctx, op := operator.NewOperator(karpenteroperator.NewOperator())
+	repairPolicies, err := cloudprovider.NewRepairPoliciesFromEnv()
+	if err != nil {
+		logging.FromContext(ctx).Fatalf("loading repair policies, %s", err)
+	}
 	azureCloudProvider := cloudprovider.New(
 		op.InstanceProvider,
 		op.GetClient(),
+		op.KubernetesInterface.Discovery(),
+		repairPolicies,
 	)
 
 	cloudProvider := metrics.Decorate(azureCloudProvider)
 
+	webhookOpts, err := webhooks.NewOptionsFromEnv()
+	if err == nil {
+		err = webhooks.Setup(op.Manager, webhookOpts)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Fatalf("setting up webhooks, %s", err)
+	}
+
 	op.
 		WithControllers(ctx, karpentercontrollers.NewControllers(
 			ctx,
@@ -55,5 +75,6 @@ func main() {
 		WithControllers(ctx, controllers.NewControllers(
 			op.GetClient(),
 			cloudProvider,
+			op,
 		)...).Start(ctx)
 }
@@ -0,0 +1,45 @@
+#!/bin/bash
+set -eu -o pipefail
+
+# Renders the KaitoNodeClass CRD generated by controller-gen into the helm chart, only the labels, annotations and
+# conversion of the CRD are templated so the schema is always the generated one.
+crd=${1:-pkg/apis/crds/kaito.sh_kaitonodeclasses.yaml}
+
+echo '{{- if .Values.kaitoNodeClass.installCRD }}'
+echo "# Code generated by hack/crd-template.sh from ${crd}. DO NOT EDIT."
+awk '
+NR == 1 && $0 == "---" { next }
+{ print }
+/^    controller-gen.kubebuilder.io\/version:/ {
+  print "    # the KaitoNodeClasses are deleted with the CRD, so the CRD is kept when the chart is uninstalled."
+  print "    helm.sh/resource-policy: keep"
+  print "  {{- with .Values.additionalAnnotations }}"
+  print "    {{- toYaml . | nindent 4 }}"
+  print "  {{- end }}"
+  print "  {{- with .Values.webhook.annotations }}"
+  print "    {{- toYaml . | nindent 4 }}"
+  print "  {{- end }}"
+  print "  labels:"
+  print "    {{- include \"gpu-provisioner.labels\" . | nindent 4 }}"
+}
+/^  scope: Cluster$/ {
+  print "  {{- if .Values.webhook.enabled }}"
+  print "  conversion:"
+  print "    strategy: Webhook"
+  print "    webhook:"
+  print "      conversionReviewVersions: [\"v1\"]"
+  print "      clientConfig:"
+  print "        service:"
+  print "          name: {{ include \"gpu-provisioner.fullname\" . }}"
+  print "          namespace: {{ .Release.Namespace }}"
+  print "          path: /convert"
+  print "        {{- with .Values.webhook.caBundle }}"
+  print "        caBundle: {{ . }}"
+  print "        {{- end }}"
+  print "  {{- else }}"
+  print "  conversion:"
+  print "    strategy: None"
+  print "  {{- end }}"
+}
+' "${crd}"
+echo '{{- end }}'
@@ -0,0 +1,167 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+
+	"github.com/azure/gpu-provisioner/pkg/apis/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+// ConvertTo converts the KaitoNodeClass to the hub version v1beta1.
+func (in *KaitoNodeClass) ConvertTo(hub conversion.Hub) error {
+	dst, ok := hub.(*v1beta1.KaitoNodeClass)
+	if !ok {
+		return fmt.Errorf("unsupported hub type %T", hub)
+	}
+	dst.ObjectMeta = *in.ObjectMeta.DeepCopy()
+	in.Spec.convertTo(&dst.Spec)
+	in.Status.convertTo(&dst.Status)
+	return nil
+}
+
+// ConvertFrom converts the hub version v1beta1 to the KaitoNodeClass.
+func (in *KaitoNodeClass) ConvertFrom(hub conversion.Hub) error {
+	src, ok := hub.(*v1beta1.KaitoNodeClass)
+	if !ok {
+		return fmt.Errorf("unsupported hub type %T", hub)
+	}
+	in.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	in.Spec.convertFrom(&src.Spec)
+	in.Status.convertFrom(&src.Status)
+	return nil
+}
+
+// convertTo copies the fields one by one, the spec is deep copied first so the converted object doesn't share the
+// pointers, slices and maps with the original one.
+func (in *KaitoNodeClassSpec) convertTo(out *v1beta1.KaitoNodeClassSpec) {
+	spec := in.DeepCopy()
+	out.AgentPoolType = spec.AgentPoolType
+	out.OSSKU = spec.OSSKU
+	out.OSDiskType = spec.OSDiskType
+	out.OSDiskSizeGB = spec.OSDiskSizeGB
+	out.MaxPods = spec.MaxPods
+	out.VnetSubnetID = spec.VnetSubnetID
+	out.PodSubnetID = spec.PodSubnetID
+	out.NetworkProfile = nil
+	if spec.NetworkProfile != nil {
+		out.NetworkProfile = &v1beta1.NetworkProfile{ApplicationSecurityGroups: spec.NetworkProfile.ApplicationSecurityGroups}
+		if spec.NetworkProfile.AllowedHostPorts != nil {
+			out.NetworkProfile.AllowedHostPorts = make([]v1beta1.PortRange, len(spec.NetworkProfile.AllowedHostPorts))
+			for i, portRange := range spec.NetworkProfile.AllowedHostPorts {
+				out.NetworkProfile.AllowedHostPorts[i] = v1beta1.PortRange{
+					PortStart: portRange.PortStart,
+					PortEnd:   portRange.PortEnd,
+					Protocol:  portRange.Protocol,
+				}
+			}
+		}
+	}
+	out.EnableNodePublicIP = spec.EnableNodePublicIP
+	out.Tags = spec.Tags
+	out.GPUDriver = spec.GPUDriver
+	out.GPUInstanceProfile = spec.GPUInstanceProfile
+	out.Kubelet = nil
+	if spec.Kubelet != nil {
+		out.Kubelet = &v1beta1.KubeletConfiguration{
+			CPUManagerPolicy:            spec.Kubelet.CPUManagerPolicy,
+			CPUCFSQuota:                 spec.Kubelet.CPUCFSQuota,
+			CPUCFSQuotaPeriod:           spec.Kubelet.CPUCFSQuotaPeriod,
+			ImageGCHighThresholdPercent: spec.Kubelet.ImageGCHighThresholdPercent,
+			ImageGCLowThresholdPercent:  spec.Kubelet.ImageGCLowThresholdPercent,
+			TopologyManagerPolicy:       spec.Kubelet.TopologyManagerPolicy,
+			AllowedUnsafeSysctls:        spec.Kubelet.AllowedUnsafeSysctls,
+			ContainerLogMaxSizeMB:       spec.Kubelet.ContainerLogMaxSizeMB,
+			ContainerLogMaxFiles:        spec.Kubelet.ContainerLogMaxFiles,
+			PodPidsLimit:                spec.Kubelet.PodPidsLimit,
+		}
+	}
+	out.LinuxOSConfig = nil
+	if spec.LinuxOSConfig != nil {
+		out.LinuxOSConfig = &v1beta1.LinuxOSConfiguration{
+			TransparentHugePageEnabled: spec.LinuxOSConfig.TransparentHugePageEnabled,
+			TransparentHugePageDefrag:  spec.LinuxOSConfig.TransparentHugePageDefrag,
+			SwapFileSizeMB:             spec.LinuxOSConfig.SwapFileSizeMB,
+			Sysctls:                    spec.LinuxOSConfig.Sysctls,
+		}
+	}
+}
+
+func (in *KaitoNodeClassSpec) convertFrom(src *v1beta1.KaitoNodeClassSpec) {
+	spec := src.DeepCopy()
+	in.AgentPoolType = spec.AgentPoolType
+	in.OSSKU = spec.OSSKU
+	in.OSDiskType = spec.OSDiskType
+	in.OSDiskSizeGB = spec.OSDiskSizeGB
+	in.MaxPods = spec.MaxPods
+	in.VnetSubnetID = spec.VnetSubnetID
+	in.PodSubnetID = spec.PodSubnetID
+	in.NetworkProfile = nil
+	if spec.NetworkProfile != nil {
+		in.NetworkProfile = &NetworkProfile{ApplicationSecurityGroups: spec.NetworkProfile.ApplicationSecurityGroups}
+		if spec.NetworkProfile.AllowedHostPorts != nil {
+			in.NetworkProfile.AllowedHostPorts = make([]PortRange, len(spec.NetworkProfile.AllowedHostPorts))
+			for i, portRange := range spec.NetworkProfile.AllowedHostPorts {
+				in.NetworkProfile.AllowedHostPorts[i] = PortRange{
+					PortStart: portRange.PortStart,
+					PortEnd:   portRange.PortEnd,
+					Protocol:  portRange.Protocol,
+				}
+			}
+		}
+	}
+	in.EnableNodePublicIP = spec.EnableNodePublicIP
+	in.Tags = spec.Tags
+	in.GPUDriver = spec.GPUDriver
+	in.GPUInstanceProfile = spec.GPUInstanceProfile
+	in.Kubelet = nil
+	if spec.Kubelet != nil {
+		in.Kubelet = &KubeletConfiguration{
+			CPUManagerPolicy:            spec.Kubelet.CPUManagerPolicy,
+			CPUCFSQuota:                 spec.Kubelet.CPUCFSQuota,
+			CPUCFSQuotaPeriod:           spec.Kubelet.CPUCFSQuotaPeriod,
+			ImageGCHighThresholdPercent: spec.Kubelet.ImageGCHighThresholdPercent,
+			ImageGCLowThresholdPercent:  spec.Kubelet.ImageGCLowThresholdPercent,
+			TopologyManagerPolicy:       spec.Kubelet.TopologyManagerPolicy,
+			AllowedUnsafeSysctls:        spec.Kubelet.AllowedUnsafeSysctls,
+			ContainerLogMaxSizeMB:       spec.Kubelet.ContainerLogMaxSizeMB,
+			ContainerLogMaxFiles:        spec.Kubelet.ContainerLogMaxFiles,
+			PodPidsLimit:                spec.Kubelet.PodPidsLimit,
+		}
+	}
+	in.LinuxOSConfig = nil
+	if spec.LinuxOSConfig != nil {
+		in.LinuxOSConfig = &LinuxOSConfiguration{
+			TransparentHugePageEnabled: spec.LinuxOSConfig.TransparentHugePageEnabled,
+			TransparentHugePageDefrag:  spec.LinuxOSConfig.TransparentHugePageDefrag,
+			SwapFileSizeMB:             spec.LinuxOSConfig.SwapFileSizeMB,
+			Sysctls:                    spec.LinuxOSConfig.Sysctls,
+		}
+	}
+}
+
+func (in *KaitoNodeClassStatus) convertTo(out *v1beta1.KaitoNodeClassStatus) {
+	status := in.DeepCopy()
+	out.KubernetesVersion = status.KubernetesVersion
+	out.Conditions = status.Conditions
+}
+
+func (in *KaitoNodeClassStatus) convertFrom(src *v1beta1.KaitoNodeClassStatus) {
+	status := src.DeepCopy()
+	in.KubernetesVersion = status.KubernetesVersion
+	in.Conditions = status.Conditions
+}
@@ -0,0 +1,102 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/azure/gpu-provisioner/pkg/apis/v1beta1"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+	"sigs.k8s.io/randfill"
+)
+
+func TestKaitoNodeClassConversionRoundTrip(t *testing.T) {
+	filler := randfill.New().NilChance(0.3).NumElements(0, 3)
+	for i := 0; i < 100; i++ {
+		original := &KaitoNodeClass{}
+		filler.Fill(&original.ObjectMeta)
+		filler.Fill(&original.Spec)
+		filler.Fill(&original.Status)
+
+		hub := &v1beta1.KaitoNodeClass{}
+		assert.NoError(t, original.ConvertTo(hub))
+		converted := &KaitoNodeClass{}
+		assert.NoError(t, converted.ConvertFrom(hub))
+		assert.Equal(t, original, converted)
+
+		originalHub := &v1beta1.KaitoNodeClass{}
+		filler.Fill(&originalHub.ObjectMeta)
+		filler.Fill(&originalHub.Spec)
+		filler.Fill(&originalHub.Status)
+
+		spoke := &KaitoNodeClass{}
+		assert.NoError(t, spoke.ConvertFrom(originalHub))
+		convertedHub := &v1beta1.KaitoNodeClass{}
+		assert.NoError(t, spoke.ConvertTo(convertedHub))
+		assert.Equal(t, originalHub, convertedHub)
+	}
+}
+
+// TestKaitoNodeClassConversionAllFields fills every field, a field which is added to both versions but missed by the
+// conversion is left empty in the converted object, so the serialized specs differ.
+func TestKaitoNodeClassConversionAllFields(t *testing.T) {
+	filler := randfill.New().NilChance(0).NumElements(1, 3)
+	for i := 0; i < 10; i++ {
+		original := &KaitoNodeClass{}
+		filler.Fill(&original.Spec)
+		filler.Fill(&original.Status)
+
+		hub := &v1beta1.KaitoNodeClass{}
+		assert.NoError(t, original.ConvertTo(hub))
+		assert.JSONEq(t, string(lo.Must(json.Marshal(original.Spec))), string(lo.Must(json.Marshal(hub.Spec))))
+		assert.JSONEq(t, string(lo.Must(json.Marshal(original.Status))), string(lo.Must(json.Marshal(hub.Status))))
+
+		converted := &KaitoNodeClass{}
+		assert.NoError(t, converted.ConvertFrom(hub))
+		assert.Equal(t, original.Hash(), converted.Hash())
+	}
+}
+
+func TestKaitoNodeClassConversionDeepCopies(t *testing.T) {
+	original := &KaitoNodeClass{}
+	original.Name = "default"
+	original.Spec.Tags = map[string]string{"team": "kaito"}
+	original.Spec.NetworkProfile = &NetworkProfile{ApplicationSecurityGroups: []string{"asg"}}
+
+	hub := &v1beta1.KaitoNodeClass{}
+	assert.NoError(t, original.ConvertTo(hub))
+	original.Spec.Tags["team"] = "other"
+	original.Spec.NetworkProfile.ApplicationSecurityGroups[0] = "other"
+
+	assert.Equal(t, "kaito", hub.Spec.Tags["team"])
+	assert.Equal(t, []string{"asg"}, hub.Spec.NetworkProfile.ApplicationSecurityGroups)
+}
+
+func TestKaitoNodeClassConversionUnsupportedHub(t *testing.T) {
+	var hub conversion.Hub = &unsupportedHub{}
+	assert.ErrorContains(t, (&KaitoNodeClass{}).ConvertTo(hub), "unsupported hub type")
+	assert.ErrorContains(t, (&KaitoNodeClass{}).ConvertFrom(hub), "unsupported hub type")
+}
+
+type unsupportedHub struct {
+	unstructured.Unstructured
+}
+
+func (*unsupportedHub) Hub() {}
@@ -20,14 +20,37 @@ import (
 	"github.com/awslabs/operatorpkg/status"
 )
 
+const (
+	// ConditionTypeSubnetsReady indicates the subnets and application security groups referenced by the KaitoNodeClass
+	// are valid.
+	ConditionTypeSubnetsReady = "SubnetsReady"
+	// ConditionTypeOSSKUReady indicates the OS SKU is supported by the Kubernetes version of the cluster.
+	ConditionTypeOSSKUReady = "OSSKUReady"
+	// ConditionTypeTagsReady indicates the tags satisfy the limits of Azure Resource Manager.
+	ConditionTypeTagsReady = "TagsReady"
+)
+
+type KaitoNodeClassStatus struct {
+	// KubernetesVersion is the Kubernetes version of the cluster which the KaitoNodeClass is validated against.
+	// +optional
+	KubernetesVersion string `json:"kubernetesVersion,omitempty"`
+	// Conditions contains signals for health and readiness
+	// +optional
+	Conditions []status.Condition `json:"conditions,omitempty"`
+}
+
 func (in *KaitoNodeClass) StatusConditions() status.ConditionSet {
-	return status.ConditionSet{}
+	return status.NewReadyConditions(
+		ConditionTypeSubnetsReady,
+		ConditionTypeOSSKUReady,
+		ConditionTypeTagsReady,
+	).For(in)
 }
 
 func (in *KaitoNodeClass) GetConditions() []status.Condition {
-	return []status.Condition{}
+	return in.Status.Conditions
 }
 
 func (in *KaitoNodeClass) SetConditions(conditions []status.Condition) {
-	// no-op
+	in.Status.Conditions = conditions
 }
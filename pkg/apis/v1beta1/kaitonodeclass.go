@@ -0,0 +1,200 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// KaitoNodeClass is the Schema for the KaitoNodeClass API, v1beta1 is the storage version and the hub of conversion.
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=kaitonodeclasses,scope=Cluster,categories=karpenter,shortName={knc}
+// +kubebuilder:subresource:status
+// +kubebuilder:storageversion
+type KaitoNodeClass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   KaitoNodeClassSpec   `json:"spec,omitempty"`
+	Status KaitoNodeClassStatus `json:"status,omitempty"`
+}
+
+// KaitoNodeClassSpec is the configuration of the AKS agent pools created for the nodeclaims.
+type KaitoNodeClassSpec struct {
+	// AgentPoolType is the type of AKS agent pool created for the nodeclaims which reference this nodeclass.
+	// The agent pool type configured for gpu-provisioner is used when it's not specified.
+	// +kubebuilder:validation:Enum:={VirtualMachineScaleSets,VirtualMachines}
+	// +optional
+	AgentPoolType string `json:"agentPoolType,omitempty"`
+	// OSSKU is the OS SKU of the nodes, Ubuntu is used when it's not specified.
+	// The image family annotation of nodeclaim takes precedence over it.
+	// +kubebuilder:validation:Enum:={Ubuntu,AzureLinux}
+	// +optional
+	OSSKU string `json:"osSKU,omitempty"`
+	// OSDiskType is the type of OS disk of the nodes, AKS chooses Ephemeral when the vm size supports it and
+	// Managed otherwise when it's not specified.
+	// +kubebuilder:validation:Enum:={Managed,Ephemeral}
+	// +optional
+	OSDiskType string `json:"osDiskType,omitempty"`
+	// OSDiskSizeGB is the OS disk size of the nodes when nodeclaim doesn't request storage.
+	// +kubebuilder:validation:Minimum=30
+	// +kubebuilder:validation:Maximum=2048
+	// +optional
+	OSDiskSizeGB *int32 `json:"osDiskSizeGB,omitempty"`
+	// MaxPods is the maximum number of pods that can run on a node.
+	// +kubebuilder:validation:Minimum=10
+	// +kubebuilder:validation:Maximum=250
+	// +optional
+	MaxPods *int32 `json:"maxPods,omitempty"`
+	// VnetSubnetID is the ARM ID of the subnet which the nodes join, the subnet of the cluster is used when it's not specified.
+	// +optional
+	VnetSubnetID *string `json:"vnetSubnetID,omitempty"`
+	// PodSubnetID is the ARM ID of the subnet which the pod IPs are allocated from, it should be in the same virtual
+	// network as VnetSubnetID. The pod IPs are allocated from VnetSubnetID when it's not specified.
+	// +optional
+	PodSubnetID *string `json:"podSubnetID,omitempty"`
+	// NetworkProfile is the network settings of the nodes.
+	// +optional
+	NetworkProfile *NetworkProfile `json:"networkProfile,omitempty"`
+	// EnableNodePublicIP allocates a public IP to each node.
+	// +optional
+	EnableNodePublicIP *bool `json:"enableNodePublicIP,omitempty"`
+	// Tags are the Azure tags added to the agent pool and its underlying resources. They're not hashed, so changing
+	// them doesn't drift the nodeclaims, the new tags are only added to the agent pools created afterwards.
+	// +optional
+	Tags map[string]string `json:"tags,omitempty" hash:"ignore"`
+	// GPUDriver specifies whether the GPU driver is installed by AKS, Install is used when it's not specified.
+	// The GPU driver annotation of nodeclaim takes precedence over it.
+	// +kubebuilder:validation:Enum:={Install,None}
+	// +optional
+	GPUDriver string `json:"gpuDriver,omitempty"`
+	// GPUInstanceProfile is the Multi-Instance GPU profile which partitions the GPUs of the nodes, it's only supported
+	// by A100 and H100 vm sizes. The GPU instance profile annotation of nodeclaim takes precedence over it.
+	// +kubebuilder:validation:Enum:={MIG1g,MIG2g,MIG3g,MIG4g,MIG7g}
+	// +optional
+	GPUInstanceProfile string `json:"gpuInstanceProfile,omitempty"`
+	// Kubelet is the kubelet configuration of the nodes, the AKS defaults are used when it's not specified.
+	// +optional
+	Kubelet *KubeletConfiguration `json:"kubelet,omitempty"`
+	// LinuxOSConfig is the OS configuration of the nodes, the AKS defaults are used when it's not specified.
+	// +optional
+	LinuxOSConfig *LinuxOSConfiguration `json:"linuxOSConfig,omitempty"`
+}
+
+// NetworkProfile is the network settings of the nodes.
+type NetworkProfile struct {
+	// ApplicationSecurityGroups are the ARM IDs of the application security groups which the nodes are associated with.
+	// +kubebuilder:validation:MaxItems=10
+	// +optional
+	ApplicationSecurityGroups []string `json:"applicationSecurityGroups,omitempty"`
+	// AllowedHostPorts are the port ranges on the nodes which are allowed to access, the ranges can overlap.
+	// +optional
+	AllowedHostPorts []PortRange `json:"allowedHostPorts,omitempty"`
+}
+
+// PortRange is a range of host ports with the network protocol.
+type PortRange struct {
+	// PortStart is the first port of the range.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	PortStart int32 `json:"portStart"`
+	// PortEnd is the last port of the range, it should be greater than or equal to PortStart.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	PortEnd int32 `json:"portEnd"`
+	// Protocol is the network protocol of the ports.
+	// +kubebuilder:validation:Enum:={TCP,UDP}
+	Protocol string `json:"protocol"`
+}
+
+// KubeletConfiguration is the subset of kubelet settings which can be customized on AKS nodes.
+// https://learn.microsoft.com/en-us/azure/aks/custom-node-configuration#kubelet-custom-configuration
+type KubeletConfiguration struct {
+	// CPUManagerPolicy is the CPU management policy of kubelet.
+	// +kubebuilder:validation:Enum:={none,static}
+	// +optional
+	CPUManagerPolicy string `json:"cpuManagerPolicy,omitempty"`
+	// CPUCFSQuota enables CPU CFS quota enforcement for containers that specify CPU limits.
+	// +optional
+	CPUCFSQuota *bool `json:"cpuCFSQuota,omitempty"`
+	// CPUCFSQuotaPeriod is the CPU CFS quota period value.
+	// +optional
+	CPUCFSQuotaPeriod *metav1.Duration `json:"cpuCFSQuotaPeriod,omitempty"`
+	// ImageGCHighThresholdPercent is the percent of disk usage after which image garbage collection is always run.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	ImageGCHighThresholdPercent *int32 `json:"imageGCHighThresholdPercent,omitempty"`
+	// ImageGCLowThresholdPercent is the percent of disk usage before which image garbage collection is never run,
+	// it should be lower than ImageGCHighThresholdPercent.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	ImageGCLowThresholdPercent *int32 `json:"imageGCLowThresholdPercent,omitempty"`
+	// TopologyManagerPolicy is the topology management policy of kubelet, single-numa-node aligns the GPUs and CPUs
+	// of a pod on the same NUMA node.
+	// +kubebuilder:validation:Enum:={none,best-effort,restricted,single-numa-node}
+	// +optional
+	TopologyManagerPolicy string `json:"topologyManagerPolicy,omitempty"`
+	// AllowedUnsafeSysctls are the unsafe sysctls or sysctl patterns(ending in *) which pods are allowed to set.
+	// +optional
+	AllowedUnsafeSysctls []string `json:"allowedUnsafeSysctls,omitempty"`
+	// ContainerLogMaxSizeMB is the maximum size in MB of a container log file before it's rotated.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	ContainerLogMaxSizeMB *int32 `json:"containerLogMaxSizeMB,omitempty"`
+	// ContainerLogMaxFiles is the maximum number of log files that can be present for a container.
+	// +kubebuilder:validation:Minimum=2
+	// +optional
+	ContainerLogMaxFiles *int32 `json:"containerLogMaxFiles,omitempty"`
+	// PodPidsLimit is the maximum number of processes per pod.
+	// +optional
+	PodPidsLimit *int32 `json:"podPidsLimit,omitempty"`
+}
+
+// LinuxOSConfiguration is the subset of OS settings which can be customized on AKS Linux nodes.
+// https://learn.microsoft.com/en-us/azure/aks/custom-node-configuration#linux-os-custom-configuration
+type LinuxOSConfiguration struct {
+	// TransparentHugePageEnabled configures whether transparent hugepages are enabled.
+	// +kubebuilder:validation:Enum:={always,madvise,never}
+	// +optional
+	TransparentHugePageEnabled string `json:"transparentHugePageEnabled,omitempty"`
+	// TransparentHugePageDefrag configures whether the kernel makes aggressive use of memory compaction to make more
+	// hugepages available.
+	// +kubebuilder:validation:Enum:={always,defer,defer+madvise,madvise,never}
+	// +optional
+	TransparentHugePageDefrag string `json:"transparentHugePageDefrag,omitempty"`
+	// SwapFileSizeMB is the size in MB of a swap file created on each node.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	SwapFileSizeMB *int32 `json:"swapFileSizeMB,omitempty"`
+	// Sysctls are the kernel parameters of the nodes keyed by sysctl name, e.g. vm.max_map_count, only the sysctls
+	// supported by AKS custom node configuration are allowed.
+	// +optional
+	Sysctls map[string]string `json:"sysctls,omitempty"`
+}
+
+// KaitoNodeClassList contains a list of KaitoNodeClass
+// +kubebuilder:object:root=true
+type KaitoNodeClassList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KaitoNodeClass `json:"items"`
+}
+
+// Hub marks v1beta1 as the version which other versions of KaitoNodeClass are converted to and from.
+func (*KaitoNodeClass) Hub() {}
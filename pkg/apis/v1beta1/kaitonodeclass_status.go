@@ -0,0 +1,56 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"github.com/awslabs/operatorpkg/status"
+)
+
+const (
+	// ConditionTypeSubnetsReady indicates the subnets and application security groups referenced by the KaitoNodeClass
+	// are valid.
+	ConditionTypeSubnetsReady = "SubnetsReady"
+	// ConditionTypeOSSKUReady indicates the OS SKU is supported by the Kubernetes version of the cluster.
+	ConditionTypeOSSKUReady = "OSSKUReady"
+	// ConditionTypeTagsReady indicates the tags satisfy the limits of Azure Resource Manager.
+	ConditionTypeTagsReady = "TagsReady"
+)
+
+type KaitoNodeClassStatus struct {
+	// KubernetesVersion is the Kubernetes version of the cluster which the KaitoNodeClass is validated against.
+	// +optional
+	KubernetesVersion string `json:"kubernetesVersion,omitempty"`
+	// Conditions contains signals for health and readiness
+	// +optional
+	Conditions []status.Condition `json:"conditions,omitempty"`
+}
+
+func (in *KaitoNodeClass) StatusConditions() status.ConditionSet {
+	return status.NewReadyConditions(
+		ConditionTypeSubnetsReady,
+		ConditionTypeOSSKUReady,
+		ConditionTypeTagsReady,
+	).For(in)
+}
+
+func (in *KaitoNodeClass) GetConditions() []status.Condition {
+	return in.Status.Conditions
+}
+
+func (in *KaitoNodeClass) SetConditions(conditions []status.Condition) {
+	in.Status.Conditions = conditions
+}
@@ -24,13 +24,34 @@ import (
 
 	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/azure"
+
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/utils"
+	"k8s.io/apimachinery/pkg/util/validation"
+	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
 )
 
 const (
-	// toggle
-	dynamicSKUCacheDefault = false
+	// the embedded gpu sku catalog isn't filtered by region, so the skus are refreshed from resource skus api by default
+	dynamicSKUCacheDefault = true
+	agentPoolTypeDefault   = v1alpha1.AgentPoolTypeVirtualMachineScaleSets
+	// warmPoolOSDiskSizeGBDefault is large enough for the model images of most kaito workspaces
+	warmPoolOSDiskSizeGBDefault = 512
+	// spotPriceFactorDefault estimates the spot price as 40% of the on-demand price, the discount of spot VMs varies
+	// over time and by region
+	spotPriceFactorDefault = 0.4
 )
 
+// tagNodeClaimLabelsDefault attribute the cost of agent pools to the nodepool, and the kaito workspace or ragengine
+// with its namespace.
+var tagNodeClaimLabelsDefault = []string{
+	karpenterv1.NodePoolLabelKey,
+	"kaito.sh/workspace",
+	"kaito.sh/workspacenamespace",
+	"kaito.sh/ragengine",
+	"kaito.sh/ragenginenamespace",
+}
+
 // ClientConfig contains all essential information to create an Azure client.
 type ClientConfig struct {
 	CloudName               string
@@ -55,6 +76,8 @@ type Config struct {
 	ClusterName string `json:"clusterName" yaml:"clusterName"`
 	// enableDynamicSKUCache defines whether to enable dynamic instance workflow for instance information check
 	EnableDynamicSKUCache bool `json:"enableDynamicSKUCache,omitempty" yaml:"enableDynamicSKUCache,omitempty"`
+	// SpotPriceFactor defines the ratio of the estimated spot price to the on-demand price of a vm size
+	SpotPriceFactor float64 `json:"spotPriceFactor,omitempty" yaml:"spotPriceFactor,omitempty"`
 	// EnableDetailedCSEMessage defines whether to emit error messages in the CSE error body info
 	EnableDetailedCSEMessage bool `json:"enableDetailedCSEMessage,omitempty" yaml:"enableDetailedCSEMessage,omitempty"`
 
@@ -70,6 +93,20 @@ type Config struct {
 
 	// EnablePartialScaling defines whether to enable partial scaling based on quota limits
 	EnablePartialScaling bool `json:"enablePartialScaling,omitempty" yaml:"enablePartialScaling,omitempty"`
+
+	// AgentPoolType defines the type of agent pools created for the nodeclaims which don't specify it in KaitoNodeClass,
+	// VirtualMachineScaleSets or VirtualMachines
+	AgentPoolType string `json:"agentPoolType,omitempty" yaml:"agentPoolType,omitempty"`
+
+	// WarmPools defines the number of warm standby agent pools kept for each vm size
+	WarmPools map[string]int `json:"warmPools,omitempty" yaml:"warmPools,omitempty"`
+	// WarmPoolOSDiskSizeGB defines the os disk size of warm standby agent pools
+	WarmPoolOSDiskSizeGB int32 `json:"warmPoolOSDiskSizeGB,omitempty" yaml:"warmPoolOSDiskSizeGB,omitempty"`
+
+	// Tags are the Azure tags added to all agent pools for cost allocation
+	Tags map[string]string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	// TagNodeClaimLabels are the labels of nodeclaim which are added to its agent pool as Azure tags
+	TagNodeClaimLabels []string `json:"tagNodeClaimLabels,omitempty" yaml:"tagNodeClaimLabels,omitempty"`
 }
 
 func (cfg *Config) BaseVars() {
@@ -80,6 +117,7 @@ func (cfg *Config) BaseVars() {
 	cfg.ClusterName = os.Getenv("AZURE_CLUSTER_NAME")
 	cfg.SubscriptionID = os.Getenv("ARM_SUBSCRIPTION_ID")
 	cfg.DeploymentMode = os.Getenv("DEPLOYMENT_MODE")
+	cfg.AgentPoolType = os.Getenv("AZURE_AGENT_POOL_TYPE")
 }
 
 // BuildAzureConfig returns a Config object for the Azure clients
@@ -97,7 +135,50 @@ func BuildAzureConfig() (*Config, error) {
 		cfg.EnableDynamicSKUCache = dynamicSKUCacheDefault
 	}
 
+	cfg.SpotPriceFactor = spotPriceFactorDefault
+	if factor := os.Getenv("AZURE_SPOT_PRICE_FACTOR"); factor != "" {
+		cfg.SpotPriceFactor, err = strconv.ParseFloat(factor, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse AZURE_SPOT_PRICE_FACTOR %q: %w", factor, err)
+		}
+	}
+
+	if warmPools := os.Getenv("AZURE_WARM_POOLS"); warmPools != "" {
+		cfg.WarmPools, err = parseWarmPools(warmPools)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse AZURE_WARM_POOLS %q: %w", warmPools, err)
+		}
+	}
+	cfg.WarmPoolOSDiskSizeGB = warmPoolOSDiskSizeGBDefault
+	if diskSize := os.Getenv("AZURE_WARM_POOL_OS_DISK_SIZE_GB"); diskSize != "" {
+		size, err := strconv.ParseInt(diskSize, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse AZURE_WARM_POOL_OS_DISK_SIZE_GB %q: %w", diskSize, err)
+		}
+		cfg.WarmPoolOSDiskSizeGB = int32(size)
+	}
+
+	if tags := os.Getenv("AZURE_TAGS"); tags != "" {
+		cfg.Tags, err = parseTags(tags)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse AZURE_TAGS %q: %w", tags, err)
+		}
+	}
+	// an empty AZURE_TAG_NODECLAIM_LABELS disables the tags from nodeclaim labels.
+	cfg.TagNodeClaimLabels = tagNodeClaimLabelsDefault
+	if labels, ok := os.LookupEnv("AZURE_TAG_NODECLAIM_LABELS"); ok {
+		cfg.TagNodeClaimLabels = nil
+		for _, label := range strings.Split(labels, ",") {
+			if label = strings.TrimSpace(label); label != "" {
+				cfg.TagNodeClaimLabels = append(cfg.TagNodeClaimLabels, label)
+			}
+		}
+	}
+
 	cfg.TrimSpace()
+	if cfg.AgentPoolType == "" {
+		cfg.AgentPoolType = agentPoolTypeDefault
+	}
 
 	if err := cfg.validate(); err != nil {
 		return nil, err
@@ -122,6 +203,7 @@ func (cfg *Config) TrimSpace() {
 	cfg.SubscriptionID = strings.TrimSpace(cfg.SubscriptionID)
 	cfg.ResourceGroup = strings.TrimSpace(cfg.ResourceGroup)
 	cfg.ClusterName = strings.TrimSpace(cfg.ClusterName)
+	cfg.AgentPoolType = strings.TrimSpace(cfg.AgentPoolType)
 }
 
 // nolint: gocyclo
@@ -132,6 +214,62 @@ func (cfg *Config) validate() error {
 	if cfg.TenantID == "" {
 		return fmt.Errorf("tenant ID not set")
 	}
+	if cfg.AgentPoolType != "" && cfg.AgentPoolType != v1alpha1.AgentPoolTypeVirtualMachineScaleSets && cfg.AgentPoolType != v1alpha1.AgentPoolTypeVirtualMachines {
+		return fmt.Errorf("agent pool type %q is invalid, must be %s or %s", cfg.AgentPoolType, v1alpha1.AgentPoolTypeVirtualMachineScaleSets, v1alpha1.AgentPoolTypeVirtualMachines)
+	}
+	if cfg.SpotPriceFactor <= 0 || cfg.SpotPriceFactor > 1 {
+		return fmt.Errorf("spot price factor %v is invalid, must be more than 0 and at most 1", cfg.SpotPriceFactor)
+	}
+	if len(cfg.WarmPools) != 0 && cfg.WarmPoolOSDiskSizeGB <= 0 {
+		return fmt.Errorf("warm pool os disk size %d is invalid, must be more than 0", cfg.WarmPoolOSDiskSizeGB)
+	}
+	if err := utils.ValidateTags(cfg.Tags); err != nil {
+		return fmt.Errorf("tags are invalid, %w", err)
+	}
+	for _, label := range cfg.TagNodeClaimLabels {
+		if errs := validation.IsQualifiedName(label); len(errs) != 0 {
+			return fmt.Errorf("nodeclaim label %q of tags is invalid, %s", label, strings.Join(errs, "; "))
+		}
+	}
 
 	return nil
 }
+
+// parseWarmPools parses the warm pool sizes in the format of "Standard_NC6s_v3=1,Standard_NC24ads_A100_v4=2", the vm
+// sizes are case-insensitive, so they're returned in lower case.
+func parseWarmPools(value string) (map[string]int, error) {
+	warmPools := map[string]int{}
+	for _, item := range strings.Split(value, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		vmSize, count, found := strings.Cut(item, "=")
+		vmSize = strings.TrimSpace(vmSize)
+		if !found || vmSize == "" {
+			return nil, fmt.Errorf("warm pool %q should be in the format of <vm size>=<count>", item)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(count))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("warm pool count of vm size %s should be a non-negative integer", vmSize)
+		}
+		warmPools[strings.ToLower(vmSize)] = n
+	}
+	return warmPools, nil
+}
+
+// parseTags parses the tags in the format of "team=ml,costcenter=1234", the value of a tag can be empty.
+func parseTags(value string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, item := range strings.Split(value, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		key, tagValue, found := strings.Cut(item, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return nil, fmt.Errorf("tag %q should be in the format of <key>=<value>", item)
+		}
+		tags[key] = strings.TrimSpace(tagValue)
+	}
+	return tags, nil
+}
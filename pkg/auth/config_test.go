@@ -17,6 +17,7 @@ package auth
 
 import (
 	"os"
+	"reflect"
 	"testing"
 
 	"github.com/Azure/go-autorest/autorest/azure"
@@ -137,8 +138,9 @@ func TestTrimSpace(t *testing.T) {
 
 func TestValidate(t *testing.T) {
 	cfg := &Config{
-		TenantID:       "tenant",
-		SubscriptionID: "sub",
+		TenantID:        "tenant",
+		SubscriptionID:  "sub",
+		SpotPriceFactor: spotPriceFactorDefault,
 	}
 	if err := cfg.validate(); err != nil {
 		t.Errorf("unexpected error: %v", err)
@@ -171,6 +173,37 @@ func TestBuildAzureConfig_DefaultDynamicSKUCache(t *testing.T) {
 	}
 }
 
+func TestBuildAzureConfig_SpotPriceFactor(t *testing.T) {
+	os.Setenv("ARM_SUBSCRIPTION_ID", "sub-abc")
+	os.Setenv("AZURE_TENANT_ID", "tenant-123")
+	defer unsetEnvVars([]string{"ARM_SUBSCRIPTION_ID", "AZURE_TENANT_ID", "AZURE_SPOT_PRICE_FACTOR"})
+
+	os.Unsetenv("AZURE_SPOT_PRICE_FACTOR")
+	cfg, err := BuildAzureConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.SpotPriceFactor != spotPriceFactorDefault {
+		t.Errorf("expected SpotPriceFactor to be %v, got %v", spotPriceFactorDefault, cfg.SpotPriceFactor)
+	}
+
+	os.Setenv("AZURE_SPOT_PRICE_FACTOR", "0.25")
+	cfg, err = BuildAzureConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.SpotPriceFactor != 0.25 {
+		t.Errorf("expected SpotPriceFactor to be 0.25, got %v", cfg.SpotPriceFactor)
+	}
+
+	for _, invalid := range []string{"cheap", "0", "-0.5", "1.5"} {
+		os.Setenv("AZURE_SPOT_PRICE_FACTOR", invalid)
+		if _, err := BuildAzureConfig(); err == nil {
+			t.Errorf("expected error for invalid AZURE_SPOT_PRICE_FACTOR %q", invalid)
+		}
+	}
+}
+
 func TestConfig_GetAzureClientConfig(t *testing.T) {
 	cfg := &Config{
 		Location:       "eastus",
@@ -185,3 +218,113 @@ func TestConfig_GetAzureClientConfig(t *testing.T) {
 		t.Errorf("expected SubscriptionID to be 'sub-abc', got %s", clientCfg.SubscriptionID)
 	}
 }
+
+func TestBuildAzureConfig_AgentPoolType(t *testing.T) {
+	os.Setenv("ARM_SUBSCRIPTION_ID", "sub-abc")
+	os.Setenv("AZURE_TENANT_ID", "tenant-123")
+	defer unsetEnvVars([]string{"ARM_SUBSCRIPTION_ID", "AZURE_TENANT_ID", "AZURE_AGENT_POOL_TYPE"})
+
+	os.Unsetenv("AZURE_AGENT_POOL_TYPE")
+	cfg, err := BuildAzureConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.AgentPoolType != agentPoolTypeDefault {
+		t.Errorf("expected AgentPoolType to be %s, got %s", agentPoolTypeDefault, cfg.AgentPoolType)
+	}
+
+	os.Setenv("AZURE_AGENT_POOL_TYPE", "VirtualMachines")
+	cfg, err = BuildAzureConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.AgentPoolType != "VirtualMachines" {
+		t.Errorf("expected AgentPoolType to be 'VirtualMachines', got %s", cfg.AgentPoolType)
+	}
+
+	os.Setenv("AZURE_AGENT_POOL_TYPE", "AvailabilitySet")
+	if _, err = BuildAzureConfig(); err == nil {
+		t.Errorf("expected error for invalid AZURE_AGENT_POOL_TYPE")
+	}
+}
+
+func TestBuildAzureConfig_WarmPools(t *testing.T) {
+	os.Setenv("ARM_SUBSCRIPTION_ID", "sub-abc")
+	os.Setenv("AZURE_TENANT_ID", "tenant-123")
+	defer unsetEnvVars([]string{"ARM_SUBSCRIPTION_ID", "AZURE_TENANT_ID", "AZURE_WARM_POOLS", "AZURE_WARM_POOL_OS_DISK_SIZE_GB"})
+
+	os.Setenv("AZURE_WARM_POOLS", "Standard_NC6s_v3=1, Standard_NC24ads_A100_v4=2")
+	os.Setenv("AZURE_WARM_POOL_OS_DISK_SIZE_GB", "256")
+	cfg, err := BuildAzureConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.WarmPools) != 2 || cfg.WarmPools["standard_nc6s_v3"] != 1 || cfg.WarmPools["standard_nc24ads_a100_v4"] != 2 {
+		t.Errorf("unexpected WarmPools %v", cfg.WarmPools)
+	}
+	if cfg.WarmPoolOSDiskSizeGB != 256 {
+		t.Errorf("expected WarmPoolOSDiskSizeGB to be 256, got %d", cfg.WarmPoolOSDiskSizeGB)
+	}
+
+	for _, invalid := range []string{"Standard_NC6s_v3", "=1", "Standard_NC6s_v3=-1", "Standard_NC6s_v3=one"} {
+		os.Setenv("AZURE_WARM_POOLS", invalid)
+		if _, err := BuildAzureConfig(); err == nil {
+			t.Errorf("expected error for invalid AZURE_WARM_POOLS %q", invalid)
+		}
+	}
+
+	os.Setenv("AZURE_WARM_POOLS", "Standard_NC6s_v3=1")
+	os.Setenv("AZURE_WARM_POOL_OS_DISK_SIZE_GB", "0")
+	if _, err := BuildAzureConfig(); err == nil {
+		t.Errorf("expected error for invalid AZURE_WARM_POOL_OS_DISK_SIZE_GB")
+	}
+}
+
+func TestBuildAzureConfig_Tags(t *testing.T) {
+	os.Setenv("ARM_SUBSCRIPTION_ID", "sub-abc")
+	os.Setenv("AZURE_TENANT_ID", "tenant-123")
+	defer unsetEnvVars([]string{"ARM_SUBSCRIPTION_ID", "AZURE_TENANT_ID", "AZURE_TAGS", "AZURE_TAG_NODECLAIM_LABELS"})
+
+	cfg, err := BuildAzureConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Tags) != 0 || !reflect.DeepEqual(cfg.TagNodeClaimLabels, tagNodeClaimLabelsDefault) {
+		t.Errorf("unexpected default tags %v and nodeclaim labels %v", cfg.Tags, cfg.TagNodeClaimLabels)
+	}
+
+	os.Setenv("AZURE_TAGS", "team=ml, costcenter=1234,owner=")
+	os.Setenv("AZURE_TAG_NODECLAIM_LABELS", "kaito.sh/workspace, app")
+	cfg, err = BuildAzureConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(cfg.Tags, map[string]string{"team": "ml", "costcenter": "1234", "owner": ""}) {
+		t.Errorf("unexpected Tags %v", cfg.Tags)
+	}
+	if !reflect.DeepEqual(cfg.TagNodeClaimLabels, []string{"kaito.sh/workspace", "app"}) {
+		t.Errorf("unexpected TagNodeClaimLabels %v", cfg.TagNodeClaimLabels)
+	}
+
+	os.Setenv("AZURE_TAG_NODECLAIM_LABELS", "")
+	cfg, err = BuildAzureConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.TagNodeClaimLabels) != 0 {
+		t.Errorf("expected no TagNodeClaimLabels, got %v", cfg.TagNodeClaimLabels)
+	}
+
+	for _, invalid := range []string{"team", "=ml", "azure-team=ml", "team/name=ml"} {
+		os.Setenv("AZURE_TAGS", invalid)
+		if _, err := BuildAzureConfig(); err == nil {
+			t.Errorf("expected error for invalid AZURE_TAGS %q", invalid)
+		}
+	}
+
+	os.Setenv("AZURE_TAGS", "")
+	os.Setenv("AZURE_TAG_NODECLAIM_LABELS", "kaito.sh/work space")
+	if _, err := BuildAzureConfig(); err == nil {
+		t.Errorf("expected error for invalid AZURE_TAG_NODECLAIM_LABELS")
+	}
+}
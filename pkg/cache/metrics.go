@@ -0,0 +1,50 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	opmetrics "github.com/awslabs/operatorpkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/karpenter/pkg/metrics"
+)
+
+const (
+	cloudProviderSubsystem = "cloudprovider"
+
+	instanceTypeLabel = "instance_type"
+	zoneLabel         = "zone"
+	capacityTypeLabel = "capacity_type"
+	reasonLabel       = "reason"
+)
+
+var (
+	UnavailableOfferingsGauge = opmetrics.NewPrometheusGauge(
+		crmetrics.Registry,
+		prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: cloudProviderSubsystem,
+			Name:      "unavailable_offerings",
+			Help:      "Offerings which are skipped by agent pool creation because of recent capacity errors. Labeled by instance type, zone, capacity type and the error code.",
+		},
+		[]string{
+			instanceTypeLabel,
+			zoneLabel,
+			capacityTypeLabel,
+			reasonLabel,
+		},
+	)
+)
@@ -0,0 +1,93 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"knative.dev/pkg/logging"
+)
+
+const (
+	// UnavailableOfferingsTTL is the time before offerings that were marked as unavailable are tried again
+	UnavailableOfferingsTTL = 3 * time.Minute
+	// UnavailableOfferingsCleanupInterval is the interval of removing expired offerings from the cache
+	UnavailableOfferingsCleanupInterval = time.Minute
+)
+
+// UnavailableOfferings stores the vm size, zone and capacity type combinations which failed recently with capacity
+// related errors(allocation failure, sku not available, quota exceeded), so they are skipped by the following creations
+// instead of sending requests to ARM again.
+type UnavailableOfferings struct {
+	cache *cache.Cache
+}
+
+type unavailableOffering struct {
+	vmSize       string
+	zone         string
+	capacityType string
+	reason       string
+}
+
+func NewUnavailableOfferings() *UnavailableOfferings {
+	c := cache.New(UnavailableOfferingsTTL, UnavailableOfferingsCleanupInterval)
+	c.OnEvicted(func(_ string, v interface{}) {
+		if o, ok := v.(unavailableOffering); ok {
+			UnavailableOfferingsGauge.Delete(o.labels())
+		}
+	})
+	return &UnavailableOfferings{cache: c}
+}
+
+// IsUnavailable returns true when the offering is marked as unavailable and not expired yet.
+func (u *UnavailableOfferings) IsUnavailable(vmSize, zone, capacityType string) bool {
+	_, found := u.cache.Get(key(vmSize, zone, capacityType))
+	return found
+}
+
+// MarkUnavailable marks the offering as unavailable for UnavailableOfferingsTTL, reason is the error code returned by ARM.
+func (u *UnavailableOfferings) MarkUnavailable(ctx context.Context, reason, vmSize, zone, capacityType string) {
+	logging.FromContext(ctx).Debugf("marking offering %s/%s/%s unavailable for %s, reason: %s", vmSize, capacityType, zone, UnavailableOfferingsTTL, reason)
+	o := unavailableOffering{vmSize: vmSize, zone: zone, capacityType: capacityType, reason: reason}
+	// the previous entry is deleted first, so its metric is removed when the reason changes.
+	u.cache.Delete(key(vmSize, zone, capacityType))
+	u.cache.SetDefault(key(vmSize, zone, capacityType), o)
+	UnavailableOfferingsGauge.Set(1, o.labels())
+}
+
+// Flush removes all offerings from the cache.
+func (u *UnavailableOfferings) Flush() {
+	u.cache.Flush()
+	UnavailableOfferingsGauge.Reset()
+}
+
+// key returns the cache key of offering, vm size is case-insensitive in ARM.
+func key(vmSize, zone, capacityType string) string {
+	return fmt.Sprintf("%s:%s:%s", capacityType, strings.ToLower(vmSize), zone)
+}
+
+func (o unavailableOffering) labels() map[string]string {
+	return map[string]string{
+		instanceTypeLabel: o.vmSize,
+		zoneLabel:         o.zone,
+		capacityTypeLabel: o.capacityType,
+		reasonLabel:       o.reason,
+	}
+}
@@ -0,0 +1,112 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"context"
+	"testing"
+
+	opmetrics "github.com/awslabs/operatorpkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+func TestUnavailableOfferings(t *testing.T) {
+	testCases := []struct {
+		name          string
+		marked        [][3]string
+		vmSize        string
+		zone          string
+		capacityType  string
+		isUnavailable bool
+	}{
+		{
+			name:          "offering is available when nothing is marked",
+			vmSize:        "Standard_NC6s_v3",
+			capacityType:  karpenterv1.CapacityTypeOnDemand,
+			isUnavailable: false,
+		},
+		{
+			name:          "offering is unavailable after it's marked",
+			marked:        [][3]string{{"Standard_NC6s_v3", "", karpenterv1.CapacityTypeOnDemand}},
+			vmSize:        "Standard_NC6s_v3",
+			capacityType:  karpenterv1.CapacityTypeOnDemand,
+			isUnavailable: true,
+		},
+		{
+			name:          "vm size is case-insensitive",
+			marked:        [][3]string{{"standard_nc6s_v3", "eastus-1", karpenterv1.CapacityTypeOnDemand}},
+			vmSize:        "Standard_NC6s_v3",
+			zone:          "eastus-1",
+			capacityType:  karpenterv1.CapacityTypeOnDemand,
+			isUnavailable: true,
+		},
+		{
+			name:          "offering in another zone is available",
+			marked:        [][3]string{{"Standard_NC6s_v3", "eastus-1", karpenterv1.CapacityTypeOnDemand}},
+			vmSize:        "Standard_NC6s_v3",
+			zone:          "eastus-2",
+			capacityType:  karpenterv1.CapacityTypeOnDemand,
+			isUnavailable: false,
+		},
+		{
+			name:          "offering with another capacity type is available",
+			marked:        [][3]string{{"Standard_NC6s_v3", "", karpenterv1.CapacityTypeSpot}},
+			vmSize:        "Standard_NC6s_v3",
+			capacityType:  karpenterv1.CapacityTypeOnDemand,
+			isUnavailable: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u := NewUnavailableOfferings()
+			defer u.Flush()
+			for _, m := range tc.marked {
+				u.MarkUnavailable(context.Background(), "SkuNotAvailable", m[0], m[1], m[2])
+			}
+			assert.Equal(t, tc.isUnavailable, u.IsUnavailable(tc.vmSize, tc.zone, tc.capacityType))
+		})
+	}
+}
+
+func TestUnavailableOfferingsMetrics(t *testing.T) {
+	u := NewUnavailableOfferings()
+	defer u.Flush()
+
+	u.MarkUnavailable(context.Background(), "AllocationFailed", "Standard_NC6s_v3", "", karpenterv1.CapacityTypeOnDemand)
+	u.MarkUnavailable(context.Background(), "ZonalAllocationFailed", "Standard_NC12s_v3", "eastus-1", karpenterv1.CapacityTypeSpot)
+	assert.Equal(t, 2, gaugeSeriesCount())
+
+	// marking the offering again with another reason replaces its metric.
+	u.MarkUnavailable(context.Background(), "QuotaExceeded", "Standard_NC6s_v3", "", karpenterv1.CapacityTypeOnDemand)
+	assert.Equal(t, 2, gaugeSeriesCount())
+	assert.False(t, UnavailableOfferingsGauge.(*opmetrics.PrometheusGauge).GaugeVec.Delete(unavailableOffering{
+		vmSize: "Standard_NC6s_v3", capacityType: karpenterv1.CapacityTypeOnDemand, reason: "AllocationFailed",
+	}.labels()), "metric with the previous reason should be removed")
+
+	u.Flush()
+	assert.False(t, u.IsUnavailable("Standard_NC6s_v3", "", karpenterv1.CapacityTypeOnDemand))
+	assert.Equal(t, 0, gaugeSeriesCount())
+}
+
+func gaugeSeriesCount() int {
+	ch := make(chan prometheus.Metric, 16)
+	UnavailableOfferingsGauge.(*opmetrics.PrometheusGauge).Collect(ch)
+	close(ch)
+	return len(ch)
+}
@@ -24,9 +24,11 @@ import (
 	"github.com/awslabs/operatorpkg/status"
 	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
 	"github.com/azure/gpu-provisioner/pkg/providers/instance"
+	gocache "github.com/patrickmn/go-cache"
 	"github.com/samber/lo"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
@@ -38,12 +40,22 @@ var _ cloudprovider.CloudProvider = &CloudProvider{}
 type CloudProvider struct {
 	instanceProvider *instance.Provider
 	kubeClient       client.Client
+	// versionClient resolves the Kubernetes version of control plane for drift detection, the Kubernetes version
+	// drift is not detected when it's nil.
+	versionClient discovery.ServerVersionInterface
+	// serverVersions caches the Kubernetes version of control plane returned by versionClient.
+	serverVersions *gocache.Cache
+	// repairPolicies serves the node repair policies, the default policies are used when it's nil.
+	repairPolicies *RepairPolicies
 }
 
-func New(instanceProvider *instance.Provider, kubeClient client.Client) *CloudProvider {
+func New(instanceProvider *instance.Provider, kubeClient client.Client, versionClient discovery.ServerVersionInterface, repairPolicies *RepairPolicies) *CloudProvider {
 	return &CloudProvider{
 		instanceProvider: instanceProvider,
 		kubeClient:       kubeClient,
+		versionClient:    versionClient,
+		serverVersions:   gocache.New(ServerVersionCacheTTL, time.Hour),
+		repairPolicies:   repairPolicies,
 	}
 }
 
@@ -57,6 +69,14 @@ func (c *CloudProvider) Create(ctx context.Context, nodeClaim *karpenterv1.NodeC
 	}
 	nc := c.instanceToNodeClaim(ctx, instance)
 	nc.Labels = lo.Assign(nc.Labels, instance.Labels)
+	if instance.NodeClassHash != nil {
+		// the hash of the KaitoNodeClass which the instance is launched with is stamped on nodeclaim for detecting
+		// the drift of KaitoNodeClass.
+		nc.Annotations = lo.Assign(nc.Annotations, map[string]string{
+			v1alpha1.AnnotationKaitoNodeClassHash:        *instance.NodeClassHash,
+			v1alpha1.AnnotationKaitoNodeClassHashVersion: v1alpha1.KaitoNodeClassHashVersion,
+		})
+	}
 	return nc, nil
 }
 
@@ -88,31 +108,35 @@ func (c *CloudProvider) Get(ctx context.Context, providerID string) (*karpenterv
 
 func (c *CloudProvider) Delete(ctx context.Context, nodeClaim *karpenterv1.NodeClaim) error {
 	klog.InfoS("Delete", "nodeClaim", klog.KObj(nodeClaim))
-	return c.instanceProvider.Delete(ctx, nodeClaim.Name)
+	return c.instanceProvider.Delete(ctx, nodeClaim)
 }
 
 func (c *CloudProvider) IsDrifted(ctx context.Context, nodeClaim *karpenterv1.NodeClaim) (cloudprovider.DriftReason, error) {
 	klog.V(5).InfoS("IsDrifted", "nodeclaim", klog.KObj(nodeClaim))
-	return cloudprovider.DriftReason(""), nil
+	reason, err := c.isDrifted(ctx, nodeClaim)
+	if err != nil {
+		return "", fmt.Errorf("checking drift of nodeclaim(%s), %w", nodeClaim.Name, err)
+	}
+	return reason, nil
 }
 
+// GetInstanceTypes returns the GPU SKUs which can be launched for nodePool, the KaitoNodeClass of nodePool determines
+// the pods capacity.
 func (c *CloudProvider) GetInstanceTypes(ctx context.Context, nodePool *karpenterv1.NodePool) ([]*cloudprovider.InstanceType, error) {
-	return []*cloudprovider.InstanceType{}, nil
+	var nodeClass *v1alpha1.KaitoNodeClass
+	if nodePool != nil {
+		var err error
+		if nodeClass, err = c.resolveNodeClass(ctx, nodePool.Spec.Template.Spec.NodeClassRef); err != nil {
+			return nil, fmt.Errorf("resolving kaitonodeclass of nodepool(%s), %w", nodePool.Name, err)
+		}
+	}
+	return c.instanceProvider.InstanceTypes(ctx, nodeClass), nil
 }
 
+// RepairPolicies returns the current node repair policies, the node health controller calls it on every reconcile so
+// the changes of the repair policies file take effect without restarting the controller.
 func (c *CloudProvider) RepairPolicies() []cloudprovider.RepairPolicy {
-	return []cloudprovider.RepairPolicy{
-		{
-			ConditionType:      corev1.NodeReady,
-			ConditionStatus:    corev1.ConditionFalse,
-			TolerationDuration: 10 * time.Minute,
-		},
-		{
-			ConditionType:      corev1.NodeReady,
-			ConditionStatus:    corev1.ConditionUnknown,
-			TolerationDuration: 10 * time.Minute,
-		},
-	}
+	return c.repairPolicies.Get()
 }
 
 // Name returns the CloudProvider implementation name.
@@ -124,6 +148,19 @@ func (c *CloudProvider) GetSupportedNodeClasses() []status.Object {
 	return []status.Object{&v1alpha1.KaitoNodeClass{}}
 }
 
+// resolveNodeClass returns the KaitoNodeClass of ref, nil is returned when ref doesn't reference a KaitoNodeClass or
+// it's deleted.
+func (c *CloudProvider) resolveNodeClass(ctx context.Context, ref *karpenterv1.NodeClassReference) (*v1alpha1.KaitoNodeClass, error) {
+	if ref == nil || ref.Name == "" || ref.Group != v1alpha1.Group || ref.Kind != "KaitoNodeClass" {
+		return nil, nil
+	}
+	nodeClass := &v1alpha1.KaitoNodeClass{}
+	if err := c.kubeClient.Get(ctx, client.ObjectKey{Name: ref.Name}, nodeClass); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return nodeClass, nil
+}
+
 func (c *CloudProvider) instanceToNodeClaim(ctx context.Context, instanceObj *instance.Instance) *karpenterv1.NodeClaim {
 	nodeClaim := &karpenterv1.NodeClaim{}
 	if instanceObj == nil {
@@ -132,8 +169,21 @@ func (c *CloudProvider) instanceToNodeClaim(ctx context.Context, instanceObj *in
 
 	labels := instanceObj.Labels
 	annotations := map[string]string{}
+	if instanceObj.Name != nil {
+		annotations[instance.AnnotationAgentPoolName] = *instanceObj.Name
+	}
+	if instanceObj.SubnetID != nil {
+		annotations[instance.AnnotationSubnetID] = *instanceObj.SubnetID
+	}
+	if instanceObj.PodSubnetID != nil {
+		annotations[instance.AnnotationPodSubnetID] = *instanceObj.PodSubnetID
+	}
 
+	// agent pool name may be generated from nodeclaim name, so nodeclaim name is resolved from the instance.
 	nodeClaim.Name = lo.FromPtr(instanceObj.Name)
+	if instanceObj.NodeClaimName != nil {
+		nodeClaim.Name = *instanceObj.NodeClaimName
+	}
 
 	if instanceObj.CapacityType != nil {
 		labels[karpenterv1.CapacityTypeLabelKey] = *instanceObj.CapacityType
@@ -143,8 +193,8 @@ func (c *CloudProvider) instanceToNodeClaim(ctx context.Context, instanceObj *in
 		labels[corev1.LabelInstanceTypeStable] = lo.FromPtr(instanceObj.Type)
 	}
 
-	if instanceObj.Tags[karpenterv1.NodePoolLabelKey] != nil {
-		labels[karpenterv1.NodePoolLabelKey] = *instanceObj.Tags[karpenterv1.NodePoolLabelKey]
+	if instanceObj.Tags[instance.NodePoolTag] != nil {
+		labels[karpenterv1.NodePoolLabelKey] = *instanceObj.Tags[instance.NodePoolTag]
 	}
 
 	nodeClaim.Labels = labels
@@ -163,6 +213,10 @@ func (c *CloudProvider) instanceToNodeClaim(ctx context.Context, instanceObj *in
 		nodeClaim.Status.ImageID = *instanceObj.ImageID
 	}
 
+	// the resources of the node are known before kubelet reports them, so the GPUs of the nodeclaim can be scheduled.
+	nodeClaim.Status.Capacity = instanceObj.Capacity
+	nodeClaim.Status.Allocatable = instanceObj.Allocatable
+
 	if instanceObj.State != nil {
 		if strings.Contains(strings.ToLower(*instanceObj.State), "deleting") {
 			nodeClaim.DeletionTimestamp = &metav1.Time{Time: time.Now()}
@@ -22,17 +22,24 @@ import (
 	"net/http"
 	"testing"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v4"
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/cache"
 	"github.com/azure/gpu-provisioner/pkg/fake"
 	"github.com/azure/gpu-provisioner/pkg/providers/instance"
+	"github.com/azure/gpu-provisioner/pkg/providers/instancetype"
 	"github.com/samber/lo"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"go.uber.org/mock/gomock"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
 	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
 	"sigs.k8s.io/karpenter/pkg/cloudprovider"
 )
@@ -65,7 +72,7 @@ func TestCreate(t *testing.T) {
 				}
 				resp := http.Response{StatusCode: http.StatusAccepted, Body: http.NoBody}
 
-				mockHandler.EXPECT().Done().Return(true).Times(3)
+				mockHandler.EXPECT().Done().Return(true).Times(4)
 				mockHandler.EXPECT().Result(gomock.Any(), gomock.Any()).Return(nil)
 
 				pollingOptions := &runtime.NewPollerOptions[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse]{
@@ -104,7 +111,7 @@ func TestCreate(t *testing.T) {
 			}
 
 			// prepare kubeclient
-			mockK8sClient := fake.NewClient()
+			mockK8sClient := fake.NewClient().WithKaitoNodeClass(fake.GetKaitoNodeClassObj())
 			nodeList := fake.CreateNodeListWithNodeClaim([]*karpenterv1.NodeClaim{tc.nodeClaim})
 			relevantMap := mockK8sClient.CreateMapWithType(nodeList)
 			for _, obj := range nodeList.Items {
@@ -116,10 +123,10 @@ func TestCreate(t *testing.T) {
 
 			// prepare instance provider
 			mockAzClient := instance.NewAZClientFromAPI(agentPoolMocks)
-			instanceProvider := instance.NewProvider(mockAzClient, mockK8sClient, "testRG", "testCluster")
+			instanceProvider := instance.NewProvider(mockAzClient, mockK8sClient, "testRG", "testCluster", v1alpha1.AgentPoolTypeVirtualMachineScaleSets, nil, instance.TagOptions{}, instancetype.NewProvider("", nil, 0.4, cache.NewUnavailableOfferings()))
 
 			// create cloud provider and call create function
-			cloudProvider := New(instanceProvider, nil)
+			cloudProvider := New(instanceProvider, mockK8sClient, nil, nil)
 			nc, err := cloudProvider.Create(context.Background(), tc.nodeClaim)
 
 			if tc.expectedError {
@@ -131,11 +138,71 @@ func TestCreate(t *testing.T) {
 			if nc != nil {
 				assert.Equal(t, nc.Name, tc.nodeClaim.Name, "nodeclaim name is not the same")
 				assert.NotEmpty(t, nc.Status.ProviderID, "provider id is not empty")
+				// the hash of the KaitoNodeClass read by the instance provider is stamped.
+				assert.Equal(t, fake.GetKaitoNodeClassObj().Hash(), nc.Annotations[v1alpha1.AnnotationKaitoNodeClassHash])
+				assert.Equal(t, v1alpha1.KaitoNodeClassHashVersion, nc.Annotations[v1alpha1.AnnotationKaitoNodeClassHashVersion])
 			}
 		})
 	}
 }
 
+func TestCreateWithSKULabels(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	nodeClaim := fake.GetNodeClaimObj("agentpool0", map[string]string{"test": "test"}, []v1.Taint{},
+		karpenterv1.ResourceRequirements{Requests: v1.ResourceList{
+			v1.ResourceStorage: lo.FromPtr(resource.NewQuantity(30*1024*1024*1024, resource.DecimalSI)),
+		}},
+		[]v1.NodeSelectorRequirement{{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"Standard_NC24ads_A100_v4"}}})
+
+	// the created agent pool has the node labels which are requested.
+	agentPoolMocks := fake.NewMockAgentPoolsAPI(mockCtrl)
+	agentPoolMocks.EXPECT().BeginCreateOrUpdate(gomock.Any(), gomock.Any(), gomock.Any(), nodeClaim.Name, gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _, _, _ string, parameters armcontainerservice.AgentPool, _ *armcontainerservice.AgentPoolsClientBeginCreateOrUpdateOptions) (*runtime.Poller[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse], error) {
+			ap := fake.CreateAgentPoolObjWithNodeClaim(nodeClaim)
+			ap.Properties.NodeLabels = parameters.Properties.NodeLabels
+			mockHandler := fake.NewMockPollingHandler[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse](mockCtrl)
+			mockHandler.EXPECT().Done().Return(true).AnyTimes()
+			mockHandler.EXPECT().Result(gomock.Any(), gomock.Any()).Return(nil)
+			return runtime.NewPoller(&http.Response{StatusCode: http.StatusAccepted, Body: http.NoBody}, runtime.NewPipeline("", "", runtime.PipelineOptions{}, nil),
+				&runtime.NewPollerOptions[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse]{
+					Handler:  mockHandler,
+					Response: &armcontainerservice.AgentPoolsClientCreateOrUpdateResponse{AgentPool: ap},
+				})
+		})
+
+	mockK8sClient := fake.NewClient().WithKaitoNodeClass(fake.GetKaitoNodeClassObj())
+	nodeList := fake.CreateNodeListWithNodeClaim([]*karpenterv1.NodeClaim{nodeClaim})
+	relevantMap := mockK8sClient.CreateMapWithType(nodeList)
+	for _, obj := range nodeList.Items {
+		n := obj
+		relevantMap[client.ObjectKeyFromObject(&n)] = &n
+	}
+	mockK8sClient.On("List", mock.IsType(context.Background()), mock.IsType(&v1.NodeList{}), mock.Anything).Return(nil)
+
+	instanceProvider := instance.NewProvider(instance.NewAZClientFromAPI(agentPoolMocks), mockK8sClient, "testRG", "testCluster", v1alpha1.AgentPoolTypeVirtualMachineScaleSets, nil, instance.TagOptions{}, instancetype.NewProvider("", nil, 0.4, cache.NewUnavailableOfferings()))
+	nc, err := New(instanceProvider, mockK8sClient, nil, nil).Create(context.Background(), nodeClaim)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	for key, value := range map[string]string{
+		instance.LabelMachineType:               "gpu",
+		instancetype.LabelGPUVendor:             instancetype.GPUVendorNVIDIA,
+		instancetype.LabelGPUProduct:            "A100",
+		instancetype.LabelGPUCount:              "1",
+		instancetype.LabelGPUMemoryGiB:          "80",
+		instancetype.LabelGPUNVLink:             "false",
+		instancetype.LabelInfiniBand:            "false",
+		instancetype.LabelAcceleratedNetworking: "true",
+		"test":                                  "test",
+	} {
+		assert.Equal(t, value, nc.Labels[key], key)
+	}
+	gpus := nc.Status.Allocatable["nvidia.com/gpu"]
+	assert.Equal(t, int64(1), gpus.Value())
+}
+
 func TestList(t *testing.T) {
 	testcases := map[string]struct {
 		nodeClaims        []*karpenterv1.NodeClaim
@@ -213,10 +280,10 @@ func TestList(t *testing.T) {
 
 			// prepare instance provider
 			mockAzClient := instance.NewAZClientFromAPI(agentPoolMocks)
-			instanceProvider := instance.NewProvider(mockAzClient, mockK8sClient, "testRG", "testCluster")
+			instanceProvider := instance.NewProvider(mockAzClient, mockK8sClient, "testRG", "testCluster", v1alpha1.AgentPoolTypeVirtualMachineScaleSets, nil, instance.TagOptions{}, instancetype.NewProvider("", nil, 0.4, cache.NewUnavailableOfferings()))
 
 			// create cloud provider and call list function
-			cloudProvider := New(instanceProvider, nil)
+			cloudProvider := New(instanceProvider, nil, nil, nil)
 			nodeClaims, err := cloudProvider.List(context.Background())
 
 			if tc.expectedError {
@@ -276,7 +343,7 @@ func TestGet(t *testing.T) {
 				},
 			}),
 			mockAgentPoolResp: func(nodeClaim *karpenterv1.NodeClaim) (armcontainerservice.AgentPoolsClientGetResponse, error) {
-				return armcontainerservice.AgentPoolsClientGetResponse{AgentPool: fake.CreateAgentPoolObjWithNodeClaim(nodeClaim)}, fmt.Errorf("Agent Pool not found")
+				return armcontainerservice.AgentPoolsClientGetResponse{AgentPool: fake.CreateAgentPoolObjWithNodeClaim(nodeClaim)}, &azcore.ResponseError{ErrorCode: "NotFound", StatusCode: http.StatusNotFound}
 			},
 			IsNodeClaimNotFoundError: true,
 		},
@@ -296,10 +363,10 @@ func TestGet(t *testing.T) {
 
 			// prepare instance provider
 			mockAzClient := instance.NewAZClientFromAPI(agentPoolMocks)
-			instanceProvider := instance.NewProvider(mockAzClient, nil, "testRG", "testCluster")
+			instanceProvider := instance.NewProvider(mockAzClient, nil, "testRG", "testCluster", v1alpha1.AgentPoolTypeVirtualMachineScaleSets, nil, instance.TagOptions{}, instancetype.NewProvider("", nil, 0.4, cache.NewUnavailableOfferings()))
 
 			// create cloud provider and call list function
-			cloudProvider := New(instanceProvider, nil)
+			cloudProvider := New(instanceProvider, nil, nil, nil)
 			nodeClaim, err := cloudProvider.Get(context.Background(), tc.nodeClaim.Status.ProviderID)
 
 			if tc.IsNodeClaimNotFoundError {
@@ -316,6 +383,38 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestGetWithNodeResources(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	nodeClaim := fake.GetNodeClaimObj("agentpool1", map[string]string{}, []v1.Taint{}, karpenterv1.ResourceRequirements{},
+		[]v1.NodeSelectorRequirement{{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"Standard_NC6s_v3"}}})
+	ap := fake.CreateAgentPoolObjWithNodeClaim(nodeClaim)
+	ap.Properties.MaxPods = lo.ToPtr(int32(30))
+	ap.Properties.OSDiskSizeGB = lo.ToPtr(int32(128))
+	agentPoolMocks := fake.NewMockAgentPoolsAPI(mockCtrl)
+	agentPoolMocks.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any(), nodeClaim.Name, gomock.Any()).Return(armcontainerservice.AgentPoolsClientGetResponse{AgentPool: ap}, nil)
+
+	instanceProvider := instance.NewProvider(instance.NewAZClientFromAPI(agentPoolMocks), nil, "testRG", "testCluster", v1alpha1.AgentPoolTypeVirtualMachineScaleSets, nil, instance.TagOptions{}, instancetype.NewProvider("", nil, 0.4, cache.NewUnavailableOfferings()))
+	nc, err := New(instanceProvider, nil, nil, nil).Get(context.Background(), nodeClaim.Status.ProviderID)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	quantity := func(resources v1.ResourceList, name v1.ResourceName) string {
+		return lo.ToPtr(resources[name]).String()
+	}
+	assert.Equal(t, "1", quantity(nc.Status.Capacity, "nvidia.com/gpu"))
+	assert.Equal(t, "30", quantity(nc.Status.Capacity, v1.ResourcePods))
+	assert.Equal(t, "128Gi", quantity(nc.Status.Capacity, v1.ResourceEphemeralStorage))
+	assert.Equal(t, "1", quantity(nc.Status.Allocatable, "nvidia.com/gpu"))
+	assert.Equal(t, "5840m", quantity(nc.Status.Allocatable, v1.ResourceCPU))
+
+	// the resources of the vm size which is not a known GPU SKU are reported by kubelet.
+	nc = New(nil, nil, nil, nil).instanceToNodeClaim(context.Background(), &instance.Instance{Name: lo.ToPtr("agentpool1"), Type: lo.ToPtr("Standard_D4s_v3"), Labels: map[string]string{}})
+	assert.Nil(t, nc.Status.Capacity)
+	assert.Nil(t, nc.Status.Allocatable)
+}
+
 func TestDelete(t *testing.T) {
 	testcases := map[string]struct {
 		nodeClaim         *karpenterv1.NodeClaim
@@ -401,10 +500,10 @@ func TestDelete(t *testing.T) {
 
 			// prepare instance provider
 			mockAzClient := instance.NewAZClientFromAPI(agentPoolMocks)
-			instanceProvider := instance.NewProvider(mockAzClient, nil, "testRG", "testCluster")
+			instanceProvider := instance.NewProvider(mockAzClient, nil, "testRG", "testCluster", v1alpha1.AgentPoolTypeVirtualMachineScaleSets, nil, instance.TagOptions{}, instancetype.NewProvider("", nil, 0.4, cache.NewUnavailableOfferings()))
 
 			// create cloud provider and call list function
-			cloudProvider := New(instanceProvider, nil)
+			cloudProvider := New(instanceProvider, nil, nil, nil)
 			err := cloudProvider.Delete(context.Background(), tc.nodeClaim)
 
 			if tc.expectedError != nil {
@@ -435,3 +534,65 @@ func GetAgentPoolObjWithName(apName string, apId string, vmSize string) armconta
 		},
 	}
 }
+
+func TestInstanceToNodeClaimWithSubnets(t *testing.T) {
+	subnetID := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/gpu"
+	podSubnetID := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/pods"
+	cloudProvider := New(nil, nil, nil, nil)
+
+	nodeClaim := cloudProvider.instanceToNodeClaim(context.Background(), &instance.Instance{
+		Name:        lo.ToPtr("agentpool1"),
+		SubnetID:    lo.ToPtr(subnetID),
+		PodSubnetID: lo.ToPtr(podSubnetID),
+		Labels:      map[string]string{},
+	})
+	assert.Equal(t, map[string]string{
+		instance.AnnotationAgentPoolName: "agentpool1",
+		instance.AnnotationSubnetID:      subnetID,
+		instance.AnnotationPodSubnetID:   podSubnetID,
+	}, nodeClaim.Annotations)
+
+	// the subnets are not reported when the subnet of cluster is used.
+	nodeClaim = cloudProvider.instanceToNodeClaim(context.Background(), &instance.Instance{Name: lo.ToPtr("agentpool1"), Labels: map[string]string{}})
+	assert.Equal(t, map[string]string{instance.AnnotationAgentPoolName: "agentpool1"}, nodeClaim.Annotations)
+}
+
+func TestInstanceToNodeClaimWithNodePoolTag(t *testing.T) {
+	cloudProvider := New(nil, nil, nil, nil)
+	nodeClaim := cloudProvider.instanceToNodeClaim(context.Background(), &instance.Instance{
+		Name:   lo.ToPtr("agentpool1"),
+		Tags:   map[string]*string{instance.NodePoolTag: lo.ToPtr("gpu")},
+		Labels: map[string]string{},
+	})
+	assert.Equal(t, "gpu", nodeClaim.Labels[karpenterv1.NodePoolLabelKey])
+}
+
+func TestGetInstanceTypes(t *testing.T) {
+	nodeClass := &v1alpha1.KaitoNodeClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec:       v1alpha1.KaitoNodeClassSpec{MaxPods: lo.ToPtr(int32(30))},
+	}
+	scheme := k8sruntime.NewScheme()
+	assert.NoError(t, v1alpha1.SchemeBuilder.AddToScheme(scheme))
+	kubeClient := crfake.NewClientBuilder().WithScheme(scheme).WithObjects(nodeClass).Build()
+	instanceProvider := instance.NewProvider(instance.NewAZClientFromAPI(nil), kubeClient, "testRG", "testCluster", v1alpha1.AgentPoolTypeVirtualMachineScaleSets,
+		nil, instance.TagOptions{}, instancetype.NewProvider("eastus", nil, 0.4, cache.NewUnavailableOfferings()))
+	cloudProvider := New(instanceProvider, kubeClient, nil, nil)
+
+	nodePool := &karpenterv1.NodePool{ObjectMeta: metav1.ObjectMeta{Name: "gpu"}}
+	nodePool.Spec.Template.Spec.NodeClassRef = &karpenterv1.NodeClassReference{Group: v1alpha1.Group, Kind: "KaitoNodeClass", Name: "default"}
+	instanceTypes, err := cloudProvider.GetInstanceTypes(context.Background(), nodePool)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, instanceTypes)
+	for _, instanceType := range instanceTypes {
+		pods := instanceType.Capacity[v1.ResourcePods]
+		assert.Equal(t, int64(30), pods.Value(), instanceType.Name)
+		assert.True(t, instanceType.Requirements.Has(instancetype.LabelGPUProduct), instanceType.Name)
+	}
+
+	// the default max pods is used when nodepool doesn't reference a KaitoNodeClass.
+	instanceTypes, err = cloudProvider.GetInstanceTypes(context.Background(), &karpenterv1.NodePool{})
+	assert.NoError(t, err)
+	pods := instanceTypes[0].Capacity[v1.ResourcePods]
+	assert.Equal(t, int64(110), pods.Value())
+}
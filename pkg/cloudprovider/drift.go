@@ -0,0 +1,157 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/providers/instance"
+	"k8s.io/apimachinery/pkg/util/version"
+	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+	"sigs.k8s.io/karpenter/pkg/cloudprovider"
+)
+
+const (
+	// NodeClassDrift means the spec of KaitoNodeClass is changed after nodeclaim is launched.
+	NodeClassDrift cloudprovider.DriftReason = "NodeClassDrift"
+	// NodeImageDrift means the node image of nodeclaim is older than the latest one for its OS SKU.
+	NodeImageDrift cloudprovider.DriftReason = "NodeImageDrift"
+	// KubernetesVersionDrift means the minor Kubernetes version of nodeclaim is lower than the control plane.
+	KubernetesVersionDrift cloudprovider.DriftReason = "KubernetesVersionDrift"
+)
+
+const (
+	// ServerVersionCacheTTL is the time before the Kubernetes version of control plane is fetched again for drift
+	// detection.
+	ServerVersionCacheTTL = 10 * time.Minute
+
+	serverVersionCacheKey = "serverVersion"
+)
+
+// isNodeClassDrifted compares the hash of KaitoNodeClass stamped on nodeClaim at creation with the current spec.
+// nodeClaim is not drifted when it's launched before the hash is stamped, or with another version of hash.
+func isNodeClassDrifted(nodeClaim *karpenterv1.NodeClaim, nodeClass *v1alpha1.KaitoNodeClass) cloudprovider.DriftReason {
+	if nodeClass == nil {
+		return ""
+	}
+	hash, ok := nodeClaim.Annotations[v1alpha1.AnnotationKaitoNodeClassHash]
+	if !ok || nodeClaim.Annotations[v1alpha1.AnnotationKaitoNodeClassHashVersion] != v1alpha1.KaitoNodeClassHashVersion {
+		return ""
+	}
+	if hash != nodeClass.Hash() {
+		return NodeClassDrift
+	}
+	return ""
+}
+
+// isNodeImageDrifted returns NodeImageDrift when the node image of agent pool is older than the latest one for its OS
+// SKU. It's not drifted when the node image is newer than the cached latest version, e.g. the latest version is rolled
+// back or the agent pool is upgraded after the latest version is cached, or when the versions can't be compared.
+func isNodeImageDrifted(versions *instance.NodeVersions) cloudprovider.DriftReason {
+	if olderNodeImage(versions.NodeImageVersion, versions.LatestNodeImageVersion) {
+		return NodeImageDrift
+	}
+	return ""
+}
+
+// olderNodeImage returns true when node image version current is older than latest, the versions are in the format
+// of <node image>-<version> such as AKSUbuntu-2204gen2containerd-202405.03.0. false is returned when they're versions
+// of different node images or can't be parsed.
+func olderNodeImage(current, latest string) bool {
+	currentIndex, latestIndex := strings.LastIndex(current, "-"), strings.LastIndex(latest, "-")
+	if currentIndex <= 0 || latestIndex <= 0 || !strings.EqualFold(current[:currentIndex], latest[:latestIndex]) {
+		return false
+	}
+	currentVersion, err := version.ParseGeneric(current[currentIndex+1:])
+	if err != nil {
+		return false
+	}
+	latestVersion, err := version.ParseGeneric(latest[latestIndex+1:])
+	if err != nil {
+		return false
+	}
+	return currentVersion.LessThan(latestVersion)
+}
+
+// isKubernetesVersionDrifted returns KubernetesVersionDrift when the minor version of agent pool lags the control
+// plane, patch versions are not compared because AKS upgrades them in place.
+func isKubernetesVersionDrifted(versions *instance.NodeVersions, controlPlaneVersion string) (cloudprovider.DriftReason, error) {
+	if versions.KubernetesVersion == "" {
+		return "", nil
+	}
+	nodeVersion, err := version.ParseGeneric(versions.KubernetesVersion)
+	if err != nil {
+		return "", fmt.Errorf("parsing kubernetes version of agent pool, %w", err)
+	}
+	serverVersion, err := version.ParseGeneric(controlPlaneVersion)
+	if err != nil {
+		return "", fmt.Errorf("parsing kubernetes version of control plane, %w", err)
+	}
+	if nodeVersion.Major() < serverVersion.Major() ||
+		(nodeVersion.Major() == serverVersion.Major() && nodeVersion.Minor() < serverVersion.Minor()) {
+		return KubernetesVersionDrift, nil
+	}
+	return "", nil
+}
+
+// isDrifted checks the drift of nodeClaim in the order of KaitoNodeClass, node image and Kubernetes version, only
+// the first drift reason is returned.
+func (c *CloudProvider) isDrifted(ctx context.Context, nodeClaim *karpenterv1.NodeClaim) (cloudprovider.DriftReason, error) {
+	nodeClass, err := c.resolveNodeClass(ctx, nodeClaim.Spec.NodeClassRef)
+	if err != nil {
+		return "", err
+	}
+	if reason := isNodeClassDrifted(nodeClaim, nodeClass); reason != "" {
+		return reason, nil
+	}
+
+	versions, err := c.instanceProvider.GetNodeVersions(ctx, nodeClaim)
+	if err != nil {
+		if cloudprovider.IsNodeClaimNotFoundError(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if reason := isNodeImageDrifted(versions); reason != "" {
+		return reason, nil
+	}
+
+	if c.versionClient == nil {
+		return "", nil
+	}
+	serverVersion, err := c.serverVersion()
+	if err != nil {
+		return "", err
+	}
+	return isKubernetesVersionDrifted(versions, serverVersion)
+}
+
+// serverVersion returns the Kubernetes version of control plane, it's cached for ServerVersionCacheTTL.
+func (c *CloudProvider) serverVersion() (string, error) {
+	if serverVersion, ok := c.serverVersions.Get(serverVersionCacheKey); ok {
+		return serverVersion.(string), nil
+	}
+	info, err := c.versionClient.ServerVersion()
+	if err != nil {
+		return "", fmt.Errorf("getting kubernetes version of control plane, %w", err)
+	}
+	c.serverVersions.SetDefault(serverVersionCacheKey, info.GitVersion)
+	return info.GitVersion, nil
+}
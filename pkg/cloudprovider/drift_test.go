@@ -0,0 +1,304 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	azruntime "github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v4"
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/cache"
+	"github.com/azure/gpu-provisioner/pkg/fake"
+	"github.com/azure/gpu-provisioner/pkg/providers/instance"
+	"github.com/azure/gpu-provisioner/pkg/providers/instancetype"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/version"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+	"sigs.k8s.io/karpenter/pkg/cloudprovider"
+)
+
+type fakeVersionClient struct {
+	gitVersion string
+	calls      int
+}
+
+func (f *fakeVersionClient) ServerVersion() (*version.Info, error) {
+	f.calls++
+	return &version.Info{GitVersion: f.gitVersion}, nil
+}
+
+func TestIsDrifted(t *testing.T) {
+	nodeClass := &v1alpha1.KaitoNodeClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec:       v1alpha1.KaitoNodeClassSpec{OSSKU: "Ubuntu", MaxPods: lo.ToPtr(int32(50))},
+	}
+	latestImage := "AKSUbuntu-2204gen2containerd-202410.15.0"
+
+	testCases := []struct {
+		name               string
+		annotations        map[string]string
+		nodeClassSpec      *v1alpha1.KaitoNodeClassSpec
+		agentPool          *armcontainerservice.ManagedClusterAgentPoolProfileProperties
+		listErr            error
+		controlPlane       string
+		expectedReason     cloudprovider.DriftReason
+		expectedError      string
+		skipVersionLookups bool
+	}{
+		{
+			name:        "nodeclaim is not drifted",
+			annotations: map[string]string{v1alpha1.AnnotationKaitoNodeClassHash: nodeClass.Hash(), v1alpha1.AnnotationKaitoNodeClassHashVersion: v1alpha1.KaitoNodeClassHashVersion},
+			agentPool: &armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+				NodeImageVersion:           lo.ToPtr(latestImage),
+				CurrentOrchestratorVersion: lo.ToPtr("1.30.5"),
+			},
+			controlPlane: "v1.30.7",
+		},
+		{
+			name:               "nodeclass drift when spec of nodeclass is changed",
+			annotations:        map[string]string{v1alpha1.AnnotationKaitoNodeClassHash: nodeClass.Hash(), v1alpha1.AnnotationKaitoNodeClassHashVersion: v1alpha1.KaitoNodeClassHashVersion},
+			nodeClassSpec:      &v1alpha1.KaitoNodeClassSpec{OSSKU: "AzureLinux", MaxPods: lo.ToPtr(int32(50))},
+			expectedReason:     NodeClassDrift,
+			skipVersionLookups: true,
+		},
+		{
+			name:          "hash of another version is not compared",
+			annotations:   map[string]string{v1alpha1.AnnotationKaitoNodeClassHash: "12345", v1alpha1.AnnotationKaitoNodeClassHashVersion: "v0"},
+			nodeClassSpec: &v1alpha1.KaitoNodeClassSpec{OSSKU: "AzureLinux"},
+			agentPool: &armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+				NodeImageVersion:           lo.ToPtr(latestImage),
+				CurrentOrchestratorVersion: lo.ToPtr("1.30.5"),
+			},
+			controlPlane: "v1.30.7",
+		},
+		{
+			name: "node image drift when a newer node image is available",
+			agentPool: &armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+				NodeImageVersion:           lo.ToPtr("AKSUbuntu-2204gen2containerd-202405.03.0"),
+				CurrentOrchestratorVersion: lo.ToPtr("1.30.5"),
+			},
+			controlPlane:   "v1.30.7",
+			expectedReason: NodeImageDrift,
+		},
+		{
+			name: "nodeclaim is not drifted when node image is newer than the cached latest one",
+			agentPool: &armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+				NodeImageVersion:           lo.ToPtr("AKSUbuntu-2204gen2containerd-202411.01.0"),
+				CurrentOrchestratorVersion: lo.ToPtr("1.30.5"),
+			},
+			controlPlane: "v1.30.7",
+		},
+		{
+			name: "kubernetes version drift when minor version lags the control plane",
+			agentPool: &armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+				NodeImageVersion:    lo.ToPtr(latestImage),
+				OrchestratorVersion: lo.ToPtr("1.29"),
+			},
+			controlPlane:   "v1.30.7",
+			expectedReason: KubernetesVersionDrift,
+		},
+		{
+			name: "nodeclaim is not drifted when agent pool is not found",
+		},
+		{
+			name:          "fail when agent pools can't be listed",
+			listErr:       &azcore.ResponseError{ErrorCode: "TooManyRequests", StatusCode: http.StatusTooManyRequests},
+			expectedError: "listing agent pools",
+		},
+		{
+			name: "fail with invalid kubernetes version",
+			agentPool: &armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+				NodeImageVersion:           lo.ToPtr(latestImage),
+				CurrentOrchestratorVersion: lo.ToPtr("latest"),
+			},
+			controlPlane:  "v1.30.7",
+			expectedError: "checking drift of nodeclaim(agentpool0), parsing kubernetes version of agent pool",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			agentPoolMocks := fake.NewMockAgentPoolsAPI(mockCtrl)
+			if !tc.skipVersionLookups {
+				var agentPools []*armcontainerservice.AgentPool
+				if tc.agentPool != nil {
+					agentPools = append(agentPools, &armcontainerservice.AgentPool{Name: lo.ToPtr("agentpool0"), Properties: tc.agentPool})
+				}
+				agentPoolMocks.EXPECT().NewListPager("testRG", "testCluster", gomock.Any()).Return(listPager(agentPools, tc.listErr))
+				if tc.agentPool != nil {
+					agentPoolMocks.EXPECT().GetUpgradeProfile(gomock.Any(), "testRG", "testCluster", "agentpool0", gomock.Any()).
+						Return(armcontainerservice.AgentPoolsClientGetUpgradeProfileResponse{AgentPoolUpgradeProfile: armcontainerservice.AgentPoolUpgradeProfile{
+							Properties: &armcontainerservice.AgentPoolUpgradeProfileProperties{LatestNodeImageVersion: lo.ToPtr(latestImage)},
+						}}, nil)
+				}
+			}
+			instanceProvider := instance.NewProvider(instance.NewAZClientFromAPI(agentPoolMocks), nil, "testRG", "testCluster", v1alpha1.AgentPoolTypeVirtualMachineScaleSets, nil, instance.TagOptions{}, instancetype.NewProvider("", nil, 0.4, cache.NewUnavailableOfferings()))
+
+			current := nodeClass.DeepCopy()
+			if tc.nodeClassSpec != nil {
+				current.Spec = *tc.nodeClassSpec
+			}
+			scheme := runtime.NewScheme()
+			assert.NoError(t, v1alpha1.SchemeBuilder.AddToScheme(scheme))
+			kubeClient := crfake.NewClientBuilder().WithScheme(scheme).WithObjects(current).Build()
+
+			nodeClaim := fake.GetNodeClaimObj("agentpool0", map[string]string{}, []v1.Taint{}, karpenterv1.ResourceRequirements{}, nil)
+			nodeClaim.Spec.NodeClassRef = &karpenterv1.NodeClassReference{Group: v1alpha1.Group, Kind: "KaitoNodeClass", Name: "default"}
+			nodeClaim.Annotations = tc.annotations
+
+			cloudProvider := New(instanceProvider, kubeClient, &fakeVersionClient{gitVersion: tc.controlPlane}, nil)
+			reason, err := cloudProvider.IsDrifted(context.Background(), nodeClaim)
+			if tc.expectedError != "" {
+				assert.ErrorContains(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedReason, reason)
+		})
+	}
+}
+
+func TestIsDriftedCachesVersions(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	agentPoolMocks := fake.NewMockAgentPoolsAPI(mockCtrl)
+	// the agent pools of all nodeclaims are listed once.
+	agentPools := lo.Map([]string{"agentpool0", "agentpool1"}, func(apName string, _ int) *armcontainerservice.AgentPool {
+		return &armcontainerservice.AgentPool{Name: lo.ToPtr(apName), Properties: &armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+			OSSKU:                      lo.ToPtr(armcontainerservice.OSSKUUbuntu),
+			NodeImageVersion:           lo.ToPtr("AKSUbuntu-2204gen2containerd-202405.03.0"),
+			CurrentOrchestratorVersion: lo.ToPtr("1.30.5"),
+		}}
+	})
+	agentPoolMocks.EXPECT().NewListPager("testRG", "testCluster", gomock.Any()).Return(listPager(agentPools, nil)).Times(1)
+	// the latest node image of the same OS SKU and node image is only fetched once.
+	agentPoolMocks.EXPECT().GetUpgradeProfile(gomock.Any(), "testRG", "testCluster", "agentpool0", gomock.Any()).
+		Return(armcontainerservice.AgentPoolsClientGetUpgradeProfileResponse{AgentPoolUpgradeProfile: armcontainerservice.AgentPoolUpgradeProfile{
+			Properties: &armcontainerservice.AgentPoolUpgradeProfileProperties{LatestNodeImageVersion: lo.ToPtr("AKSUbuntu-2204gen2containerd-202405.03.0")},
+		}}, nil).Times(1)
+	instanceProvider := instance.NewProvider(instance.NewAZClientFromAPI(agentPoolMocks), nil, "testRG", "testCluster", v1alpha1.AgentPoolTypeVirtualMachineScaleSets, nil, instance.TagOptions{}, instancetype.NewProvider("", nil, 0.4, cache.NewUnavailableOfferings()))
+
+	versionClient := &fakeVersionClient{gitVersion: "v1.31.1"}
+	cloudProvider := New(instanceProvider, crfake.NewClientBuilder().Build(), versionClient, nil)
+	for _, apName := range []string{"agentpool0", "agentpool1"} {
+		nodeClaim := fake.GetNodeClaimObj(apName, map[string]string{}, []v1.Taint{}, karpenterv1.ResourceRequirements{}, nil)
+		nodeClaim.Spec.NodeClassRef = nil
+		reason, err := cloudProvider.IsDrifted(context.Background(), nodeClaim)
+		assert.NoError(t, err)
+		assert.Equal(t, KubernetesVersionDrift, reason)
+	}
+	assert.Equal(t, 1, versionClient.calls, "kubernetes version of control plane should be cached")
+}
+
+func TestOlderNodeImage(t *testing.T) {
+	testCases := []struct {
+		name     string
+		current  string
+		latest   string
+		expected bool
+	}{
+		{
+			name:     "node image of an earlier date is older",
+			current:  "AKSUbuntu-2204gen2containerd-202405.03.0",
+			latest:   "AKSUbuntu-2204gen2containerd-202410.15.0",
+			expected: true,
+		},
+		{
+			name:     "versions are compared numerically",
+			current:  "AKSUbuntu-2204gen2containerd-202410.9.0",
+			latest:   "AKSUbuntu-2204gen2containerd-202410.15.0",
+			expected: true,
+		},
+		{
+			name:    "same node image is not older",
+			current: "AKSUbuntu-2204gen2containerd-202410.15.0",
+			latest:  "AKSUbuntu-2204gen2containerd-202410.15.0",
+		},
+		{
+			name:    "node image newer than the latest one is not older",
+			current: "AKSUbuntu-2204gen2containerd-202410.15.1",
+			latest:  "AKSUbuntu-2204gen2containerd-202410.15.0",
+		},
+		{
+			name:    "versions of different node images are not compared",
+			current: "AKSUbuntu-2204gen2containerd-202405.03.0",
+			latest:  "AKSAzureLinux-V2gen2-202410.15.0",
+		},
+		{
+			name:    "invalid versions are not compared",
+			current: "AKSUbuntu-2204gen2containerd-latest",
+			latest:  "AKSUbuntu-2204gen2containerd-202410.15.0",
+		},
+		{
+			name:   "empty version is not compared",
+			latest: "AKSUbuntu-2204gen2containerd-202410.15.0",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, olderNodeImage(tc.current, tc.latest))
+		})
+	}
+}
+
+func listPager(agentPools []*armcontainerservice.AgentPool, err error) *azruntime.Pager[armcontainerservice.AgentPoolsClientListResponse] {
+	return azruntime.NewPager(azruntime.PagingHandler[armcontainerservice.AgentPoolsClientListResponse]{
+		More: func(page armcontainerservice.AgentPoolsClientListResponse) bool {
+			return false
+		},
+		Fetcher: func(ctx context.Context, page *armcontainerservice.AgentPoolsClientListResponse) (armcontainerservice.AgentPoolsClientListResponse, error) {
+			return armcontainerservice.AgentPoolsClientListResponse{
+				AgentPoolListResult: armcontainerservice.AgentPoolListResult{Value: agentPools},
+			}, err
+		},
+	})
+}
+
+func TestKaitoNodeClassHash(t *testing.T) {
+	nodeClass := &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{Tags: map[string]string{"team": "ml"}}}
+	hash := nodeClass.Hash()
+
+	// the hash is stable and the status is not hashed.
+	updated := nodeClass.DeepCopy()
+	updated.Status.KubernetesVersion = "1.30.7"
+	assert.Equal(t, hash, updated.Hash())
+
+	// unset optional fields don't change the hash.
+	updated.Spec.Kubelet = nil
+	updated.Spec.MaxPods = nil
+	assert.Equal(t, hash, updated.Hash())
+
+	// the tags are not hashed.
+	updated.Spec.Tags["team"] = "infra"
+	assert.Equal(t, hash, updated.Hash())
+
+	updated.Spec.MaxPods = lo.ToPtr(int32(50))
+	assert.NotEqual(t, hash, updated.Hash())
+}
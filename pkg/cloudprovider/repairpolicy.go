@@ -0,0 +1,184 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/karpenter/pkg/cloudprovider"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// repairPoliciesFileEnv is the path of the repair policies file, which is usually mounted from a ConfigMap.
+	repairPoliciesFileEnv = "REPAIR_POLICIES_FILE"
+	// repairPoliciesReloadInterval is the minimum time between the checks of the repair policies file for changes.
+	repairPoliciesReloadInterval = time.Minute
+)
+
+// The node conditions of GPU failures, they're reported by node-problem-detector custom plugins or DCGM health checks.
+const (
+	// ConditionGPUXIDError means the GPU driver reported a fatal XID error, e.g. double bit ECC error(XID 48).
+	ConditionGPUXIDError corev1.NodeConditionType = "GPUXIDError"
+	// ConditionGPUFallenOffBus means a GPU is no longer reachable on the PCIe bus(XID 79), only a reboot recovers it.
+	ConditionGPUFallenOffBus corev1.NodeConditionType = "GPUFallenOffBus"
+	// ConditionNVLinkFault means the NVLink between GPUs is down or reports uncorrectable errors.
+	ConditionNVLinkFault corev1.NodeConditionType = "NVLinkFault"
+)
+
+// DefaultRepairPolicies are used when no repair policies file is configured.
+var DefaultRepairPolicies = []cloudprovider.RepairPolicy{
+	{ConditionType: corev1.NodeReady, ConditionStatus: corev1.ConditionFalse, TolerationDuration: 10 * time.Minute},
+	{ConditionType: corev1.NodeReady, ConditionStatus: corev1.ConditionUnknown, TolerationDuration: 10 * time.Minute},
+	{ConditionType: ConditionGPUXIDError, ConditionStatus: corev1.ConditionTrue, TolerationDuration: 10 * time.Minute},
+	{ConditionType: ConditionGPUFallenOffBus, ConditionStatus: corev1.ConditionTrue, TolerationDuration: 5 * time.Minute},
+	{ConditionType: ConditionNVLinkFault, ConditionStatus: corev1.ConditionTrue, TolerationDuration: 10 * time.Minute},
+}
+
+// repairPolicy is the format of a repair policy in the repair policies file, e.g.
+//
+//   - conditionType: GPUXIDError
+//     conditionStatus: "True"
+//     toleration: 10m
+type repairPolicy struct {
+	ConditionType   corev1.NodeConditionType `json:"conditionType"`
+	ConditionStatus corev1.ConditionStatus   `json:"conditionStatus"`
+	Toleration      metav1.Duration          `json:"toleration"`
+}
+
+// RepairPolicies serves the repair policies of the nodes. The policies loaded from the repair policies file are
+// reloaded when the file is changed, and the last valid policies are kept when the changed file is invalid. The nodes
+// are repaired by the policies only when the NodeRepair feature gate of karpenter is enabled, e.g. FEATURE_GATES=NodeRepair=true.
+type RepairPolicies struct {
+	// path is empty when the default policies are used.
+	path string
+
+	mu           sync.Mutex
+	policies     []cloudprovider.RepairPolicy
+	modTime      time.Time
+	lastChecked  time.Time
+	reloadPeriod time.Duration
+}
+
+// NewRepairPoliciesFromEnv loads the repair policies from the file of REPAIR_POLICIES_FILE, the default policies are
+// used when it's not set. An error is returned when the file can't be read or the policies are invalid.
+func NewRepairPoliciesFromEnv() (*RepairPolicies, error) {
+	path := os.Getenv(repairPoliciesFileEnv)
+	if path == "" {
+		return &RepairPolicies{policies: DefaultRepairPolicies}, nil
+	}
+	return LoadRepairPolicies(path)
+}
+
+// LoadRepairPolicies loads the repair policies from the file of path.
+func LoadRepairPolicies(path string) (*RepairPolicies, error) {
+	r := &RepairPolicies{path: path, reloadPeriod: repairPoliciesReloadInterval}
+	policies, modTime, err := readRepairPolicies(path)
+	if err != nil {
+		return nil, err
+	}
+	r.policies, r.modTime, r.lastChecked = policies, modTime, time.Now()
+	return r, nil
+}
+
+// Get returns the current repair policies, the repair policies file is reloaded when it has been changed.
+func (r *RepairPolicies) Get() []cloudprovider.RepairPolicy {
+	if r == nil {
+		return DefaultRepairPolicies
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.path == "" || time.Since(r.lastChecked) < r.reloadPeriod {
+		return r.policies
+	}
+	r.lastChecked = time.Now()
+
+	// the file mounted from a ConfigMap is replaced through a symlink when the ConfigMap is updated, so the modification
+	// time of the file changes.
+	info, err := os.Stat(r.path)
+	if err != nil {
+		klog.ErrorS(err, "Checking repair policies file failed, keeping the current repair policies", "path", r.path)
+		return r.policies
+	}
+	if info.ModTime().Equal(r.modTime) {
+		return r.policies
+	}
+	policies, modTime, err := readRepairPolicies(r.path)
+	if err != nil {
+		klog.ErrorS(err, "Reloading repair policies failed, keeping the current repair policies", "path", r.path)
+		// the invalid file is not reloaded again until it's changed.
+		r.modTime = info.ModTime()
+		return r.policies
+	}
+	klog.InfoS("Reloaded repair policies", "path", r.path, "policies", len(policies))
+	r.policies, r.modTime = policies, modTime
+	return r.policies
+}
+
+func readRepairPolicies(path string) ([]cloudprovider.RepairPolicy, time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("reading repair policies file, %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("reading repair policies file, %w", err)
+	}
+	policies, err := ParseRepairPolicies(data)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("parsing repair policies file %s, %w", path, err)
+	}
+	return policies, info.ModTime(), nil
+}
+
+// ParseRepairPolicies parses and validates the list of repair policies in YAML or JSON. An empty list disables node
+// repair, the node health controller is not started when there is no repair policy at startup.
+func ParseRepairPolicies(data []byte) ([]cloudprovider.RepairPolicy, error) {
+	var parsed []repairPolicy
+	if err := yaml.UnmarshalStrict(data, &parsed); err != nil {
+		return nil, err
+	}
+	policies := make([]cloudprovider.RepairPolicy, 0, len(parsed))
+	for i, p := range parsed {
+		if p.ConditionType == "" {
+			return nil, fmt.Errorf("conditionType of repair policy %d should not be empty", i)
+		}
+		if !lo.Contains([]corev1.ConditionStatus{corev1.ConditionTrue, corev1.ConditionFalse, corev1.ConditionUnknown}, p.ConditionStatus) {
+			return nil, fmt.Errorf("conditionStatus(%s) of repair policy %d should be True, False or Unknown", p.ConditionStatus, i)
+		}
+		if p.Toleration.Duration <= 0 {
+			return nil, fmt.Errorf("toleration(%s) of repair policy %d should be more than 0", p.Toleration.Duration, i)
+		}
+		if lo.ContainsBy(policies, func(existing cloudprovider.RepairPolicy) bool {
+			return existing.ConditionType == p.ConditionType && existing.ConditionStatus == p.ConditionStatus
+		}) {
+			return nil, fmt.Errorf("repair policy %d of condition %s=%s is duplicated", i, p.ConditionType, p.ConditionStatus)
+		}
+		policies = append(policies, cloudprovider.RepairPolicy{
+			ConditionType:      p.ConditionType,
+			ConditionStatus:    p.ConditionStatus,
+			TolerationDuration: p.Toleration.Duration,
+		})
+	}
+	return policies, nil
+}
@@ -0,0 +1,180 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/karpenter/pkg/cloudprovider"
+)
+
+func TestParseRepairPolicies(t *testing.T) {
+	testCases := []struct {
+		name        string
+		data        string
+		expected    []cloudprovider.RepairPolicy
+		expectedErr string
+	}{
+		{
+			name: "valid policies",
+			data: `
+- conditionType: Ready
+  conditionStatus: "False"
+  toleration: 15m
+- conditionType: GPUXIDError
+  conditionStatus: "True"
+  toleration: 90s
+`,
+			expected: []cloudprovider.RepairPolicy{
+				{ConditionType: v1.NodeReady, ConditionStatus: v1.ConditionFalse, TolerationDuration: 15 * time.Minute},
+				{ConditionType: ConditionGPUXIDError, ConditionStatus: v1.ConditionTrue, TolerationDuration: 90 * time.Second},
+			},
+		},
+		{
+			name:     "empty list disables repair",
+			data:     "[]",
+			expected: []cloudprovider.RepairPolicy{},
+		},
+		{
+			name: "empty condition type",
+			data: `
+- conditionStatus: "True"
+  toleration: 10m
+`,
+			expectedErr: "conditionType of repair policy 0 should not be empty",
+		},
+		{
+			name: "invalid condition status",
+			data: `
+- conditionType: NVLinkFault
+  conditionStatus: "Yes"
+  toleration: 10m
+`,
+			expectedErr: "conditionStatus(Yes) of repair policy 0 should be True, False or Unknown",
+		},
+		{
+			name: "missing toleration",
+			data: `
+- conditionType: NVLinkFault
+  conditionStatus: "True"
+`,
+			expectedErr: "toleration(0s) of repair policy 0 should be more than 0",
+		},
+		{
+			name: "duplicated policies",
+			data: `
+- conditionType: Ready
+  conditionStatus: Unknown
+  toleration: 10m
+- conditionType: Ready
+  conditionStatus: Unknown
+  toleration: 5m
+`,
+			expectedErr: "repair policy 1 of condition Ready=Unknown is duplicated",
+		},
+		{
+			name: "unknown field",
+			data: `
+- conditionType: Ready
+  conditionStatus: Unknown
+  tolerationDuration: 10m
+`,
+			expectedErr: `unknown field "tolerationDuration"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			policies, err := ParseRepairPolicies([]byte(tc.data))
+			if tc.expectedErr != "" {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, policies)
+		})
+	}
+}
+
+func TestNewRepairPoliciesFromEnv(t *testing.T) {
+	t.Setenv(repairPoliciesFileEnv, "")
+	r, err := NewRepairPoliciesFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultRepairPolicies, r.Get())
+	assert.Equal(t, DefaultRepairPolicies, New(nil, nil, nil, nil).RepairPolicies())
+
+	path := filepath.Join(t.TempDir(), "policies.yaml")
+	t.Setenv(repairPoliciesFileEnv, path)
+	_, err = NewRepairPoliciesFromEnv()
+	assert.ErrorContains(t, err, "reading repair policies file")
+
+	assert.NoError(t, os.WriteFile(path, []byte("- conditionType: GPUFallenOffBus\n  conditionStatus: \"True\"\n"), 0600))
+	_, err = NewRepairPoliciesFromEnv()
+	assert.ErrorContains(t, err, "should be more than 0")
+
+	assert.NoError(t, os.WriteFile(path, []byte("- conditionType: GPUFallenOffBus\n  conditionStatus: \"True\"\n  toleration: 1m\n"), 0600))
+	r, err = NewRepairPoliciesFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, []cloudprovider.RepairPolicy{
+		{ConditionType: ConditionGPUFallenOffBus, ConditionStatus: v1.ConditionTrue, TolerationDuration: time.Minute},
+	}, New(nil, nil, nil, r).RepairPolicies())
+}
+
+func TestRepairPoliciesReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies.yaml")
+	writePolicies := func(data string, modTime time.Time) {
+		assert.NoError(t, os.WriteFile(path, []byte(data), 0600))
+		assert.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+	now := time.Now()
+	writePolicies("- conditionType: Ready\n  conditionStatus: \"False\"\n  toleration: 10m\n", now.Add(-time.Hour))
+
+	r, err := LoadRepairPolicies(path)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	initial := []cloudprovider.RepairPolicy{
+		{ConditionType: v1.NodeReady, ConditionStatus: v1.ConditionFalse, TolerationDuration: 10 * time.Minute},
+	}
+	assert.Equal(t, initial, r.Get())
+
+	// the file is not checked again within the reload interval.
+	updated := "- conditionType: NVLinkFault\n  conditionStatus: \"True\"\n  toleration: 3m\n"
+	writePolicies(updated, now.Add(-30*time.Minute))
+	assert.Equal(t, initial, r.Get())
+
+	r.lastChecked = time.Time{}
+	assert.Equal(t, []cloudprovider.RepairPolicy{
+		{ConditionType: ConditionNVLinkFault, ConditionStatus: v1.ConditionTrue, TolerationDuration: 3 * time.Minute},
+	}, r.Get())
+
+	// the last valid policies are kept when the changed file is invalid.
+	writePolicies("- conditionType: NVLinkFault\n  conditionStatus: Maybe\n  toleration: 3m\n", now.Add(-20*time.Minute))
+	r.lastChecked = time.Time{}
+	assert.Equal(t, []cloudprovider.RepairPolicy{
+		{ConditionType: ConditionNVLinkFault, ConditionStatus: v1.ConditionTrue, TolerationDuration: 3 * time.Minute},
+	}, r.Get())
+
+	// an empty list disables the repair after reloading.
+	writePolicies("[]", now.Add(-10*time.Minute))
+	r.lastChecked = time.Time{}
+	assert.Empty(t, r.Get())
+}
@@ -19,13 +19,25 @@ package controllers
 import (
 	"github.com/awslabs/operatorpkg/controller"
 	instancegarbagecollection "github.com/azure/gpu-provisioner/pkg/controllers/instance/garbagecollection"
+	instancewarmpool "github.com/azure/gpu-provisioner/pkg/controllers/instance/warmpool"
+	nodeclassmigration "github.com/azure/gpu-provisioner/pkg/controllers/nodeclass/migration"
+	nodeclassstatus "github.com/azure/gpu-provisioner/pkg/controllers/nodeclass/status"
+	nodeclasstermination "github.com/azure/gpu-provisioner/pkg/controllers/nodeclass/termination"
+	"github.com/azure/gpu-provisioner/pkg/operator"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/karpenter/pkg/cloudprovider"
 )
 
-func NewControllers(kubeClient client.Client, cloudProvider cloudprovider.CloudProvider) []controller.Controller {
+func NewControllers(kubeClient client.Client, cloudProvider cloudprovider.CloudProvider, op *operator.Operator) []controller.Controller {
 	controllers := []controller.Controller{
 		instancegarbagecollection.NewController(kubeClient, cloudProvider),
+		nodeclassstatus.NewController(kubeClient, op.KubernetesInterface.Discovery(), op.SubnetsClient),
+		nodeclasstermination.NewController(kubeClient, op.EventRecorder),
+		nodeclassmigration.NewController(kubeClient, op.GetAPIReader()),
+	}
+	// the warm pool controller lists the agent pools periodically, so it's registered only when warm pools are configured.
+	if len(op.AzConfig.WarmPools) > 0 {
+		controllers = append(controllers, instancewarmpool.NewController(op.InstanceProvider, op.AzConfig.WarmPools, op.AzConfig.WarmPoolOSDiskSizeGB))
 	}
 	return controllers
 }
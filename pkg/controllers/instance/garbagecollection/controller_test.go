@@ -24,9 +24,12 @@ import (
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v4"
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/cache"
 	"github.com/azure/gpu-provisioner/pkg/cloudprovider"
 	"github.com/azure/gpu-provisioner/pkg/fake"
 	"github.com/azure/gpu-provisioner/pkg/providers/instance"
+	"github.com/azure/gpu-provisioner/pkg/providers/instancetype"
 	"github.com/samber/lo"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/mock/gomock"
@@ -38,6 +41,11 @@ import (
 	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
 )
 
+func init() {
+	// karpenter lists nodeclaims of the supported nodeclasses, so KaitoNodeClass should be registered as main does
+	v1alpha1.SchemeBuilder.AddToScheme(scheme.Scheme)
+}
+
 func TestReconcile(t *testing.T) {
 	testcases := map[string]struct {
 		nodeClaims              []*karpenterv1.NodeClaim
@@ -75,7 +83,7 @@ func TestReconcile(t *testing.T) {
 			mockListAgentPoolResp: func(nodeClaims []*karpenterv1.NodeClaim) *runtime.Pager[armcontainerservice.AgentPoolsClientListResponse] {
 				var agentPools []*armcontainerservice.AgentPool
 				for i := range nodeClaims {
-					ap := fake.CreateAgentPoolObjWithNodeClaim(nodeClaims[i])
+					ap := createAgentPoolObjWithNodeClaim(nodeClaims[i])
 					agentPools = append(agentPools, &ap)
 				}
 				return runtime.NewPager(runtime.PagingHandler[armcontainerservice.AgentPoolsClientListResponse]{
@@ -137,7 +145,62 @@ func TestReconcile(t *testing.T) {
 			mockListAgentPoolResp: func(nodeClaims []*karpenterv1.NodeClaim) *runtime.Pager[armcontainerservice.AgentPoolsClientListResponse] {
 				var agentPools []*armcontainerservice.AgentPool
 				for i := range nodeClaims {
-					ap := fake.CreateAgentPoolObjWithNodeClaim(nodeClaims[i])
+					ap := createAgentPoolObjWithNodeClaim(nodeClaims[i])
+					agentPools = append(agentPools, &ap)
+				}
+				return runtime.NewPager(runtime.PagingHandler[armcontainerservice.AgentPoolsClientListResponse]{
+					More: func(page armcontainerservice.AgentPoolsClientListResponse) bool {
+						return false
+					},
+					Fetcher: func(ctx context.Context, page *armcontainerservice.AgentPoolsClientListResponse) (armcontainerservice.AgentPoolsClientListResponse, error) {
+						return armcontainerservice.AgentPoolsClientListResponse{
+							AgentPoolListResult: armcontainerservice.AgentPoolListResult{
+								Value: agentPools,
+							},
+						}, nil
+					},
+				})
+			},
+			mockDeleteAgentPoolResp: func(mockHandler *fake.MockPollingHandler[armcontainerservice.AgentPoolsClientDeleteResponse]) (*runtime.Poller[armcontainerservice.AgentPoolsClientDeleteResponse], error) {
+				delResp := armcontainerservice.AgentPoolsClientDeleteResponse{}
+				resp := http.Response{Status: "200 OK", StatusCode: http.StatusOK, Body: http.NoBody}
+
+				mockHandler.EXPECT().Done().Return(true).Times(3)
+				mockHandler.EXPECT().Result(gomock.Any(), gomock.Any()).Return(nil)
+
+				pollingOptions := &runtime.NewPollerOptions[armcontainerservice.AgentPoolsClientDeleteResponse]{
+					Handler:  mockHandler,
+					Response: &delResp,
+				}
+
+				p, err := runtime.NewPoller(&resp, runtime.NewPipeline("", "", runtime.PipelineOptions{}, nil), pollingOptions)
+				return p, err
+			},
+			expectedError: nil,
+		},
+		"garbage collection leaked instance of nodeclaim whose name is not a valid agent pool name": {
+			nodeClaims: []*karpenterv1.NodeClaim{
+				fake.GetNodeClaimObj("kaito-workspace-1", map[string]string{"test": "test"}, []v1.Taint{}, karpenterv1.ResourceRequirements{}, []v1.NodeSelectorRequirement{
+					{
+						Key:      "node.kubernetes.io/instance-type",
+						Operator: "In",
+						Values:   []string{"Standard_NC6s_v3"},
+					},
+				}),
+			},
+			leakedNodeClaims: []*karpenterv1.NodeClaim{
+				fake.GetNodeClaimObjWithoutProviderID("kaito-workspace-2", map[string]string{"test": "test"}, []v1.Taint{}, karpenterv1.ResourceRequirements{}, []v1.NodeSelectorRequirement{
+					{
+						Key:      "node.kubernetes.io/instance-type",
+						Operator: "In",
+						Values:   []string{"Standard_NC6s_v3"},
+					},
+				}),
+			},
+			mockListAgentPoolResp: func(nodeClaims []*karpenterv1.NodeClaim) *runtime.Pager[armcontainerservice.AgentPoolsClientListResponse] {
+				var agentPools []*armcontainerservice.AgentPool
+				for i := range nodeClaims {
+					ap := createAgentPoolObjWithNodeClaim(nodeClaims[i])
 					agentPools = append(agentPools, &ap)
 				}
 				return runtime.NewPager(runtime.PagingHandler[armcontainerservice.AgentPoolsClientListResponse]{
@@ -199,7 +262,7 @@ func TestReconcile(t *testing.T) {
 			mockListAgentPoolResp: func(nodeClaims []*karpenterv1.NodeClaim) *runtime.Pager[armcontainerservice.AgentPoolsClientListResponse] {
 				var agentPools []*armcontainerservice.AgentPool
 				for i := range nodeClaims {
-					ap := fake.CreateAgentPoolObjWithNodeClaim(nodeClaims[i])
+					ap := createAgentPoolObjWithNodeClaim(nodeClaims[i])
 					agentPools = append(agentPools, &ap)
 				}
 				return runtime.NewPager(runtime.PagingHandler[armcontainerservice.AgentPoolsClientListResponse]{
@@ -220,6 +283,77 @@ func TestReconcile(t *testing.T) {
 			},
 			expectedError: errors.New("internal server error"),
 		},
+		"warm agent pools are not garbage collected": {
+			nodeClaims: []*karpenterv1.NodeClaim{
+				fake.GetNodeClaimObj("agentpool1", map[string]string{"test": "test"}, []v1.Taint{}, karpenterv1.ResourceRequirements{}, []v1.NodeSelectorRequirement{
+					{
+						Key:      "node.kubernetes.io/instance-type",
+						Operator: "In",
+						Values:   []string{"Standard_NC6s_v3"},
+					},
+				}),
+			},
+			mockListAgentPoolResp: func(nodeClaims []*karpenterv1.NodeClaim) *runtime.Pager[armcontainerservice.AgentPoolsClientListResponse] {
+				var agentPools []*armcontainerservice.AgentPool
+				for i := range nodeClaims {
+					ap := createAgentPoolObjWithNodeClaim(nodeClaims[i])
+					agentPools = append(agentPools, &ap)
+				}
+				agentPools = append(agentPools, &armcontainerservice.AgentPool{
+					Name: lo.ToPtr("wabcdefghijk"),
+					Properties: &armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+						VMSize:            lo.ToPtr("Standard_NC6s_v3"),
+						ProvisioningState: lo.ToPtr("Succeeded"),
+						NodeLabels:        map[string]*string{instance.LabelWarmPool: lo.ToPtr("Standard_NC6s_v3")},
+						Tags:              map[string]*string{instance.WarmPoolTag: lo.ToPtr("Standard_NC6s_v3")},
+					},
+				})
+				return runtime.NewPager(runtime.PagingHandler[armcontainerservice.AgentPoolsClientListResponse]{
+					More: func(page armcontainerservice.AgentPoolsClientListResponse) bool {
+						return false
+					},
+					Fetcher: func(ctx context.Context, page *armcontainerservice.AgentPoolsClientListResponse) (armcontainerservice.AgentPoolsClientListResponse, error) {
+						return armcontainerservice.AgentPoolsClientListResponse{
+							AgentPoolListResult: armcontainerservice.AgentPoolListResult{
+								Value: agentPools,
+							},
+						}, nil
+					},
+				})
+			},
+			expectedError: nil,
+		},
+		"warm agent pools are not garbage collected without any nodeclaim": {
+			mockListAgentPoolResp: func(nodeClaims []*karpenterv1.NodeClaim) *runtime.Pager[armcontainerservice.AgentPoolsClientListResponse] {
+				var agentPools []*armcontainerservice.AgentPool
+				for i := range nodeClaims {
+					ap := createAgentPoolObjWithNodeClaim(nodeClaims[i])
+					agentPools = append(agentPools, &ap)
+				}
+				agentPools = append(agentPools, &armcontainerservice.AgentPool{
+					Name: lo.ToPtr("wabcdefghijk"),
+					Properties: &armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+						VMSize:            lo.ToPtr("Standard_NC6s_v3"),
+						ProvisioningState: lo.ToPtr("Succeeded"),
+						NodeLabels:        map[string]*string{instance.LabelWarmPool: lo.ToPtr("Standard_NC6s_v3")},
+						Tags:              map[string]*string{instance.WarmPoolTag: lo.ToPtr("Standard_NC6s_v3")},
+					},
+				})
+				return runtime.NewPager(runtime.PagingHandler[armcontainerservice.AgentPoolsClientListResponse]{
+					More: func(page armcontainerservice.AgentPoolsClientListResponse) bool {
+						return false
+					},
+					Fetcher: func(ctx context.Context, page *armcontainerservice.AgentPoolsClientListResponse) (armcontainerservice.AgentPoolsClientListResponse, error) {
+						return armcontainerservice.AgentPoolsClientListResponse{
+							AgentPoolListResult: armcontainerservice.AgentPoolListResult{
+								Value: agentPools,
+							},
+						}, nil
+					},
+				})
+			},
+			expectedError: nil,
+		},
 	}
 
 	for k, tc := range testcases {
@@ -235,9 +369,14 @@ func TestReconcile(t *testing.T) {
 			}
 
 			if tc.mockDeleteAgentPoolResp != nil {
+				for _, nc := range tc.leakedNodeClaims {
+					ap := createAgentPoolObjWithNodeClaim(nc)
+					ap.Properties.ProvisioningState = lo.ToPtr("Succeeded")
+					agentPoolMocks.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any(), instance.AgentPoolName(nc.Name), gomock.Any()).Return(armcontainerservice.AgentPoolsClientGetResponse{AgentPool: ap}, nil)
+				}
 				mockHandler := fake.NewMockPollingHandler[armcontainerservice.AgentPoolsClientDeleteResponse](mockCtrl)
 				resp, err := tc.mockDeleteAgentPoolResp(mockHandler)
-				agentPoolMocks.EXPECT().BeginDelete(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(resp, err)
+				agentPoolMocks.EXPECT().BeginDelete(gomock.Any(), gomock.Any(), gomock.Any(), instance.AgentPoolName(tc.leakedNodeClaims[0].Name), gomock.Any()).Return(resp, err)
 			}
 
 			// prepare kubeclient
@@ -262,10 +401,10 @@ func TestReconcile(t *testing.T) {
 
 			// prepare instance provider
 			mockAzClient := instance.NewAZClientFromAPI(agentPoolMocks)
-			instanceProvider := instance.NewProvider(mockAzClient, fakeClient, "testRG", "testCluster")
+			instanceProvider := instance.NewProvider(mockAzClient, fakeClient, "testRG", "testCluster", v1alpha1.AgentPoolTypeVirtualMachineScaleSets, nil, instance.TagOptions{}, instancetype.NewProvider("", nil, 0.4, cache.NewUnavailableOfferings()))
 
 			// create cloud provider
-			cloudProvider := cloudprovider.New(instanceProvider, nil)
+			cloudProvider := cloudprovider.New(instanceProvider, nil, nil, nil)
 
 			// create garbage collection controller
 			c := NewController(fakeClient, cloudProvider)
@@ -279,3 +418,11 @@ func TestReconcile(t *testing.T) {
 		})
 	}
 }
+
+// createAgentPoolObjWithNodeClaim returns the agent pool which is named and tagged by instance provider for nodeclaim.
+func createAgentPoolObjWithNodeClaim(nc *karpenterv1.NodeClaim) armcontainerservice.AgentPool {
+	ap := fake.CreateAgentPoolObjWithNodeClaim(nc)
+	ap.Name = lo.ToPtr(instance.AgentPoolName(nc.Name))
+	ap.Properties.Tags = map[string]*string{instance.NodeClaimNameTag: lo.ToPtr(nc.Name)}
+	return ap
+}
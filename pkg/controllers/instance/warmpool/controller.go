@@ -0,0 +1,107 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package warmpool
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/awslabs/operatorpkg/reconciler"
+	"github.com/awslabs/operatorpkg/singleton"
+	"github.com/azure/gpu-provisioner/pkg/providers/instance"
+	"github.com/samber/lo"
+	"go.uber.org/multierr"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/karpenter/pkg/cloudprovider"
+	"sigs.k8s.io/karpenter/pkg/operator/injection"
+)
+
+// Controller keeps the configured number of warm standby agent pools for each vm size. warm agent pools bound to
+// nodeclaims are replenished, failed ones are replaced, and the surplus ones are removed.
+type Controller struct {
+	instanceProvider *instance.Provider
+	warmPools        map[string]int
+	osDiskSizeGB     int32
+}
+
+func NewController(instanceProvider *instance.Provider, warmPools map[string]int, osDiskSizeGB int32) *Controller {
+	return &Controller{
+		instanceProvider: instanceProvider,
+		warmPools:        lo.MapKeys(warmPools, func(_ int, vmSize string) string { return strings.ToLower(vmSize) }),
+		osDiskSizeGB:     osDiskSizeGB,
+	}
+}
+
+func (c *Controller) Reconcile(ctx context.Context) (reconciler.Result, error) {
+	ctx = injection.WithControllerName(ctx, "instance.warmpool")
+
+	warmInstances, err := c.instanceProvider.ListWarmPools(ctx)
+	if err != nil {
+		return reconciler.Result{}, err
+	}
+	instancesByVMSize := lo.GroupBy(warmInstances, func(ins *instance.Instance) string {
+		return strings.ToLower(lo.FromPtr(ins.Type))
+	})
+
+	var errs []error
+	available := map[string]int{}
+	for vmSize, instances := range instancesByVMSize {
+		for _, ins := range instances {
+			switch lo.FromPtr(ins.State) {
+			case "Deleting":
+				continue
+			case "Failed":
+				// failed warm agent pool is replaced by a new one.
+			default:
+				if available[vmSize] < c.warmPools[vmSize] {
+					available[vmSize]++
+					continue
+				}
+				// surplus warm agent pool is removed after the desired count is decreased.
+			}
+			if err := c.instanceProvider.DeleteWarmPool(ctx, lo.FromPtr(ins.Name)); cloudprovider.IgnoreNodeClaimNotFoundError(err) != nil {
+				log.FromContext(ctx).Error(err, "failed to delete warm agent pool", "agentpool", lo.FromPtr(ins.Name))
+				errs = append(errs, err)
+				continue
+			}
+			log.FromContext(ctx).Info("delete warm agent pool successfully", "agentpool", lo.FromPtr(ins.Name), "state", lo.FromPtr(ins.State))
+		}
+	}
+
+	for vmSize, count := range c.warmPools {
+		for i := available[vmSize]; i < count; i++ {
+			apName, err := c.instanceProvider.CreateWarmPool(ctx, vmSize, c.osDiskSizeGB)
+			if err != nil {
+				log.FromContext(ctx).Error(err, "failed to create warm agent pool", "vmSize", vmSize)
+				errs = append(errs, err)
+				break
+			}
+			log.FromContext(ctx).Info("start creating warm agent pool", "agentpool", apName, "vmSize", vmSize)
+		}
+	}
+
+	return reconciler.Result{RequeueAfter: time.Minute}, multierr.Combine(errs...)
+}
+
+func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	return controllerruntime.NewControllerManagedBy(m).
+		Named("instance.warmpool").
+		WatchesRawSource(singleton.Source()).
+		Complete(singleton.AsReconciler(c))
+}
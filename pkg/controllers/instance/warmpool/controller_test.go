@@ -0,0 +1,173 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package warmpool
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v4"
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/cache"
+	"github.com/azure/gpu-provisioner/pkg/fake"
+	"github.com/azure/gpu-provisioner/pkg/providers/instance"
+	"github.com/azure/gpu-provisioner/pkg/providers/instancetype"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestReconcile(t *testing.T) {
+	testcases := map[string]struct {
+		warmPools       map[string]int
+		agentPools      []*armcontainerservice.AgentPool
+		createErr       error
+		expectedCreates map[string]int
+		expectedDeletes []string
+		expectedError   string
+	}{
+		"create warm agent pools up to the configured count": {
+			warmPools: map[string]int{"Standard_NC6s_v3": 2, "Standard_NC24ads_A100_v4": 1},
+			agentPools: []*armcontainerservice.AgentPool{
+				warmAgentPool("wpool1", "Standard_NC6s_v3", "Succeeded"),
+			},
+			expectedCreates: map[string]int{"Standard_NC6s_v3": 1, "Standard_NC24ads_A100_v4": 1},
+		},
+		"creating warm agent pools are counted": {
+			warmPools: map[string]int{"Standard_NC6s_v3": 1},
+			agentPools: []*armcontainerservice.AgentPool{
+				warmAgentPool("wpool1", "Standard_NC6s_v3", "Creating"),
+			},
+		},
+		"warm agent pools bound to nodeclaims are replenished": {
+			warmPools: map[string]int{"Standard_NC6s_v3": 1},
+			agentPools: []*armcontainerservice.AgentPool{
+				boundAgentPool("wpool1", "Standard_NC6s_v3", "workspace-0"),
+			},
+			expectedCreates: map[string]int{"Standard_NC6s_v3": 1},
+		},
+		"failed warm agent pool is replaced": {
+			warmPools: map[string]int{"Standard_NC6s_v3": 1},
+			agentPools: []*armcontainerservice.AgentPool{
+				warmAgentPool("wpool1", "Standard_NC6s_v3", "Failed"),
+				warmAgentPool("wpool2", "Standard_NC6s_v3", "Deleting"),
+			},
+			expectedCreates: map[string]int{"Standard_NC6s_v3": 1},
+			expectedDeletes: []string{"wpool1"},
+		},
+		"surplus warm agent pools are removed": {
+			warmPools: map[string]int{"Standard_NC6s_v3": 1},
+			agentPools: []*armcontainerservice.AgentPool{
+				warmAgentPool("wpool1", "Standard_NC6s_v3", "Succeeded"),
+				warmAgentPool("wpool2", "Standard_NC6s_v3", "Succeeded"),
+				warmAgentPool("wpool3", "Standard_NC24ads_A100_v4", "Succeeded"),
+			},
+			expectedDeletes: []string{"wpool2", "wpool3"},
+		},
+		"fail to create warm agent pool": {
+			warmPools:       map[string]int{"Standard_NC6s_v3": 2},
+			createErr:       errors.New("quota exceeded"),
+			expectedCreates: map[string]int{"Standard_NC6s_v3": 1},
+			expectedError:   "quota exceeded",
+		},
+	}
+
+	for k, tc := range testcases {
+		t.Run(k, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			agentPoolMocks := fake.NewMockAgentPoolsAPI(mockCtrl)
+			agentPoolMocks.EXPECT().NewListPager(gomock.Any(), gomock.Any(), gomock.Any()).Return(listPager(tc.agentPools))
+
+			for vmSize, count := range tc.expectedCreates {
+				matchWarmPool := gomock.Cond(func(x any) bool {
+					ap := x.(armcontainerservice.AgentPool)
+					return lo.FromPtr(ap.Properties.VMSize) == vmSize && lo.FromPtr(ap.Properties.Tags[instance.WarmPoolTag]) == vmSize &&
+						lo.FromPtr(ap.Properties.OSDiskSizeGB) == 256
+				})
+				agentPoolMocks.EXPECT().BeginCreateOrUpdate(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), matchWarmPool, gomock.Any()).Return(nil, tc.createErr).Times(count)
+			}
+
+			for _, apName := range tc.expectedDeletes {
+				ap, _ := lo.Find(tc.agentPools, func(ap *armcontainerservice.AgentPool) bool { return lo.FromPtr(ap.Name) == apName })
+				agentPoolMocks.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any(), apName, gomock.Any()).Return(armcontainerservice.AgentPoolsClientGetResponse{AgentPool: *ap}, nil).AnyTimes()
+				agentPoolMocks.EXPECT().BeginDelete(gomock.Any(), gomock.Any(), gomock.Any(), apName, gomock.Any()).Return(deletePoller(t, mockCtrl), nil)
+			}
+
+			instanceProvider := instance.NewProvider(instance.NewAZClientFromAPI(agentPoolMocks), nil, "testRG", "testCluster", v1alpha1.AgentPoolTypeVirtualMachineScaleSets, tc.warmPools, instance.TagOptions{}, instancetype.NewProvider("", nil, 0.4, cache.NewUnavailableOfferings()))
+			c := NewController(instanceProvider, tc.warmPools, 256)
+			_, err := c.Reconcile(context.Background())
+
+			if tc.expectedError != "" {
+				assert.ErrorContains(t, err, tc.expectedError)
+			} else {
+				assert.NoError(t, err, "expect no error but got one")
+			}
+		})
+	}
+}
+
+func warmAgentPool(name, vmSize, state string) *armcontainerservice.AgentPool {
+	return &armcontainerservice.AgentPool{
+		Name: lo.ToPtr(name),
+		Properties: &armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+			VMSize:            lo.ToPtr(vmSize),
+			ProvisioningState: lo.ToPtr(state),
+			NodeLabels:        map[string]*string{instance.LabelWarmPool: lo.ToPtr(vmSize)},
+			Tags:              map[string]*string{instance.WarmPoolTag: lo.ToPtr(vmSize)},
+		},
+	}
+}
+
+func boundAgentPool(name, vmSize, nodeClaimName string) *armcontainerservice.AgentPool {
+	ap := warmAgentPool(name, vmSize, "Succeeded")
+	ap.Properties.NodeLabels = map[string]*string{"kaito.sh/workspace": lo.ToPtr("workspace")}
+	ap.Properties.Tags = map[string]*string{instance.NodeClaimNameTag: lo.ToPtr(nodeClaimName)}
+	return ap
+}
+
+func listPager(agentPools []*armcontainerservice.AgentPool) *runtime.Pager[armcontainerservice.AgentPoolsClientListResponse] {
+	return runtime.NewPager(runtime.PagingHandler[armcontainerservice.AgentPoolsClientListResponse]{
+		More: func(page armcontainerservice.AgentPoolsClientListResponse) bool {
+			return false
+		},
+		Fetcher: func(ctx context.Context, page *armcontainerservice.AgentPoolsClientListResponse) (armcontainerservice.AgentPoolsClientListResponse, error) {
+			return armcontainerservice.AgentPoolsClientListResponse{
+				AgentPoolListResult: armcontainerservice.AgentPoolListResult{
+					Value: agentPools,
+				},
+			}, nil
+		},
+	})
+}
+
+func deletePoller(t *testing.T, mockCtrl *gomock.Controller) *runtime.Poller[armcontainerservice.AgentPoolsClientDeleteResponse] {
+	mockHandler := fake.NewMockPollingHandler[armcontainerservice.AgentPoolsClientDeleteResponse](mockCtrl)
+	mockHandler.EXPECT().Done().Return(true).Times(3)
+	mockHandler.EXPECT().Result(gomock.Any(), gomock.Any()).Return(nil)
+
+	resp := http.Response{Status: "200 OK", StatusCode: http.StatusOK, Body: http.NoBody}
+	p, err := runtime.NewPoller(&resp, runtime.NewPipeline("", "", runtime.PipelineOptions{}, nil), &runtime.NewPollerOptions[armcontainerservice.AgentPoolsClientDeleteResponse]{
+		Handler:  mockHandler,
+		Response: &armcontainerservice.AgentPoolsClientDeleteResponse{},
+	})
+	assert.NoError(t, err)
+	return p
+}
@@ -0,0 +1,122 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/awslabs/operatorpkg/reconciler"
+	"github.com/awslabs/operatorpkg/singleton"
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/apis/v1beta1"
+	"github.com/samber/lo"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/karpenter/pkg/operator/injection"
+)
+
+const (
+	// CRDName is the name of the KaitoNodeClass CRD.
+	CRDName = "kaitonodeclasses.kaito.sh"
+
+	// AnnotationStorageVersion records the version which the KaitoNodeClass is rewritten at, the annotation is
+	// patched to make the API server store the KaitoNodeClass at the storage version again.
+	AnnotationStorageVersion = v1alpha1.Group + "/storage-version"
+
+	// checkInterval is the interval of reading the CRD, the CRD may be installed or upgraded after gpu-provisioner.
+	checkInterval = time.Minute * 10
+)
+
+// Controller migrates the KaitoNodeClasses stored at v1alpha1 to the storage version v1beta1, and then removes v1alpha1
+// from the stored versions of the CRD, so v1alpha1 can be dropped from the CRD later. The CRD is read by apiReader
+// periodically instead of being watched, so the CRDs of the cluster are not cached.
+type Controller struct {
+	kubeClient client.Client
+	apiReader  client.Reader
+}
+
+func NewController(kubeClient client.Client, apiReader client.Reader) *Controller {
+	return &Controller{
+		kubeClient: kubeClient,
+		apiReader:  apiReader,
+	}
+}
+
+func (c *Controller) Reconcile(ctx context.Context) (reconciler.Result, error) {
+	ctx = injection.WithControllerName(ctx, "nodeclass.migration")
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	if err := c.apiReader.Get(ctx, client.ObjectKey{Name: CRDName}, crd); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			return reconciler.Result{}, fmt.Errorf("getting crd(%s), %w", CRDName, err)
+		}
+		return reconciler.Result{RequeueAfter: checkInterval}, nil
+	}
+	// the objects are only stored at v1beta1 after the CRD is upgraded.
+	if storageVersion(crd) != v1beta1.SchemeGroupVersion.Version {
+		return reconciler.Result{RequeueAfter: checkInterval}, nil
+	}
+	if len(crd.Status.StoredVersions) == 1 && crd.Status.StoredVersions[0] == v1beta1.SchemeGroupVersion.Version {
+		return reconciler.Result{RequeueAfter: checkInterval}, nil
+	}
+
+	nodeClassList := &v1alpha1.KaitoNodeClassList{}
+	if err := c.kubeClient.List(ctx, nodeClassList); err != nil {
+		return reconciler.Result{}, fmt.Errorf("listing kaitonodeclasses, %w", err)
+	}
+	for i := range nodeClassList.Items {
+		if err := c.migrate(ctx, &nodeClassList.Items[i]); err != nil {
+			return reconciler.Result{}, err
+		}
+	}
+
+	stored := crd.DeepCopy()
+	crd.Status.StoredVersions = []string{v1beta1.SchemeGroupVersion.Version}
+	if err := c.kubeClient.Status().Patch(ctx, crd, client.MergeFromWithOptions(stored, client.MergeFromWithOptimisticLock{})); err != nil {
+		return reconciler.Result{}, fmt.Errorf("patching stored versions of crd(%s), %w", crd.Name, err)
+	}
+	log.FromContext(ctx).WithValues("storedVersions", stored.Status.StoredVersions).Info("migrated kaitonodeclasses to storage version v1beta1")
+	return reconciler.Result{RequeueAfter: checkInterval}, nil
+}
+
+// migrate rewrites nodeClass so it's stored at v1beta1, nothing is patched when nodeClass has been rewritten.
+func (c *Controller) migrate(ctx context.Context, nodeClass *v1alpha1.KaitoNodeClass) error {
+	if nodeClass.Annotations[AnnotationStorageVersion] == v1beta1.SchemeGroupVersion.Version {
+		return nil
+	}
+	stored := nodeClass.DeepCopy()
+	nodeClass.Annotations = lo.Assign(nodeClass.Annotations, map[string]string{AnnotationStorageVersion: v1beta1.SchemeGroupVersion.Version})
+	if err := c.kubeClient.Patch(ctx, nodeClass, client.MergeFrom(stored)); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("patching storage version of kaitonodeclass(%s), %w", nodeClass.Name, err)
+	}
+	return nil
+}
+
+func storageVersion(crd *apiextensionsv1.CustomResourceDefinition) string {
+	version, _ := lo.Find(crd.Spec.Versions, func(v apiextensionsv1.CustomResourceDefinitionVersion) bool { return v.Storage })
+	return version.Name
+}
+
+func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	return controllerruntime.NewControllerManagedBy(m).
+		Named("nodeclass.migration").
+		WatchesRawSource(singleton.Source()).
+		Complete(singleton.AsReconciler(c))
+}
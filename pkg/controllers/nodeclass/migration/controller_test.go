@@ -0,0 +1,122 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"context"
+	"testing"
+
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newCRD(storage string, storedVersions ...string) *apiextensionsv1.CustomResourceDefinition {
+	return &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: CRDName},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{
+				{Name: "v1alpha1", Served: true, Storage: storage == "v1alpha1"},
+				{Name: "v1beta1", Served: true, Storage: storage == "v1beta1"},
+			},
+		},
+		Status: apiextensionsv1.CustomResourceDefinitionStatus{StoredVersions: storedVersions},
+	}
+}
+
+func newNodeClass(name string, annotations map[string]string) *v1alpha1.KaitoNodeClass {
+	return &v1alpha1.KaitoNodeClass{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}
+}
+
+func TestReconcile(t *testing.T) {
+	testCases := []struct {
+		name                   string
+		crd                    *apiextensionsv1.CustomResourceDefinition
+		nodeClasses            []client.Object
+		expectedStoredVersions []string
+		expectedMigrated       bool
+	}{
+		{
+			name:                   "kaitonodeclasses are migrated when v1beta1 is the storage version",
+			crd:                    newCRD("v1beta1", "v1alpha1", "v1beta1"),
+			nodeClasses:            []client.Object{newNodeClass("default", nil), newNodeClass("gpu", map[string]string{"team": "kaito"})},
+			expectedStoredVersions: []string{"v1beta1"},
+			expectedMigrated:       true,
+		},
+		{
+			name:                   "migrated kaitonodeclasses are not patched again",
+			crd:                    newCRD("v1beta1", "v1alpha1", "v1beta1"),
+			nodeClasses:            []client.Object{newNodeClass("default", map[string]string{AnnotationStorageVersion: "v1beta1"})},
+			expectedStoredVersions: []string{"v1beta1"},
+			expectedMigrated:       true,
+		},
+		{
+			name:                   "kaitonodeclasses are not migrated when v1alpha1 is the storage version",
+			crd:                    newCRD("v1alpha1", "v1alpha1"),
+			nodeClasses:            []client.Object{newNodeClass("default", nil)},
+			expectedStoredVersions: []string{"v1alpha1"},
+		},
+		{
+			name:                   "kaitonodeclasses are not migrated when only v1beta1 is stored",
+			crd:                    newCRD("v1beta1", "v1beta1"),
+			nodeClasses:            []client.Object{newNodeClass("default", nil)},
+			expectedStoredVersions: []string{"v1beta1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			assert.NoError(t, v1alpha1.SchemeBuilder.AddToScheme(scheme))
+			assert.NoError(t, apiextensionsv1.AddToScheme(scheme))
+			kubeClient := fake.NewClientBuilder().WithScheme(scheme).
+				WithObjects(append(tc.nodeClasses, tc.crd)...).
+				WithStatusSubresource(&apiextensionsv1.CustomResourceDefinition{}).Build()
+
+			c := NewController(kubeClient, kubeClient)
+			result, err := c.Reconcile(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, checkInterval, result.RequeueAfter)
+
+			updated := &apiextensionsv1.CustomResourceDefinition{}
+			assert.NoError(t, kubeClient.Get(context.Background(), client.ObjectKeyFromObject(tc.crd), updated))
+			assert.Equal(t, tc.expectedStoredVersions, updated.Status.StoredVersions)
+
+			for _, o := range tc.nodeClasses {
+				nodeClass := &v1alpha1.KaitoNodeClass{}
+				assert.NoError(t, kubeClient.Get(context.Background(), client.ObjectKeyFromObject(o), nodeClass))
+				assert.Equal(t, tc.expectedMigrated, nodeClass.Annotations[AnnotationStorageVersion] == "v1beta1")
+				for k, v := range o.GetAnnotations() {
+					assert.Equal(t, v, nodeClass.Annotations[k])
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileWithoutCRD(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, apiextensionsv1.AddToScheme(scheme))
+	kubeClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+	result, err := NewController(kubeClient, kubeClient).Reconcile(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, checkInterval, result.RequeueAfter)
+}
@@ -0,0 +1,205 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package status
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/awslabs/operatorpkg/status"
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/providers/instance"
+	"github.com/azure/gpu-provisioner/pkg/utils"
+	"github.com/samber/lo"
+	"go.uber.org/multierr"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/discovery"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/karpenter/pkg/operator/injection"
+)
+
+const (
+	ReasonSubnetInvalid                   = "SubnetInvalid"
+	ReasonSubnetNotFound                  = "SubnetNotFound"
+	ReasonSubnetForbidden                 = "SubnetForbidden"
+	ReasonSubnetUnresolved                = "SubnetUnresolved"
+	ReasonApplicationSecurityGroupInvalid = "ApplicationSecurityGroupInvalid"
+	ReasonOSSKUUnsupported                = "OSSKUUnsupported"
+	ReasonTagsInvalid                     = "TagsInvalid"
+	ReasonKubernetesVersionUnresolved     = "KubernetesVersionUnresolved"
+)
+
+// osSKUMinKubernetesVersions are the minimal Kubernetes versions of cluster which AKS supports the OS SKUs on.
+var osSKUMinKubernetesVersions = map[string]*version.Version{
+	"AzureLinux": version.MustParseGeneric("1.25.0"),
+}
+
+// Controller validates KaitoNodeClasses and sets their Ready condition, karpenter refuses to launch the nodeclaims
+// which reference a KaitoNodeClass that is not ready.
+type Controller struct {
+	kubeClient    client.Client
+	versionClient discovery.ServerVersionInterface
+	// subnetsClient checks the subnets exist, only the format of subnet IDs is validated when it's nil.
+	subnetsClient instance.SubnetsAPI
+}
+
+func NewController(kubeClient client.Client, versionClient discovery.ServerVersionInterface, subnetsClient instance.SubnetsAPI) *Controller {
+	return &Controller{
+		kubeClient:    kubeClient,
+		versionClient: versionClient,
+		subnetsClient: subnetsClient,
+	}
+}
+
+func (c *Controller) Reconcile(ctx context.Context, nodeClass *v1alpha1.KaitoNodeClass) (reconcile.Result, error) {
+	ctx = injection.WithControllerName(ctx, "nodeclass.status")
+	if !nodeClass.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	stored := nodeClass.DeepCopy()
+	subnetErr := c.validateSubnets(ctx, nodeClass)
+	validateTags(nodeClass)
+	versionErr := c.validateOSSKU(nodeClass)
+
+	if !equality.Semantic.DeepEqual(stored, nodeClass) {
+		// use optimistic locking so the conditions set by other controllers are not overwritten.
+		if err := c.kubeClient.Status().Patch(ctx, nodeClass, client.MergeFromWithOptions(stored, client.MergeFromWithOptimisticLock{})); err != nil {
+			if errors.IsConflict(err) {
+				return reconcile.Result{Requeue: true}, nil
+			}
+			return reconcile.Result{}, client.IgnoreNotFound(err)
+		}
+	}
+	if err := multierr.Combine(subnetErr, versionErr); err != nil {
+		return reconcile.Result{}, err
+	}
+	log.FromContext(ctx).V(1).Info("validated kaitonodeclass", "ready", nodeClass.StatusConditions().IsTrue(status.ConditionReady))
+	// the kubernetes version of cluster may be upgraded, so the nodeclass is validated periodically.
+	return reconcile.Result{RequeueAfter: 5 * time.Minute}, nil
+}
+
+// validateSubnets checks the format of the subnets and application security groups, and then checks the subnets exist.
+// The error of getting the subnets is returned so the nodeclass is reconciled again.
+func (c *Controller) validateSubnets(ctx context.Context, nodeClass *v1alpha1.KaitoNodeClass) error {
+	spec := nodeClass.Spec
+	var vnetSubnet, podSubnet *arm.ResourceID
+	if spec.VnetSubnetID != nil {
+		parsed, err := utils.ParseSubnetID(*spec.VnetSubnetID)
+		if err != nil {
+			nodeClass.StatusConditions().SetFalse(v1alpha1.ConditionTypeSubnetsReady, ReasonSubnetInvalid, fmt.Sprintf("vnetSubnetID is invalid, %s", err))
+			return nil
+		}
+		vnetSubnet = parsed
+	}
+	if spec.PodSubnetID != nil {
+		parsed, err := utils.ParseSubnetID(*spec.PodSubnetID)
+		if err != nil {
+			nodeClass.StatusConditions().SetFalse(v1alpha1.ConditionTypeSubnetsReady, ReasonSubnetInvalid, fmt.Sprintf("podSubnetID is invalid, %s", err))
+			return nil
+		}
+		if vnetSubnet == nil || !utils.InSameVirtualNetwork(vnetSubnet, parsed) {
+			nodeClass.StatusConditions().SetFalse(v1alpha1.ConditionTypeSubnetsReady, ReasonSubnetInvalid, "podSubnetID should be in the same virtual network as vnetSubnetID")
+			return nil
+		}
+		podSubnet = parsed
+	}
+	if spec.NetworkProfile != nil {
+		for _, asg := range spec.NetworkProfile.ApplicationSecurityGroups {
+			if _, err := utils.ParseApplicationSecurityGroupID(asg); err != nil {
+				nodeClass.StatusConditions().SetFalse(v1alpha1.ConditionTypeSubnetsReady, ReasonApplicationSecurityGroupInvalid, fmt.Sprintf("applicationSecurityGroups is invalid, %s", err))
+				return nil
+			}
+		}
+	}
+	if c.subnetsClient != nil {
+		for _, subnet := range []lo.Entry[string, *arm.ResourceID]{{Key: "vnetSubnetID", Value: vnetSubnet}, {Key: "podSubnetID", Value: podSubnet}} {
+			if subnet.Value == nil {
+				continue
+			}
+			if err := c.subnetsClient.Get(ctx, subnet.Value); err != nil {
+				return setSubnetUnavailable(nodeClass, subnet.Key, subnet.Value, err)
+			}
+		}
+	}
+	nodeClass.StatusConditions().SetTrue(v1alpha1.ConditionTypeSubnetsReady)
+	return nil
+}
+
+// setSubnetUnavailable reports the error of getting subnet on the SubnetsReady condition, the error is returned when
+// it may be resolved by retrying.
+func setSubnetUnavailable(nodeClass *v1alpha1.KaitoNodeClass, field string, subnet *arm.ResourceID, err error) error {
+	switch instance.ClassifyError(err).Reason {
+	case instance.ReasonNotFound:
+		nodeClass.StatusConditions().SetFalse(v1alpha1.ConditionTypeSubnetsReady, ReasonSubnetNotFound, fmt.Sprintf("subnet %s of %s is not found", subnet, field))
+		return nil
+	case instance.ReasonUnauthorized:
+		nodeClass.StatusConditions().SetFalse(v1alpha1.ConditionTypeSubnetsReady, ReasonSubnetForbidden,
+			fmt.Sprintf("gpu-provisioner is not authorized to read subnet %s of %s, %s", subnet, field, instance.ClassifyError(err).Code))
+		return nil
+	}
+	nodeClass.StatusConditions().SetUnknownWithReason(v1alpha1.ConditionTypeSubnetsReady, ReasonSubnetUnresolved, fmt.Sprintf("getting subnet %s of %s failed", subnet, field))
+	return fmt.Errorf("getting subnet %s, %w", subnet, err)
+}
+
+func validateTags(nodeClass *v1alpha1.KaitoNodeClass) {
+	if err := instance.ValidateNodeClassTags(nodeClass.Spec.Tags); err != nil {
+		nodeClass.StatusConditions().SetFalse(v1alpha1.ConditionTypeTagsReady, ReasonTagsInvalid, err.Error())
+		return
+	}
+	nodeClass.StatusConditions().SetTrue(v1alpha1.ConditionTypeTagsReady)
+}
+
+// validateOSSKU checks the OS SKU against the kubernetes version of cluster, the error of resolving the kubernetes
+// version is returned so the nodeclass is reconciled again.
+func (c *Controller) validateOSSKU(nodeClass *v1alpha1.KaitoNodeClass) error {
+	serverVersion, err := c.versionClient.ServerVersion()
+	if err != nil {
+		nodeClass.StatusConditions().SetUnknownWithReason(v1alpha1.ConditionTypeOSSKUReady, ReasonKubernetesVersionUnresolved, err.Error())
+		return fmt.Errorf("getting kubernetes version, %w", err)
+	}
+	kubernetesVersion, err := version.ParseGeneric(serverVersion.GitVersion)
+	if err != nil {
+		nodeClass.StatusConditions().SetUnknownWithReason(v1alpha1.ConditionTypeOSSKUReady, ReasonKubernetesVersionUnresolved, err.Error())
+		return fmt.Errorf("parsing kubernetes version %q, %w", serverVersion.GitVersion, err)
+	}
+	nodeClass.Status.KubernetesVersion = kubernetesVersion.String()
+
+	if minVersion, ok := osSKUMinKubernetesVersions[nodeClass.Spec.OSSKU]; ok && kubernetesVersion.LessThan(minVersion) {
+		nodeClass.StatusConditions().SetFalse(v1alpha1.ConditionTypeOSSKUReady, ReasonOSSKUUnsupported,
+			fmt.Sprintf("os sku %s requires kubernetes version %s or later, the cluster is %s", nodeClass.Spec.OSSKU, minVersion, kubernetesVersion))
+		return nil
+	}
+	nodeClass.StatusConditions().SetTrue(v1alpha1.ConditionTypeOSSKUReady)
+	return nil
+}
+
+func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	return controllerruntime.NewControllerManagedBy(m).
+		Named("nodeclass.status").
+		For(&v1alpha1.KaitoNodeClass{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 10}).
+		Complete(reconcile.AsReconciler(m.GetClient(), c))
+}
@@ -0,0 +1,206 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package status
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/awslabs/operatorpkg/status"
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/providers/instance"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/version"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+type fakeVersionClient struct {
+	gitVersion string
+	err        error
+}
+
+func (f *fakeVersionClient) ServerVersion() (*version.Info, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &version.Info{GitVersion: f.gitVersion}, nil
+}
+
+type fakeSubnetsClient struct {
+	errs map[string]error
+}
+
+func (f *fakeSubnetsClient) Get(_ context.Context, subnetID *arm.ResourceID) error {
+	return f.errs[subnetID.String()]
+}
+
+func TestReconcile(t *testing.T) {
+	subnetID := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/gpu"
+	podSubnetID := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/pods"
+
+	testCases := []struct {
+		name               string
+		spec               v1alpha1.KaitoNodeClassSpec
+		gitVersion         string
+		versionErr         error
+		subnetErrs         map[string]error
+		expectedReady      metav1.ConditionStatus
+		expectedConditions map[string]metav1.ConditionStatus
+		expectedMessage    string
+		expectedErr        bool
+	}{
+		{
+			name:          "nodeclass without any setting is ready",
+			gitVersion:    "v1.30.3",
+			expectedReady: metav1.ConditionTrue,
+		},
+		{
+			name: "nodeclass with valid settings is ready",
+			spec: v1alpha1.KaitoNodeClassSpec{
+				OSSKU:        "AzureLinux",
+				VnetSubnetID: lo.ToPtr(subnetID),
+				PodSubnetID:  lo.ToPtr(podSubnetID),
+				Tags:         map[string]string{"team": "ml"},
+			},
+			gitVersion:    "v1.30.3",
+			expectedReady: metav1.ConditionTrue,
+		},
+		{
+			name:               "nodeclass with invalid subnet is not ready",
+			spec:               v1alpha1.KaitoNodeClassSpec{VnetSubnetID: lo.ToPtr("/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet")},
+			gitVersion:         "v1.30.3",
+			expectedReady:      metav1.ConditionFalse,
+			expectedConditions: map[string]metav1.ConditionStatus{v1alpha1.ConditionTypeSubnetsReady: metav1.ConditionFalse},
+			expectedMessage:    "vnetSubnetID is invalid",
+		},
+		{
+			name: "nodeclass with pod subnet in another virtual network is not ready",
+			spec: v1alpha1.KaitoNodeClassSpec{
+				VnetSubnetID: lo.ToPtr("/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/gpu"),
+				PodSubnetID:  lo.ToPtr("/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/other/subnets/pods"),
+			},
+			gitVersion:         "v1.30.3",
+			expectedReady:      metav1.ConditionFalse,
+			expectedConditions: map[string]metav1.ConditionStatus{v1alpha1.ConditionTypeSubnetsReady: metav1.ConditionFalse},
+			expectedMessage:    "podSubnetID should be in the same virtual network as vnetSubnetID",
+		},
+		{
+			name: "nodeclass with invalid application security group is not ready",
+			spec: v1alpha1.KaitoNodeClassSpec{NetworkProfile: &v1alpha1.NetworkProfile{
+				ApplicationSecurityGroups: []string{"/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/networkSecurityGroups/gpu"},
+			}},
+			gitVersion:         "v1.30.3",
+			expectedReady:      metav1.ConditionFalse,
+			expectedConditions: map[string]metav1.ConditionStatus{v1alpha1.ConditionTypeSubnetsReady: metav1.ConditionFalse},
+			expectedMessage:    "applicationSecurityGroups is invalid",
+		},
+		{
+			name:               "nodeclass with subnet which doesn't exist is not ready",
+			spec:               v1alpha1.KaitoNodeClassSpec{VnetSubnetID: lo.ToPtr(subnetID)},
+			gitVersion:         "v1.30.3",
+			subnetErrs:         map[string]error{subnetID: &azcore.ResponseError{ErrorCode: "NotFound", StatusCode: http.StatusNotFound}},
+			expectedReady:      metav1.ConditionFalse,
+			expectedConditions: map[string]metav1.ConditionStatus{v1alpha1.ConditionTypeSubnetsReady: metav1.ConditionFalse},
+			expectedMessage:    "of vnetSubnetID is not found",
+		},
+		{
+			name:               "nodeclass with pod subnet which can't be read is not ready",
+			spec:               v1alpha1.KaitoNodeClassSpec{VnetSubnetID: lo.ToPtr(subnetID), PodSubnetID: lo.ToPtr(podSubnetID)},
+			gitVersion:         "v1.30.3",
+			subnetErrs:         map[string]error{podSubnetID: &azcore.ResponseError{ErrorCode: "AuthorizationFailed", StatusCode: http.StatusForbidden}},
+			expectedReady:      metav1.ConditionFalse,
+			expectedConditions: map[string]metav1.ConditionStatus{v1alpha1.ConditionTypeSubnetsReady: metav1.ConditionFalse},
+			expectedMessage:    "not authorized to read subnet",
+		},
+		{
+			name:               "readiness is unknown when subnet can not be read",
+			spec:               v1alpha1.KaitoNodeClassSpec{VnetSubnetID: lo.ToPtr(subnetID)},
+			gitVersion:         "v1.30.3",
+			subnetErrs:         map[string]error{subnetID: &azcore.ResponseError{ErrorCode: "InternalServerError", StatusCode: http.StatusInternalServerError}},
+			expectedReady:      metav1.ConditionUnknown,
+			expectedConditions: map[string]metav1.ConditionStatus{v1alpha1.ConditionTypeSubnetsReady: metav1.ConditionUnknown},
+			expectedErr:        true,
+		},
+		{
+			name:               "nodeclass with os sku which is not supported by the kubernetes version is not ready",
+			spec:               v1alpha1.KaitoNodeClassSpec{OSSKU: "AzureLinux"},
+			gitVersion:         "v1.24.9",
+			expectedReady:      metav1.ConditionFalse,
+			expectedConditions: map[string]metav1.ConditionStatus{v1alpha1.ConditionTypeOSSKUReady: metav1.ConditionFalse},
+			expectedMessage:    "os sku AzureLinux requires kubernetes version 1.25.0 or later, the cluster is 1.24.9",
+		},
+		{
+			name:               "nodeclass with invalid tags is not ready",
+			spec:               v1alpha1.KaitoNodeClassSpec{Tags: map[string]string{"azure-team": "ml"}},
+			gitVersion:         "v1.30.3",
+			expectedReady:      metav1.ConditionFalse,
+			expectedConditions: map[string]metav1.ConditionStatus{v1alpha1.ConditionTypeTagsReady: metav1.ConditionFalse},
+			expectedMessage:    "reserved prefix",
+		},
+		{
+			name:               "nodeclass with tags reserved by gpu-provisioner is not ready",
+			spec:               v1alpha1.KaitoNodeClassSpec{Tags: map[string]string{instance.NodeClaimNameTag: "other"}},
+			gitVersion:         "v1.30.3",
+			expectedReady:      metav1.ConditionFalse,
+			expectedConditions: map[string]metav1.ConditionStatus{v1alpha1.ConditionTypeTagsReady: metav1.ConditionFalse},
+			expectedMessage:    "is reserved by gpu-provisioner",
+		},
+		{
+			name:               "readiness is unknown when kubernetes version can not be resolved",
+			versionErr:         errors.New("connection refused"),
+			expectedReady:      metav1.ConditionUnknown,
+			expectedConditions: map[string]metav1.ConditionStatus{v1alpha1.ConditionTypeOSSKUReady: metav1.ConditionUnknown},
+			expectedErr:        true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			assert.NoError(t, v1alpha1.SchemeBuilder.AddToScheme(scheme))
+			nodeClass := &v1alpha1.KaitoNodeClass{ObjectMeta: metav1.ObjectMeta{Name: "default"}, Spec: tc.spec}
+			kubeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(nodeClass).WithStatusSubresource(nodeClass).Build()
+
+			c := NewController(kubeClient, &fakeVersionClient{gitVersion: tc.gitVersion, err: tc.versionErr}, &fakeSubnetsClient{errs: tc.subnetErrs})
+			_, err := c.Reconcile(context.Background(), nodeClass.DeepCopy())
+			if tc.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			updated := &v1alpha1.KaitoNodeClass{}
+			assert.NoError(t, kubeClient.Get(context.Background(), client.ObjectKeyFromObject(nodeClass), updated))
+			ready := updated.StatusConditions().Get(status.ConditionReady)
+			assert.Equal(t, tc.expectedReady, ready.Status)
+			for conditionType, expected := range tc.expectedConditions {
+				condition := updated.StatusConditions().Get(conditionType)
+				assert.Equal(t, expected, condition.Status, conditionType)
+				if tc.expectedMessage != "" {
+					assert.Contains(t, condition.Message, tc.expectedMessage)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package termination
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+	"sigs.k8s.io/karpenter/pkg/events"
+	"sigs.k8s.io/karpenter/pkg/operator/injection"
+	"sigs.k8s.io/karpenter/pkg/utils/pretty"
+)
+
+const (
+	// ReasonWaitingOnNodeClaimTermination is the reason of the event emitted when the deletion of KaitoNodeClass is
+	// blocked by the nodeclaims referencing it.
+	ReasonWaitingOnNodeClaimTermination = "WaitingOnNodeClaimTermination"
+
+	// nodeClassRefNameField is the field index of nodeclaims registered by karpenter operator.
+	nodeClassRefNameField = "spec.nodeClassRef.name"
+)
+
+// Controller adds the termination finalizer to KaitoNodeClasses and removes it when no nodeclaim references the
+// KaitoNodeClass, so a KaitoNodeClass is not deleted while its nodes are running.
+type Controller struct {
+	kubeClient client.Client
+	recorder   events.Recorder
+}
+
+func NewController(kubeClient client.Client, recorder events.Recorder) *Controller {
+	return &Controller{
+		kubeClient: kubeClient,
+		recorder:   recorder,
+	}
+}
+
+func (c *Controller) Reconcile(ctx context.Context, nodeClass *v1alpha1.KaitoNodeClass) (reconcile.Result, error) {
+	ctx = injection.WithControllerName(ctx, "nodeclass.termination")
+	if nodeClass.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, c.patchFinalizer(ctx, nodeClass, controllerutil.AddFinalizer)
+	}
+	if !controllerutil.ContainsFinalizer(nodeClass, v1alpha1.TerminationFinalizer) {
+		return reconcile.Result{}, nil
+	}
+
+	nodeClaims, err := c.nodeClaimsOf(ctx, nodeClass)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if len(nodeClaims) > 0 {
+		// the nodeclass is reconciled again when the nodeclaims are deleted.
+		c.recorder.Publish(events.Event{
+			InvolvedObject: nodeClass,
+			Type:           v1.EventTypeNormal,
+			Reason:         ReasonWaitingOnNodeClaimTermination,
+			Message:        fmt.Sprintf("Waiting on NodeClaim termination for %s", pretty.Slice(nodeClaims, 5)),
+			DedupeValues:   append([]string{nodeClass.Name}, nodeClaims...),
+		})
+		return reconcile.Result{}, nil
+	}
+	if err := c.patchFinalizer(ctx, nodeClass, controllerutil.RemoveFinalizer); err != nil {
+		return reconcile.Result{}, err
+	}
+	log.FromContext(ctx).Info("removed termination finalizer of kaitonodeclass")
+	return reconcile.Result{}, nil
+}
+
+// nodeClaimsOf returns the sorted names of nodeclaims which reference nodeClass.
+func (c *Controller) nodeClaimsOf(ctx context.Context, nodeClass *v1alpha1.KaitoNodeClass) ([]string, error) {
+	nodeClaimList := &karpenterv1.NodeClaimList{}
+	if err := c.kubeClient.List(ctx, nodeClaimList, client.MatchingFields{nodeClassRefNameField: nodeClass.Name}); err != nil {
+		return nil, fmt.Errorf("listing nodeclaims of kaitonodeclass(%s), %w", nodeClass.Name, err)
+	}
+	names := lo.FilterMap(nodeClaimList.Items, func(nodeClaim karpenterv1.NodeClaim, _ int) (string, bool) {
+		return nodeClaim.Name, referencesNodeClass(&nodeClaim, nodeClass.Name)
+	})
+	sort.Strings(names)
+	return names, nil
+}
+
+// patchFinalizer adds or removes the termination finalizer of nodeClass, nothing is patched when the finalizer is
+// already added or removed.
+func (c *Controller) patchFinalizer(ctx context.Context, nodeClass *v1alpha1.KaitoNodeClass, update func(client.Object, string) bool) error {
+	stored := nodeClass.DeepCopy()
+	if !update(nodeClass, v1alpha1.TerminationFinalizer) {
+		return nil
+	}
+	// use optimistic locking so the finalizers added by others are not overwritten.
+	if err := c.kubeClient.Patch(ctx, nodeClass, client.MergeFromWithOptions(stored, client.MergeFromWithOptimisticLock{})); err != nil {
+		if errors.IsConflict(err) {
+			return fmt.Errorf("patching finalizer of kaitonodeclass(%s), %w", nodeClass.Name, err)
+		}
+		return client.IgnoreNotFound(err)
+	}
+	return nil
+}
+
+func referencesNodeClass(nodeClaim *karpenterv1.NodeClaim, name string) bool {
+	ref := nodeClaim.Spec.NodeClassRef
+	return ref != nil && ref.Group == v1alpha1.Group && ref.Kind == "KaitoNodeClass" && ref.Name == name
+}
+
+func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	return controllerruntime.NewControllerManagedBy(m).
+		Named("nodeclass.termination").
+		For(&v1alpha1.KaitoNodeClass{}).
+		// the deleting nodeclass is reconciled when a nodeclaim referencing it is deleted.
+		Watches(&karpenterv1.NodeClaim{}, handler.EnqueueRequestsFromMapFunc(func(_ context.Context, o client.Object) []reconcile.Request {
+			nodeClaim := o.(*karpenterv1.NodeClaim)
+			if ref := nodeClaim.Spec.NodeClassRef; ref != nil && referencesNodeClass(nodeClaim, ref.Name) {
+				return []reconcile.Request{{NamespacedName: client.ObjectKey{Name: ref.Name}}}
+			}
+			return nil
+		})).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 10}).
+		Complete(reconcile.AsReconciler(m.GetClient(), c))
+}
@@ -0,0 +1,138 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package termination
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+	"sigs.k8s.io/karpenter/pkg/events"
+)
+
+type fakeRecorder struct {
+	events []events.Event
+}
+
+func (r *fakeRecorder) Publish(evts ...events.Event) {
+	r.events = append(r.events, evts...)
+}
+
+func nodeClaim(name string, ref *karpenterv1.NodeClassReference) *karpenterv1.NodeClaim {
+	return &karpenterv1.NodeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       karpenterv1.NodeClaimSpec{NodeClassRef: ref},
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	ref := &karpenterv1.NodeClassReference{Group: v1alpha1.Group, Kind: "KaitoNodeClass", Name: "default"}
+	testCases := []struct {
+		name              string
+		deleting          bool
+		finalizers        []string
+		nodeClaims        []client.Object
+		expectedFinalizer bool
+		expectedDeleted   bool
+		expectedMessage   string
+	}{
+		{
+			name:              "finalizer is added to kaitonodeclass",
+			expectedFinalizer: true,
+		},
+		{
+			name:              "finalizer is kept when it's already added",
+			finalizers:        []string{v1alpha1.TerminationFinalizer},
+			expectedFinalizer: true,
+		},
+		{
+			name:       "deletion is blocked by nodeclaims referencing kaitonodeclass",
+			deleting:   true,
+			finalizers: []string{v1alpha1.TerminationFinalizer},
+			nodeClaims: []client.Object{
+				nodeClaim("nodeclaim-b", ref),
+				nodeClaim("nodeclaim-a", ref),
+				nodeClaim("nodeclaim-other", &karpenterv1.NodeClassReference{Group: v1alpha1.Group, Kind: "KaitoNodeClass", Name: "other"}),
+				nodeClaim("nodeclaim-aws", &karpenterv1.NodeClassReference{Group: "karpenter.k8s.aws", Kind: "EC2NodeClass", Name: "default"}),
+			},
+			expectedFinalizer: true,
+			expectedMessage:   "Waiting on NodeClaim termination for nodeclaim-a, nodeclaim-b",
+		},
+		{
+			name:       "finalizer is removed when no nodeclaim references kaitonodeclass",
+			deleting:   true,
+			finalizers: []string{v1alpha1.TerminationFinalizer},
+			nodeClaims: []client.Object{
+				nodeClaim("nodeclaim-aws", &karpenterv1.NodeClassReference{Group: "karpenter.k8s.aws", Kind: "EC2NodeClass", Name: "default"}),
+			},
+			expectedDeleted: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			scheme := clientgoscheme.Scheme
+			assert.NoError(t, v1alpha1.SchemeBuilder.AddToScheme(scheme))
+			nodeClass := &v1alpha1.KaitoNodeClass{ObjectMeta: metav1.ObjectMeta{Name: "default", Finalizers: tc.finalizers}}
+			if tc.deleting {
+				nodeClass.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+			}
+			kubeClient := fake.NewClientBuilder().WithScheme(scheme).
+				WithObjects(append(tc.nodeClaims, nodeClass)...).
+				WithIndex(&karpenterv1.NodeClaim{}, nodeClassRefNameField, func(o client.Object) []string {
+					if ref := o.(*karpenterv1.NodeClaim).Spec.NodeClassRef; ref != nil {
+						return []string{ref.Name}
+					}
+					return nil
+				}).Build()
+			current := &v1alpha1.KaitoNodeClass{}
+			assert.NoError(t, kubeClient.Get(context.Background(), client.ObjectKeyFromObject(nodeClass), current))
+
+			recorder := &fakeRecorder{}
+			c := NewController(kubeClient, recorder)
+			_, err := c.Reconcile(context.Background(), current)
+			assert.NoError(t, err)
+
+			updated := &v1alpha1.KaitoNodeClass{}
+			err = kubeClient.Get(context.Background(), client.ObjectKeyFromObject(nodeClass), updated)
+			if tc.expectedDeleted {
+				// the deleting object is removed once its last finalizer is removed.
+				assert.True(t, apierrors.IsNotFound(err), err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedFinalizer, controllerutil.ContainsFinalizer(updated, v1alpha1.TerminationFinalizer))
+			}
+
+			if tc.expectedMessage == "" {
+				assert.Empty(t, recorder.events)
+				return
+			}
+			if assert.Len(t, recorder.events, 1) {
+				assert.Equal(t, ReasonWaitingOnNodeClaimTermination, recorder.events[0].Reason)
+				assert.Equal(t, tc.expectedMessage, recorder.events[0].Message)
+			}
+		})
+	}
+}
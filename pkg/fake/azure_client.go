@@ -101,6 +101,21 @@ func (mr *MockAgentPoolsAPIMockRecorder) Get(ctx, resourceGroupName, resourceNam
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockAgentPoolsAPI)(nil).Get), ctx, resourceGroupName, resourceName, agentPoolName, options)
 }
 
+// GetUpgradeProfile mocks base method.
+func (m *MockAgentPoolsAPI) GetUpgradeProfile(ctx context.Context, resourceGroupName, resourceName, agentPoolName string, options *armcontainerservice.AgentPoolsClientGetUpgradeProfileOptions) (armcontainerservice.AgentPoolsClientGetUpgradeProfileResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUpgradeProfile", ctx, resourceGroupName, resourceName, agentPoolName, options)
+	ret0, _ := ret[0].(armcontainerservice.AgentPoolsClientGetUpgradeProfileResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUpgradeProfile indicates an expected call of GetUpgradeProfile.
+func (mr *MockAgentPoolsAPIMockRecorder) GetUpgradeProfile(ctx, resourceGroupName, resourceName, agentPoolName, options any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUpgradeProfile", reflect.TypeOf((*MockAgentPoolsAPI)(nil).GetUpgradeProfile), ctx, resourceGroupName, resourceName, agentPoolName, options)
+}
+
 // NewListPager mocks base method.
 func (m *MockAgentPoolsAPI) NewListPager(resourceGroupName, resourceName string, options *armcontainerservice.AgentPoolsClientListOptions) *runtime.Pager[armcontainerservice.AgentPoolsClientListResponse] {
 	m.ctrl.T.Helper()
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"reflect"
 
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
 	"github.com/stretchr/testify/mock"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
@@ -49,6 +50,13 @@ func NewClient() *MockClient {
 	}
 }
 
+// WithKaitoNodeClass serves nodeClass from the client, e.g. the KaitoNodeClass referenced by the fake nodeclaims.
+func (m *MockClient) WithKaitoNodeClass(nodeClass *v1alpha1.KaitoNodeClass) *MockClient {
+	m.CreateOrUpdateObjectInMap(nodeClass)
+	m.On("Get", mock.Anything, k8sClient.ObjectKeyFromObject(nodeClass), mock.IsType(&v1alpha1.KaitoNodeClass{}), mock.Anything).Return(nil)
+	return m
+}
+
 // Retrieves or creates a map associated with the type of obj
 func (m *MockClient) ensureMapForType(t reflect.Type) map[k8sClient.ObjectKey]k8sClient.Object {
 	if _, ok := m.ObjectMap[t]; !ok {
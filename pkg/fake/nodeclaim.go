@@ -18,12 +18,28 @@ package fake
 import (
 	"fmt"
 
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
 	"github.com/samber/lo"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
 )
 
+// nodeClassRef references the KaitoNodeClass of GetKaitoNodeClassObj, so the nodeclaims are managed by the cloud
+// provider.
+func nodeClassRef() *karpenterv1.NodeClassReference {
+	return &karpenterv1.NodeClassReference{Group: v1alpha1.Group, Kind: "KaitoNodeClass", Name: "default"}
+}
+
+// GetKaitoNodeClassObj returns the ready KaitoNodeClass which the nodeclaims of GetNodeClaimObj reference.
+func GetKaitoNodeClassObj() *v1alpha1.KaitoNodeClass {
+	nodeClass := &v1alpha1.KaitoNodeClass{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+	for _, condition := range []string{v1alpha1.ConditionTypeSubnetsReady, v1alpha1.ConditionTypeOSSKUReady, v1alpha1.ConditionTypeTagsReady} {
+		nodeClass.StatusConditions().SetTrue(condition)
+	}
+	return nodeClass
+}
+
 func GetNodeClaimObj(name string, labels map[string]string, taints []v1.Taint, resource karpenterv1.ResourceRequirements, req []v1.NodeSelectorRequirement) *karpenterv1.NodeClaim {
 	requirements := lo.Map(req, func(v1Requirements v1.NodeSelectorRequirement, _ int) karpenterv1.NodeSelectorRequirementWithMinValues {
 		return karpenterv1.NodeSelectorRequirementWithMinValues{
@@ -47,7 +63,7 @@ func GetNodeClaimObj(name string, labels map[string]string, taints []v1.Taint, r
 		Spec: karpenterv1.NodeClaimSpec{
 			Resources:    resource,
 			Requirements: requirements,
-			NodeClassRef: &karpenterv1.NodeClassReference{},
+			NodeClassRef: nodeClassRef(),
 			Taints:       taints,
 		},
 		Status: karpenterv1.NodeClaimStatus{
@@ -76,7 +92,7 @@ func GetNodeClaimObjWithoutProviderID(name string, labels map[string]string, tai
 		Spec: karpenterv1.NodeClaimSpec{
 			Resources:    resource,
 			Requirements: requirements,
-			NodeClassRef: &karpenterv1.NodeClassReference{},
+			NodeClassRef: nodeClassRef(),
 			Taints:       taints,
 		},
 	}
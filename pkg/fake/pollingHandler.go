@@ -64,7 +64,9 @@ func (mr *MockPollingHandlerMockRecorder[T]) Done() *gomock.Call {
 func (m *MockPollingHandler[T]) Poll(ctx context.Context) (*http.Response, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Poll", ctx)
-	return ret[0].(*http.Response), ret[1].(error)
+	ret0, _ := ret[0].(*http.Response)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 func (mr *MockPollingHandlerMockRecorder[T]) Poll(ctx any) *gomock.Call {
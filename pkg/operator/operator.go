@@ -21,7 +21,9 @@ import (
 	"os"
 
 	"github.com/azure/gpu-provisioner/pkg/auth"
+	"github.com/azure/gpu-provisioner/pkg/cache"
 	"github.com/azure/gpu-provisioner/pkg/providers/instance"
+	"github.com/azure/gpu-provisioner/pkg/providers/instancetype"
 	"knative.dev/pkg/logging"
 	"sigs.k8s.io/karpenter/pkg/operator"
 )
@@ -30,6 +32,9 @@ import (
 type Operator struct {
 	*operator.Operator
 	InstanceProvider *instance.Provider
+	AzConfig         *auth.Config
+	// SubnetsClient checks the subnets of KaitoNodeClasses exist.
+	SubnetsClient instance.SubnetsAPI
 }
 
 func NewOperator(ctx context.Context, operator *operator.Operator) (context.Context, *Operator) {
@@ -46,16 +51,29 @@ func NewOperator(ctx context.Context, operator *operator.Operator) (context.Cont
 		panic(fmt.Sprintf("Configure azure client fails. Please ensure federatedcredential has been created for identity %s.", os.Getenv("AZURE_CLIENT_ID")))
 	}
 
+	// the gpu sku catalog is refreshed from resource skus api only when the dynamic sku cache is enabled.
+	var skuClient instancetype.ResourceSKUsAPI
+	if azConfig.EnableDynamicSKUCache {
+		skuClient = azClient.ResourceSKUsClient()
+	}
+	instanceTypeProvider := instancetype.NewProvider(azConfig.Location, skuClient, azConfig.SpotPriceFactor, cache.NewUnavailableOfferings())
+
 	instanceProvider := instance.NewProvider(
 		azClient,
 		operator.GetClient(),
 		azConfig.ResourceGroup,
 		azConfig.ClusterName,
+		azConfig.AgentPoolType,
+		azConfig.WarmPools,
+		instance.TagOptions{Tags: azConfig.Tags, NodeClaimLabels: azConfig.TagNodeClaimLabels},
+		instanceTypeProvider,
 	)
 
 	return ctx, &Operator{
 		Operator:         operator,
 		InstanceProvider: instanceProvider,
+		AzConfig:         azConfig,
+		SubnetsClient:    azClient.SubnetsClient(),
 	}
 }
 
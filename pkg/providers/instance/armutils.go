@@ -17,26 +17,22 @@ package instance
 
 import (
 	"context"
-	"strings"
 
-	sdkerrors "github.com/Azure/azure-sdk-for-go-extensions/pkg/errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v4"
 	"k8s.io/klog/v2"
-	"sigs.k8s.io/karpenter/pkg/cloudprovider"
 )
 
-func createAgentPool(ctx context.Context, client AgentPoolsAPI, rg, apName, clusterName string, ap armcontainerservice.AgentPool) (*armcontainerservice.AgentPool, error) {
-	klog.InfoS("createAgentPool", "agentpool", apName)
+// beginCreateAgentPool starts creating agent pool, the creation is resumed instead of sending a new request
+// when resumeToken of previous creation is specified.
+func beginCreateAgentPool(ctx context.Context, client AgentPoolsAPI, rg, apName, clusterName string, ap armcontainerservice.AgentPool, resumeToken string) (*runtime.Poller[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse], error) {
+	klog.InfoS("beginCreateAgentPool", "agentpool", apName, "resume", resumeToken != "")
 
-	poller, err := client.BeginCreateOrUpdate(ctx, rg, clusterName, apName, ap, nil)
-	if err != nil {
-		return nil, err
-	}
-	res, err := poller.PollUntilDone(ctx, nil)
-	if err != nil {
-		return nil, err
+	var options *armcontainerservice.AgentPoolsClientBeginCreateOrUpdateOptions
+	if resumeToken != "" {
+		options = &armcontainerservice.AgentPoolsClientBeginCreateOrUpdateOptions{ResumeToken: resumeToken}
 	}
-	return &res.AgentPool, nil
+	return client.BeginCreateOrUpdate(ctx, rg, clusterName, apName, ap, options)
 }
 
 func deleteAgentPool(ctx context.Context, client AgentPoolsAPI, rg, clusterName, apName string) error {
@@ -59,29 +55,16 @@ func deleteAgentPool(ctx context.Context, client AgentPoolsAPI, rg, clusterName,
 
 	poller, err := client.BeginDelete(ctx, rg, clusterName, apName, nil)
 	if err != nil {
-		azErr := sdkerrors.IsResponseError(err)
-		if azErr != nil && azErr.ErrorCode == "NotFound" {
-			return cloudprovider.NewNodeClaimNotFoundError(err)
-		}
-		return err
+		return toCloudProviderError(err)
 	}
 	_, err = poller.PollUntilDone(ctx, nil)
-	if err != nil {
-		azErr := sdkerrors.IsResponseError(err)
-		if azErr != nil && azErr.ErrorCode == "NotFound" {
-			return cloudprovider.NewNodeClaimNotFoundError(err)
-		}
-	}
-	return err
+	return toCloudProviderError(err)
 }
 
 func getAgentPool(ctx context.Context, client AgentPoolsAPI, rg, clusterName, apName string) (*armcontainerservice.AgentPool, error) {
 	resp, err := client.Get(ctx, rg, clusterName, apName, nil)
 	if err != nil {
-		if strings.Contains(err.Error(), "Agent Pool not found") {
-			return nil, cloudprovider.NewNodeClaimNotFoundError(err)
-		}
-		return nil, err
+		return nil, toCloudProviderError(err)
 	}
 
 	return &resp.AgentPool, nil
@@ -93,7 +76,7 @@ func listAgentPools(ctx context.Context, client AgentPoolsAPI, rg, clusterName s
 	for pager.More() {
 		page, err := pager.NextPage(ctx)
 		if err != nil {
-			return nil, err
+			return nil, toCloudProviderError(err)
 		}
 		apList = append(apList, page.Value...)
 	}
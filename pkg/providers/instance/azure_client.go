@@ -29,6 +29,7 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v4"
 	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/azure/gpu-provisioner/pkg/auth"
+	"github.com/azure/gpu-provisioner/pkg/providers/instancetype"
 	"github.com/azure/gpu-provisioner/pkg/utils"
 	armopts "github.com/azure/gpu-provisioner/pkg/utils/opts"
 	"github.com/google/uuid"
@@ -44,10 +45,14 @@ type AgentPoolsAPI interface {
 	Get(ctx context.Context, resourceGroupName string, resourceName string, agentPoolName string, options *armcontainerservice.AgentPoolsClientGetOptions) (armcontainerservice.AgentPoolsClientGetResponse, error)
 	BeginDelete(ctx context.Context, resourceGroupName string, resourceName string, agentPoolName string, options *armcontainerservice.AgentPoolsClientBeginDeleteOptions) (*runtime.Poller[armcontainerservice.AgentPoolsClientDeleteResponse], error)
 	NewListPager(resourceGroupName string, resourceName string, options *armcontainerservice.AgentPoolsClientListOptions) *runtime.Pager[armcontainerservice.AgentPoolsClientListResponse]
+	GetUpgradeProfile(ctx context.Context, resourceGroupName string, resourceName string, agentPoolName string, options *armcontainerservice.AgentPoolsClientGetUpgradeProfileOptions) (armcontainerservice.AgentPoolsClientGetUpgradeProfileResponse, error)
 }
 
 type AZClient struct {
 	agentPoolsClient AgentPoolsAPI
+	// resourceSKUsClient and subnetsClient are nil when AZClient is created from the agent pools API.
+	resourceSKUsClient instancetype.ResourceSKUsAPI
+	subnetsClient      SubnetsAPI
 }
 
 func NewAZClientFromAPI(
@@ -105,11 +110,33 @@ func NewAZClient(cfg *auth.Config, env *azure.Environment) (*AZClient, error) {
 	}
 	klog.V(5).Infof("Created agent pool client %v using token credential", agentPoolClient)
 
+	resourceSKUsClient, err := instancetype.NewResourceSKUsClient(cfg.SubscriptionID, cred, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	subnetsClient, err := NewSubnetsClient(cred, opts)
+	if err != nil {
+		return nil, err
+	}
+
 	return &AZClient{
-		agentPoolsClient: agentPoolClient,
+		agentPoolsClient:   agentPoolClient,
+		resourceSKUsClient: resourceSKUsClient,
+		subnetsClient:      subnetsClient,
 	}, nil
 }
 
+// ResourceSKUsClient returns the client of Resource SKUs API which refreshes the GPU SKU catalog.
+func (c *AZClient) ResourceSKUsClient() instancetype.ResourceSKUsAPI {
+	return c.resourceSKUsClient
+}
+
+// SubnetsClient returns the client of subnets API which checks the subnets of KaitoNodeClasses.
+func (c *AZClient) SubnetsClient() SubnetsAPI {
+	return c.subnetsClient
+}
+
 func setArmClientOptions() *arm.ClientOptions {
 	opt := new(arm.ClientOptions)
 
@@ -0,0 +1,129 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instance
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v4"
+	"github.com/samber/lo"
+	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+	"sigs.k8s.io/karpenter/pkg/cloudprovider"
+)
+
+const (
+	// LatestNodeImageCacheTTL is the time before the latest node image version of a node image is fetched again, AKS
+	// releases node images weekly so it doesn't need to be fresher.
+	LatestNodeImageCacheTTL = time.Hour
+	// AgentPoolListCacheTTL is the time before the agent pools are listed again for drift detection, the versions of
+	// agent pools only change when they're upgraded.
+	AgentPoolListCacheTTL = time.Minute
+
+	agentPoolListCacheKey = "agentPools"
+)
+
+// NodeVersions are the versions of the agent pool which nodeclaim is launched with.
+type NodeVersions struct {
+	// NodeImageVersion is the node image version of the agent pool, e.g. AKSUbuntu-2204gen2containerd-202405.03.0.
+	NodeImageVersion string
+	// LatestNodeImageVersion is the latest node image version available for the OS SKU of the agent pool.
+	LatestNodeImageVersion string
+	// KubernetesVersion is the Kubernetes version which the nodes of the agent pool are running.
+	KubernetesVersion string
+}
+
+// GetNodeVersions returns the node image and Kubernetes versions of the agent pool which nodeClaim is launched with,
+// NodeClaimNotFoundError is returned when the agent pool doesn't exist. The agent pools are cached for
+// AgentPoolListCacheTTL, and the latest node image version is cached for LatestNodeImageCacheTTL.
+func (p *Provider) GetNodeVersions(ctx context.Context, nodeClaim *karpenterv1.NodeClaim) (*NodeVersions, error) {
+	apName := agentPoolNameOfNodeClaim(nodeClaim)
+	ap, err := p.cachedAgentPool(ctx, apName)
+	if err != nil {
+		return nil, err
+	}
+	if ap.Properties == nil {
+		return nil, fmt.Errorf("agent pool %q has no properties", apName)
+	}
+
+	versions := &NodeVersions{
+		NodeImageVersion: lo.FromPtr(ap.Properties.NodeImageVersion),
+		// CurrentOrchestratorVersion includes the patch version, while OrchestratorVersion may be major.minor only.
+		KubernetesVersion: lo.CoalesceOrEmpty(lo.FromPtr(ap.Properties.CurrentOrchestratorVersion), lo.FromPtr(ap.Properties.OrchestratorVersion)),
+	}
+	if versions.NodeImageVersion == "" {
+		return versions, nil
+	}
+	if versions.LatestNodeImageVersion, err = p.latestNodeImageVersion(ctx, apName, ap); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// cachedAgentPool returns the agent pool apName from the agent pools listed within AgentPoolListCacheTTL, the agent
+// pools created after they're listed are reported as not found until they're listed again.
+func (p *Provider) cachedAgentPool(ctx context.Context, apName string) (*armcontainerservice.AgentPool, error) {
+	p.agentPoolsMu.Lock()
+	defer p.agentPoolsMu.Unlock()
+	cached, ok := p.agentPools.Get(agentPoolListCacheKey)
+	if !ok {
+		apList, err := listAgentPools(ctx, p.azClient.agentPoolsClient, p.resourceGroup, p.clusterName)
+		if err != nil {
+			return nil, fmt.Errorf("listing agent pools, %w", err)
+		}
+		cached = lo.SliceToMap(apList, func(ap *armcontainerservice.AgentPool) (string, *armcontainerservice.AgentPool) {
+			return lo.FromPtr(ap.Name), ap
+		})
+		p.agentPools.SetDefault(agentPoolListCacheKey, cached)
+	}
+	ap, ok := cached.(map[string]*armcontainerservice.AgentPool)[apName]
+	if !ok {
+		return nil, cloudprovider.NewNodeClaimNotFoundError(fmt.Errorf("agent pool %q is not found", apName))
+	}
+	return ap, nil
+}
+
+// latestNodeImageVersion returns the latest node image version available for the node image of agent pool ap. It's
+// cached by the OS SKU and the node image without version, since all agent pools with the same node image have the
+// same latest version.
+func (p *Provider) latestNodeImageVersion(ctx context.Context, apName string, ap *armcontainerservice.AgentPool) (string, error) {
+	key := nodeImageKey(ap)
+	if latest, ok := p.latestNodeImages.Get(key); ok {
+		return latest.(string), nil
+	}
+	profile, err := p.azClient.agentPoolsClient.GetUpgradeProfile(ctx, p.resourceGroup, p.clusterName, apName, nil)
+	if err != nil {
+		return "", fmt.Errorf("getting upgrade profile of agent pool %q, %w", apName, toCloudProviderError(err))
+	}
+	var latest string
+	if profile.Properties != nil {
+		latest = lo.FromPtr(profile.Properties.LatestNodeImageVersion)
+	}
+	p.latestNodeImages.SetDefault(key, latest)
+	return latest, nil
+}
+
+// nodeImageKey returns the OS SKU and the node image of ap without the version, e.g. ubuntu/aksubuntu-2204gen2containerd
+// for the node image version AKSUbuntu-2204gen2containerd-202405.03.0.
+func nodeImageKey(ap *armcontainerservice.AgentPool) string {
+	image := lo.FromPtr(ap.Properties.NodeImageVersion)
+	if i := strings.LastIndex(image, "-"); i > 0 {
+		image = image[:i]
+	}
+	return strings.ToLower(fmt.Sprintf("%s/%s", lo.FromPtr(ap.Properties.OSSKU), image))
+}
@@ -0,0 +1,161 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instance
+
+import (
+	"fmt"
+	"net/http"
+
+	sdkerrors "github.com/Azure/azure-sdk-for-go-extensions/pkg/errors"
+	"sigs.k8s.io/karpenter/pkg/cloudprovider"
+)
+
+const (
+	// ARM error codes which are not defined in sdkerrors.
+	NotFoundErrorCode                         = "NotFound"
+	AllocationFailedErrorCode                 = "AllocationFailed"
+	OverconstrainedAllocationRequestErrorCode = "OverconstrainedAllocationRequest"
+	OverconstrainedZonalAllocationErrorCode   = "OverconstrainedZonalAllocationRequest"
+	QuotaExceededErrorCode                    = "QuotaExceeded"
+	TooManyRequestsErrorCode                  = "TooManyRequests"
+	AuthorizationFailedErrorCode              = "AuthorizationFailed"
+	AuthenticationFailedErrorCode             = "AuthenticationFailed"
+	LinkedAuthorizationFailedErrorCode        = "LinkedAuthorizationFailed"
+)
+
+// Reasons of classified errors, which are used as the reason of NodeClaim Launched condition.
+const (
+	ReasonInsufficientCapacity = "InsufficientCapacity"
+	ReasonNotFound             = "NotFound"
+	ReasonOperationInProgress  = "OperationInProgress"
+	ReasonThrottled            = "Throttled"
+	ReasonInternalError        = "InternalError"
+	ReasonUnauthorized         = "Unauthorized"
+	ReasonInvalidRequest       = "InvalidRequest"
+	ReasonUnknown              = "Unknown"
+)
+
+// AzureError is the classification of an error returned by Azure Resource Manager.
+type AzureError struct {
+	// Code is the ARM error code, it's empty when the error is not returned by ARM.
+	Code string
+	// StatusCode is the http status code of ARM response, it's 0 when the error is not returned by ARM.
+	StatusCode int
+	// Reason is the CamelCase category of the error.
+	Reason string
+	// Retryable is true when the same request may succeed later without any change.
+	Retryable bool
+}
+
+// ClassifyError maps the ARM error code and http status of err to an AzureError.
+func ClassifyError(err error) AzureError {
+	azErr := sdkerrors.IsResponseError(err)
+	if azErr == nil {
+		return AzureError{Reason: ReasonUnknown}
+	}
+
+	classified := AzureError{Code: azErr.ErrorCode, StatusCode: azErr.StatusCode}
+	switch {
+	case isCapacityErrorCode(err):
+		classified.Reason = ReasonInsufficientCapacity
+	case azErr.StatusCode == http.StatusNotFound || azErr.ErrorCode == NotFoundErrorCode || azErr.ErrorCode == sdkerrors.ResourceNotFound:
+		classified.Reason = ReasonNotFound
+	case azErr.StatusCode == http.StatusConflict:
+		// another operation such as creating or deleting is in progress on the agent pool
+		classified.Reason, classified.Retryable = ReasonOperationInProgress, true
+	case azErr.StatusCode == http.StatusTooManyRequests || azErr.ErrorCode == TooManyRequestsErrorCode:
+		classified.Reason, classified.Retryable = ReasonThrottled, true
+	case azErr.StatusCode >= http.StatusInternalServerError:
+		classified.Reason, classified.Retryable = ReasonInternalError, true
+	case azErr.StatusCode == http.StatusUnauthorized || azErr.StatusCode == http.StatusForbidden ||
+		azErr.ErrorCode == AuthorizationFailedErrorCode || azErr.ErrorCode == AuthenticationFailedErrorCode || azErr.ErrorCode == LinkedAuthorizationFailedErrorCode:
+		classified.Reason = ReasonUnauthorized
+	case azErr.StatusCode >= http.StatusBadRequest:
+		classified.Reason = ReasonInvalidRequest
+	default:
+		// errors of long running operation may carry the status code of the initial response
+		classified.Reason = ReasonUnknown
+	}
+	return classified
+}
+
+// isCapacityErrorCode returns true when the requested vm size is not available, out of capacity or quota.
+func isCapacityErrorCode(err error) bool {
+	if sdkerrors.IsSKUNotAvailable(err) ||
+		sdkerrors.ZonalAllocationFailureOccurred(err) ||
+		sdkerrors.SKUFamilyQuotaHasBeenReached(err) ||
+		sdkerrors.SubscriptionQuotaHasBeenReached(err) ||
+		sdkerrors.RegionalQuotaHasBeenReached(err) ||
+		sdkerrors.LowPriorityQuotaHasBeenReached(err) {
+		return true
+	}
+
+	azErr := sdkerrors.IsResponseError(err)
+	if azErr == nil {
+		return false
+	}
+	switch azErr.ErrorCode {
+	case AllocationFailedErrorCode, OverconstrainedAllocationRequestErrorCode, OverconstrainedZonalAllocationErrorCode, QuotaExceededErrorCode:
+		return true
+	}
+	return false
+}
+
+// isCapacityError returns true when the agent pool can not be created because the requested
+// vm size is not available, out of capacity or quota, and another offering could be tried.
+func isCapacityError(err error) bool {
+	return ClassifyError(err).Reason == ReasonInsufficientCapacity
+}
+
+// isOperationInProgressError returns true when the request conflicts with another operation on the agent pool.
+func isOperationInProgressError(err error) bool {
+	return ClassifyError(err).Reason == ReasonOperationInProgress
+}
+
+// isRetryableError returns true when the same request may succeed later without any change.
+func isRetryableError(err error) bool {
+	return ClassifyError(err).Retryable
+}
+
+// toCloudProviderError converts err to the karpenter cloudprovider error which matches its classification,
+// err is returned as it is when there is no matching karpenter error.
+func toCloudProviderError(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch ClassifyError(err).Reason {
+	case ReasonNotFound:
+		return cloudprovider.NewNodeClaimNotFoundError(err)
+	case ReasonInsufficientCapacity:
+		return cloudprovider.NewInsufficientCapacityError(err)
+	}
+	return err
+}
+
+// toCreateError converts err returned by creating agent pool to the karpenter cloudprovider error,
+// so the reason is reflected in NodeClaim Launched condition. err is returned as it is when it's not
+// returned by ARM, such as invalid nodeClaim.
+func toCreateError(err error) error {
+	if err == nil || sdkerrors.IsResponseError(err) == nil {
+		return err
+	}
+	classified := ClassifyError(err)
+	if classified.Reason == ReasonInsufficientCapacity {
+		return cloudprovider.NewInsufficientCapacityError(err)
+	}
+	return cloudprovider.NewCreateError(err, classified.Reason,
+		fmt.Sprintf("creating agent pool failed with error code %s(%d), retryable: %t", classified.Code, classified.StatusCode, classified.Retryable))
+}
@@ -0,0 +1,135 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instance
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/karpenter/pkg/cloudprovider"
+)
+
+func TestClassifyError(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected AzureError
+	}{
+		{
+			name:     "error not returned by ARM",
+			err:      errors.New("connection reset by peer"),
+			expected: AzureError{Reason: ReasonUnknown},
+		},
+		{
+			name:     "sku is not available",
+			err:      &azcore.ResponseError{ErrorCode: "SkuNotAvailable", StatusCode: http.StatusBadRequest},
+			expected: AzureError{Code: "SkuNotAvailable", StatusCode: http.StatusBadRequest, Reason: ReasonInsufficientCapacity},
+		},
+		{
+			name:     "zonal allocation failure",
+			err:      &azcore.ResponseError{ErrorCode: "ZonalAllocationFailed", StatusCode: http.StatusOK},
+			expected: AzureError{Code: "ZonalAllocationFailed", StatusCode: http.StatusOK, Reason: ReasonInsufficientCapacity},
+		},
+		{
+			name: "sku family quota is reached",
+			err: &azcore.ResponseError{ErrorCode: "OperationNotAllowed", StatusCode: http.StatusBadRequest,
+				RawResponse: &http.Response{StatusCode: http.StatusBadRequest, Body: io.NopCloser(strings.NewReader(`{"error":{"code":"OperationNotAllowed","message":"Operation could not be completed as it results in exceeding approved standardNCSv3Family Cores quota."}}`))}},
+			expected: AzureError{Code: "OperationNotAllowed", StatusCode: http.StatusBadRequest, Reason: ReasonInsufficientCapacity},
+		},
+		{
+			name:     "agent pool is not found",
+			err:      &azcore.ResponseError{ErrorCode: "NotFound", StatusCode: http.StatusNotFound},
+			expected: AzureError{Code: "NotFound", StatusCode: http.StatusNotFound, Reason: ReasonNotFound},
+		},
+		{
+			name:     "resource is not found without status code",
+			err:      fmt.Errorf("wrapped, %w", &azcore.ResponseError{ErrorCode: "ResourceNotFound"}),
+			expected: AzureError{Code: "ResourceNotFound", Reason: ReasonNotFound},
+		},
+		{
+			name:     "another operation is in progress",
+			err:      &azcore.ResponseError{ErrorCode: "OperationNotAllowed", StatusCode: http.StatusConflict},
+			expected: AzureError{Code: "OperationNotAllowed", StatusCode: http.StatusConflict, Reason: ReasonOperationInProgress, Retryable: true},
+		},
+		{
+			name:     "request is throttled",
+			err:      &azcore.ResponseError{ErrorCode: "TooManyRequests", StatusCode: http.StatusTooManyRequests},
+			expected: AzureError{Code: "TooManyRequests", StatusCode: http.StatusTooManyRequests, Reason: ReasonThrottled, Retryable: true},
+		},
+		{
+			name:     "internal server error",
+			err:      &azcore.ResponseError{ErrorCode: "InternalOperationError", StatusCode: http.StatusInternalServerError},
+			expected: AzureError{Code: "InternalOperationError", StatusCode: http.StatusInternalServerError, Reason: ReasonInternalError, Retryable: true},
+		},
+		{
+			name:     "authorization failed",
+			err:      &azcore.ResponseError{ErrorCode: "AuthorizationFailed", StatusCode: http.StatusForbidden},
+			expected: AzureError{Code: "AuthorizationFailed", StatusCode: http.StatusForbidden, Reason: ReasonUnauthorized},
+		},
+		{
+			name:     "invalid parameter",
+			err:      &azcore.ResponseError{ErrorCode: "InvalidParameter", StatusCode: http.StatusBadRequest},
+			expected: AzureError{Code: "InvalidParameter", StatusCode: http.StatusBadRequest, Reason: ReasonInvalidRequest},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, ClassifyError(tc.err))
+		})
+	}
+}
+
+func TestToCloudProviderError(t *testing.T) {
+	notFoundErr := toCloudProviderError(&azcore.ResponseError{ErrorCode: "NotFound", StatusCode: http.StatusNotFound})
+	assert.True(t, cloudprovider.IsNodeClaimNotFoundError(notFoundErr))
+
+	capacityErr := toCloudProviderError(&azcore.ResponseError{ErrorCode: "AllocationFailed", StatusCode: http.StatusOK})
+	assert.True(t, cloudprovider.IsInsufficientCapacityError(capacityErr))
+
+	throttledErr := &azcore.ResponseError{ErrorCode: "TooManyRequests", StatusCode: http.StatusTooManyRequests}
+	assert.Equal(t, error(throttledErr), toCloudProviderError(throttledErr))
+
+	assert.NoError(t, toCloudProviderError(nil))
+}
+
+func TestIsCapacityErrorCode(t *testing.T) {
+	assert.True(t, isCapacityErrorCode(&azcore.ResponseError{ErrorCode: "AllocationFailed", StatusCode: http.StatusOK}))
+	assert.False(t, isCapacityErrorCode(&azcore.ResponseError{ErrorCode: "InvalidParameter", StatusCode: http.StatusBadRequest}))
+	assert.False(t, isCapacityErrorCode(errors.New("not returned by ARM")))
+}
+
+func TestToCreateError(t *testing.T) {
+	plainErr := errors.New("storage request of nodeclaim should be more than 0")
+	assert.Equal(t, plainErr, toCreateError(plainErr), "error not returned by ARM should be kept as it is")
+
+	capacityErr := toCreateError(&azcore.ResponseError{ErrorCode: "SkuNotAvailable", StatusCode: http.StatusBadRequest})
+	assert.True(t, cloudprovider.IsInsufficientCapacityError(capacityErr))
+
+	var createErr *cloudprovider.CreateError
+	err := toCreateError(&azcore.ResponseError{ErrorCode: "InternalOperationError", StatusCode: http.StatusInternalServerError})
+	if assert.ErrorAs(t, err, &createErr) {
+		assert.Equal(t, ReasonInternalError, createErr.ConditionReason)
+		assert.Contains(t, createErr.ConditionMessage, "InternalOperationError(500)")
+		assert.Contains(t, createErr.ConditionMessage, "retryable: true")
+	}
+}
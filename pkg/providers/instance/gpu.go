@@ -0,0 +1,122 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instance
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v4"
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/providers/instancetype"
+	"github.com/samber/lo"
+	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+const (
+	// LabelGPUInstanceProfile is the nodeclaim annotation which specifies the MIG instance profile of the GPUs, it's
+	// also added as a node label, so workloads can select the nodes partitioned with the profile.
+	LabelGPUInstanceProfile = "kaito.sh/gpu-instance-profile"
+	// AnnotationGPUDriver specifies whether the GPU driver is installed by AKS, Install or None.
+	AnnotationGPUDriver = "kaito.sh/gpu-driver"
+)
+
+// migVMSizes are the vm sizes whose GPUs(A100 and H100) can be partitioned by Multi-Instance GPU.
+// https://learn.microsoft.com/en-us/azure/aks/gpu-multi-instance
+var migVMSizes = []string{
+	"standard_nc24ads_a100_v4",
+	"standard_nc48ads_a100_v4",
+	"standard_nc96ads_a100_v4",
+	"standard_nd96asr_v4",
+	"standard_nd96amsr_a100_v4",
+	"standard_nc40ads_h100_v5",
+	"standard_nc80adis_h100_v5",
+	"standard_nd96isr_h100_v5",
+}
+
+var gpuDrivers = []string{v1alpha1.GPUDriverInstall, v1alpha1.GPUDriverNone}
+
+// isGPUVMSize returns true for the N-series vm sizes which have GPUs, vmSize is matched case-insensitively.
+func isGPUVMSize(vmSize string) bool {
+	return strings.HasPrefix(strings.ToLower(vmSize), "standard_n")
+}
+
+// machineLabels returns the machine type label and the labels describing the GPUs and network capabilities of vmSize.
+// sku is nil when vmSize is not a known GPU SKU, then only the machine type is returned and it falls back to the
+// N-series naming, so the GPU SKUs missing from the catalog are still labelled as gpu.
+func machineLabels(vmSize string, sku *instancetype.SKU) map[string]*string {
+	if sku == nil {
+		return map[string]*string{LabelMachineType: lo.ToPtr(lo.Ternary(isGPUVMSize(vmSize), "gpu", "cpu"))}
+	}
+	labels := lo.MapValues(sku.Labels(), func(v string, _ string) *string { return lo.ToPtr(v) })
+	labels[LabelMachineType] = lo.ToPtr(lo.Ternary(sku.GPU.Count > 0, "gpu", "cpu"))
+	return labels
+}
+
+// gpuInstanceProfile determines the MIG instance profile from NodeClaim annotations, then the KaitoNodeClass. nil is
+// returned when the GPUs are not partitioned.
+func gpuInstanceProfile(nodeClaim *karpenterv1.NodeClaim, nodeClass *v1alpha1.KaitoNodeClass) (*armcontainerservice.GPUInstanceProfile, error) {
+	profile, ok := nodeClaim.Annotations[LabelGPUInstanceProfile]
+	if !ok && nodeClass != nil {
+		profile = nodeClass.Spec.GPUInstanceProfile
+	}
+	if profile == "" {
+		return nil, nil
+	}
+	matched, found := lo.Find(armcontainerservice.PossibleGPUInstanceProfileValues(), func(p armcontainerservice.GPUInstanceProfile) bool {
+		return strings.EqualFold(string(p), profile)
+	})
+	if !found {
+		return nil, fmt.Errorf("gpu instance profile(%s) of nodeclaim(%s) is invalid, must be one of %v", profile, nodeClaim.Name, armcontainerservice.PossibleGPUInstanceProfileValues())
+	}
+	return lo.ToPtr(matched), nil
+}
+
+// gpuDriver determines the GPU driver mode from NodeClaim annotations, then the KaitoNodeClass, defaulting to Install.
+func gpuDriver(nodeClaim *karpenterv1.NodeClaim, nodeClass *v1alpha1.KaitoNodeClass) (string, error) {
+	driver, ok := nodeClaim.Annotations[AnnotationGPUDriver]
+	if !ok && nodeClass != nil {
+		driver = nodeClass.Spec.GPUDriver
+	}
+	if driver == "" {
+		return v1alpha1.GPUDriverInstall, nil
+	}
+	matched, found := lo.Find(gpuDrivers, func(d string) bool { return strings.EqualFold(d, driver) })
+	if !found {
+		return "", fmt.Errorf("gpu driver(%s) of nodeclaim(%s) is invalid, must be one of %v", driver, nodeClaim.Name, gpuDrivers)
+	}
+	return matched, nil
+}
+
+// gpuSettings determines the MIG instance profile and GPU driver mode of nodeClaim, and checks them against the vm
+// size. MIG is only supported by A100 and H100 GPUs, and the GPU driver can only be skipped for GPU vm sizes.
+func gpuSettings(nodeClaim *karpenterv1.NodeClaim, nodeClass *v1alpha1.KaitoNodeClass, vmSize string) (*armcontainerservice.GPUInstanceProfile, string, error) {
+	profile, err := gpuInstanceProfile(nodeClaim, nodeClass)
+	if err != nil {
+		return nil, "", err
+	}
+	if profile != nil && !lo.Contains(migVMSizes, strings.ToLower(vmSize)) {
+		return nil, "", fmt.Errorf("gpu instance profile(%s) of nodeclaim(%s) is not supported by vm size %s", *profile, nodeClaim.Name, vmSize)
+	}
+	driver, err := gpuDriver(nodeClaim, nodeClass)
+	if err != nil {
+		return nil, "", err
+	}
+	if driver == v1alpha1.GPUDriverNone && !isGPUVMSize(vmSize) {
+		return nil, "", fmt.Errorf("gpu driver(%s) of nodeclaim(%s) is not applicable to vm size %s which has no GPU", driver, nodeClaim.Name, vmSize)
+	}
+	return profile, driver, nil
+}
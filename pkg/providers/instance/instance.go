@@ -17,16 +17,30 @@ package instance
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v4"
+	"github.com/awslabs/operatorpkg/status"
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/cache"
+	"github.com/azure/gpu-provisioner/pkg/providers/instancetype"
 	"github.com/azure/gpu-provisioner/pkg/utils"
+	gocache "github.com/patrickmn/go-cache"
 	"github.com/samber/lo"
+	"go.uber.org/multierr"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/util/retry"
 	"k8s.io/klog/v2"
@@ -43,18 +57,108 @@ const (
 	NodeClaimCreationLabel = "kaito.sh/creation-timestamp"
 	// use self-defined layout in order to satisfy node label syntax
 	CreationTimestampLayout = "2006-01-02T15-04-05Z"
+
+	// AnnotationSpotEvictionPolicy and AnnotationSpotMaxPrice configure the spot agent pool created for nodeclaim
+	AnnotationSpotEvictionPolicy = "kaito.sh/spot-eviction-policy"
+	AnnotationSpotMaxPrice       = "kaito.sh/spot-max-price"
 )
 
+// SpotTaint is added by AKS to the nodes of spot agent pools. spot capacity is only used for the nodeclaims carrying
+// the taint, so the pods scheduled to the nodeclaim tolerate it as karpenter simulated.
+var SpotTaint = v1.Taint{Key: "kubernetes.azure.com/scalesetpriority", Value: "spot", Effect: v1.TaintEffectNoSchedule}
+
+const (
+	// AnnotationCreateResumeToken and AnnotationCreateOffering record the agent pool creation in progress for nodeclaim,
+	// so the creation can be resumed after gpu-provisioner restarts instead of sending a new request.
+	AnnotationCreateResumeToken = "kaito.sh/agentpool-create-resume-token"
+	AnnotationCreateOffering    = "kaito.sh/agentpool-create-offering"
+
+	// AnnotationAgentPoolName records the name of agent pool which nodeclaim is launched with.
+	AnnotationAgentPoolName = "kaito.sh/agentpool-name"
+)
+
+// agentPoolPollFrequency is the interval of polling the agent pool operations when ARM doesn't specify Retry-After.
+const agentPoolPollFrequency = 10 * time.Second
+
+const (
+	// NodeClaimNameTag records the name of nodeclaim on the agent pool created for it, because the agent pool name
+	// is generated from the hash of nodeclaim name when nodeclaim name is not a valid agent pool name.
+	NodeClaimNameTag = "kaito-nodeclaim"
+	// SkipGPUDriverInstallTag skips the GPU driver installation by AKS on the nodes of agent pool.
+	SkipGPUDriverInstallTag = "SkipGPUDriverInstall"
+	// agentPoolNamePrefix and agentPoolNameHashLength are used for generating agent pool name from nodeclaim name.
+	agentPoolNamePrefix     = "n"
+	agentPoolNameHashLength = 11
+)
+
+// agentPoolTypeVirtualMachines is not defined in the vendored armcontainerservice, the vm size is still specified
+// by VMSize property for VirtualMachines agent pool which has only one node.
+const agentPoolTypeVirtualMachines = armcontainerservice.AgentPoolType(v1alpha1.AgentPoolTypeVirtualMachines)
+
 var (
-	KaitoNodeLabels    = []string{"kaito.sh/workspace", "kaito.sh/ragengine"}
+	KaitoNodeLabels = []string{"kaito.sh/workspace", "kaito.sh/ragengine"}
+	// https://learn.microsoft.com/en-us/troubleshoot/azure/azure-kubernetes/aks-common-issues-faq#what-naming-restrictions-are-enforced-for-aks-resources-and-parameters-
 	AgentPoolNameRegex = regexp.MustCompile(`^[a-z][a-z0-9]{0,11}$`)
 )
 
+// AgentPoolName returns the name of agent pool created for nodeclaim. nodeclaim name is used as it is when it's a valid
+// agent pool name, otherwise the name is generated from the sha256 hash of nodeclaim name, which is deterministic and
+// long enough to avoid collisions between nodeclaims.
+func AgentPoolName(nodeClaimName string) string {
+	if AgentPoolNameRegex.MatchString(nodeClaimName) {
+		return nodeClaimName
+	}
+	sum := sha256.Sum256([]byte(nodeClaimName))
+	return agentPoolNamePrefix + hex.EncodeToString(sum[:])[:agentPoolNameHashLength]
+}
+
+// agentPoolNameOfNodeClaim returns the name of agent pool recorded in nodeclaim annotations, which differs from
+// AgentPoolName when a warm agent pool is bound to the nodeclaim.
+func agentPoolNameOfNodeClaim(nodeClaim *karpenterv1.NodeClaim) string {
+	if apName := nodeClaim.Annotations[AnnotationAgentPoolName]; apName != "" {
+		return apName
+	}
+	return AgentPoolName(nodeClaim.Name)
+}
+
+// nodeClaimNameFromAgentPool returns the name of nodeclaim which agent pool is created for, agent pool name is
+// returned for the agent pools created before the nodeclaim name is recorded in the agent pool tags.
+func nodeClaimNameFromAgentPool(ap *armcontainerservice.AgentPool) *string {
+	if ap.Properties != nil && lo.FromPtr(ap.Properties.Tags[NodeClaimNameTag]) != "" {
+		return ap.Properties.Tags[NodeClaimNameTag]
+	}
+	return ap.Name
+}
+
 type Provider struct {
 	azClient      *AZClient
 	kubeClient    client.Client
 	resourceGroup string
 	clusterName   string
+	// agentPoolType is the type of agent pool created for the nodeclaims which don't specify it in KaitoNodeClass.
+	agentPoolType string
+	// warmPools is the number of warm standby agent pools kept for each vm size, the vm sizes are in lower case.
+	warmPools map[string]int
+	// tagOptions are the Azure tags added to the agent pools for cost allocation.
+	tagOptions TagOptions
+	// warmPoolMu guards claimedWarmPools, which prevents a warm agent pool from being bound to more than one nodeclaim
+	// or deleted while it's bound.
+	warmPoolMu       sync.Mutex
+	claimedWarmPools sets.Set[string]
+	// instanceTypeProvider serves the GPU SKUs of the region.
+	instanceTypeProvider *instancetype.Provider
+	// unavailableOfferings caches the offerings which failed recently with capacity errors, it's shared with
+	// instanceTypeProvider so the offerings are reported as unavailable to karpenter too.
+	unavailableOfferings *cache.UnavailableOfferings
+	// latestNodeImages caches the latest node image versions of the node images, so the upgrade profile is not
+	// fetched for every nodeclaim when the drift is checked.
+	latestNodeImages *gocache.Cache
+	// agentPoolsMu guards listing agent pools into agentPools, which caches the agent pools for drift detection so
+	// they're listed once for all nodeclaims instead of being fetched one by one.
+	agentPoolsMu sync.Mutex
+	agentPools   *gocache.Cache
+	// pollFrequency is the interval of polling the agent pool creation and update.
+	pollFrequency time.Duration
 }
 
 func NewProvider(
@@ -62,64 +166,151 @@ func NewProvider(
 	kubeClient client.Client,
 	resourceGroup string,
 	clusterName string,
+	agentPoolType string,
+	warmPools map[string]int,
+	tagOptions TagOptions,
+	instanceTypeProvider *instancetype.Provider,
 ) *Provider {
 	return &Provider{
 		azClient:      azClient,
 		kubeClient:    kubeClient,
 		resourceGroup: resourceGroup,
 		clusterName:   clusterName,
+		agentPoolType: agentPoolType,
+		warmPools:     lo.MapKeys(warmPools, func(_ int, vmSize string) string { return strings.ToLower(vmSize) }),
+		tagOptions:    tagOptions,
+
+		claimedWarmPools: sets.New[string](),
+
+		instanceTypeProvider: instanceTypeProvider,
+		unavailableOfferings: instanceTypeProvider.UnavailableOfferings(),
+		latestNodeImages:     gocache.New(LatestNodeImageCacheTTL, time.Hour),
+		agentPools:           gocache.New(AgentPoolListCacheTTL, time.Hour),
+		pollFrequency:        agentPoolPollFrequency,
 	}
 }
 
+// InstanceTypes returns the GPU SKUs as karpenter instance types, nodeClass is nil when the nodepool doesn't reference
+// a KaitoNodeClass.
+func (p *Provider) InstanceTypes(ctx context.Context, nodeClass *v1alpha1.KaitoNodeClass) []*cloudprovider.InstanceType {
+	return p.instanceTypeProvider.List(ctx, nodeClass)
+}
+
+// sku returns the GPU SKU of vmSize, nil is returned when vmSize is not a known GPU SKU.
+func (p *Provider) sku(ctx context.Context, vmSize string) *instancetype.SKU {
+	if sku, ok := p.instanceTypeProvider.Get(ctx, vmSize); ok {
+		return &sku
+	}
+	return nil
+}
+
 // Create an instance given the constraints.
 // instanceTypes should be sorted by priority for spot capacity type.
 func (p *Provider) Create(ctx context.Context, nodeClaim *karpenterv1.NodeClaim) (*Instance, error) {
 	klog.InfoS("Instance.Create", "nodeClaim", klog.KObj(nodeClaim))
 
-	// We made a strong assumption here. The nodeClaim name should be a valid agent pool name without "-".
-	apName := nodeClaim.Name
-	if !AgentPoolNameRegex.MatchString(apName) {
-		//https://learn.microsoft.com/en-us/troubleshoot/azure/azure-kubernetes/aks-common-issues-faq#what-naming-restrictions-are-enforced-for-aks-resources-and-parameters-
-		return nil, fmt.Errorf("agentpool name(%s) is invalid, must match regex pattern: ^[a-z][a-z0-9]{0,11}$", apName)
+	apName := AgentPoolName(nodeClaim.Name)
+
+	zones, err := orderedZones(nodeClaim)
+	if err != nil {
+		return nil, err
+	}
+	nodeClass, err := p.getNodeClass(ctx, nodeClaim)
+	if err != nil {
+		return nil, err
+	}
+	instanceTypes := orderedInstanceTypes(nodeClaim)
+	if len(instanceTypes) == 0 {
+		// nodeClaim which only requests GPUs is launched with the cheapest matching SKUs.
+		if instanceTypes, err = p.resolveInstanceTypes(ctx, nodeClaim, nodeClass); err != nil {
+			return nil, err
+		}
+	}
+	for _, instanceType := range instanceTypes {
+		if _, _, err := gpuSettings(nodeClaim, nodeClass, instanceType); err != nil {
+			return nil, err
+		}
+	}
+	apType := p.resolveAgentPoolType(nodeClass)
+	capacityTypes := orderedCapacityTypes(nodeClaim)
+	if len(capacityTypes) == 0 {
+		return nil, fmt.Errorf("spot capacity type of nodeclaim(%s) requires taint %s, which is added to the nodes of spot agent pool", nodeClaim.Name, SpotTaint.ToString())
+	}
+	if apType == agentPoolTypeVirtualMachines {
+		// spot priority is only supported by virtual machine scale set agent pools.
+		capacityTypes = lo.Without(capacityTypes, karpenterv1.CapacityTypeSpot)
+		if len(capacityTypes) == 0 {
+			return nil, fmt.Errorf("spot capacity type of nodeclaim(%s) is not supported by %s agent pool", nodeClaim.Name, apType)
+		}
 	}
 
-	var ap *armcontainerservice.AgentPool
-	err := retry.OnError(retry.DefaultBackoff, func(err error) bool {
-		return false
-	}, func() error {
-		instanceTypes := scheduling.NewNodeSelectorRequirementsWithMinValues(nodeClaim.Spec.Requirements...).Get("node.kubernetes.io/instance-type").Values()
-		if len(instanceTypes) == 0 {
-			return fmt.Errorf("nodeClaim spec has no requirement for instance type")
+	// offerings are tried in the order of instance types listed in the nodeClaim requirement, spot capacity is preferred
+	// when it's allowed and tolerated, then all allowed zones are tried in order. only capacity related failures(sku not available,
+	// allocation failure, quota) fall back to the next one.
+	offerings := orderedOfferings(instanceTypes, capacityTypes, zones)
+	// the creation started by a Create call before gpu-provisioner restarted is resumed, and the offerings tried
+	// before are skipped.
+	start, resumeToken := resumedOffering(nodeClaim, offerings)
+	if resumeToken == "" && nodeClaim.Annotations[AnnotationCreateResumeToken] != "" {
+		// the offering of the saved creation is no longer requested by nodeClaim, so the stale token is removed.
+		logging.FromContext(ctx).Warnf("dropping resume token of nodeclaim(%s), offering %s is not requested", nodeClaim.Name, nodeClaim.Annotations[AnnotationCreateOffering])
+		if err := p.updateCreateResumeToken(ctx, nodeClaim, offering{}, ""); err != nil {
+			return nil, err
 		}
+	}
+	if resumeToken == "" {
+		warm, o, err := p.bindWarmPool(ctx, nodeClaim, nodeClass, offerings, apType)
+		if err != nil {
+			logging.FromContext(ctx).Warnf("binding warm agent pool to nodeclaim(%s) failed, fall back to creating agent pool, %v", nodeClaim.Name, err)
+		} else if warm != nil {
+			return p.launchedInstance(ctx, warm, o, nodeClass)
+		}
+	}
 
-		vmSize := instanceTypes[0]
-		apObj, apErr := newAgentPoolObject(vmSize, nodeClaim)
-		if apErr != nil {
-			return apErr
+	var ap *armcontainerservice.AgentPool
+	var launched offering
+	var capacityErrs []error
+	for _, o := range offerings[start:] {
+		// offerings failed recently with capacity errors are skipped, except the one whose creation is resumed.
+		if resumeToken == "" && p.unavailableOfferings.IsUnavailable(o.vmSize, o.zone, o.capacityType) {
+			logging.FromContext(ctx).Debugf("skipping offering %s for nodeclaim(%s), it's unavailable recently", o, nodeClaim.Name)
+			capacityErrs = append(capacityErrs, fmt.Errorf("offering %s is unavailable recently", o))
+			continue
 		}
 
-		logging.FromContext(ctx).Debugf("creating Agent pool %s (%s)", apName, vmSize)
 		var err error
-		ap, err = createAgentPool(ctx, p.azClient.agentPoolsClient, p.resourceGroup, apName, p.clusterName, apObj)
-		if err != nil {
-			switch {
-			case strings.Contains(err.Error(), "Operation is not allowed because there's an in progress create node pool operation"):
-				// when gpu-provisioner restarted after crash for unknown reason, we may come across this error that agent pool creating
-				// is in progress, so we just need to wait node ready based on the apObj.
-				ap = &apObj
-				return nil
-			default:
-				logging.FromContext(ctx).Errorf("failed to create agent pool for nodeclaim(%s), %v", nodeClaim.Name, err)
-				return fmt.Errorf("agentPool.BeginCreateOrUpdate for %q failed: %w", apName, err)
-			}
+		ap, err = p.createAgentPoolWithOffering(ctx, apName, o, apType, nodeClaim, nodeClass, resumeToken)
+		resumeToken = ""
+		if err == nil {
+			launched = o
+			break
 		}
-		logging.FromContext(ctx).Debugf("created agent pool %s", *ap.ID)
-		return nil
-	})
-	if err != nil {
+		if !isCapacityError(err) {
+			return nil, toCreateError(err)
+		}
+
+		logging.FromContext(ctx).Warnf("offering %s is unavailable for nodeclaim(%s), %v", o, nodeClaim.Name, err)
+		p.unavailableOfferings.MarkUnavailable(ctx, ClassifyError(err).Code, o.vmSize, o.zone, o.capacityType)
+		capacityErrs = append(capacityErrs, fmt.Errorf("offering %s, %w", o, err))
+		// agent pool with the failed offering should be removed before next offering is tried,
+		// because vm size and priority of an agent pool can not be changed.
+		if err := deleteAgentPool(ctx, p.azClient.agentPoolsClient, p.resourceGroup, p.clusterName, apName); cloudprovider.IgnoreNodeClaimNotFoundError(err) != nil {
+			return nil, fmt.Errorf("cleaning up agent pool %q for unavailable offering %s, %w", apName, o, err)
+		}
+	}
+	if ap == nil {
+		return nil, cloudprovider.NewInsufficientCapacityError(fmt.Errorf("all requested instance types %v are unavailable, %w", instanceTypes, multierr.Combine(capacityErrs...)))
+	}
+	if err := p.updateCreateResumeToken(ctx, nodeClaim, launched, ""); err != nil {
 		return nil, err
 	}
+	return p.launchedInstance(ctx, ap, launched, nodeClass)
+}
 
+// launchedInstance waits for the node of launched agent pool to be registered, and returns the instance with the
+// offering which is finally used for launching nodeclaim and the hash of nodeClass which it's launched with.
+func (p *Provider) launchedInstance(ctx context.Context, ap *armcontainerservice.AgentPool, launched offering,
+	nodeClass *v1alpha1.KaitoNodeClass) (*Instance, error) {
 	instance, err := p.fromRegisteredAgentPoolToInstance(ctx, ap)
 	if instance == nil && err == nil {
 		// means the node object has not been found yet, we wait until the node is created
@@ -147,17 +338,269 @@ func (p *Provider) Create(ctx context.Context, nodeClaim *karpenterv1.NodeClaim)
 			return nil, err
 		}
 	}
+	if instance != nil {
+		// record the instance type and capacity type which are finally used for creating agent pool
+		instance.Type = lo.ToPtr(launched.vmSize)
+		instance.CapacityType = lo.ToPtr(launched.capacityType)
+		instance.Labels = lo.Assign(instance.Labels, map[string]string{
+			v1.LabelInstanceTypeStable:       launched.vmSize,
+			karpenterv1.CapacityTypeLabelKey: launched.capacityType,
+		})
+		if launched.zone != "" {
+			instance.Labels[v1.LabelTopologyZone] = launched.zone
+		}
+		if nodeClass != nil {
+			instance.NodeClassHash = lo.ToPtr(nodeClass.Hash())
+		}
+	}
 	return instance, err
 }
 
+// createAgentPoolWithOffering creates agent pool for nodeClaim with the specified offering, or resumes the creation
+// when resumeToken is specified, and waits for the creation to complete. Create waits for the agent pool because
+// karpenter deletes the nodeclaims which are not launched within the launch timeout(5 minutes) since the Launched
+// condition became Unknown, which is shorter than the creation of most GPU agent pools. The resume token is saved in
+// nodeClaim annotations while waiting, so the creation is resumed instead of being sent again when gpu-provisioner
+// restarts.
+func (p *Provider) createAgentPoolWithOffering(ctx context.Context, apName string, o offering, apType armcontainerservice.AgentPoolType,
+	nodeClaim *karpenterv1.NodeClaim, nodeClass *v1alpha1.KaitoNodeClass, resumeToken string) (*armcontainerservice.AgentPool, error) {
+	apObj, err := newAgentPoolObject(o, p.sku(ctx, o.vmSize), nodeClaim, nodeClass, apType, p.tagOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.FromContext(ctx).Debugf("creating Agent pool %s (%s)", apName, o)
+	poller, err := beginCreateAgentPool(ctx, p.azClient.agentPoolsClient, p.resourceGroup, apName, p.clusterName, apObj, resumeToken)
+	if err != nil {
+		if isOperationInProgressError(err) {
+			// when the resume token is lost, for example gpu-provisioner crashed before saving it, we may come across this error
+			// that agent pool creating is in progress, so we just need to wait for the existing agent pool.
+			if existing, getErr := getAgentPool(ctx, p.azClient.agentPoolsClient, p.resourceGroup, p.clusterName, apName); getErr == nil {
+				switch lo.FromPtr(existing.Properties.ProvisioningState) {
+				case "Creating":
+					return p.waitForAgentPool(ctx, apName)
+				case "Succeeded":
+					return existing, nil
+				}
+			}
+		}
+		logging.FromContext(ctx).Errorf("failed to create agent pool for nodeclaim(%s), reason: %s, %v", nodeClaim.Name, ClassifyError(err).Reason, err)
+		if resumeToken != "" && !isRetryableError(err) {
+			// resume token may be expired or invalid, a new creation request will be sent by the following Create call.
+			// it's kept when the request is throttled or failed transiently, so the creation is resumed by the next call.
+			if clearErr := p.updateCreateResumeToken(ctx, nodeClaim, o, ""); clearErr != nil {
+				return nil, clearErr
+			}
+		}
+		return nil, fmt.Errorf("agentPool.BeginCreateOrUpdate for %q failed: %w", apName, err)
+	}
+
+	if resumeToken == "" && !poller.Done() {
+		token, err := poller.ResumeToken()
+		if err != nil {
+			return nil, fmt.Errorf("getting resume token of creating agent pool %q, %w", apName, err)
+		}
+		if err := p.updateCreateResumeToken(ctx, nodeClaim, o, token); err != nil {
+			return nil, err
+		}
+	}
+	logging.FromContext(ctx).Debugf("waiting for agent pool %s (%s) to be created", apName, o)
+	res, err := poller.PollUntilDone(ctx, &runtime.PollUntilDoneOptions{Frequency: p.pollFrequency})
+	if err != nil {
+		logging.FromContext(ctx).Errorf("failed to create agent pool for nodeclaim(%s), reason: %s, %v", nodeClaim.Name, ClassifyError(err).Reason, err)
+		if poller.Done() {
+			// the creation is failed, so there is nothing to resume. the token is kept when polling failed transiently.
+			if clearErr := p.updateCreateResumeToken(ctx, nodeClaim, o, ""); clearErr != nil {
+				return nil, clearErr
+			}
+		}
+		return nil, fmt.Errorf("agentPool.BeginCreateOrUpdate for %q failed: %w", apName, err)
+	}
+	logging.FromContext(ctx).Debugf("created agent pool %s", lo.FromPtr(res.AgentPool.ID))
+	return &res.AgentPool, nil
+}
+
+// waitForAgentPool waits for the agent pool which is being created or updated by another request to succeed, it's used
+// when the resume token of the request is lost.
+func (p *Provider) waitForAgentPool(ctx context.Context, apName string) (*armcontainerservice.AgentPool, error) {
+	var ap *armcontainerservice.AgentPool
+	err := wait.PollUntilContextCancel(ctx, p.pollFrequency, true, func(ctx context.Context) (bool, error) {
+		var err error
+		if ap, err = getAgentPool(ctx, p.azClient.agentPoolsClient, p.resourceGroup, p.clusterName, apName); err != nil {
+			return false, err
+		}
+		switch state := lo.FromPtr(ap.Properties.ProvisioningState); state {
+		case "Succeeded":
+			return true, nil
+		case "Creating", "Updating":
+			return false, nil
+		default:
+			return false, fmt.Errorf("agent pool %q is %s", apName, state)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("waiting for agent pool %q, %w", apName, err)
+	}
+	return ap, nil
+}
+
+// resumedOffering returns the index of offering and the resume token of the creation which is saved in nodeClaim
+// annotations by previous Create call, the first offering and empty token are returned when there is nothing to resume.
+func resumedOffering(nodeClaim *karpenterv1.NodeClaim, offerings []offering) (int, string) {
+	token := nodeClaim.Annotations[AnnotationCreateResumeToken]
+	if token == "" {
+		return 0, ""
+	}
+	index := lo.IndexOf(lo.Map(offerings, func(o offering, _ int) string { return o.String() }), nodeClaim.Annotations[AnnotationCreateOffering])
+	if index < 0 {
+		return 0, ""
+	}
+	return index, token
+}
+
+// updateCreateResumeToken saves the resume token and offering of agent pool creation in nodeClaim annotations,
+// the annotations are removed when token is empty.
+func (p *Provider) updateCreateResumeToken(ctx context.Context, nodeClaim *karpenterv1.NodeClaim, o offering, token string) error {
+	stored := nodeClaim.DeepCopy()
+	nodeClaim.Annotations = lo.Assign(nodeClaim.Annotations)
+	if token == "" {
+		delete(nodeClaim.Annotations, AnnotationCreateResumeToken)
+		delete(nodeClaim.Annotations, AnnotationCreateOffering)
+	} else {
+		nodeClaim.Annotations[AnnotationCreateResumeToken] = token
+		nodeClaim.Annotations[AnnotationCreateOffering] = o.String()
+	}
+	if equality.Semantic.DeepEqual(stored.Annotations, nodeClaim.Annotations) {
+		return nil
+	}
+	if err := p.kubeClient.Patch(ctx, nodeClaim, client.MergeFrom(stored)); err != nil {
+		return fmt.Errorf("updating resume token of creating agent pool for nodeclaim(%s), %w", nodeClaim.Name, err)
+	}
+	return nil
+}
+
+// orderedInstanceTypes returns the instance types allowed by nodeClaim requirements in the order they are listed.
+func orderedInstanceTypes(nodeClaim *karpenterv1.NodeClaim) []string {
+	return orderedRequirementValues(nodeClaim, v1.LabelInstanceTypeStable)
+}
+
+// orderedZones returns the zones allowed by nodeClaim requirements in the order they are listed.
+// an empty zone is returned when nodeClaim has no zone requirement, which means agent pool is not pinned to any zone.
+func orderedZones(nodeClaim *karpenterv1.NodeClaim) ([]string, error) {
+	requirements := scheduling.NewNodeSelectorRequirementsWithMinValues(nodeClaim.Spec.Requirements...)
+	if !requirements.Has(v1.LabelTopologyZone) || requirements.Get(v1.LabelTopologyZone).Operator() == v1.NodeSelectorOpExists {
+		return []string{""}, nil
+	}
+	zones := orderedRequirementValues(nodeClaim, v1.LabelTopologyZone)
+	if len(zones) == 0 {
+		return nil, fmt.Errorf("nodeClaim requirement for %s should list the allowed zones with operator In", v1.LabelTopologyZone)
+	}
+	return zones, nil
+}
+
+// orderedRequirementValues returns the values allowed by nodeClaim requirements of key in the order they are listed.
+// scheduling.Requirement keeps values in a set, so the order is taken from the raw requirements.
+func orderedRequirementValues(nodeClaim *karpenterv1.NodeClaim, key string) []string {
+	requirement := scheduling.NewNodeSelectorRequirementsWithMinValues(nodeClaim.Spec.Requirements...).Get(key)
+	var values []string
+	for _, req := range nodeClaim.Spec.Requirements {
+		if req.Key == key && req.Operator == v1.NodeSelectorOpIn {
+			values = append(values, req.Values...)
+		}
+	}
+	return lo.Filter(lo.Uniq(values), func(value string, _ int) bool {
+		return requirement.Has(value)
+	})
+}
+
+// orderedCapacityTypes returns the capacity types allowed by nodeClaim requirements, spot is preferred over on-demand.
+// on-demand is used when nodeClaim has no requirement for capacity type. spot is only allowed when nodeClaim has
+// SpotTaint, otherwise the pods which karpenter scheduled to nodeClaim would not tolerate the taint of the spot nodes.
+func orderedCapacityTypes(nodeClaim *karpenterv1.NodeClaim) []string {
+	requirements := scheduling.NewNodeSelectorRequirementsWithMinValues(nodeClaim.Spec.Requirements...)
+	if !requirements.Has(karpenterv1.CapacityTypeLabelKey) {
+		return []string{karpenterv1.CapacityTypeOnDemand}
+	}
+	requirement := requirements.Get(karpenterv1.CapacityTypeLabelKey)
+	spotTainted := lo.ContainsBy(nodeClaim.Spec.Taints, func(taint v1.Taint) bool { return taint.MatchTaint(&SpotTaint) && taint.Value == SpotTaint.Value })
+	return lo.Filter([]string{karpenterv1.CapacityTypeSpot, karpenterv1.CapacityTypeOnDemand}, func(capacityType string, _ int) bool {
+		return requirement.Has(capacityType) && (capacityType != karpenterv1.CapacityTypeSpot || spotTainted)
+	})
+}
+
+// orderedOfferings returns all combinations of instance types, capacity types and zones,
+// all zones of a capacity type are tried before the next capacity type, and all capacity types
+// of an instance type are tried before the next instance type.
+func orderedOfferings(instanceTypes, capacityTypes, zones []string) []offering {
+	var offerings []offering
+	for _, instanceType := range instanceTypes {
+		for _, capacityType := range capacityTypes {
+			for _, zone := range zones {
+				offerings = append(offerings, offering{vmSize: instanceType, capacityType: capacityType, zone: zone})
+			}
+		}
+	}
+	return offerings
+}
+
+// getNodeClass returns the KaitoNodeClass referenced by nodeClaim, nil is returned when nodeClaim doesn't reference
+// a KaitoNodeClass.
+func (p *Provider) getNodeClass(ctx context.Context, nodeClaim *karpenterv1.NodeClaim) (*v1alpha1.KaitoNodeClass, error) {
+	ref := nodeClaim.Spec.NodeClassRef
+	if ref == nil || ref.Name == "" || ref.Group != v1alpha1.Group || ref.Kind != "KaitoNodeClass" {
+		return nil, nil
+	}
+	nodeClass := &v1alpha1.KaitoNodeClass{}
+	if err := p.kubeClient.Get(ctx, client.ObjectKey{Name: ref.Name}, nodeClass); err != nil {
+		if apierrors.IsNotFound(err) {
+			// nodeclaim is retried instead of being deleted, because the nodeclass may be created after the
+			// nodeclaim, e.g. they are applied together, and the nodeclaim would be recreated by karpenter anyway.
+			return nil, cloudprovider.NewCreateError(fmt.Errorf("kaitonodeclass %q of nodeclaim(%s) is not found", ref.Name, nodeClaim.Name),
+				"NodeClassNotFound", "KaitoNodeClass is not found")
+		}
+		return nil, fmt.Errorf("getting kaitonodeclass %q of nodeclaim(%s), %w", ref.Name, nodeClaim.Name, err)
+	}
+	// nodeclaim is not launched with an invalid nodeclass, it's retried when the readiness is not resolved yet.
+	ready := nodeClass.StatusConditions().Get(status.ConditionReady)
+	if ready.IsFalse() {
+		reasons := lo.FilterMap(nodeClass.GetConditions(), func(c status.Condition, _ int) (string, bool) {
+			return fmt.Sprintf("%s: %s", c.Type, c.Message), c.Type != status.ConditionReady && c.IsFalse()
+		})
+		return nil, cloudprovider.NewNodeClassNotReadyError(fmt.Errorf("kaitonodeclass %q of nodeclaim(%s) is not ready, %s", ref.Name, nodeClaim.Name, strings.Join(reasons, "; ")))
+	}
+	if ready.IsUnknown() {
+		return nil, cloudprovider.NewCreateError(fmt.Errorf("resolving readiness of kaitonodeclass %q, %s", ref.Name, ready.Message),
+			"NodeClassNotReady", "KaitoNodeClass is in Ready=Unknown")
+	}
+	return nodeClass, nil
+}
+
+// resolveAgentPoolType returns the agent pool type specified in nodeClass, or the agent pool type of provider
+// when nodeClass doesn't specify it.
+func (p *Provider) resolveAgentPoolType(nodeClass *v1alpha1.KaitoNodeClass) armcontainerservice.AgentPoolType {
+	if nodeClass != nil && nodeClass.Spec.AgentPoolType != "" {
+		return armcontainerservice.AgentPoolType(nodeClass.Spec.AgentPoolType)
+	}
+	if p.agentPoolType != "" {
+		return armcontainerservice.AgentPoolType(p.agentPoolType)
+	}
+	return armcontainerservice.AgentPoolTypeVirtualMachineScaleSets
+}
+
+// availabilityZone converts zone label value such as "eastus-1" to the availability zone "1" of agent pool.
+func availabilityZone(zone string) string {
+	return zone[strings.LastIndex(zone, "-")+1:]
+}
+
 func (p *Provider) Get(ctx context.Context, id string) (*Instance, error) {
-	apName, err := utils.ParseAgentPoolNameFromID(id)
+	providerID, err := utils.ParseProviderID(id)
 	if err != nil {
 		return nil, fmt.Errorf("getting agentpool name, %w", err)
 	}
+	apName := providerID.AgentPoolName
 	apObj, err := getAgentPool(ctx, p.azClient.agentPoolsClient, p.resourceGroup, p.clusterName, apName)
 	if err != nil {
-		logging.FromContext(ctx).Errorf("Get agentpool %q failed: %v", apName, err)
+		logging.FromContext(ctx).Errorf("Get agentpool %q failed, reason: %s, %v", apName, ClassifyError(err).Reason, err)
 		return nil, err
 	}
 
@@ -167,7 +610,7 @@ func (p *Provider) Get(ctx context.Context, id string) (*Instance, error) {
 func (p *Provider) List(ctx context.Context) ([]*Instance, error) {
 	apList, err := listAgentPools(ctx, p.azClient.agentPoolsClient, p.resourceGroup, p.clusterName)
 	if err != nil {
-		logging.FromContext(ctx).Errorf("Listing agentpools failed: %v", err)
+		logging.FromContext(ctx).Errorf("Listing agentpools failed, reason: %s, %v", ClassifyError(err).Reason, err)
 		return nil, err
 	}
 
@@ -175,12 +618,14 @@ func (p *Provider) List(ctx context.Context) ([]*Instance, error) {
 	return instances, cloudprovider.IgnoreNodeClaimNotFoundError(err)
 }
 
-func (p *Provider) Delete(ctx context.Context, apName string) error {
-	klog.InfoS("Instance.Delete", "agentpool name", apName)
+// Delete deletes the agent pool of the nodeclaim.
+func (p *Provider) Delete(ctx context.Context, nodeClaim *karpenterv1.NodeClaim) error {
+	apName := agentPoolNameOfNodeClaim(nodeClaim)
+	klog.InfoS("Instance.Delete", "nodeclaim", nodeClaim.Name, "agentpool name", apName)
 
 	err := deleteAgentPool(ctx, p.azClient.agentPoolsClient, p.resourceGroup, p.clusterName, apName)
 	if err != nil {
-		logging.FromContext(ctx).Errorf("Deleting agentpool %q failed: %v", apName, err)
+		logging.FromContext(ctx).Errorf("Deleting agentpool %q failed, reason: %s, %v", apName, ClassifyError(err).Reason, err)
 		return err
 	}
 	return nil
@@ -194,16 +639,23 @@ func (p *Provider) convertAgentPoolToInstance(ctx context.Context, apObj *armcon
 	instanceLabels := lo.MapValues(apObj.Properties.NodeLabels, func(k *string, _ string) string {
 		return lo.FromPtr(k)
 	})
+	capacity, allocatable := p.nodeResources(ctx, apObj)
 
 	return &Instance{
-		Name:     apObj.Name,
-		ID:       lo.ToPtr(id),
-		Type:     apObj.Properties.VMSize,
-		SubnetID: apObj.Properties.VnetSubnetID,
-		Tags:     apObj.Properties.Tags,
-		State:    apObj.Properties.ProvisioningState,
-		Labels:   instanceLabels,
-		ImageID:  apObj.Properties.NodeImageVersion,
+		Name:        apObj.Name,
+		ID:          lo.ToPtr(id),
+		Type:        apObj.Properties.VMSize,
+		SubnetID:    apObj.Properties.VnetSubnetID,
+		PodSubnetID: apObj.Properties.PodSubnetID,
+		Tags:        apObj.Properties.Tags,
+		State:       apObj.Properties.ProvisioningState,
+		Labels:      instanceLabels,
+		ImageID:     apObj.Properties.NodeImageVersion,
+		Capacity:    capacity,
+		Allocatable: allocatable,
+
+		CapacityType:  capacityTypeFromAgentPool(apObj),
+		NodeClaimName: nodeClaimNameFromAgentPool(apObj),
 	}, nil
 }
 
@@ -243,15 +695,22 @@ func (p *Provider) fromRegisteredAgentPoolToInstance(ctx context.Context, apObj
 	instanceLabels := lo.MapValues(apObj.Properties.NodeLabels, func(k *string, _ string) string {
 		return lo.FromPtr(k)
 	})
+	capacity, allocatable := p.nodeResources(ctx, apObj)
 	return &Instance{
 		Name: apObj.Name,
 		// ID:      lo.ToPtr(fmt.Sprint("azure://", p.getVMSSNodeProviderID(lo.FromPtr(subID), tokens[0]))),
-		ID:       lo.ToPtr(nodes[0].Spec.ProviderID),
-		Type:     apObj.Properties.VMSize,
-		SubnetID: apObj.Properties.VnetSubnetID,
-		Tags:     apObj.Properties.Tags,
-		State:    apObj.Properties.ProvisioningState,
-		Labels:   instanceLabels,
+		ID:          lo.ToPtr(nodes[0].Spec.ProviderID),
+		Type:        apObj.Properties.VMSize,
+		SubnetID:    apObj.Properties.VnetSubnetID,
+		PodSubnetID: apObj.Properties.PodSubnetID,
+		Tags:        apObj.Properties.Tags,
+		State:       apObj.Properties.ProvisioningState,
+		Labels:      instanceLabels,
+		Capacity:    capacity,
+		Allocatable: allocatable,
+
+		CapacityType:  capacityTypeFromAgentPool(apObj),
+		NodeClaimName: nodeClaimNameFromAgentPool(apObj),
 	}, nil
 }
 
@@ -266,13 +725,18 @@ func (p *Provider) fromKaitoAgentPoolToInstance(ctx context.Context, apObj *armc
 		return lo.FromPtr(k)
 	})
 	ins := &Instance{
-		Name:     apObj.Name,
-		Type:     apObj.Properties.VMSize,
-		SubnetID: apObj.Properties.VnetSubnetID,
-		Tags:     apObj.Properties.Tags,
-		State:    apObj.Properties.ProvisioningState,
-		Labels:   instanceLabels,
+		Name:        apObj.Name,
+		Type:        apObj.Properties.VMSize,
+		SubnetID:    apObj.Properties.VnetSubnetID,
+		PodSubnetID: apObj.Properties.PodSubnetID,
+		Tags:        apObj.Properties.Tags,
+		State:       apObj.Properties.ProvisioningState,
+		Labels:      instanceLabels,
+
+		CapacityType:  capacityTypeFromAgentPool(apObj),
+		NodeClaimName: nodeClaimNameFromAgentPool(apObj),
 	}
+	ins.Capacity, ins.Allocatable = p.nodeResources(ctx, apObj)
 
 	nodes, err := p.getNodesByName(ctx, lo.FromPtr(apObj.Name))
 	if err != nil {
@@ -286,6 +750,14 @@ func (p *Provider) fromKaitoAgentPoolToInstance(ctx context.Context, apObj *armc
 	return ins, nil
 }
 
+// nodeResources returns the capacity and allocatable resources of the node of apObj, they're nil when the vm size is not
+// a known GPU SKU.
+func (p *Provider) nodeResources(ctx context.Context, apObj *armcontainerservice.AgentPool) (v1.ResourceList, v1.ResourceList) {
+	capacity, allocatable, _ := p.instanceTypeProvider.NodeResources(ctx, lo.FromPtr(apObj.Properties.VMSize),
+		int64(lo.FromPtr(apObj.Properties.MaxPods)), int64(lo.FromPtr(apObj.Properties.OSDiskSizeGB)), string(lo.FromPtr(apObj.Properties.GpuInstanceProfile)))
+	return capacity, allocatable
+}
+
 func (p *Provider) fromAPListToInstances(ctx context.Context, apList []*armcontainerservice.AgentPool) ([]*Instance, error) {
 	instances := []*Instance{}
 	if len(apList) == 0 {
@@ -302,6 +774,11 @@ func (p *Provider) fromAPListToInstances(ctx context.Context, apList []*armconta
 			continue
 		}
 
+		// skip warm agentPool which is not bound to any nodeclaim yet
+		if isWarmAgentPool(apList[index]) {
+			continue
+		}
+
 		instance, err := p.fromKaitoAgentPoolToInstance(ctx, apList[index])
 		if err != nil {
 			return instances, err
@@ -318,54 +795,113 @@ func (p *Provider) fromAPListToInstances(ctx context.Context, apList []*armconta
 	return instances, nil
 }
 
-func newAgentPoolObject(vmSize string, nodeClaim *karpenterv1.NodeClaim) (armcontainerservice.AgentPool, error) {
+// newAgentPoolObject builds the agent pool for nodeClaim with the offering, the settings of nodeClass are applied when
+// nodeClaim references a KaitoNodeClass, otherwise the defaults are used. sku is the GPU SKU of the offering, it's nil
+// when the vm size is not a known GPU SKU.
+func newAgentPoolObject(o offering, sku *instancetype.SKU, nodeClaim *karpenterv1.NodeClaim, nodeClass *v1alpha1.KaitoNodeClass, apType armcontainerservice.AgentPoolType,
+	tagOptions TagOptions) (armcontainerservice.AgentPool, error) {
+	vmSize := o.vmSize
 	taints := nodeClaim.Spec.Taints
 	taintsStr := []*string{}
 	for _, t := range taints {
 		taintsStr = append(taintsStr, lo.ToPtr(fmt.Sprintf("%s=%s:%s", t.Key, t.Value, t.Effect)))
 	}
 
-	scaleSetsType := armcontainerservice.AgentPoolTypeVirtualMachineScaleSets
 	// todo: why nodepool label is used here
 	labels := map[string]*string{karpenterv1.NodePoolLabelKey: lo.ToPtr("kaito")}
 	for k, v := range nodeClaim.Labels {
 		labels[k] = lo.ToPtr(v)
 	}
 
-	if strings.Contains(vmSize, "Standard_N") {
-		labels = lo.Assign(labels, map[string]*string{LabelMachineType: lo.ToPtr("gpu")})
-	} else {
-		labels = lo.Assign(labels, map[string]*string{LabelMachineType: lo.ToPtr("cpu")})
-	}
+	// the labels derived from the SKU describe the actual machine, so they take precedence over nodeClaim labels.
+	labels = lo.Assign(labels, machineLabels(vmSize, sku))
 	// NodeClaimCreationLabel is used for recording the create timestamp of agentPool resource.
 	// then used by garbage collection controller to cleanup orphan agentpool which lived more than 10min
 	labels[NodeClaimCreationLabel] = lo.ToPtr(nodeClaim.CreationTimestamp.UTC().Format(CreationTimestampLayout))
 
+	profile, driver, err := gpuSettings(nodeClaim, nodeClass, vmSize)
+	if err != nil {
+		return armcontainerservice.AgentPool{}, err
+	}
+	if profile != nil {
+		labels[LabelGPUInstanceProfile] = lo.ToPtr(string(*profile))
+	}
+
 	storage := &resource.Quantity{}
 	if nodeClaim.Spec.Resources.Requests != nil {
 		storage = nodeClaim.Spec.Resources.Requests.Storage()
 	}
 	var diskSizeGB int32
-	if storage.Value() <= 0 {
-		return armcontainerservice.AgentPool{}, fmt.Errorf("storage request of nodeclaim(%s) should be more than 0", nodeClaim.Name)
-	} else {
+	if storage.Value() > 0 {
 		diskSizeGB = int32(storage.Value() >> 30)
+	} else if nodeClass != nil && nodeClass.Spec.OSDiskSizeGB != nil {
+		diskSizeGB = *nodeClass.Spec.OSDiskSizeGB
+	} else {
+		return armcontainerservice.AgentPool{}, fmt.Errorf("storage request of nodeclaim(%s) should be more than 0", nodeClaim.Name)
 	}
 
-	// Determine OSSKU from NodeClaim annotations, default to Ubuntu
+	tags, err := agentPoolTags(tagOptions, nodeClaim, nodeClass, driver)
+	if err != nil {
+		return armcontainerservice.AgentPool{}, err
+	}
+	osSKU, err := determineOSSKU(nodeClaim, nodeClass)
+	if err != nil {
+		return armcontainerservice.AgentPool{}, err
+	}
 
-	return armcontainerservice.AgentPool{
+	ap := armcontainerservice.AgentPool{
 		Properties: &armcontainerservice.ManagedClusterAgentPoolProfileProperties{
-			NodeLabels:   labels,
-			NodeTaints:   taintsStr, //[]*string{lo.ToPtr("sku=gpu:NoSchedule")},
-			Type:         lo.ToPtr(scaleSetsType),
-			VMSize:       lo.ToPtr(vmSize),
-			OSType:       lo.ToPtr(armcontainerservice.OSTypeLinux),
-			OSSKU:        determineOSSKU(nodeClaim),
-			Count:        lo.ToPtr(int32(1)),
-			OSDiskSizeGB: lo.ToPtr(diskSizeGB),
+			NodeLabels:         labels,
+			NodeTaints:         taintsStr, //[]*string{lo.ToPtr("sku=gpu:NoSchedule")},
+			Type:               lo.ToPtr(apType),
+			VMSize:             lo.ToPtr(vmSize),
+			OSType:             lo.ToPtr(armcontainerservice.OSTypeLinux),
+			OSSKU:              osSKU,
+			Count:              lo.ToPtr(int32(1)),
+			OSDiskSizeGB:       lo.ToPtr(diskSizeGB),
+			ScaleSetPriority:   lo.ToPtr(armcontainerservice.ScaleSetPriorityRegular),
+			GpuInstanceProfile: profile,
+			Tags:               tags,
 		},
-	}, nil
+	}
+
+	if nodeClass != nil {
+		if nodeClass.Spec.OSDiskType != "" {
+			ap.Properties.OSDiskType = lo.ToPtr(armcontainerservice.OSDiskType(nodeClass.Spec.OSDiskType))
+		}
+		ap.Properties.MaxPods = nodeClass.Spec.MaxPods
+		ap.Properties.VnetSubnetID = nodeClass.Spec.VnetSubnetID
+		ap.Properties.PodSubnetID = nodeClass.Spec.PodSubnetID
+		ap.Properties.NetworkProfile = networkProfile(nodeClass)
+		ap.Properties.EnableNodePublicIP = nodeClass.Spec.EnableNodePublicIP
+		ap.Properties.KubeletConfig = kubeletConfig(nodeClass)
+		linuxOSConfig, err := linuxOSConfig(nodeClass)
+		if err != nil {
+			return armcontainerservice.AgentPool{}, fmt.Errorf("linux os config of kaitonodeclass(%s) is invalid, %w", nodeClass.Name, err)
+		}
+		ap.Properties.LinuxOSConfig = linuxOSConfig
+	}
+
+	if apType == agentPoolTypeVirtualMachines {
+		// scale set priority is not applicable to VirtualMachines agent pool.
+		ap.Properties.ScaleSetPriority = nil
+	}
+
+	if o.zone != "" {
+		ap.Properties.AvailabilityZones = []*string{lo.ToPtr(availabilityZone(o.zone))}
+	}
+
+	if o.capacityType == karpenterv1.CapacityTypeSpot {
+		// AKS adds label and taint kubernetes.azure.com/scalesetpriority=spot to the nodes of spot agent pool automatically.
+		evictionPolicy, maxPrice, err := spotSettings(nodeClaim)
+		if err != nil {
+			return armcontainerservice.AgentPool{}, err
+		}
+		ap.Properties.ScaleSetPriority = lo.ToPtr(armcontainerservice.ScaleSetPrioritySpot)
+		ap.Properties.ScaleSetEvictionPolicy = evictionPolicy
+		ap.Properties.SpotMaxPrice = maxPrice
+	}
+	return ap, nil
 }
 
 func (p *Provider) getNodesByName(ctx context.Context, apName string) ([]*v1.Node, error) {
@@ -412,30 +948,70 @@ func agentPoolIsCreatedFromNodeClaim(ap *armcontainerservice.AgentPool) bool {
 	return false
 }
 
-// determineOSSKU determines the OS SKU from NodeClaim annotations, defaulting to Ubuntu
-func determineOSSKU(nodeClaim *karpenterv1.NodeClaim) *armcontainerservice.OSSKU {
-	if nodeClaim == nil {
-		return lo.ToPtr(armcontainerservice.OSSKUUbuntu)
+// spotSettings determines the eviction policy and max price of spot agent pool from NodeClaim annotations,
+// defaulting to Delete eviction policy and on-demand price(-1) as max price.
+func spotSettings(nodeClaim *karpenterv1.NodeClaim) (*armcontainerservice.ScaleSetEvictionPolicy, *float32, error) {
+	evictionPolicy := armcontainerservice.ScaleSetEvictionPolicyDelete
+	if policy, ok := nodeClaim.Annotations[AnnotationSpotEvictionPolicy]; ok {
+		matched, found := lo.Find(armcontainerservice.PossibleScaleSetEvictionPolicyValues(), func(p armcontainerservice.ScaleSetEvictionPolicy) bool {
+			return strings.EqualFold(string(p), policy)
+		})
+		if !found {
+			return nil, nil, fmt.Errorf("spot eviction policy(%s) of nodeclaim(%s) is invalid, must be one of %v", policy, nodeClaim.Name, armcontainerservice.PossibleScaleSetEvictionPolicyValues())
+		}
+		evictionPolicy = matched
+	}
+
+	maxPrice := float32(-1)
+	if price, ok := nodeClaim.Annotations[AnnotationSpotMaxPrice]; ok {
+		parsed, err := strconv.ParseFloat(price, 32)
+		if err != nil || (parsed <= 0 && parsed != -1) {
+			return nil, nil, fmt.Errorf("spot max price(%s) of nodeclaim(%s) is invalid, must be -1 or a positive number", price, nodeClaim.Name)
+		}
+		maxPrice = float32(parsed)
+	}
+	return lo.ToPtr(evictionPolicy), lo.ToPtr(maxPrice), nil
+}
+
+// capacityTypeFromAgentPool returns spot for spot agent pool, and on-demand for others.
+func capacityTypeFromAgentPool(ap *armcontainerservice.AgentPool) *string {
+	if ap.Properties.ScaleSetPriority != nil && *ap.Properties.ScaleSetPriority == armcontainerservice.ScaleSetPrioritySpot {
+		return lo.ToPtr(karpenterv1.CapacityTypeSpot)
 	}
+	return lo.ToPtr(karpenterv1.CapacityTypeOnDemand)
+}
 
+// determineOSSKU determines the OS SKU from NodeClaim annotations, then the KaitoNodeClass, defaulting to Ubuntu.
+// an unknown image family is rejected instead of falling back to Ubuntu, because the nodeclaim webhook which
+// validates it may be disabled.
+func determineOSSKU(nodeClaim *karpenterv1.NodeClaim, nodeClass *v1alpha1.KaitoNodeClass) (*armcontainerservice.OSSKU, error) {
 	// Check annotations on the NodeClaim
-	if imageFamily, ok := nodeClaim.Annotations[LabelNodeImageFamily]; ok {
-		return imageFamilyToOSSKU(imageFamily)
+	if nodeClaim != nil {
+		if imageFamily, ok := nodeClaim.Annotations[LabelNodeImageFamily]; ok {
+			osSKU, err := imageFamilyToOSSKU(imageFamily)
+			if err != nil {
+				return nil, fmt.Errorf("annotation %s of nodeclaim(%s) is invalid, %w", LabelNodeImageFamily, nodeClaim.Name, err)
+			}
+			return osSKU, nil
+		}
+	}
+
+	if nodeClass != nil && nodeClass.Spec.OSSKU != "" {
+		return lo.ToPtr(armcontainerservice.OSSKU(nodeClass.Spec.OSSKU)), nil
 	}
 
 	// Default to Ubuntu if no image family is specified
-	return lo.ToPtr(armcontainerservice.OSSKUUbuntu)
+	return lo.ToPtr(armcontainerservice.OSSKUUbuntu), nil
 }
 
 // imageFamilyToOSSKU converts an image family string to an OSSKU
-func imageFamilyToOSSKU(imageFamily string) *armcontainerservice.OSSKU {
+func imageFamilyToOSSKU(imageFamily string) (*armcontainerservice.OSSKU, error) {
 	switch strings.ToLower(imageFamily) {
 	case "azurelinux":
-		return lo.ToPtr(armcontainerservice.OSSKUAzureLinux)
+		return lo.ToPtr(armcontainerservice.OSSKUAzureLinux), nil
 	case "ubuntu":
-		return lo.ToPtr(armcontainerservice.OSSKUUbuntu)
+		return lo.ToPtr(armcontainerservice.OSSKUUbuntu), nil
 	default:
-		klog.Warningf("Unknown imageFamily %q, defaulting to Ubuntu", imageFamily)
-		return lo.ToPtr(armcontainerservice.OSSKUUbuntu)
+		return nil, fmt.Errorf("unsupported image family %q, supported values are %s", imageFamily, strings.Join(imageFamilies, ", "))
 	}
 }
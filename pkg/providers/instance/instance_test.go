@@ -17,24 +17,34 @@ package instance
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v4"
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/cache"
 	"github.com/azure/gpu-provisioner/pkg/fake"
+	"github.com/azure/gpu-provisioner/pkg/providers/instancetype"
 	"github.com/samber/lo"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"go.uber.org/mock/gomock"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+	"sigs.k8s.io/karpenter/pkg/cloudprovider"
 )
 
 func TestNewAgentPoolObject(t *testing.T) {
@@ -71,7 +81,7 @@ func TestNewAgentPoolObject(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			result, err := newAgentPoolObject(tc.vmSize, tc.nodeClaim)
+			result, err := newAgentPoolObject(offering{vmSize: tc.vmSize, capacityType: karpenterv1.CapacityTypeOnDemand}, nil, tc.nodeClaim, nil, armcontainerservice.AgentPoolTypeVirtualMachineScaleSets, TagOptions{})
 			if tc.expectedErr {
 				assert.EqualError(t, err, fmt.Sprintf("storage request of nodeclaim(%s) should be more than 0", tc.nodeClaim.Name))
 				return
@@ -82,6 +92,69 @@ func TestNewAgentPoolObject(t *testing.T) {
 	}
 }
 
+func TestNewAgentPoolObjectWithSKULabels(t *testing.T) {
+	nodeClaim := fake.GetNodeClaimObj("nodeclaim-test", map[string]string{instancetype.LabelGPUProduct: "V100"}, []v1.Taint{}, karpenterv1.ResourceRequirements{
+		Requests: v1.ResourceList{v1.ResourceStorage: lo.FromPtr(resource.NewQuantity(30*1024*1024*1024, resource.DecimalSI))},
+	}, []v1.NodeSelectorRequirement{})
+	h100 := &instancetype.SKU{
+		Name:                  "Standard_ND96isr_H100_v5",
+		GPU:                   instancetype.GPU{Vendor: instancetype.GPUVendorNVIDIA, Product: "H100", Count: 8, MemoryGiB: 80, NVLink: true},
+		AcceleratedNetworking: true,
+		InfiniBand:            true,
+	}
+
+	testCases := []struct {
+		name     string
+		vmSize   string
+		sku      *instancetype.SKU
+		expected map[string]string
+		absent   []string
+	}{
+		{
+			name:   "labels of gpu sku",
+			vmSize: "Standard_ND96isr_H100_v5",
+			sku:    h100,
+			expected: map[string]string{
+				LabelMachineType:                        "gpu",
+				instancetype.LabelGPUVendor:             instancetype.GPUVendorNVIDIA,
+				instancetype.LabelGPUProduct:            "H100",
+				instancetype.LabelGPUCount:              "8",
+				instancetype.LabelGPUMemoryGiB:          "80",
+				instancetype.LabelGPUNVLink:             "true",
+				instancetype.LabelInfiniBand:            "true",
+				instancetype.LabelAcceleratedNetworking: "true",
+			},
+		},
+		{
+			name:     "unknown N-series vm size falls back to gpu machine type",
+			vmSize:   "Standard_NC6s_v3",
+			expected: map[string]string{LabelMachineType: "gpu", instancetype.LabelGPUProduct: "V100"},
+			absent:   []string{instancetype.LabelGPUCount, instancetype.LabelInfiniBand},
+		},
+		{
+			name:     "vm size without gpu",
+			vmSize:   "Standard_D4s_v3",
+			expected: map[string]string{LabelMachineType: "cpu"},
+			absent:   []string{instancetype.LabelGPUCount, instancetype.LabelGPUVendor},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ap, err := newAgentPoolObject(offering{vmSize: tc.vmSize, capacityType: karpenterv1.CapacityTypeOnDemand}, tc.sku, nodeClaim, nil, armcontainerservice.AgentPoolTypeVirtualMachineScaleSets, TagOptions{})
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			for key, value := range tc.expected {
+				assert.Equal(t, value, lo.FromPtr(ap.Properties.NodeLabels[key]), key)
+			}
+			for _, key := range tc.absent {
+				assert.NotContains(t, ap.Properties.NodeLabels, key)
+			}
+		})
+	}
+}
+
 func TestGet(t *testing.T) {
 	testCases := []struct {
 		name              string
@@ -123,7 +196,23 @@ func TestGet(t *testing.T) {
 		{
 			name:          "Fail to get instance because agent pool ID cannot be parsed properly",
 			id:            "azure:///subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/nodeRG/providers/Microsoft.Compute/virtualMachineScaleSets/virtualMachines/0",
-			expectedError: errors.New("getting agentpool name, id does not match the regxp for ParseAgentPoolNameFromID"),
+			expectedError: errors.New("getting agentpool name, provider id"),
+		},
+		{
+			name:          "Successfully Get instance from agent pool with case-insensitive provider id",
+			id:            "azure:///subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/NODERG/providers/microsoft.compute/virtualmachinescalesets/AKS-AGENTPOOL0-20562481-VMSS/virtualmachines/0",
+			mockAgentPool: GetAgentPoolObjWithName("agentpool0", "/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/nodeRG/providers/Microsoft.Compute/virtualMachineScaleSets/aks-agentpool0-20562481-vmss", "Standard_NC6s_v3"),
+			mockAgentPoolResp: func(ap armcontainerservice.AgentPool) armcontainerservice.AgentPoolsClientGetResponse {
+				return armcontainerservice.AgentPoolsClientGetResponse{AgentPool: ap}
+			},
+		},
+		{
+			name:          "Successfully Get instance from agent pool with standalone vm provider id",
+			id:            "azure:///subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/nodeRG/providers/Microsoft.Compute/virtualMachines/aks-agentpool0-20562481-vm0",
+			mockAgentPool: GetAgentPoolObjWithName("agentpool0", "/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/nodeRG/providers/Microsoft.Compute/virtualMachines/aks-agentpool0-20562481-vm0", "Standard_NC6s_v3"),
+			mockAgentPoolResp: func(ap armcontainerservice.AgentPool) armcontainerservice.AgentPoolsClientGetResponse {
+				return armcontainerservice.AgentPoolsClientGetResponse{AgentPool: ap}
+			},
 		},
 	}
 
@@ -237,14 +326,15 @@ func TestFromAgentPoolToInstance(t *testing.T) {
 func TestDelete(t *testing.T) {
 	testCases := []struct {
 		name              string
-		apName            string
+		nodeClaimName     string
+		agentPoolName     string
 		mockAgentPoolGet  func() (armcontainerservice.AgentPoolsClientGetResponse, error)
 		mockAgentPoolResp func(mockHandler *fake.MockPollingHandler[armcontainerservice.AgentPoolsClientDeleteResponse]) (*runtime.Poller[armcontainerservice.AgentPoolsClientDeleteResponse], error)
 		expectedError     error
 	}{
 		{
-			name:   "Successfully delete instance",
-			apName: "agentpool0",
+			name:          "Successfully delete instance",
+			nodeClaimName: "agentpool0",
 			mockAgentPoolGet: func() (armcontainerservice.AgentPoolsClientGetResponse, error) {
 				ap := GetAgentPoolObjWithName("agentpool0", "/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/nodeRG/providers/Microsoft.Compute/virtualMachineScaleSets/aks-agentpool0-20562481-vmss", "Standard_NC6s_v3")
 				ap.Properties.ProvisioningState = lo.ToPtr("Succeeded")
@@ -267,8 +357,57 @@ func TestDelete(t *testing.T) {
 			},
 		},
 		{
-			name:   "Successfully deletes instance because poller returns a 404 not found error",
-			apName: "agentpool0",
+			name:          "Successfully delete instance of nodeclaim whose name is not a valid agent pool name",
+			nodeClaimName: "kaito-workspace-0",
+			mockAgentPoolGet: func() (armcontainerservice.AgentPoolsClientGetResponse, error) {
+				ap := GetAgentPoolObjWithName(AgentPoolName("kaito-workspace-0"), "/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/nodeRG/providers/Microsoft.Compute/virtualMachineScaleSets/aks-agentpool0-20562481-vmss", "Standard_NC6s_v3")
+				ap.Properties.ProvisioningState = lo.ToPtr("Succeeded")
+				return armcontainerservice.AgentPoolsClientGetResponse{AgentPool: ap}, nil
+			},
+			mockAgentPoolResp: func(mockHandler *fake.MockPollingHandler[armcontainerservice.AgentPoolsClientDeleteResponse]) (*runtime.Poller[armcontainerservice.AgentPoolsClientDeleteResponse], error) {
+				delResp := armcontainerservice.AgentPoolsClientDeleteResponse{}
+				resp := http.Response{Status: "200 OK", StatusCode: http.StatusOK, Body: http.NoBody}
+
+				mockHandler.EXPECT().Done().Return(true).Times(3)
+				mockHandler.EXPECT().Result(gomock.Any(), gomock.Any()).Return(nil)
+
+				pollingOptions := &runtime.NewPollerOptions[armcontainerservice.AgentPoolsClientDeleteResponse]{
+					Handler:  mockHandler,
+					Response: &delResp,
+				}
+
+				p, err := runtime.NewPoller(&resp, runtime.NewPipeline("", "", runtime.PipelineOptions{}, nil), pollingOptions)
+				return p, err
+			},
+		},
+		{
+			name:          "Successfully delete warm agent pool bound to nodeclaim",
+			nodeClaimName: "kaito-workspace-0",
+			agentPoolName: "wabcdefghijk",
+			mockAgentPoolGet: func() (armcontainerservice.AgentPoolsClientGetResponse, error) {
+				ap := GetAgentPoolObjWithName("wabcdefghijk", "/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/nodeRG/providers/Microsoft.Compute/virtualMachineScaleSets/aks-wabcdefghijk-20562481-vmss", "Standard_NC6s_v3")
+				ap.Properties.ProvisioningState = lo.ToPtr("Succeeded")
+				return armcontainerservice.AgentPoolsClientGetResponse{AgentPool: ap}, nil
+			},
+			mockAgentPoolResp: func(mockHandler *fake.MockPollingHandler[armcontainerservice.AgentPoolsClientDeleteResponse]) (*runtime.Poller[armcontainerservice.AgentPoolsClientDeleteResponse], error) {
+				delResp := armcontainerservice.AgentPoolsClientDeleteResponse{}
+				resp := http.Response{Status: "200 OK", StatusCode: http.StatusOK, Body: http.NoBody}
+
+				mockHandler.EXPECT().Done().Return(true).Times(3)
+				mockHandler.EXPECT().Result(gomock.Any(), gomock.Any()).Return(nil)
+
+				pollingOptions := &runtime.NewPollerOptions[armcontainerservice.AgentPoolsClientDeleteResponse]{
+					Handler:  mockHandler,
+					Response: &delResp,
+				}
+
+				p, err := runtime.NewPoller(&resp, runtime.NewPipeline("", "", runtime.PipelineOptions{}, nil), pollingOptions)
+				return p, err
+			},
+		},
+		{
+			name:          "Successfully deletes instance because poller returns a 404 not found error",
+			nodeClaimName: "agentpool0",
 			mockAgentPoolGet: func() (armcontainerservice.AgentPoolsClientGetResponse, error) {
 				ap := GetAgentPoolObjWithName("agentpool0", "/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/nodeRG/providers/Microsoft.Compute/virtualMachineScaleSets/aks-agentpool0-20562481-vmss", "Standard_NC6s_v3")
 				ap.Properties.ProvisioningState = lo.ToPtr("Succeeded")
@@ -292,8 +431,8 @@ func TestDelete(t *testing.T) {
 			expectedError: errors.New("nodeclaim not found"),
 		},
 		{
-			name:   "Fail to delete instance because poller returns error",
-			apName: "agentpool0",
+			name:          "Fail to delete instance because poller returns error",
+			nodeClaimName: "agentpool0",
 			mockAgentPoolGet: func() (armcontainerservice.AgentPoolsClientGetResponse, error) {
 				ap := GetAgentPoolObjWithName("agentpool0", "/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/nodeRG/providers/Microsoft.Compute/virtualMachineScaleSets/aks-agentpool0-20562481-vmss", "Standard_NC6s_v3")
 				ap.Properties.ProvisioningState = lo.ToPtr("Succeeded")
@@ -317,8 +456,8 @@ func TestDelete(t *testing.T) {
 			expectedError: errors.New("Failed to fetch latest status of operation"),
 		},
 		{
-			name:   "Successfully delete instance because agentPool.Delete returns a NotFound error",
-			apName: "agentpool0",
+			name:          "Successfully delete instance because agentPool.Delete returns a NotFound error",
+			nodeClaimName: "agentpool0",
 			mockAgentPoolGet: func() (armcontainerservice.AgentPoolsClientGetResponse, error) {
 				ap := GetAgentPoolObjWithName("agentpool0", "/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/nodeRG/providers/Microsoft.Compute/virtualMachineScaleSets/aks-agentpool0-20562481-vmss", "Standard_NC6s_v3")
 				ap.Properties.ProvisioningState = lo.ToPtr("Succeeded")
@@ -330,8 +469,8 @@ func TestDelete(t *testing.T) {
 			expectedError: errors.New("nodeclaim not found"),
 		},
 		{
-			name:   "Fail to delete instance because agentPool.Delete returns a failure",
-			apName: "agentpool0",
+			name:          "Fail to delete instance because agentPool.Delete returns a failure",
+			nodeClaimName: "agentpool0",
 			mockAgentPoolGet: func() (armcontainerservice.AgentPoolsClientGetResponse, error) {
 				ap := GetAgentPoolObjWithName("agentpool0", "/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/nodeRG/providers/Microsoft.Compute/virtualMachineScaleSets/aks-agentpool0-20562481-vmss", "Standard_NC6s_v3")
 				ap.Properties.ProvisioningState = lo.ToPtr("Succeeded")
@@ -343,8 +482,8 @@ func TestDelete(t *testing.T) {
 			expectedError: errors.New("Failed to delete agent pool"),
 		},
 		{
-			name:   "Successfully delete instance when agent pool is already deleting",
-			apName: "agentpool0",
+			name:          "Successfully delete instance when agent pool is already deleting",
+			nodeClaimName: "agentpool0",
 			mockAgentPoolGet: func() (armcontainerservice.AgentPoolsClientGetResponse, error) {
 				ap := GetAgentPoolObjWithName("agentpool0", "/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/nodeRG/providers/Microsoft.Compute/virtualMachineScaleSets/aks-agentpool0-20562481-vmss", "Standard_NC6s_v3")
 				ap.Properties.ProvisioningState = lo.ToPtr("Deleting")
@@ -352,10 +491,10 @@ func TestDelete(t *testing.T) {
 			},
 		},
 		{
-			name:   "Successfully delete instance when agent pool get returns NotFound error",
-			apName: "agentpool0",
+			name:          "Successfully delete instance when agent pool get returns NotFound error",
+			nodeClaimName: "agentpool0",
 			mockAgentPoolGet: func() (armcontainerservice.AgentPoolsClientGetResponse, error) {
-				return armcontainerservice.AgentPoolsClientGetResponse{}, errors.New("Agent Pool not found")
+				return armcontainerservice.AgentPoolsClientGetResponse{}, NotFoundAzError()
 			},
 			expectedError: errors.New("nodeclaim not found"),
 		},
@@ -367,11 +506,17 @@ func TestDelete(t *testing.T) {
 			defer mockCtrl.Finish()
 
 			agentPoolMocks := fake.NewMockAgentPoolsAPI(mockCtrl)
+			nodeClaim := &karpenterv1.NodeClaim{ObjectMeta: metav1.ObjectMeta{Name: tc.nodeClaimName}}
+			apName := AgentPoolName(tc.nodeClaimName)
+			if tc.agentPoolName != "" {
+				nodeClaim.Annotations = map[string]string{AnnotationAgentPoolName: tc.agentPoolName}
+				apName = tc.agentPoolName
+			}
 
 			// Mock Get call if specified
 			if tc.mockAgentPoolGet != nil {
 				getResp, getErr := tc.mockAgentPoolGet()
-				agentPoolMocks.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any(), "agentpool0", gomock.Any()).Return(getResp, getErr).MaxTimes(1)
+				agentPoolMocks.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any(), apName, gomock.Any()).Return(getResp, getErr).MaxTimes(1)
 			}
 
 			// Mock Delete call if specified
@@ -379,13 +524,13 @@ func TestDelete(t *testing.T) {
 				mockHandler := fake.NewMockPollingHandler[armcontainerservice.AgentPoolsClientDeleteResponse](mockCtrl)
 
 				p, err := tc.mockAgentPoolResp(mockHandler)
-				agentPoolMocks.EXPECT().BeginDelete(gomock.Any(), gomock.Any(), gomock.Any(), "agentpool0", gomock.Any()).Return(p, err).MaxTimes(1)
+				agentPoolMocks.EXPECT().BeginDelete(gomock.Any(), gomock.Any(), gomock.Any(), apName, gomock.Any()).Return(p, err).MaxTimes(1)
 			}
 
 			mockK8sClient := fake.NewClient()
 			p := createTestProvider(agentPoolMocks, mockK8sClient)
 
-			err := p.Delete(context.Background(), tc.apName)
+			err := p.Delete(context.Background(), nodeClaim)
 
 			if tc.expectedError == nil {
 				assert.NoError(t, err, "Not expected to return error")
@@ -403,6 +548,7 @@ func TestList(t *testing.T) {
 		mockAgentPoolList func() []*armcontainerservice.AgentPool
 		mockAgentPoolResp func(apList []*armcontainerservice.AgentPool) *runtime.Pager[armcontainerservice.AgentPoolsClientListResponse]
 		callK8sMocks      func(c *fake.MockClient)
+		expectedCount     int
 		expectedError     error
 	}{
 		{
@@ -443,6 +589,36 @@ func TestList(t *testing.T) {
 				c.On("List", mock.IsType(context.Background()), mock.IsType(&v1.NodeList{}), mock.Anything).Return(nil)
 			},
 		},
+		{
+			name: "Warm agent pools are not listed",
+			mockAgentPoolList: func() []*armcontainerservice.AgentPool {
+				ap := GetAgentPoolObjWithName("agentpool0", "/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/nodeRG/providers/Microsoft.Compute/virtualMachineScaleSets/aks-agentpool0-20562481-vmss", "Standard_NC6s_v3")
+				warm := GetAgentPoolObjWithName("wabcdefghijk", "/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/nodeRG/providers/Microsoft.Compute/virtualMachineScaleSets/aks-wabcdefghijk-20562481-vmss", "Standard_NC6s_v3")
+				warm.Properties.Tags = map[string]*string{WarmPoolTag: lo.ToPtr("Standard_NC6s_v3")}
+
+				return []*armcontainerservice.AgentPool{
+					&ap, &warm,
+				}
+			},
+			mockAgentPoolResp: func(apList []*armcontainerservice.AgentPool) *runtime.Pager[armcontainerservice.AgentPoolsClientListResponse] {
+				return runtime.NewPager(runtime.PagingHandler[armcontainerservice.AgentPoolsClientListResponse]{
+					More: func(page armcontainerservice.AgentPoolsClientListResponse) bool {
+						return false
+					},
+					Fetcher: func(ctx context.Context, page *armcontainerservice.AgentPoolsClientListResponse) (armcontainerservice.AgentPoolsClientListResponse, error) {
+						return armcontainerservice.AgentPoolsClientListResponse{
+							AgentPoolListResult: armcontainerservice.AgentPoolListResult{
+								Value: apList,
+							},
+						}, nil
+					},
+				})
+			},
+			callK8sMocks: func(c *fake.MockClient) {
+				c.On("List", mock.IsType(context.Background()), mock.IsType(&v1.NodeList{}), mock.Anything).Return(nil)
+			},
+			expectedCount: 1,
+		},
 		{
 			name: "Fail to list instances because pager fails to fetch page",
 			mockAgentPoolList: func() []*armcontainerservice.AgentPool {
@@ -485,9 +661,13 @@ func TestList(t *testing.T) {
 			if tc.expectedError == nil {
 				assert.NoError(t, err, "Not expected to return error")
 				assert.NotNil(t, instanceList, "Response instance list should not be nil")
-				assert.Equal(t, len(tc.mockAgentPoolList()), len(instanceList), "Number of Instances should be same as number of agent pools")
+				expectedCount := len(tc.mockAgentPoolList())
+				if tc.expectedCount != 0 {
+					expectedCount = tc.expectedCount
+				}
+				assert.Equal(t, expectedCount, len(instanceList), "Number of Instances should be same as number of agent pools created for nodeclaims")
 
-				for i := range tc.mockAgentPoolList() {
+				for i := range instanceList {
 					assert.Equal(t, tc.mockAgentPoolList()[i].Name, instanceList[i].Name, "Instance name should be same as agent pool")
 					assert.Equal(t, tc.mockAgentPoolList()[i].Properties.VMSize, instanceList[i].Type, "Instance type should be same as agent pool's vm size")
 				}
@@ -498,6 +678,25 @@ func TestList(t *testing.T) {
 	}
 }
 
+func TestListWithResponseError(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	agentPoolMocks := fake.NewMockAgentPoolsAPI(mockCtrl)
+	agentPoolMocks.EXPECT().NewListPager(gomock.Any(), gomock.Any(), gomock.Any()).Return(runtime.NewPager(runtime.PagingHandler[armcontainerservice.AgentPoolsClientListResponse]{
+		More: func(page armcontainerservice.AgentPoolsClientListResponse) bool {
+			return false
+		},
+		Fetcher: func(ctx context.Context, page *armcontainerservice.AgentPoolsClientListResponse) (armcontainerservice.AgentPoolsClientListResponse, error) {
+			return armcontainerservice.AgentPoolsClientListResponse{}, &azcore.ResponseError{ErrorCode: "ResourceNotFound", StatusCode: http.StatusNotFound}
+		},
+	}))
+
+	p := createTestProvider(agentPoolMocks, fake.NewClient())
+	_, err := p.List(context.Background())
+	assert.True(t, cloudprovider.IsNodeClaimNotFoundError(err), "listing error should be classified, %v", err)
+}
+
 func TestFromAPListToInstanceFailure(t *testing.T) {
 	testCases := []struct {
 		name              string
@@ -562,7 +761,54 @@ func TestCreateSuccess(t *testing.T) {
 				}
 				resp := http.Response{StatusCode: http.StatusAccepted, Body: http.NoBody}
 
-				mockHandler.EXPECT().Done().Return(true).Times(3)
+				mockHandler.EXPECT().Done().Return(true).Times(4)
+				mockHandler.EXPECT().Result(gomock.Any(), gomock.Any()).Return(nil)
+
+				pollingOptions := &runtime.NewPollerOptions[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse]{
+					Handler:  mockHandler,
+					Response: &createResp,
+				}
+
+				p, err := runtime.NewPoller(&resp, runtime.NewPipeline("", "", runtime.PipelineOptions{}, nil), pollingOptions)
+				return p, err
+			},
+			callK8sMocks: func(c *fake.MockClient) {
+				nodeList := GetNodeList([]v1.Node{ReadyNode})
+				relevantMap := c.CreateMapWithType(nodeList)
+				//insert node objects into the map
+				for _, obj := range nodeList.Items {
+					n := obj
+					objKey := client.ObjectKeyFromObject(&n)
+
+					relevantMap[objKey] = &n
+				}
+
+				c.On("List", mock.IsType(context.Background()), mock.IsType(&v1.NodeList{}), mock.Anything).Return(nil)
+			},
+		},
+		{
+			name: "Successfully create instance for nodeclaim whose name is not a valid agent pool name",
+			nodeClaim: fake.GetNodeClaimObj("kaito-workspace-0", map[string]string{"test": "test"}, []v1.Taint{},
+				karpenterv1.ResourceRequirements{Requests: v1.ResourceList{
+					v1.ResourceStorage: lo.FromPtr(resource.NewQuantity(30*1024*1024*1024, resource.DecimalSI)),
+				}},
+				[]v1.NodeSelectorRequirement{
+					{
+						Key:      "node.kubernetes.io/instance-type",
+						Operator: "In",
+						Values:   []string{"Standard_NC6s_v3"},
+					},
+				}),
+			mockAgentPoolResp: func(nodeClaim *karpenterv1.NodeClaim, mockHandler *fake.MockPollingHandler[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse]) (*runtime.Poller[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse], error) {
+				ap := GetAgentPoolObjWithName(AgentPoolName(nodeClaim.Name), "/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/nodeRG/providers/Microsoft.Compute/virtualMachineScaleSets/aks-agentpool0-20562481-vmss", nodeClaim.Spec.Requirements[0].Values[0])
+				ap.Properties.Tags = map[string]*string{NodeClaimNameTag: lo.ToPtr(nodeClaim.Name)}
+
+				createResp := armcontainerservice.AgentPoolsClientCreateOrUpdateResponse{
+					AgentPool: ap,
+				}
+				resp := http.Response{StatusCode: http.StatusAccepted, Body: http.NoBody}
+
+				mockHandler.EXPECT().Done().Return(true).Times(4)
 				mockHandler.EXPECT().Result(gomock.Any(), gomock.Any()).Return(nil)
 
 				pollingOptions := &runtime.NewPollerOptions[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse]{
@@ -608,7 +854,7 @@ func TestCreateSuccess(t *testing.T) {
 				}
 				resp := http.Response{StatusCode: http.StatusAccepted, Body: http.NoBody}
 
-				mockHandler.EXPECT().Done().Return(true).Times(3)
+				mockHandler.EXPECT().Done().Return(true).Times(4)
 				mockHandler.EXPECT().Result(gomock.Any(), gomock.Any()).Return(nil)
 
 				pollingOptions := &runtime.NewPollerOptions[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse]{
@@ -647,10 +893,13 @@ func TestCreateSuccess(t *testing.T) {
 				mockHandler := fake.NewMockPollingHandler[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse](mockCtrl)
 
 				p, err := tc.mockAgentPoolResp(tc.nodeClaim, mockHandler)
-				agentPoolMocks.EXPECT().BeginCreateOrUpdate(gomock.Any(), gomock.Any(), gomock.Any(), tc.nodeClaim.Name, gomock.Any(), gomock.Any()).Return(p, err)
+				matchNodeClaimTag := gomock.Cond(func(x any) bool {
+					return lo.FromPtr(x.(armcontainerservice.AgentPool).Properties.Tags[NodeClaimNameTag]) == tc.nodeClaim.Name
+				})
+				agentPoolMocks.EXPECT().BeginCreateOrUpdate(gomock.Any(), gomock.Any(), gomock.Any(), AgentPoolName(tc.nodeClaim.Name), matchNodeClaimTag, gomock.Any()).Return(p, err)
 			}
 
-			mockK8sClient := fake.NewClient()
+			mockK8sClient := fake.NewClient().WithKaitoNodeClass(fake.GetKaitoNodeClassObj())
 			if tc.callK8sMocks != nil {
 				tc.callK8sMocks(mockK8sClient)
 			}
@@ -661,7 +910,8 @@ func TestCreateSuccess(t *testing.T) {
 
 			assert.NoError(t, err, "Not expected to return error")
 			assert.NotNil(t, instance, "Response instance should not be nil")
-			assert.Equal(t, &tc.nodeClaim.Name, instance.Name, "Instance name should be same as nodeclaim name")
+			assert.Equal(t, AgentPoolName(tc.nodeClaim.Name), lo.FromPtr(instance.Name), "Instance name should be the agent pool name of nodeclaim")
+			assert.Equal(t, &tc.nodeClaim.Name, instance.NodeClaimName, "Instance nodeclaim name should be same as nodeclaim name")
 			assert.Equal(t, &tc.nodeClaim.Spec.Requirements[0].Values[0], instance.Type, "Instance type should be same as nodeclaim's instance type")
 		})
 	}
@@ -696,7 +946,7 @@ func TestCreateFailure(t *testing.T) {
 				}
 				resp := http.Response{StatusCode: http.StatusAccepted, Body: http.NoBody}
 
-				mockHandler.EXPECT().Done().Return(true).Times(3)
+				mockHandler.EXPECT().Done().Return(true).Times(4)
 				mockHandler.EXPECT().Result(gomock.Any(), gomock.Any()).Return(nil)
 
 				pollingOptions := &runtime.NewPollerOptions[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse]{
@@ -735,7 +985,7 @@ func TestCreateFailure(t *testing.T) {
 				}
 				resp := http.Response{StatusCode: http.StatusAccepted, Body: http.NoBody}
 
-				mockHandler.EXPECT().Done().Return(true).Times(3)
+				mockHandler.EXPECT().Done().Return(true).Times(4)
 				mockHandler.EXPECT().Result(gomock.Any(), gomock.Any()).Return(nil)
 
 				pollingOptions := &runtime.NewPollerOptions[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse]{
@@ -770,7 +1020,7 @@ func TestCreateFailure(t *testing.T) {
 				}
 				resp := http.Response{StatusCode: http.StatusBadRequest, Body: http.NoBody}
 
-				mockHandler.EXPECT().Done().Return(false)
+				mockHandler.EXPECT().Done().Return(false).Times(4)
 				mockHandler.EXPECT().Poll(gomock.Any()).Return(&resp, errors.New("Failed to fetch latest status of operation"))
 
 				pollingOptions := &runtime.NewPollerOptions[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse]{
@@ -781,6 +1031,10 @@ func TestCreateFailure(t *testing.T) {
 				p, err := runtime.NewPoller(&resp, runtime.NewPipeline("", "", runtime.PipelineOptions{}, nil), pollingOptions)
 				return p, err
 			},
+			callK8sMocks: func(c *fake.MockClient) {
+				// the resume token is saved before polling, and kept when polling failed.
+				c.On("Patch", mock.IsType(context.Background()), mock.IsType(&karpenterv1.NodeClaim{}), mock.Anything, mock.Anything).Return(nil).Once()
+			},
 			expectedError: errors.New("Failed to fetch latest status of operation"),
 		},
 		{
@@ -810,15 +1064,6 @@ func TestCreateFailure(t *testing.T) {
 				[]v1.NodeSelectorRequirement{}),
 			expectedError: errors.New("nodeClaim spec has no requirement for instance type"),
 		},
-		{
-			name: "Fail to create instance because of invalid nodeClaim name",
-			nodeClaim: fake.GetNodeClaimObj("invalid-name", map[string]string{"test": "test"}, []v1.Taint{},
-				karpenterv1.ResourceRequirements{Requests: v1.ResourceList{
-					v1.ResourceStorage: lo.FromPtr(resource.NewQuantity(30*1024*1024*1024, resource.DecimalSI)),
-				}},
-				[]v1.NodeSelectorRequirement{}),
-			expectedError: errors.New("is invalid, must match regex pattern: ^[a-z][a-z0-9]{0,11}$"),
-		},
 		{
 			name: "Fail to create instance because of no storage request",
 			nodeClaim: fake.GetNodeClaimObj("agentpool000", map[string]string{"test": "test"}, []v1.Taint{}, karpenterv1.ResourceRequirements{}, []v1.NodeSelectorRequirement{
@@ -845,7 +1090,7 @@ func TestCreateFailure(t *testing.T) {
 				agentPoolMocks.EXPECT().BeginCreateOrUpdate(gomock.Any(), gomock.Any(), gomock.Any(), tc.nodeClaim.Name, gomock.Any(), gomock.Any()).Return(p, err)
 			}
 
-			mockK8sClient := fake.NewClient()
+			mockK8sClient := fake.NewClient().WithKaitoNodeClass(fake.GetKaitoNodeClassObj())
 			if tc.callK8sMocks != nil {
 				tc.callK8sMocks(mockK8sClient)
 			}
@@ -860,108 +1105,1400 @@ func TestCreateFailure(t *testing.T) {
 	}
 }
 
-func TestDetermineOSSKUWithNilNodeClaim(t *testing.T) {
-	result := determineOSSKU(nil)
-	assert.Equal(t, armcontainerservice.OSSKUUbuntu, *result)
-}
-
-func TestNewAgentPoolObjectWithImageFamily(t *testing.T) {
+func TestCreateWithInstanceTypeFallback(t *testing.T) {
 	testCases := []struct {
-		name          string
-		vmSize        string
-		nodeClaim     *karpenterv1.NodeClaim
-		expectedOSSKU armcontainerservice.OSSKU
+		name                   string
+		instanceTypes          []string
+		capacityTypes          []string
+		spotNotTolerated       bool
+		zones                  []string
+		unavailableOfferings   []offering
+		createErrs             []error
+		expectedInstanceType   string
+		expectedCapacityType   string
+		expectedZone           string
+		isInsufficientCapacity bool
+		expectedCreateReason   string
+		expectedError          error
 	}{
 		{
-			name:   "NodeClaim with AzureLinux image family annotation",
-			vmSize: "Standard_NC6s_v3",
-			nodeClaim: &karpenterv1.NodeClaim{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: "test-nodeclaim",
-					Annotations: map[string]string{
-						"kaito.sh/node-image-family": "AzureLinux",
-					},
-				},
-				Spec: karpenterv1.NodeClaimSpec{
-					Resources: karpenterv1.ResourceRequirements{
-						Requests: v1.ResourceList{
-							v1.ResourceStorage: *resource.NewQuantity(30*1024*1024*1024, resource.DecimalSI),
-						},
-					},
-				},
-			},
-			expectedOSSKU: armcontainerservice.OSSKUAzureLinux,
+			name:                 "Successfully create instance with the second instance type when the first one is not available",
+			instanceTypes:        []string{"Standard_NC6s_v3", "Standard_NC12s_v3"},
+			createErrs:           []error{&azcore.ResponseError{ErrorCode: "SkuNotAvailable"}, nil},
+			expectedInstanceType: "Standard_NC12s_v3",
+			expectedCapacityType: karpenterv1.CapacityTypeOnDemand,
 		},
 		{
-			name:   "NodeClaim with Ubuntu image family annotation",
-			vmSize: "Standard_NC6s_v3",
-			nodeClaim: &karpenterv1.NodeClaim{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: "test-nodeclaim",
-					Annotations: map[string]string{
-						"kaito.sh/node-image-family": "Ubuntu",
-					},
-				},
-				Spec: karpenterv1.NodeClaimSpec{
-					Resources: karpenterv1.ResourceRequirements{
-						Requests: v1.ResourceList{
-							v1.ResourceStorage: *resource.NewQuantity(30*1024*1024*1024, resource.DecimalSI),
-						},
-					},
-				},
-			},
-			expectedOSSKU: armcontainerservice.OSSKUUbuntu,
+			name:                 "Successfully create instance with the third instance type after allocation and quota failures",
+			instanceTypes:        []string{"Standard_NC6s_v3", "Standard_NC12s_v3", "Standard_NC24s_v3"},
+			createErrs:           []error{&azcore.ResponseError{ErrorCode: "AllocationFailed"}, &azcore.ResponseError{ErrorCode: "QuotaExceeded"}, nil},
+			expectedInstanceType: "Standard_NC24s_v3",
+			expectedCapacityType: karpenterv1.CapacityTypeOnDemand,
 		},
 		{
-			name:   "NodeClaim with case-insensitive AzureLinux image family annotation",
-			vmSize: "Standard_NC6s_v3",
-			nodeClaim: &karpenterv1.NodeClaim{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: "test-nodeclaim",
-					Annotations: map[string]string{
-						"kaito.sh/node-image-family": "azurelinux",
-					},
-				},
-				Spec: karpenterv1.NodeClaimSpec{
-					Resources: karpenterv1.ResourceRequirements{
-						Requests: v1.ResourceList{
-							v1.ResourceStorage: *resource.NewQuantity(30*1024*1024*1024, resource.DecimalSI),
-						},
-					},
-				},
-			},
-			expectedOSSKU: armcontainerservice.OSSKUAzureLinux,
+			name:                 "Successfully create spot instance when spot capacity type is allowed",
+			instanceTypes:        []string{"Standard_NC6s_v3"},
+			capacityTypes:        []string{karpenterv1.CapacityTypeOnDemand, karpenterv1.CapacityTypeSpot},
+			createErrs:           []error{nil},
+			expectedInstanceType: "Standard_NC6s_v3",
+			expectedCapacityType: karpenterv1.CapacityTypeSpot,
 		},
 		{
-			name:   "NodeClaim with unknown image family annotation defaults to Ubuntu",
-			vmSize: "Standard_NC6s_v3",
-			nodeClaim: &karpenterv1.NodeClaim{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: "test-nodeclaim",
-					Annotations: map[string]string{
-						"kaito.sh/node-image-family": "Unknown",
-					},
-				},
-				Spec: karpenterv1.NodeClaimSpec{
-					Resources: karpenterv1.ResourceRequirements{
-						Requests: v1.ResourceList{
-							v1.ResourceStorage: *resource.NewQuantity(30*1024*1024*1024, resource.DecimalSI),
-						},
-					},
-				},
-			},
-			expectedOSSKU: armcontainerservice.OSSKUUbuntu,
+			name:                 "Successfully create on-demand instance when spot capacity type is allowed but the spot taint is missing",
+			instanceTypes:        []string{"Standard_NC6s_v3"},
+			capacityTypes:        []string{karpenterv1.CapacityTypeOnDemand, karpenterv1.CapacityTypeSpot},
+			spotNotTolerated:     true,
+			createErrs:           []error{nil},
+			expectedInstanceType: "Standard_NC6s_v3",
+			expectedCapacityType: karpenterv1.CapacityTypeOnDemand,
 		},
 		{
-			name:   "NodeClaim without image family annotation defaults to Ubuntu",
-			vmSize: "Standard_NC6s_v3",
-			nodeClaim: &karpenterv1.NodeClaim{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: "test-nodeclaim",
-				},
-				Spec: karpenterv1.NodeClaimSpec{
-					Resources: karpenterv1.ResourceRequirements{
-						Requests: v1.ResourceList{
+			name:             "Fail to create instance because only spot capacity type is allowed but the spot taint is missing",
+			instanceTypes:    []string{"Standard_NC6s_v3"},
+			capacityTypes:    []string{karpenterv1.CapacityTypeSpot},
+			spotNotTolerated: true,
+			expectedError:    errors.New("spot capacity type of nodeclaim(agentpool0) requires taint kubernetes.azure.com/scalesetpriority=spot:NoSchedule"),
+		},
+		{
+			name:                 "Successfully create on-demand instance when spot capacity is refused",
+			instanceTypes:        []string{"Standard_NC6s_v3"},
+			capacityTypes:        []string{karpenterv1.CapacityTypeSpot, karpenterv1.CapacityTypeOnDemand},
+			createErrs:           []error{&azcore.ResponseError{ErrorCode: "OperationNotAllowed", StatusCode: http.StatusBadRequest, RawResponse: &http.Response{StatusCode: http.StatusBadRequest, Body: io.NopCloser(strings.NewReader(`{"error":{"code":"OperationNotAllowed","message":"Operation could not be completed as it results in exceeding approved LowPriorityCores quota."}}`))}}, nil},
+			expectedInstanceType: "Standard_NC6s_v3",
+			expectedCapacityType: karpenterv1.CapacityTypeOnDemand,
+		},
+		{
+			name:                 "Successfully create instance in the second zone when the first zone has no capacity",
+			instanceTypes:        []string{"Standard_NC6s_v3"},
+			zones:                []string{"eastus-2", "eastus-1"},
+			createErrs:           []error{&azcore.ResponseError{ErrorCode: "ZonalAllocationFailed"}, nil},
+			expectedInstanceType: "Standard_NC6s_v3",
+			expectedCapacityType: karpenterv1.CapacityTypeOnDemand,
+			expectedZone:         "eastus-1",
+		},
+		{
+			name:                 "Successfully create instance with the second instance type when the first one is unavailable recently",
+			instanceTypes:        []string{"Standard_NC6s_v3", "Standard_NC12s_v3"},
+			unavailableOfferings: []offering{{vmSize: "standard_nc6s_v3", capacityType: karpenterv1.CapacityTypeOnDemand}},
+			createErrs:           []error{nil},
+			expectedInstanceType: "Standard_NC12s_v3",
+			expectedCapacityType: karpenterv1.CapacityTypeOnDemand,
+		},
+		{
+			name:                   "Fail to create instance without calling ARM because all instance types are unavailable recently",
+			instanceTypes:          []string{"Standard_NC6s_v3", "Standard_NC12s_v3"},
+			unavailableOfferings:   []offering{{vmSize: "Standard_NC6s_v3", capacityType: karpenterv1.CapacityTypeOnDemand}, {vmSize: "Standard_NC12s_v3", capacityType: karpenterv1.CapacityTypeOnDemand}},
+			isInsufficientCapacity: true,
+			expectedError:          errors.New("all requested instance types [Standard_NC6s_v3 Standard_NC12s_v3] are unavailable"),
+		},
+		{
+			name:                   "Fail to create instance because spot capacity is refused and on-demand is not allowed",
+			instanceTypes:          []string{"Standard_NC6s_v3"},
+			capacityTypes:          []string{karpenterv1.CapacityTypeSpot},
+			createErrs:             []error{&azcore.ResponseError{ErrorCode: "SkuNotAvailable"}},
+			isInsufficientCapacity: true,
+			expectedError:          errors.New("all requested instance types [Standard_NC6s_v3] are unavailable"),
+		},
+		{
+			name:                   "Fail to create instance because all instance types are not available",
+			instanceTypes:          []string{"Standard_NC6s_v3", "Standard_NC12s_v3"},
+			createErrs:             []error{&azcore.ResponseError{ErrorCode: "SkuNotAvailable"}, &azcore.ResponseError{ErrorCode: "AllocationFailed"}},
+			isInsufficientCapacity: true,
+			expectedError:          errors.New("all requested instance types [Standard_NC6s_v3 Standard_NC12s_v3] are unavailable"),
+		},
+		{
+			name:          "Fail to create instance without fallback because error is not capacity related",
+			instanceTypes: []string{"Standard_NC6s_v3", "Standard_NC12s_v3"},
+			createErrs:    []error{errors.New("Failed to create agent pool")},
+			expectedError: errors.New("Failed to create agent pool"),
+		},
+		{
+			name:                 "Fail to create instance with create error reason because request is invalid",
+			instanceTypes:        []string{"Standard_NC6s_v3", "Standard_NC12s_v3"},
+			createErrs:           []error{&azcore.ResponseError{ErrorCode: "InvalidParameter", StatusCode: http.StatusBadRequest}},
+			expectedError:        errors.New("InvalidParameter"),
+			expectedCreateReason: ReasonInvalidRequest,
+		},
+		{
+			name:                 "Fail to create instance with create error reason because request is throttled",
+			instanceTypes:        []string{"Standard_NC6s_v3"},
+			createErrs:           []error{&azcore.ResponseError{ErrorCode: "TooManyRequests", StatusCode: http.StatusTooManyRequests}},
+			expectedError:        errors.New("TooManyRequests"),
+			expectedCreateReason: ReasonThrottled,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			requirements := []v1.NodeSelectorRequirement{
+				{
+					Key:      "node.kubernetes.io/instance-type",
+					Operator: "In",
+					Values:   tc.instanceTypes,
+				},
+			}
+			if len(tc.capacityTypes) != 0 {
+				requirements = append(requirements, v1.NodeSelectorRequirement{
+					Key:      karpenterv1.CapacityTypeLabelKey,
+					Operator: "In",
+					Values:   tc.capacityTypes,
+				})
+			}
+			if len(tc.zones) != 0 {
+				requirements = append(requirements, v1.NodeSelectorRequirement{
+					Key:      v1.LabelTopologyZone,
+					Operator: "In",
+					Values:   tc.zones,
+				})
+			}
+			var taints []v1.Taint
+			if lo.Contains(tc.capacityTypes, karpenterv1.CapacityTypeSpot) && !tc.spotNotTolerated {
+				taints = append(taints, SpotTaint)
+			}
+			nodeClaim := fake.GetNodeClaimObj("agentpool0", map[string]string{"test": "test"}, taints,
+				karpenterv1.ResourceRequirements{Requests: v1.ResourceList{
+					v1.ResourceStorage: lo.FromPtr(resource.NewQuantity(30*1024*1024*1024, resource.DecimalSI)),
+				}}, requirements)
+
+			agentPoolMocks := fake.NewMockAgentPoolsAPI(mockCtrl)
+			zones, err := orderedZones(nodeClaim)
+			assert.NoError(t, err)
+			offerings := lo.Reject(orderedOfferings(tc.instanceTypes, orderedCapacityTypes(nodeClaim), zones), func(o offering, _ int) bool {
+				return lo.ContainsBy(tc.unavailableOfferings, func(u offering) bool {
+					return strings.EqualFold(u.vmSize, o.vmSize) && u.zone == o.zone && u.capacityType == o.capacityType
+				})
+			})
+			var calls []any
+			for i, createErr := range tc.createErrs {
+				o := offerings[i]
+				vmSize := o.vmSize
+				matchVMSize := gomock.Cond(func(x any) bool {
+					ap := x.(armcontainerservice.AgentPool)
+					zones := lo.Map(ap.Properties.AvailabilityZones, func(z *string, _ int) string { return lo.FromPtr(z) })
+					return lo.FromPtr(ap.Properties.VMSize) == vmSize && lo.FromPtr(capacityTypeFromAgentPool(&ap)) == o.capacityType &&
+						(o.zone == "" && len(zones) == 0 || len(zones) == 1 && zones[0] == availabilityZone(o.zone))
+				})
+				if createErr != nil {
+					calls = append(calls, agentPoolMocks.EXPECT().BeginCreateOrUpdate(gomock.Any(), gomock.Any(), gomock.Any(), nodeClaim.Name, matchVMSize, gomock.Any()).Return(nil, createErr))
+					if isCapacityError(createErr) {
+						// agent pool of the unavailable instance type is cleaned up before next instance type is tried.
+						calls = append(calls, agentPoolMocks.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any(), nodeClaim.Name, gomock.Any()).Return(armcontainerservice.AgentPoolsClientGetResponse{}, NotFoundAzError()))
+					}
+					continue
+				}
+
+				mockHandler := fake.NewMockPollingHandler[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse](mockCtrl)
+				createResp := armcontainerservice.AgentPoolsClientCreateOrUpdateResponse{
+					AgentPool: GetAgentPoolObjWithName(nodeClaim.Name, "/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/nodeRG/providers/Microsoft.Compute/virtualMachineScaleSets/aks-agentpool0-20562481-vmss", vmSize),
+				}
+				mockHandler.EXPECT().Done().Return(true).Times(4)
+				mockHandler.EXPECT().Result(gomock.Any(), gomock.Any()).Return(nil)
+				p, err := runtime.NewPoller(&http.Response{StatusCode: http.StatusAccepted, Body: http.NoBody}, runtime.NewPipeline("", "", runtime.PipelineOptions{}, nil), &runtime.NewPollerOptions[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse]{
+					Handler:  mockHandler,
+					Response: &createResp,
+				})
+				calls = append(calls, agentPoolMocks.EXPECT().BeginCreateOrUpdate(gomock.Any(), gomock.Any(), gomock.Any(), nodeClaim.Name, matchVMSize, gomock.Any()).Return(p, err))
+			}
+			gomock.InOrder(calls...)
+
+			mockK8sClient := fake.NewClient().WithKaitoNodeClass(fake.GetKaitoNodeClassObj())
+			nodeList := GetNodeList([]v1.Node{ReadyNode})
+			relevantMap := mockK8sClient.CreateMapWithType(nodeList)
+			for _, obj := range nodeList.Items {
+				n := obj
+				relevantMap[client.ObjectKeyFromObject(&n)] = &n
+			}
+			mockK8sClient.On("List", mock.IsType(context.Background()), mock.IsType(&v1.NodeList{}), mock.Anything).Return(nil)
+
+			p := createTestProvider(agentPoolMocks, mockK8sClient)
+			for _, o := range tc.unavailableOfferings {
+				p.unavailableOfferings.MarkUnavailable(context.Background(), "SkuNotAvailable", o.vmSize, o.zone, o.capacityType)
+			}
+			defer p.unavailableOfferings.Flush()
+
+			instance, err := p.Create(context.Background(), nodeClaim)
+
+			if tc.expectedError != nil {
+				assert.Error(t, err, "Expected to return error")
+				assert.Contains(t, err.Error(), tc.expectedError.Error())
+				assert.Equal(t, tc.isInsufficientCapacity, cloudprovider.IsInsufficientCapacityError(err))
+				var createErr *cloudprovider.CreateError
+				if tc.expectedCreateReason != "" && assert.ErrorAs(t, err, &createErr) {
+					assert.Equal(t, tc.expectedCreateReason, createErr.ConditionReason)
+				}
+				assert.Nil(t, instance, "Response instance should be nil")
+				return
+			}
+			assert.NoError(t, err, "Not expected to return error")
+			assert.Equal(t, tc.expectedInstanceType, lo.FromPtr(instance.Type), "Instance type should be the instance type finally used")
+			assert.Equal(t, tc.expectedInstanceType, instance.Labels[v1.LabelInstanceTypeStable], "Instance type label should be the instance type finally used")
+			assert.Equal(t, tc.expectedCapacityType, lo.FromPtr(instance.CapacityType), "Capacity type should be the capacity type finally used")
+			assert.Equal(t, tc.expectedCapacityType, instance.Labels[karpenterv1.CapacityTypeLabelKey], "Capacity type label should be the capacity type finally used")
+			assert.Equal(t, tc.expectedZone, instance.Labels[v1.LabelTopologyZone], "Zone label should be the zone finally used")
+		})
+	}
+}
+
+func TestCreateWithResolvedInstanceTypes(t *testing.T) {
+	gpuRequirements := []v1.NodeSelectorRequirement{
+		{Key: instancetype.LabelGPUCount, Operator: v1.NodeSelectorOpIn, Values: []string{"2"}},
+		{Key: instancetype.LabelGPUMemoryGiB, Operator: v1.NodeSelectorOpGt, Values: []string{"79"}},
+	}
+	testCases := []struct {
+		name                   string
+		requirements           []v1.NodeSelectorRequirement
+		annotations            map[string]string
+		createErrs             []error
+		expectedInstanceType   string
+		expectedAnnotation     string
+		isInsufficientCapacity bool
+		expectedError          error
+	}{
+		{
+			name:                 "Successfully create instance with the cheapest matching sku",
+			requirements:         gpuRequirements,
+			createErrs:           []error{nil},
+			expectedInstanceType: "Standard_NC48ads_A100_v4",
+			expectedAnnotation:   "Standard_NC48ads_A100_v4=7.346,Standard_NC80adis_H100_v5=13.96",
+		},
+		{
+			name:                 "Successfully create instance with the next cheapest sku when the cheapest one is not available",
+			requirements:         gpuRequirements,
+			createErrs:           []error{&azcore.ResponseError{ErrorCode: "SkuNotAvailable"}, nil},
+			expectedInstanceType: "Standard_NC80adis_H100_v5",
+			expectedAnnotation:   "Standard_NC48ads_A100_v4=7.346,Standard_NC80adis_H100_v5=13.96",
+		},
+		{
+			name:                 "Successfully create instance with the instance types resolved by previous Create call",
+			requirements:         gpuRequirements,
+			annotations:          map[string]string{AnnotationResolvedInstanceTypes: "Standard_NC80adis_H100_v5=13.96"},
+			createErrs:           []error{nil},
+			expectedInstanceType: "Standard_NC80adis_H100_v5",
+			expectedAnnotation:   "Standard_NC80adis_H100_v5=13.96",
+		},
+		{
+			name:                   "Fail to create instance because no sku matches gpu requirements",
+			requirements:           []v1.NodeSelectorRequirement{{Key: instancetype.LabelGPUProduct, Operator: v1.NodeSelectorOpIn, Values: []string{"MI300X"}}},
+			isInsufficientCapacity: true,
+			expectedError:          errors.New("no gpu sku matches requirements"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			nodeClaim := fake.GetNodeClaimObj("agentpool0", map[string]string{"test": "test"}, []v1.Taint{},
+				karpenterv1.ResourceRequirements{Requests: v1.ResourceList{
+					v1.ResourceStorage: lo.FromPtr(resource.NewQuantity(30*1024*1024*1024, resource.DecimalSI)),
+				}}, tc.requirements)
+			nodeClaim.Annotations = tc.annotations
+
+			agentPoolMocks := fake.NewMockAgentPoolsAPI(mockCtrl)
+			var calls []any
+			for _, createErr := range tc.createErrs {
+				if createErr != nil {
+					calls = append(calls,
+						agentPoolMocks.EXPECT().BeginCreateOrUpdate(gomock.Any(), gomock.Any(), gomock.Any(), nodeClaim.Name, gomock.Any(), gomock.Any()).Return(nil, createErr),
+						agentPoolMocks.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any(), nodeClaim.Name, gomock.Any()).Return(armcontainerservice.AgentPoolsClientGetResponse{}, NotFoundAzError()))
+					continue
+				}
+				vmSize := tc.expectedInstanceType
+				mockHandler := fake.NewMockPollingHandler[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse](mockCtrl)
+				mockHandler.EXPECT().Done().Return(true).Times(4)
+				mockHandler.EXPECT().Result(gomock.Any(), gomock.Any()).Return(nil)
+				p, err := runtime.NewPoller(&http.Response{StatusCode: http.StatusAccepted, Body: http.NoBody}, runtime.NewPipeline("", "", runtime.PipelineOptions{}, nil), &runtime.NewPollerOptions[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse]{
+					Handler: mockHandler,
+					Response: &armcontainerservice.AgentPoolsClientCreateOrUpdateResponse{
+						AgentPool: GetAgentPoolObjWithName(nodeClaim.Name, "/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/nodeRG/providers/Microsoft.Compute/virtualMachineScaleSets/aks-agentpool0-20562481-vmss", vmSize),
+					},
+				})
+				matchVMSize := gomock.Cond(func(x any) bool { return lo.FromPtr(x.(armcontainerservice.AgentPool).Properties.VMSize) == vmSize })
+				calls = append(calls, agentPoolMocks.EXPECT().BeginCreateOrUpdate(gomock.Any(), gomock.Any(), gomock.Any(), nodeClaim.Name, matchVMSize, gomock.Any()).Return(p, err))
+			}
+			gomock.InOrder(calls...)
+
+			mockK8sClient := fake.NewClient().WithKaitoNodeClass(fake.GetKaitoNodeClassObj())
+			nodeList := GetNodeList([]v1.Node{ReadyNode})
+			relevantMap := mockK8sClient.CreateMapWithType(nodeList)
+			for _, obj := range nodeList.Items {
+				n := obj
+				relevantMap[client.ObjectKeyFromObject(&n)] = &n
+			}
+			mockK8sClient.On("List", mock.IsType(context.Background()), mock.IsType(&v1.NodeList{}), mock.Anything).Return(nil)
+			mockK8sClient.On("Patch", mock.IsType(context.Background()), mock.IsType(&karpenterv1.NodeClaim{}), mock.Anything, mock.Anything).Return(nil)
+
+			p := createTestProvider(agentPoolMocks, mockK8sClient)
+
+			instance, err := p.Create(context.Background(), nodeClaim)
+			if tc.expectedError != nil {
+				assert.Error(t, err, "Expected to return error")
+				assert.Contains(t, err.Error(), tc.expectedError.Error())
+				assert.Equal(t, tc.isInsufficientCapacity, cloudprovider.IsInsufficientCapacityError(err))
+				assert.Nil(t, instance, "Response instance should be nil")
+				return
+			}
+			assert.NoError(t, err, "Not expected to return error")
+			assert.Equal(t, tc.expectedInstanceType, lo.FromPtr(instance.Type))
+			assert.Equal(t, tc.expectedInstanceType, instance.Labels[v1.LabelInstanceTypeStable])
+			assert.Equal(t, tc.expectedAnnotation, nodeClaim.Annotations[AnnotationResolvedInstanceTypes])
+			if tc.annotations != nil {
+				mockK8sClient.AssertNotCalled(t, "Patch", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestCreateWithOperationInProgress(t *testing.T) {
+	testCases := []struct {
+		name              string
+		provisioningState string
+		waitStates        []string
+		expectedError     error
+		expectedReason    string
+	}{
+		{
+			name:              "Successfully wait for the agent pool which is being created by previous request",
+			provisioningState: "Creating",
+			waitStates:        []string{"Creating", "Creating", "Succeeded"},
+		},
+		{
+			name:              "Fail to wait for the agent pool which is failed to be created by previous request",
+			provisioningState: "Creating",
+			waitStates:        []string{"Creating", "Failed"},
+			expectedError:     errors.New(`agent pool "agentpool0" is Failed`),
+		},
+		{
+			name:              "Successfully wait for the agent pool which has been created by previous request",
+			provisioningState: "Succeeded",
+		},
+		{
+			name:              "Fail to create instance because agent pool is being deleted",
+			provisioningState: "Deleting",
+			expectedError:     errors.New("in progress create node pool operation"),
+			expectedReason:    ReasonOperationInProgress,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			nodeClaim := fake.GetNodeClaimObj("agentpool0", map[string]string{"test": "test"}, []v1.Taint{},
+				karpenterv1.ResourceRequirements{Requests: v1.ResourceList{
+					v1.ResourceStorage: lo.FromPtr(resource.NewQuantity(30*1024*1024*1024, resource.DecimalSI)),
+				}},
+				[]v1.NodeSelectorRequirement{
+					{
+						Key:      "node.kubernetes.io/instance-type",
+						Operator: "In",
+						Values:   []string{"Standard_NC6s_v3"},
+					},
+				})
+
+			existing := GetAgentPoolObjWithName(nodeClaim.Name, "/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/nodeRG/providers/Microsoft.Compute/virtualMachineScaleSets/aks-agentpool0-20562481-vmss", "Standard_NC6s_v3")
+			existing.Properties.ProvisioningState = lo.ToPtr(tc.provisioningState)
+			conflictErr := &azcore.ResponseError{ErrorCode: "OperationNotAllowed", StatusCode: http.StatusConflict,
+				RawResponse: &http.Response{StatusCode: http.StatusConflict, Body: io.NopCloser(strings.NewReader(`{"error":{"code":"OperationNotAllowed","message":"Operation is not allowed because there's an in progress create node pool operation"}}`))}}
+
+			agentPoolMocks := fake.NewMockAgentPoolsAPI(mockCtrl)
+			calls := []any{
+				agentPoolMocks.EXPECT().BeginCreateOrUpdate(gomock.Any(), gomock.Any(), gomock.Any(), nodeClaim.Name, gomock.Any(), gomock.Any()).Return(nil, conflictErr),
+				agentPoolMocks.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any(), nodeClaim.Name, gomock.Any()).Return(armcontainerservice.AgentPoolsClientGetResponse{AgentPool: existing}, nil),
+			}
+			for _, state := range tc.waitStates {
+				polled := existing
+				polled.Properties = lo.ToPtr(*existing.Properties)
+				polled.Properties.ProvisioningState = lo.ToPtr(state)
+				calls = append(calls, agentPoolMocks.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any(), nodeClaim.Name, gomock.Any()).Return(armcontainerservice.AgentPoolsClientGetResponse{AgentPool: polled}, nil))
+			}
+			gomock.InOrder(calls...)
+
+			mockK8sClient := fake.NewClient().WithKaitoNodeClass(fake.GetKaitoNodeClassObj())
+			nodeList := GetNodeList([]v1.Node{ReadyNode})
+			relevantMap := mockK8sClient.CreateMapWithType(nodeList)
+			for _, obj := range nodeList.Items {
+				n := obj
+				relevantMap[client.ObjectKeyFromObject(&n)] = &n
+			}
+			mockK8sClient.On("List", mock.IsType(context.Background()), mock.IsType(&v1.NodeList{}), mock.Anything).Return(nil)
+
+			p := createTestProvider(agentPoolMocks, mockK8sClient)
+
+			instance, err := p.Create(context.Background(), nodeClaim)
+			if tc.expectedError != nil {
+				assert.Error(t, err, "Expected to return error")
+				assert.Contains(t, err.Error(), tc.expectedError.Error())
+				if tc.expectedReason != "" {
+					var createErr *cloudprovider.CreateError
+					if assert.ErrorAs(t, err, &createErr) {
+						assert.Equal(t, tc.expectedReason, createErr.ConditionReason)
+					}
+				}
+				assert.Nil(t, instance, "Response instance should be nil")
+				return
+			}
+			assert.NoError(t, err, "Not expected to return error")
+			assert.Equal(t, ReadyNode.Spec.ProviderID, lo.FromPtr(instance.ID))
+		})
+	}
+}
+
+func TestCreateWithResumeToken(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		annotations          map[string]string
+		polls                int
+		expectedResumeToken  bool
+		expectedInstanceType string
+		expectedPatches      int
+	}{
+		{
+			// Create waits for the creation, so the nodeclaim is launched in one reconcile instead of being deleted by
+			// karpenter when it's not launched within the launch timeout.
+			name:            "Save resume token while waiting for agent pool creation and remove it when the creation is done",
+			polls:           10,
+			expectedPatches: 2,
+		},
+		{
+			name:            "Don't save resume token when agent pool creation is done immediately",
+			polls:           0,
+			expectedPatches: 0,
+		},
+		{
+			name: "Resume agent pool creation and remove resume token when the creation is done",
+			annotations: map[string]string{
+				AnnotationCreateResumeToken: `{"type":"AgentPoolsClientCreateOrUpdateResponse","token":{}}`,
+				AnnotationCreateOffering:    "Standard_NC12s_v3/on-demand",
+			},
+			polls:                0,
+			expectedResumeToken:  true,
+			expectedInstanceType: "Standard_NC12s_v3",
+			expectedPatches:      1,
+		},
+		{
+			name: "Resume agent pool creation and wait until the creation is done",
+			annotations: map[string]string{
+				AnnotationCreateResumeToken: `{"type":"AgentPoolsClientCreateOrUpdateResponse","token":{}}`,
+				AnnotationCreateOffering:    "Standard_NC12s_v3/on-demand",
+			},
+			polls:                3,
+			expectedResumeToken:  true,
+			expectedInstanceType: "Standard_NC12s_v3",
+			expectedPatches:      1,
+		},
+		{
+			name: "Remove resume token when the offering is not requested by nodeclaim",
+			annotations: map[string]string{
+				AnnotationCreateResumeToken: `{"type":"AgentPoolsClientCreateOrUpdateResponse","token":{}}`,
+				AnnotationCreateOffering:    "Standard_NC24s_v3/on-demand",
+			},
+			polls:                0,
+			expectedInstanceType: "Standard_NC6s_v3",
+			expectedPatches:      1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			nodeClaim := fake.GetNodeClaimObj("agentpool0", map[string]string{"test": "test"}, []v1.Taint{},
+				karpenterv1.ResourceRequirements{Requests: v1.ResourceList{
+					v1.ResourceStorage: lo.FromPtr(resource.NewQuantity(30*1024*1024*1024, resource.DecimalSI)),
+				}},
+				[]v1.NodeSelectorRequirement{
+					{
+						Key:      "node.kubernetes.io/instance-type",
+						Operator: "In",
+						Values:   []string{"Standard_NC6s_v3", "Standard_NC12s_v3"},
+					},
+				})
+			nodeClaim.Annotations = lo.Assign(tc.annotations)
+			expectedInstanceType := lo.Ternary(tc.expectedInstanceType != "", tc.expectedInstanceType, "Standard_NC6s_v3")
+
+			mockHandler := fake.NewMockPollingHandler[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse](mockCtrl)
+			createResp := armcontainerservice.AgentPoolsClientCreateOrUpdateResponse{
+				AgentPool: GetAgentPoolObjWithName(nodeClaim.Name, "/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/nodeRG/providers/Microsoft.Compute/virtualMachineScaleSets/aks-agentpool0-20562481-vmss", expectedInstanceType),
+			}
+			polled := 0
+			mockHandler.EXPECT().Done().DoAndReturn(func() bool { return polled >= tc.polls }).AnyTimes()
+			mockHandler.EXPECT().Poll(gomock.Any()).DoAndReturn(func(context.Context) (*http.Response, error) {
+				polled++
+				return &http.Response{StatusCode: http.StatusAccepted, Body: http.NoBody}, nil
+			}).Times(tc.polls)
+			mockHandler.EXPECT().Result(gomock.Any(), gomock.Any()).Return(nil)
+			poller, err := runtime.NewPoller(&http.Response{StatusCode: http.StatusAccepted, Body: http.NoBody}, runtime.NewPipeline("", "", runtime.PipelineOptions{}, nil), &runtime.NewPollerOptions[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse]{
+				Handler:  mockHandler,
+				Response: &createResp,
+			})
+			assert.NoError(t, err)
+
+			matchVMSize := gomock.Cond(func(x any) bool {
+				return lo.FromPtr(x.(armcontainerservice.AgentPool).Properties.VMSize) == expectedInstanceType
+			})
+			matchResumeToken := gomock.Cond(func(x any) bool {
+				options := x.(*armcontainerservice.AgentPoolsClientBeginCreateOrUpdateOptions)
+				return tc.expectedResumeToken == (options != nil && options.ResumeToken == tc.annotations[AnnotationCreateResumeToken])
+			})
+			agentPoolMocks := fake.NewMockAgentPoolsAPI(mockCtrl)
+			agentPoolMocks.EXPECT().BeginCreateOrUpdate(gomock.Any(), gomock.Any(), gomock.Any(), nodeClaim.Name, matchVMSize, matchResumeToken).Return(poller, nil)
+
+			mockK8sClient := fake.NewClient().WithKaitoNodeClass(fake.GetKaitoNodeClassObj())
+			nodeList := GetNodeList([]v1.Node{ReadyNode})
+			relevantMap := mockK8sClient.CreateMapWithType(nodeList)
+			for _, obj := range nodeList.Items {
+				n := obj
+				relevantMap[client.ObjectKeyFromObject(&n)] = &n
+			}
+			mockK8sClient.On("List", mock.IsType(context.Background()), mock.IsType(&v1.NodeList{}), mock.Anything).Return(nil)
+			mockK8sClient.On("Patch", mock.IsType(context.Background()), mock.IsType(&karpenterv1.NodeClaim{}), mock.Anything, mock.Anything).Return(nil)
+
+			p := createTestProvider(agentPoolMocks, mockK8sClient)
+
+			instance, err := p.Create(context.Background(), nodeClaim)
+			assert.NoError(t, err, "Not expected to return error")
+			assert.Equal(t, expectedInstanceType, lo.FromPtr(instance.Type))
+			assert.NotContains(t, nodeClaim.Annotations, AnnotationCreateResumeToken)
+			assert.NotContains(t, nodeClaim.Annotations, AnnotationCreateOffering)
+			mockK8sClient.AssertNumberOfCalls(t, "Patch", tc.expectedPatches)
+		})
+	}
+}
+
+func TestCreateWithResumeTokenFailure(t *testing.T) {
+	testCases := []struct {
+		name                string
+		err                 error
+		expectedReason      string
+		expectedResumeToken bool
+	}{
+		{
+			name:                "Keep resume token when the request is throttled",
+			err:                 &azcore.ResponseError{ErrorCode: "TooManyRequests", StatusCode: http.StatusTooManyRequests},
+			expectedReason:      ReasonThrottled,
+			expectedResumeToken: true,
+		},
+		{
+			name:           "Remove resume token when the request is invalid",
+			err:            &azcore.ResponseError{ErrorCode: "InvalidParameter", StatusCode: http.StatusBadRequest},
+			expectedReason: ReasonInvalidRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			nodeClaim := fake.GetNodeClaimObj("agentpool0", map[string]string{"test": "test"}, []v1.Taint{},
+				karpenterv1.ResourceRequirements{Requests: v1.ResourceList{
+					v1.ResourceStorage: lo.FromPtr(resource.NewQuantity(30*1024*1024*1024, resource.DecimalSI)),
+				}},
+				[]v1.NodeSelectorRequirement{
+					{
+						Key:      "node.kubernetes.io/instance-type",
+						Operator: "In",
+						Values:   []string{"Standard_NC6s_v3"},
+					},
+				})
+			nodeClaim.Annotations = map[string]string{
+				AnnotationCreateResumeToken: `{"type":"AgentPoolsClientCreateOrUpdateResponse","token":{}}`,
+				AnnotationCreateOffering:    "Standard_NC6s_v3/on-demand",
+			}
+
+			agentPoolMocks := fake.NewMockAgentPoolsAPI(mockCtrl)
+			agentPoolMocks.EXPECT().BeginCreateOrUpdate(gomock.Any(), gomock.Any(), gomock.Any(), nodeClaim.Name, gomock.Any(), gomock.Any()).Return(nil, tc.err)
+
+			mockK8sClient := fake.NewClient().WithKaitoNodeClass(fake.GetKaitoNodeClassObj())
+			mockK8sClient.On("Patch", mock.IsType(context.Background()), mock.IsType(&karpenterv1.NodeClaim{}), mock.Anything, mock.Anything).Return(nil)
+
+			p := createTestProvider(agentPoolMocks, mockK8sClient)
+
+			instance, err := p.Create(context.Background(), nodeClaim)
+			assert.Nil(t, instance)
+			var createErr *cloudprovider.CreateError
+			if assert.ErrorAs(t, err, &createErr) {
+				assert.Equal(t, tc.expectedReason, createErr.ConditionReason)
+			}
+			if tc.expectedResumeToken {
+				assert.NotEmpty(t, nodeClaim.Annotations[AnnotationCreateResumeToken])
+				mockK8sClient.AssertNotCalled(t, "Patch", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			} else {
+				assert.NotContains(t, nodeClaim.Annotations, AnnotationCreateResumeToken)
+			}
+		})
+	}
+}
+
+func TestCreateWithAgentPoolType(t *testing.T) {
+	vmNode := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "aks-agentpool0-20562481-vms0",
+			Labels: map[string]string{
+				"agentpool":                      "agentpool0",
+				"kubernetes.azure.com/agentpool": "agentpool0",
+			},
+		},
+		Spec: v1.NodeSpec{
+			ProviderID: "azure:///subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/nodeRG/providers/Microsoft.Compute/virtualMachines/aks-agentpool0-20562481-vms0",
+		},
+	}
+	testCases := []struct {
+		name             string
+		agentPoolType    string
+		nodeClassRef     *karpenterv1.NodeClassReference
+		nodeClass        *v1alpha1.KaitoNodeClass
+		nodeClassErr     error
+		capacityTypes    []string
+		expectedType     armcontainerservice.AgentPoolType
+		expectedPriority *armcontainerservice.ScaleSetPriority
+		expectedErr      string
+		nodeClassErrType bool
+		expectedReason   string
+	}{
+		{
+			name:             "VirtualMachineScaleSets agent pool is created by default",
+			expectedType:     armcontainerservice.AgentPoolTypeVirtualMachineScaleSets,
+			expectedPriority: lo.ToPtr(armcontainerservice.ScaleSetPriorityRegular),
+		},
+		{
+			name:          "VirtualMachines agent pool is created when it's configured for provider",
+			agentPoolType: v1alpha1.AgentPoolTypeVirtualMachines,
+			expectedType:  agentPoolTypeVirtualMachines,
+		},
+		{
+			name:          "agent pool type of KaitoNodeClass overrides the one configured for provider",
+			agentPoolType: v1alpha1.AgentPoolTypeVirtualMachineScaleSets,
+			nodeClassRef:  &karpenterv1.NodeClassReference{Group: v1alpha1.Group, Kind: "KaitoNodeClass", Name: "default"},
+			nodeClass: readyNodeClass(&v1alpha1.KaitoNodeClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "default"},
+				Spec:       v1alpha1.KaitoNodeClassSpec{AgentPoolType: v1alpha1.AgentPoolTypeVirtualMachines},
+			}),
+			expectedType: agentPoolTypeVirtualMachines,
+		},
+		{
+			name:          "agent pool type configured for provider is used when KaitoNodeClass doesn't specify it",
+			agentPoolType: v1alpha1.AgentPoolTypeVirtualMachines,
+			nodeClassRef:  &karpenterv1.NodeClassReference{Group: v1alpha1.Group, Kind: "KaitoNodeClass", Name: "default"},
+			nodeClass:     readyNodeClass(&v1alpha1.KaitoNodeClass{ObjectMeta: metav1.ObjectMeta{Name: "default"}}),
+			expectedType:  agentPoolTypeVirtualMachines,
+		},
+		{
+			name:             "nodeclass of other kinds is ignored",
+			agentPoolType:    v1alpha1.AgentPoolTypeVirtualMachineScaleSets,
+			nodeClassRef:     &karpenterv1.NodeClassReference{Group: "karpenter.azure.com", Kind: "AKSNodeClass", Name: "default"},
+			expectedType:     armcontainerservice.AgentPoolTypeVirtualMachineScaleSets,
+			expectedPriority: lo.ToPtr(armcontainerservice.ScaleSetPriorityRegular),
+		},
+		{
+			// nodeclaim is retried instead of being deleted and recreated in a loop until the nodeclass is created.
+			name:           "KaitoNodeClass is not found",
+			nodeClassRef:   &karpenterv1.NodeClassReference{Group: v1alpha1.Group, Kind: "KaitoNodeClass", Name: "default"},
+			nodeClassErr:   apierrors.NewNotFound(v1alpha1.SchemeGroupVersion.WithResource("kaitonodeclasses").GroupResource(), "default"),
+			expectedErr:    `kaitonodeclass "default" of nodeclaim(agentpool0) is not found`,
+			expectedReason: "NodeClassNotFound",
+		},
+		{
+			name:         "KaitoNodeClass is not ready",
+			nodeClassRef: &karpenterv1.NodeClassReference{Group: v1alpha1.Group, Kind: "KaitoNodeClass", Name: "default"},
+			nodeClass: func() *v1alpha1.KaitoNodeClass {
+				nodeClass := readyNodeClass(&v1alpha1.KaitoNodeClass{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+				nodeClass.StatusConditions().SetFalse(v1alpha1.ConditionTypeTagsReady, "TagsInvalid", "tag key should not be empty")
+				return nodeClass
+			}(),
+			expectedErr:      `kaitonodeclass "default" of nodeclaim(agentpool0) is not ready, TagsReady: tag key should not be empty`,
+			nodeClassErrType: true,
+		},
+		{
+			name:           "KaitoNodeClass readiness is not resolved yet",
+			nodeClassRef:   &karpenterv1.NodeClassReference{Group: v1alpha1.Group, Kind: "KaitoNodeClass", Name: "default"},
+			nodeClass:      &v1alpha1.KaitoNodeClass{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+			expectedErr:    `resolving readiness of kaitonodeclass "default"`,
+			expectedReason: "NodeClassNotReady",
+		},
+		{
+			name:          "spot capacity type is not supported by VirtualMachines agent pool",
+			agentPoolType: v1alpha1.AgentPoolTypeVirtualMachines,
+			capacityTypes: []string{karpenterv1.CapacityTypeSpot},
+			expectedErr:   "spot capacity type of nodeclaim(agentpool0) is not supported by VirtualMachines agent pool",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			requirements := []v1.NodeSelectorRequirement{
+				{
+					Key:      "node.kubernetes.io/instance-type",
+					Operator: "In",
+					Values:   []string{"Standard_NC6s_v3"},
+				},
+			}
+			if len(tc.capacityTypes) != 0 {
+				requirements = append(requirements, v1.NodeSelectorRequirement{Key: karpenterv1.CapacityTypeLabelKey, Operator: "In", Values: tc.capacityTypes})
+			}
+			var taints []v1.Taint
+			if lo.Contains(tc.capacityTypes, karpenterv1.CapacityTypeSpot) {
+				taints = append(taints, SpotTaint)
+			}
+			nodeClaim := fake.GetNodeClaimObj("agentpool0", map[string]string{"test": "test"}, taints,
+				karpenterv1.ResourceRequirements{Requests: v1.ResourceList{
+					v1.ResourceStorage: lo.FromPtr(resource.NewQuantity(30*1024*1024*1024, resource.DecimalSI)),
+				}}, requirements)
+			if tc.nodeClassRef != nil {
+				nodeClaim.Spec.NodeClassRef = tc.nodeClassRef
+			}
+
+			node := ReadyNode
+			if tc.expectedType == agentPoolTypeVirtualMachines {
+				node = vmNode
+			}
+			mockK8sClient := fake.NewClient()
+			if tc.nodeClass == nil && tc.nodeClassErr == nil {
+				tc.nodeClass = fake.GetKaitoNodeClassObj()
+			}
+			if tc.nodeClass != nil {
+				mockK8sClient.CreateOrUpdateObjectInMap(tc.nodeClass)
+			}
+			mockK8sClient.On("Get", mock.Anything, client.ObjectKey{Name: "default"}, mock.IsType(&v1alpha1.KaitoNodeClass{}), mock.Anything).Return(tc.nodeClassErr)
+			mockK8sClient.CreateMapWithType(&v1.NodeList{})[client.ObjectKeyFromObject(&node)] = &node
+			mockK8sClient.On("List", mock.IsType(context.Background()), mock.IsType(&v1.NodeList{}), mock.Anything).Return(nil)
+
+			agentPoolMocks := fake.NewMockAgentPoolsAPI(mockCtrl)
+			if tc.expectedErr == "" {
+				mockHandler := fake.NewMockPollingHandler[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse](mockCtrl)
+				mockHandler.EXPECT().Done().Return(true).Times(4)
+				mockHandler.EXPECT().Result(gomock.Any(), gomock.Any()).Return(nil)
+				ap := GetAgentPoolObjWithName(nodeClaim.Name, "", "Standard_NC6s_v3")
+				ap.Properties.Type = lo.ToPtr(tc.expectedType)
+				poller, err := runtime.NewPoller(&http.Response{StatusCode: http.StatusAccepted, Body: http.NoBody}, runtime.NewPipeline("", "", runtime.PipelineOptions{}, nil),
+					&runtime.NewPollerOptions[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse]{
+						Handler:  mockHandler,
+						Response: &armcontainerservice.AgentPoolsClientCreateOrUpdateResponse{AgentPool: ap},
+					})
+				assert.NoError(t, err)
+				matchAgentPool := gomock.Cond(func(x any) bool {
+					props := x.(armcontainerservice.AgentPool).Properties
+					return lo.FromPtr(props.Type) == tc.expectedType && assert.ObjectsAreEqual(tc.expectedPriority, props.ScaleSetPriority)
+				})
+				agentPoolMocks.EXPECT().BeginCreateOrUpdate(gomock.Any(), gomock.Any(), gomock.Any(), nodeClaim.Name, matchAgentPool, gomock.Any()).Return(poller, nil)
+			}
+
+			mockAzClient := NewAZClientFromAPI(agentPoolMocks)
+			p := NewProvider(mockAzClient, mockK8sClient, "testRG", "testCluster", tc.agentPoolType, nil, TagOptions{}, instancetype.NewProvider("", nil, 0.4, cache.NewUnavailableOfferings()))
+
+			instance, err := p.Create(context.Background(), nodeClaim)
+			if tc.expectedErr != "" {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				assert.Equal(t, tc.nodeClassErrType, cloudprovider.IsNodeClassNotReadyError(err))
+				if tc.expectedReason != "" {
+					var createErr *cloudprovider.CreateError
+					if assert.ErrorAs(t, err, &createErr) {
+						assert.Equal(t, tc.expectedReason, createErr.ConditionReason)
+					}
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, node.Spec.ProviderID, lo.FromPtr(instance.ID))
+		})
+	}
+}
+
+// readyNodeClass sets all status conditions of nodeClass to true.
+func readyNodeClass(nodeClass *v1alpha1.KaitoNodeClass) *v1alpha1.KaitoNodeClass {
+	for _, condition := range []string{v1alpha1.ConditionTypeSubnetsReady, v1alpha1.ConditionTypeOSSKUReady, v1alpha1.ConditionTypeTagsReady} {
+		nodeClass.StatusConditions().SetTrue(condition)
+	}
+	return nodeClass
+}
+
+func TestAgentPoolName(t *testing.T) {
+	testCases := []struct {
+		name          string
+		nodeClaimName string
+		hashed        bool
+	}{
+		{
+			name:          "valid agent pool name is used as it is",
+			nodeClaimName: "ws0a1b2c3d4e",
+		},
+		{
+			name:          "name with dash is hashed",
+			nodeClaimName: "kaito-workspace-0",
+			hashed:        true,
+		},
+		{
+			name:          "name longer than 12 characters is hashed",
+			nodeClaimName: "workspacegpunode",
+			hashed:        true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			apName := AgentPoolName(tc.nodeClaimName)
+			assert.True(t, AgentPoolNameRegex.MatchString(apName), "agent pool name %q should be valid", apName)
+			assert.Equal(t, apName, AgentPoolName(tc.nodeClaimName), "agent pool name should be deterministic")
+			if tc.hashed {
+				sum := sha256.Sum256([]byte(tc.nodeClaimName))
+				assert.Equal(t, "n"+hex.EncodeToString(sum[:])[:11], apName)
+			} else {
+				assert.Equal(t, tc.nodeClaimName, apName)
+			}
+		})
+	}
+
+	assert.NotEqual(t, AgentPoolName("kaito-workspace-0"), AgentPoolName("kaito-workspace-1"), "different nodeclaims should have different agent pool names")
+}
+
+func TestNodeClaimNameFromAgentPool(t *testing.T) {
+	ap := GetAgentPoolObjWithName("agentpool0", "/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/nodeRG/providers/Microsoft.Compute/virtualMachineScaleSets/aks-agentpool0-20562481-vmss", "Standard_NC6s_v3")
+	assert.Equal(t, "agentpool0", lo.FromPtr(nodeClaimNameFromAgentPool(&ap)), "agent pool name should be used when tag is not set")
+
+	hashed := GetAgentPoolObjWithName(AgentPoolName("kaito-workspace-0"), "/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/nodeRG/providers/Microsoft.Compute/virtualMachineScaleSets/aks-agentpool0-20562481-vmss", "Standard_NC6s_v3")
+	hashed.Properties.Tags = map[string]*string{NodeClaimNameTag: lo.ToPtr("kaito-workspace-0")}
+	assert.Equal(t, "kaito-workspace-0", lo.FromPtr(nodeClaimNameFromAgentPool(&hashed)), "nodeclaim name should be resolved from tag")
+}
+
+func TestNewAgentPoolObjectWithCapacityType(t *testing.T) {
+	testCases := []struct {
+		name                   string
+		capacityType           string
+		annotations            map[string]string
+		expectedPriority       armcontainerservice.ScaleSetPriority
+		expectedEvictionPolicy *armcontainerservice.ScaleSetEvictionPolicy
+		expectedMaxPrice       *float32
+		expectedErr            string
+	}{
+		{
+			name:             "On-demand capacity type creates regular agent pool",
+			capacityType:     karpenterv1.CapacityTypeOnDemand,
+			expectedPriority: armcontainerservice.ScaleSetPriorityRegular,
+		},
+		{
+			name:                   "Spot capacity type creates spot agent pool with default settings",
+			capacityType:           karpenterv1.CapacityTypeSpot,
+			expectedPriority:       armcontainerservice.ScaleSetPrioritySpot,
+			expectedEvictionPolicy: lo.ToPtr(armcontainerservice.ScaleSetEvictionPolicyDelete),
+			expectedMaxPrice:       lo.ToPtr(float32(-1)),
+		},
+		{
+			name:         "Spot capacity type creates spot agent pool with annotated settings",
+			capacityType: karpenterv1.CapacityTypeSpot,
+			annotations: map[string]string{
+				AnnotationSpotEvictionPolicy: "deallocate",
+				AnnotationSpotMaxPrice:       "0.5",
+			},
+			expectedPriority:       armcontainerservice.ScaleSetPrioritySpot,
+			expectedEvictionPolicy: lo.ToPtr(armcontainerservice.ScaleSetEvictionPolicyDeallocate),
+			expectedMaxPrice:       lo.ToPtr(float32(0.5)),
+		},
+		{
+			name:         "Spot capacity type with invalid eviction policy",
+			capacityType: karpenterv1.CapacityTypeSpot,
+			annotations:  map[string]string{AnnotationSpotEvictionPolicy: "Stop"},
+			expectedErr:  "spot eviction policy(Stop) of nodeclaim(nodeclaim-test) is invalid",
+		},
+		{
+			name:         "Spot capacity type with invalid max price",
+			capacityType: karpenterv1.CapacityTypeSpot,
+			annotations:  map[string]string{AnnotationSpotMaxPrice: "0"},
+			expectedErr:  "spot max price(0) of nodeclaim(nodeclaim-test) is invalid",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			nodeClaim := fake.GetNodeClaimObj("nodeclaim-test", map[string]string{"test": "test"}, []v1.Taint{}, karpenterv1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceStorage: lo.FromPtr(resource.NewQuantity(30*1024*1024*1024, resource.DecimalSI)),
+				},
+			}, []v1.NodeSelectorRequirement{})
+			nodeClaim.Annotations = tc.annotations
+
+			result, err := newAgentPoolObject(offering{vmSize: "Standard_NC6s_v3", capacityType: tc.capacityType}, nil, nodeClaim, nil, armcontainerservice.AgentPoolTypeVirtualMachineScaleSets, TagOptions{})
+			if tc.expectedErr != "" {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedPriority, *result.Properties.ScaleSetPriority)
+			assert.Equal(t, tc.expectedEvictionPolicy, result.Properties.ScaleSetEvictionPolicy)
+			assert.Equal(t, tc.expectedMaxPrice, result.Properties.SpotMaxPrice)
+		})
+	}
+}
+
+func TestOrderedCapacityTypes(t *testing.T) {
+	testCases := []struct {
+		name         string
+		requirements []v1.NodeSelectorRequirement
+		taints       []v1.Taint
+		expected     []string
+	}{
+		{
+			name:     "No capacity type requirement defaults to on-demand",
+			expected: []string{karpenterv1.CapacityTypeOnDemand},
+		},
+		{
+			name: "Spot is preferred over on-demand",
+			requirements: []v1.NodeSelectorRequirement{
+				{Key: karpenterv1.CapacityTypeLabelKey, Operator: v1.NodeSelectorOpIn, Values: []string{karpenterv1.CapacityTypeOnDemand, karpenterv1.CapacityTypeSpot}},
+			},
+			taints:   []v1.Taint{SpotTaint},
+			expected: []string{karpenterv1.CapacityTypeSpot, karpenterv1.CapacityTypeOnDemand},
+		},
+		{
+			name: "Spot is skipped without the spot taint",
+			requirements: []v1.NodeSelectorRequirement{
+				{Key: karpenterv1.CapacityTypeLabelKey, Operator: v1.NodeSelectorOpIn, Values: []string{karpenterv1.CapacityTypeOnDemand, karpenterv1.CapacityTypeSpot}},
+			},
+			taints:   []v1.Taint{{Key: SpotTaint.Key, Value: "regular", Effect: v1.TaintEffectNoSchedule}},
+			expected: []string{karpenterv1.CapacityTypeOnDemand},
+		},
+		{
+			name: "Spot only",
+			requirements: []v1.NodeSelectorRequirement{
+				{Key: karpenterv1.CapacityTypeLabelKey, Operator: v1.NodeSelectorOpIn, Values: []string{karpenterv1.CapacityTypeSpot}},
+			},
+			taints:   []v1.Taint{SpotTaint},
+			expected: []string{karpenterv1.CapacityTypeSpot},
+		},
+		{
+			name: "Spot is excluded",
+			requirements: []v1.NodeSelectorRequirement{
+				{Key: karpenterv1.CapacityTypeLabelKey, Operator: v1.NodeSelectorOpNotIn, Values: []string{karpenterv1.CapacityTypeSpot}},
+			},
+			expected: []string{karpenterv1.CapacityTypeOnDemand},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			nodeClaim := fake.GetNodeClaimObj("nodeclaim-test", map[string]string{}, tc.taints, karpenterv1.ResourceRequirements{}, tc.requirements)
+			assert.Equal(t, tc.expected, orderedCapacityTypes(nodeClaim))
+		})
+	}
+}
+
+func TestOrderedZones(t *testing.T) {
+	testCases := []struct {
+		name         string
+		requirements []v1.NodeSelectorRequirement
+		expected     []string
+		expectedErr  bool
+	}{
+		{
+			name:     "No zone requirement means agent pool is not pinned to any zone",
+			expected: []string{""},
+		},
+		{
+			name: "Zones are returned in the listed order",
+			requirements: []v1.NodeSelectorRequirement{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"eastus-3", "eastus-1"}},
+			},
+			expected: []string{"eastus-3", "eastus-1"},
+		},
+		{
+			name: "Zones which are excluded are removed",
+			requirements: []v1.NodeSelectorRequirement{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"eastus-3", "eastus-1"}},
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpNotIn, Values: []string{"eastus-3"}},
+			},
+			expected: []string{"eastus-1"},
+		},
+		{
+			name: "Zone requirement without allowed zones",
+			requirements: []v1.NodeSelectorRequirement{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpNotIn, Values: []string{"eastus-3"}},
+			},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			nodeClaim := fake.GetNodeClaimObj("nodeclaim-test", map[string]string{}, []v1.Taint{}, karpenterv1.ResourceRequirements{}, tc.requirements)
+			zones, err := orderedZones(nodeClaim)
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, zones)
+		})
+	}
+}
+
+func TestNewAgentPoolObjectWithZone(t *testing.T) {
+	nodeClaim := fake.GetNodeClaimObj("nodeclaim-test", map[string]string{"test": "test"}, []v1.Taint{}, karpenterv1.ResourceRequirements{
+		Requests: v1.ResourceList{
+			v1.ResourceStorage: lo.FromPtr(resource.NewQuantity(30*1024*1024*1024, resource.DecimalSI)),
+		},
+	}, []v1.NodeSelectorRequirement{})
+
+	result, err := newAgentPoolObject(offering{vmSize: "Standard_NC6s_v3", capacityType: karpenterv1.CapacityTypeOnDemand, zone: "eastus-2"}, nil, nodeClaim, nil, armcontainerservice.AgentPoolTypeVirtualMachineScaleSets, TagOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []*string{lo.ToPtr("2")}, result.Properties.AvailabilityZones)
+
+	result, err = newAgentPoolObject(offering{vmSize: "Standard_NC6s_v3", capacityType: karpenterv1.CapacityTypeOnDemand}, nil, nodeClaim, nil, armcontainerservice.AgentPoolTypeVirtualMachineScaleSets, TagOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, result.Properties.AvailabilityZones)
+}
+
+func TestNewAgentPoolObjectWithNodeClass(t *testing.T) {
+	subnetID := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/gpu"
+	podSubnetID := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/pods"
+	asgID := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/applicationSecurityGroups/gpu"
+	testCases := []struct {
+		name          string
+		nodeClass     *v1alpha1.KaitoNodeClass
+		storage       int64
+		annotations   map[string]string
+		expectedError string
+		validate      func(t *testing.T, ap armcontainerservice.AgentPool)
+	}{
+		{
+			name:    "defaults are used when no nodeclass is referenced",
+			storage: 30,
+			validate: func(t *testing.T, ap armcontainerservice.AgentPool) {
+				assert.Equal(t, armcontainerservice.OSSKUUbuntu, lo.FromPtr(ap.Properties.OSSKU))
+				assert.Equal(t, int32(30), lo.FromPtr(ap.Properties.OSDiskSizeGB))
+				assert.Nil(t, ap.Properties.OSDiskType)
+				assert.Nil(t, ap.Properties.MaxPods)
+				assert.Nil(t, ap.Properties.VnetSubnetID)
+				assert.Nil(t, ap.Properties.PodSubnetID)
+				assert.Nil(t, ap.Properties.NetworkProfile)
+				assert.Nil(t, ap.Properties.EnableNodePublicIP)
+				assert.Equal(t, map[string]*string{NodeClaimNameTag: lo.ToPtr("nodeclaim-test")}, ap.Properties.Tags)
+			},
+		},
+		{
+			name: "settings of nodeclass are applied",
+			nodeClass: &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{
+				OSSKU:              "AzureLinux",
+				OSDiskType:         "Ephemeral",
+				MaxPods:            lo.ToPtr(int32(50)),
+				VnetSubnetID:       lo.ToPtr(subnetID),
+				EnableNodePublicIP: lo.ToPtr(true),
+				Tags:               map[string]string{"team": "ml"},
+				GPUDriver:          v1alpha1.GPUDriverNone,
+			}},
+			storage: 100,
+			validate: func(t *testing.T, ap armcontainerservice.AgentPool) {
+				assert.Equal(t, armcontainerservice.OSSKUAzureLinux, lo.FromPtr(ap.Properties.OSSKU))
+				assert.Equal(t, int32(100), lo.FromPtr(ap.Properties.OSDiskSizeGB))
+				assert.Equal(t, armcontainerservice.OSDiskTypeEphemeral, lo.FromPtr(ap.Properties.OSDiskType))
+				assert.Equal(t, int32(50), lo.FromPtr(ap.Properties.MaxPods))
+				assert.Equal(t, subnetID, lo.FromPtr(ap.Properties.VnetSubnetID))
+				assert.True(t, lo.FromPtr(ap.Properties.EnableNodePublicIP))
+				assert.Equal(t, map[string]*string{
+					NodeClaimNameTag:        lo.ToPtr("nodeclaim-test"),
+					"team":                  lo.ToPtr("ml"),
+					SkipGPUDriverInstallTag: lo.ToPtr("true"),
+				}, ap.Properties.Tags)
+			},
+		},
+		{
+			name: "network settings of nodeclass are applied",
+			nodeClass: &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{
+				VnetSubnetID: lo.ToPtr(subnetID),
+				PodSubnetID:  lo.ToPtr(podSubnetID),
+				NetworkProfile: &v1alpha1.NetworkProfile{
+					ApplicationSecurityGroups: []string{asgID},
+					AllowedHostPorts:          []v1alpha1.PortRange{{PortStart: 8000, PortEnd: 8080, Protocol: "TCP"}},
+				},
+			}},
+			storage: 30,
+			validate: func(t *testing.T, ap armcontainerservice.AgentPool) {
+				assert.Equal(t, subnetID, lo.FromPtr(ap.Properties.VnetSubnetID))
+				assert.Equal(t, podSubnetID, lo.FromPtr(ap.Properties.PodSubnetID))
+				assert.Equal(t, &armcontainerservice.AgentPoolNetworkProfile{
+					ApplicationSecurityGroups: []*string{lo.ToPtr(asgID)},
+					AllowedHostPorts: []*armcontainerservice.PortRange{{
+						PortStart: lo.ToPtr(int32(8000)),
+						PortEnd:   lo.ToPtr(int32(8080)),
+						Protocol:  lo.ToPtr(armcontainerservice.ProtocolTCP),
+					}},
+				}, ap.Properties.NetworkProfile)
+			},
+		},
+		{
+			name: "kubelet and linux os configuration of nodeclass are applied",
+			nodeClass: &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{
+				Kubelet:       &v1alpha1.KubeletConfiguration{TopologyManagerPolicy: "single-numa-node"},
+				LinuxOSConfig: &v1alpha1.LinuxOSConfiguration{TransparentHugePageEnabled: "never"},
+			}},
+			storage: 30,
+			validate: func(t *testing.T, ap armcontainerservice.AgentPool) {
+				assert.Equal(t, "single-numa-node", lo.FromPtr(ap.Properties.KubeletConfig.TopologyManagerPolicy))
+				assert.Equal(t, "never", lo.FromPtr(ap.Properties.LinuxOSConfig.TransparentHugePageEnabled))
+			},
+		},
+		{
+			name: "fail with unsupported sysctl of nodeclass",
+			nodeClass: &v1alpha1.KaitoNodeClass{ObjectMeta: metav1.ObjectMeta{Name: "default"}, Spec: v1alpha1.KaitoNodeClassSpec{
+				LinuxOSConfig: &v1alpha1.LinuxOSConfiguration{Sysctls: map[string]string{"vm.nr_hugepages": "1024"}},
+			}},
+			storage:       30,
+			expectedError: `linux os config of kaitonodeclass(default) is invalid, sysctl "vm.nr_hugepages" is not supported`,
+		},
+		{
+			name:      "os disk size of nodeclass is used when nodeclaim doesn't request storage",
+			nodeClass: &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{OSDiskSizeGB: lo.ToPtr(int32(256))}},
+			validate: func(t *testing.T, ap armcontainerservice.AgentPool) {
+				assert.Equal(t, int32(256), lo.FromPtr(ap.Properties.OSDiskSizeGB))
+			},
+		},
+		{
+			name:          "fail when neither nodeclaim nor nodeclass specifies os disk size",
+			nodeClass:     &v1alpha1.KaitoNodeClass{},
+			expectedError: "storage request of nodeclaim(nodeclaim-test) should be more than 0",
+		},
+		{
+			name:        "image family annotation of nodeclaim takes precedence over os sku of nodeclass",
+			nodeClass:   &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{OSSKU: "AzureLinux"}},
+			storage:     30,
+			annotations: map[string]string{LabelNodeImageFamily: "Ubuntu"},
+			validate: func(t *testing.T, ap armcontainerservice.AgentPool) {
+				assert.Equal(t, armcontainerservice.OSSKUUbuntu, lo.FromPtr(ap.Properties.OSSKU))
+			},
+		},
+		{
+			name:      "tag of nodeclaim name can not be overridden by nodeclass",
+			nodeClass: &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{Tags: map[string]string{NodeClaimNameTag: "other"}}},
+			storage:   30,
+			validate: func(t *testing.T, ap armcontainerservice.AgentPool) {
+				assert.Equal(t, "nodeclaim-test", lo.FromPtr(ap.Properties.Tags[NodeClaimNameTag]))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resources := karpenterv1.ResourceRequirements{}
+			if tc.storage != 0 {
+				resources.Requests = v1.ResourceList{v1.ResourceStorage: lo.FromPtr(resource.NewQuantity(tc.storage*1024*1024*1024, resource.DecimalSI))}
+			}
+			nodeClaim := fake.GetNodeClaimObj("nodeclaim-test", map[string]string{"test": "test"}, []v1.Taint{}, resources, []v1.NodeSelectorRequirement{})
+			nodeClaim.Annotations = tc.annotations
+
+			ap, err := newAgentPoolObject(offering{vmSize: "Standard_NC6s_v3", capacityType: karpenterv1.CapacityTypeOnDemand}, nil, nodeClaim, tc.nodeClass, armcontainerservice.AgentPoolTypeVirtualMachineScaleSets, TagOptions{})
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			tc.validate(t, ap)
+		})
+	}
+}
+
+func TestNewAgentPoolObjectWithGPUSettings(t *testing.T) {
+	testCases := []struct {
+		name          string
+		vmSize        string
+		nodeClass     *v1alpha1.KaitoNodeClass
+		annotations   map[string]string
+		expectedError string
+		validate      func(t *testing.T, ap armcontainerservice.AgentPool)
+	}{
+		{
+			name:   "gpus are not partitioned and driver is installed by default",
+			vmSize: "Standard_NC24ads_A100_v4",
+			validate: func(t *testing.T, ap armcontainerservice.AgentPool) {
+				assert.Nil(t, ap.Properties.GpuInstanceProfile)
+				assert.NotContains(t, ap.Properties.NodeLabels, LabelGPUInstanceProfile)
+				assert.NotContains(t, ap.Properties.Tags, SkipGPUDriverInstallTag)
+			},
+		},
+		{
+			name:      "gpu instance profile of nodeclass is applied and added as node label",
+			vmSize:    "Standard_NC24ads_A100_v4",
+			nodeClass: &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{GPUInstanceProfile: "MIG3g"}},
+			validate: func(t *testing.T, ap armcontainerservice.AgentPool) {
+				assert.Equal(t, armcontainerservice.GPUInstanceProfileMIG3G, lo.FromPtr(ap.Properties.GpuInstanceProfile))
+				assert.Equal(t, "MIG3g", lo.FromPtr(ap.Properties.NodeLabels[LabelGPUInstanceProfile]))
+			},
+		},
+		{
+			name:        "annotations of nodeclaim take precedence over nodeclass",
+			vmSize:      "Standard_ND96isr_H100_v5",
+			nodeClass:   &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{GPUInstanceProfile: "MIG3g", GPUDriver: v1alpha1.GPUDriverInstall}},
+			annotations: map[string]string{LabelGPUInstanceProfile: "mig7g", AnnotationGPUDriver: "none"},
+			validate: func(t *testing.T, ap armcontainerservice.AgentPool) {
+				assert.Equal(t, armcontainerservice.GPUInstanceProfileMIG7G, lo.FromPtr(ap.Properties.GpuInstanceProfile))
+				assert.Equal(t, "MIG7g", lo.FromPtr(ap.Properties.NodeLabels[LabelGPUInstanceProfile]))
+				assert.Equal(t, "true", lo.FromPtr(ap.Properties.Tags[SkipGPUDriverInstallTag]))
+			},
+		},
+		{
+			name:          "fail when vm size doesn't support mig",
+			vmSize:        "Standard_NC6s_v3",
+			annotations:   map[string]string{LabelGPUInstanceProfile: "MIG1g"},
+			expectedError: "gpu instance profile(MIG1g) of nodeclaim(nodeclaim-test) is not supported by vm size Standard_NC6s_v3",
+		},
+		{
+			name:          "fail with unknown gpu instance profile",
+			vmSize:        "Standard_NC24ads_A100_v4",
+			annotations:   map[string]string{LabelGPUInstanceProfile: "MIG5g"},
+			expectedError: "gpu instance profile(MIG5g) of nodeclaim(nodeclaim-test) is invalid, must be one of [MIG1g MIG2g MIG3g MIG4g MIG7g]",
+		},
+		{
+			name:          "fail when gpu driver is skipped for vm size without gpu",
+			vmSize:        "Standard_D4s_v3",
+			nodeClass:     &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{GPUDriver: v1alpha1.GPUDriverNone}},
+			expectedError: "gpu driver(None) of nodeclaim(nodeclaim-test) is not applicable to vm size Standard_D4s_v3 which has no GPU",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resources := karpenterv1.ResourceRequirements{Requests: v1.ResourceList{
+				v1.ResourceStorage: lo.FromPtr(resource.NewQuantity(30*1024*1024*1024, resource.DecimalSI)),
+			}}
+			nodeClaim := fake.GetNodeClaimObj("nodeclaim-test", map[string]string{"test": "test"}, []v1.Taint{}, resources, []v1.NodeSelectorRequirement{})
+			nodeClaim.Annotations = tc.annotations
+
+			ap, err := newAgentPoolObject(offering{vmSize: tc.vmSize, capacityType: karpenterv1.CapacityTypeOnDemand}, nil, nodeClaim, tc.nodeClass, armcontainerservice.AgentPoolTypeVirtualMachineScaleSets, TagOptions{})
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			tc.validate(t, ap)
+		})
+	}
+}
+
+func TestIsGPUVMSize(t *testing.T) {
+	testCases := map[string]bool{
+		"Standard_NC6s_v3":         true,
+		"standard_nc24ads_a100_v4": true,
+		"STANDARD_ND96ISR_H100_V5": true,
+		"Standard_D4s_v3":          false,
+		"Custom_Standard_NC6s_v3":  false,
+		"":                         false,
+	}
+	for vmSize, expected := range testCases {
+		assert.Equal(t, expected, isGPUVMSize(vmSize), vmSize)
+	}
+}
+
+func TestDetermineOSSKUWithNilNodeClaim(t *testing.T) {
+	result, err := determineOSSKU(nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, armcontainerservice.OSSKUUbuntu, *result)
+}
+
+func TestNewAgentPoolObjectWithImageFamily(t *testing.T) {
+	testCases := []struct {
+		name          string
+		vmSize        string
+		nodeClaim     *karpenterv1.NodeClaim
+		expectedOSSKU armcontainerservice.OSSKU
+		expectedErr   string
+	}{
+		{
+			name:   "NodeClaim with AzureLinux image family annotation",
+			vmSize: "Standard_NC6s_v3",
+			nodeClaim: &karpenterv1.NodeClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-nodeclaim",
+					Annotations: map[string]string{
+						"kaito.sh/node-image-family": "AzureLinux",
+					},
+				},
+				Spec: karpenterv1.NodeClaimSpec{
+					Resources: karpenterv1.ResourceRequirements{
+						Requests: v1.ResourceList{
+							v1.ResourceStorage: *resource.NewQuantity(30*1024*1024*1024, resource.DecimalSI),
+						},
+					},
+				},
+			},
+			expectedOSSKU: armcontainerservice.OSSKUAzureLinux,
+		},
+		{
+			name:   "NodeClaim with Ubuntu image family annotation",
+			vmSize: "Standard_NC6s_v3",
+			nodeClaim: &karpenterv1.NodeClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-nodeclaim",
+					Annotations: map[string]string{
+						"kaito.sh/node-image-family": "Ubuntu",
+					},
+				},
+				Spec: karpenterv1.NodeClaimSpec{
+					Resources: karpenterv1.ResourceRequirements{
+						Requests: v1.ResourceList{
+							v1.ResourceStorage: *resource.NewQuantity(30*1024*1024*1024, resource.DecimalSI),
+						},
+					},
+				},
+			},
+			expectedOSSKU: armcontainerservice.OSSKUUbuntu,
+		},
+		{
+			name:   "NodeClaim with case-insensitive AzureLinux image family annotation",
+			vmSize: "Standard_NC6s_v3",
+			nodeClaim: &karpenterv1.NodeClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-nodeclaim",
+					Annotations: map[string]string{
+						"kaito.sh/node-image-family": "azurelinux",
+					},
+				},
+				Spec: karpenterv1.NodeClaimSpec{
+					Resources: karpenterv1.ResourceRequirements{
+						Requests: v1.ResourceList{
+							v1.ResourceStorage: *resource.NewQuantity(30*1024*1024*1024, resource.DecimalSI),
+						},
+					},
+				},
+			},
+			expectedOSSKU: armcontainerservice.OSSKUAzureLinux,
+		},
+		{
+			name:   "NodeClaim with unknown image family annotation is rejected",
+			vmSize: "Standard_NC6s_v3",
+			nodeClaim: &karpenterv1.NodeClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-nodeclaim",
+					Annotations: map[string]string{
+						"kaito.sh/node-image-family": "Unknown",
+					},
+				},
+				Spec: karpenterv1.NodeClaimSpec{
+					Resources: karpenterv1.ResourceRequirements{
+						Requests: v1.ResourceList{
+							v1.ResourceStorage: *resource.NewQuantity(30*1024*1024*1024, resource.DecimalSI),
+						},
+					},
+				},
+			},
+			expectedErr: `unsupported image family "Unknown"`,
+		},
+		{
+			name:   "NodeClaim without image family annotation defaults to Ubuntu",
+			vmSize: "Standard_NC6s_v3",
+			nodeClaim: &karpenterv1.NodeClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-nodeclaim",
+				},
+				Spec: karpenterv1.NodeClaimSpec{
+					Resources: karpenterv1.ResourceRequirements{
+						Requests: v1.ResourceList{
 							v1.ResourceStorage: *resource.NewQuantity(30*1024*1024*1024, resource.DecimalSI),
 						},
 					},
@@ -1016,8 +2553,11 @@ func TestNewAgentPoolObjectWithImageFamily(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			result, err := newAgentPoolObject(tc.vmSize, tc.nodeClaim)
-
+			result, err := newAgentPoolObject(offering{vmSize: tc.vmSize, capacityType: karpenterv1.CapacityTypeOnDemand}, nil, tc.nodeClaim, nil, armcontainerservice.AgentPoolTypeVirtualMachineScaleSets, TagOptions{})
+			if tc.expectedErr != "" {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
 			assert.NoError(t, err)
 			assert.Equal(t, tc.expectedOSSKU, *result.Properties.OSSKU)
 			assert.Equal(t, armcontainerservice.OSTypeLinux, *result.Properties.OSType)
@@ -1027,7 +2567,9 @@ func TestNewAgentPoolObjectWithImageFamily(t *testing.T) {
 
 func createTestProvider(agentPoolsAPIMocks *fake.MockAgentPoolsAPI, mockK8sClient *fake.MockClient) *Provider {
 	mockAzClient := NewAZClientFromAPI(agentPoolsAPIMocks)
-	return NewProvider(mockAzClient, mockK8sClient, "testRG", "testCluster")
+	p := NewProvider(mockAzClient, mockK8sClient, "testRG", "testCluster", v1alpha1.AgentPoolTypeVirtualMachineScaleSets, nil, TagOptions{}, instancetype.NewProvider("", nil, 0.4, cache.NewUnavailableOfferings()))
+	p.pollFrequency = time.Millisecond
+	return p
 }
 
 func GetAgentPoolObj(apType armcontainerservice.AgentPoolType, capacityType armcontainerservice.ScaleSetPriority,
@@ -1094,5 +2636,5 @@ var (
 )
 
 func NotFoundAzError() *azcore.ResponseError {
-	return &azcore.ResponseError{ErrorCode: "NotFound"}
+	return &azcore.ResponseError{ErrorCode: "NotFound", StatusCode: http.StatusNotFound}
 }
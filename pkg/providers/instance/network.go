@@ -0,0 +1,117 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instance
+
+import (
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v4"
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/utils"
+	"github.com/samber/lo"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	// AnnotationSubnetID and AnnotationPodSubnetID record the subnets of the agent pool which nodeclaim is launched
+	// with, they're not set when the subnets of the cluster are used.
+	AnnotationSubnetID    = "kaito.sh/subnet-id"
+	AnnotationPodSubnetID = "kaito.sh/pod-subnet-id"
+
+	// maxApplicationSecurityGroups is the maximum number of application security groups of a network interface.
+	maxApplicationSecurityGroups = 10
+)
+
+var protocols = lo.Map(armcontainerservice.PossibleProtocolValues(), func(p armcontainerservice.Protocol, _ int) string { return string(p) })
+
+// networkProfile converts the network profile of nodeClass to the agent pool network profile.
+func networkProfile(nodeClass *v1alpha1.KaitoNodeClass) *armcontainerservice.AgentPoolNetworkProfile {
+	if nodeClass == nil || nodeClass.Spec.NetworkProfile == nil {
+		return nil
+	}
+	np := nodeClass.Spec.NetworkProfile
+	if len(np.ApplicationSecurityGroups) == 0 && len(np.AllowedHostPorts) == 0 {
+		return nil
+	}
+	profile := &armcontainerservice.AgentPoolNetworkProfile{}
+	for _, asg := range np.ApplicationSecurityGroups {
+		profile.ApplicationSecurityGroups = append(profile.ApplicationSecurityGroups, lo.ToPtr(asg))
+	}
+	for _, r := range np.AllowedHostPorts {
+		profile.AllowedHostPorts = append(profile.AllowedHostPorts, &armcontainerservice.PortRange{
+			PortStart: lo.ToPtr(r.PortStart),
+			PortEnd:   lo.ToPtr(r.PortEnd),
+			Protocol:  lo.ToPtr(armcontainerservice.Protocol(r.Protocol)),
+		})
+	}
+	return profile
+}
+
+// validateNetwork checks the subnets and network profile of KaitoNodeClass, the pod subnet should be in the virtual
+// network of the node subnet because AKS doesn't peer them.
+func validateNetwork(spec v1alpha1.KaitoNodeClassSpec, specPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	var vnetSubnet, podSubnet *arm.ResourceID
+	if spec.VnetSubnetID != nil {
+		parsed, err := utils.ParseSubnetID(*spec.VnetSubnetID)
+		if err != nil {
+			errs = append(errs, field.Invalid(specPath.Child("vnetSubnetID"), *spec.VnetSubnetID, err.Error()))
+		}
+		vnetSubnet = parsed
+	}
+	if spec.PodSubnetID != nil {
+		parsed, err := utils.ParseSubnetID(*spec.PodSubnetID)
+		if err != nil {
+			errs = append(errs, field.Invalid(specPath.Child("podSubnetID"), *spec.PodSubnetID, err.Error()))
+		}
+		podSubnet = parsed
+		if spec.VnetSubnetID == nil {
+			errs = append(errs, field.Required(specPath.Child("vnetSubnetID"), "vnetSubnetID is required when podSubnetID is specified"))
+		}
+	}
+	if vnetSubnet != nil && podSubnet != nil && !utils.InSameVirtualNetwork(vnetSubnet, podSubnet) {
+		errs = append(errs, field.Invalid(specPath.Child("podSubnetID"), *spec.PodSubnetID,
+			"pod subnet should be in the same virtual network as vnetSubnetID"))
+	}
+
+	if spec.NetworkProfile == nil {
+		return errs
+	}
+	profilePath := specPath.Child("networkProfile")
+	asgs := spec.NetworkProfile.ApplicationSecurityGroups
+	if len(asgs) > maxApplicationSecurityGroups {
+		errs = append(errs, field.TooMany(profilePath.Child("applicationSecurityGroups"), len(asgs), maxApplicationSecurityGroups))
+	}
+	for i, asg := range asgs {
+		if _, err := utils.ParseApplicationSecurityGroupID(asg); err != nil {
+			errs = append(errs, field.Invalid(profilePath.Child("applicationSecurityGroups").Index(i), asg, err.Error()))
+		}
+	}
+	for i, r := range spec.NetworkProfile.AllowedHostPorts {
+		portPath := profilePath.Child("allowedHostPorts").Index(i)
+		if r.PortStart < 1 || r.PortStart > 65535 {
+			errs = append(errs, field.Invalid(portPath.Child("portStart"), r.PortStart, "should be between 1 and 65535"))
+		}
+		if r.PortEnd < 1 || r.PortEnd > 65535 {
+			errs = append(errs, field.Invalid(portPath.Child("portEnd"), r.PortEnd, "should be between 1 and 65535"))
+		} else if r.PortEnd < r.PortStart {
+			errs = append(errs, field.Invalid(portPath.Child("portEnd"), r.PortEnd, "should be greater than or equal to portStart"))
+		}
+		if !lo.Contains(protocols, r.Protocol) {
+			errs = append(errs, field.NotSupported(portPath.Child("protocol"), r.Protocol, protocols))
+		}
+	}
+	return errs
+}
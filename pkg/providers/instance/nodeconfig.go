@@ -0,0 +1,238 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instance
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v4"
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/samber/lo"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// sysctls are the sysctls supported by AKS custom node configuration, keyed by sysctl name. each of them sets the
+// value on the field of SysctlConfig.
+var sysctls = map[string]func(c *armcontainerservice.SysctlConfig, value string) error{
+	"fs.aio-max-nr":                      int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.FsAioMaxNr = &v }),
+	"fs.file-max":                        int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.FsFileMax = &v }),
+	"fs.inotify.max_user_watches":        int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.FsInotifyMaxUserWatches = &v }),
+	"fs.nr_open":                         int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.FsNrOpen = &v }),
+	"kernel.threads-max":                 int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.KernelThreadsMax = &v }),
+	"net.core.netdev_max_backlog":        int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.NetCoreNetdevMaxBacklog = &v }),
+	"net.core.optmem_max":                int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.NetCoreOptmemMax = &v }),
+	"net.core.rmem_default":              int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.NetCoreRmemDefault = &v }),
+	"net.core.rmem_max":                  int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.NetCoreRmemMax = &v }),
+	"net.core.somaxconn":                 int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.NetCoreSomaxconn = &v }),
+	"net.core.wmem_default":              int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.NetCoreWmemDefault = &v }),
+	"net.core.wmem_max":                  int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.NetCoreWmemMax = &v }),
+	"net.ipv4.ip_local_port_range":       portRangeSysctl(func(c *armcontainerservice.SysctlConfig, v string) { c.NetIPv4IPLocalPortRange = &v }),
+	"net.ipv4.neigh.default.gc_thresh1":  int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.NetIPv4NeighDefaultGcThresh1 = &v }),
+	"net.ipv4.neigh.default.gc_thresh2":  int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.NetIPv4NeighDefaultGcThresh2 = &v }),
+	"net.ipv4.neigh.default.gc_thresh3":  int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.NetIPv4NeighDefaultGcThresh3 = &v }),
+	"net.ipv4.tcp_fin_timeout":           int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.NetIPv4TCPFinTimeout = &v }),
+	"net.ipv4.tcp_keepalive_intvl":       int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.NetIPv4TcpkeepaliveIntvl = &v }),
+	"net.ipv4.tcp_keepalive_probes":      int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.NetIPv4TCPKeepaliveProbes = &v }),
+	"net.ipv4.tcp_keepalive_time":        int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.NetIPv4TCPKeepaliveTime = &v }),
+	"net.ipv4.tcp_max_syn_backlog":       int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.NetIPv4TCPMaxSynBacklog = &v }),
+	"net.ipv4.tcp_max_tw_buckets":        int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.NetIPv4TCPMaxTwBuckets = &v }),
+	"net.ipv4.tcp_tw_reuse":              boolSysctl(func(c *armcontainerservice.SysctlConfig, v bool) { c.NetIPv4TCPTwReuse = &v }),
+	"net.netfilter.nf_conntrack_buckets": int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.NetNetfilterNfConntrackBuckets = &v }),
+	"net.netfilter.nf_conntrack_max":     int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.NetNetfilterNfConntrackMax = &v }),
+	"vm.max_map_count":                   int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.VMMaxMapCount = &v }),
+	"vm.swappiness":                      int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.VMSwappiness = &v }),
+	"vm.vfs_cache_pressure":              int32Sysctl(func(c *armcontainerservice.SysctlConfig, v int32) { c.VMVfsCachePressure = &v }),
+}
+
+var (
+	portRangeRegex = regexp.MustCompile(`^\d+ \d+$`)
+	// unsafeSysctlRegex matches a sysctl name or a sysctl pattern ending in *, e.g. kernel.msg*.
+	unsafeSysctlRegex = regexp.MustCompile(`^[a-z0-9]([-_a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-_a-z0-9]*[a-z0-9])?)*(\.\*|\*)?$`)
+)
+
+func int32Sysctl(set func(c *armcontainerservice.SysctlConfig, v int32)) func(c *armcontainerservice.SysctlConfig, value string) error {
+	return func(c *armcontainerservice.SysctlConfig, value string) error {
+		parsed, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || parsed < 0 {
+			return fmt.Errorf("value %q should be a non-negative 32-bit integer", value)
+		}
+		set(c, int32(parsed))
+		return nil
+	}
+}
+
+func boolSysctl(set func(c *armcontainerservice.SysctlConfig, v bool)) func(c *armcontainerservice.SysctlConfig, value string) error {
+	return func(c *armcontainerservice.SysctlConfig, value string) error {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("value %q should be a boolean", value)
+		}
+		set(c, parsed)
+		return nil
+	}
+}
+
+func portRangeSysctl(set func(c *armcontainerservice.SysctlConfig, v string)) func(c *armcontainerservice.SysctlConfig, value string) error {
+	return func(c *armcontainerservice.SysctlConfig, value string) error {
+		if !portRangeRegex.MatchString(value) {
+			return fmt.Errorf("value %q should be two port numbers separated by a space, e.g. \"32768 60999\"", value)
+		}
+		set(c, value)
+		return nil
+	}
+}
+
+// kubeletConfig converts the kubelet configuration of nodeClass to the kubelet config of agent pool, nil is returned
+// when it's not specified.
+func kubeletConfig(nodeClass *v1alpha1.KaitoNodeClass) *armcontainerservice.KubeletConfig {
+	if nodeClass == nil || nodeClass.Spec.Kubelet == nil {
+		return nil
+	}
+	kubelet := nodeClass.Spec.Kubelet
+	config := &armcontainerservice.KubeletConfig{
+		CPUCfsQuota:           kubelet.CPUCFSQuota,
+		ImageGcHighThreshold:  kubelet.ImageGCHighThresholdPercent,
+		ImageGcLowThreshold:   kubelet.ImageGCLowThresholdPercent,
+		ContainerLogMaxSizeMB: kubelet.ContainerLogMaxSizeMB,
+		ContainerLogMaxFiles:  kubelet.ContainerLogMaxFiles,
+		PodMaxPids:            kubelet.PodPidsLimit,
+	}
+	if kubelet.CPUManagerPolicy != "" {
+		config.CPUManagerPolicy = lo.ToPtr(kubelet.CPUManagerPolicy)
+	}
+	if kubelet.CPUCFSQuotaPeriod != nil {
+		config.CPUCfsQuotaPeriod = lo.ToPtr(kubelet.CPUCFSQuotaPeriod.Duration.String())
+	}
+	if kubelet.TopologyManagerPolicy != "" {
+		config.TopologyManagerPolicy = lo.ToPtr(kubelet.TopologyManagerPolicy)
+	}
+	if len(kubelet.AllowedUnsafeSysctls) > 0 {
+		config.AllowedUnsafeSysctls = lo.ToSlicePtr(kubelet.AllowedUnsafeSysctls)
+	}
+	return config
+}
+
+// linuxOSConfig converts the Linux OS configuration of nodeClass to the Linux OS config of agent pool, nil is
+// returned when it's not specified.
+func linuxOSConfig(nodeClass *v1alpha1.KaitoNodeClass) (*armcontainerservice.LinuxOSConfig, error) {
+	if nodeClass == nil || nodeClass.Spec.LinuxOSConfig == nil {
+		return nil, nil
+	}
+	linux := nodeClass.Spec.LinuxOSConfig
+	config := &armcontainerservice.LinuxOSConfig{
+		SwapFileSizeMB: linux.SwapFileSizeMB,
+	}
+	if linux.TransparentHugePageEnabled != "" {
+		config.TransparentHugePageEnabled = lo.ToPtr(linux.TransparentHugePageEnabled)
+	}
+	if linux.TransparentHugePageDefrag != "" {
+		config.TransparentHugePageDefrag = lo.ToPtr(linux.TransparentHugePageDefrag)
+	}
+	if len(linux.Sysctls) > 0 {
+		config.Sysctls = &armcontainerservice.SysctlConfig{}
+		for _, name := range lo.Keys(linux.Sysctls) {
+			set, ok := sysctls[name]
+			if !ok {
+				return nil, fmt.Errorf("sysctl %q is not supported", name)
+			}
+			if err := set(config.Sysctls, linux.Sysctls[name]); err != nil {
+				return nil, fmt.Errorf("sysctl %q, %w", name, err)
+			}
+		}
+	}
+	return config, nil
+}
+
+// validateKubeletConfiguration checks the kubelet configuration of KaitoNodeClass.
+func validateKubeletConfiguration(kubelet *v1alpha1.KubeletConfiguration, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if kubelet == nil {
+		return errs
+	}
+
+	errs = append(errs, validateEnums(path, []enumField{
+		{"cpuManagerPolicy", kubelet.CPUManagerPolicy, []string{"none", "static"}},
+		{"topologyManagerPolicy", kubelet.TopologyManagerPolicy, []string{"none", "best-effort", "restricted", "single-numa-node"}},
+	})...)
+
+	if period := kubelet.CPUCFSQuotaPeriod; period != nil && period.Duration <= 0 {
+		errs = append(errs, field.Invalid(path.Child("cpuCFSQuotaPeriod"), period.Duration.String(), "should be positive"))
+	}
+	high, low := kubelet.ImageGCHighThresholdPercent, kubelet.ImageGCLowThresholdPercent
+	if high != nil && (*high < 0 || *high > 100) {
+		errs = append(errs, field.Invalid(path.Child("imageGCHighThresholdPercent"), *high, "should be between 0 and 100"))
+	}
+	if low != nil && (*low < 0 || *low > 100) {
+		errs = append(errs, field.Invalid(path.Child("imageGCLowThresholdPercent"), *low, "should be between 0 and 100"))
+	}
+	if high != nil && low != nil && *low >= *high {
+		errs = append(errs, field.Invalid(path.Child("imageGCLowThresholdPercent"), *low, "should be lower than imageGCHighThresholdPercent"))
+	}
+	for i, sysctl := range kubelet.AllowedUnsafeSysctls {
+		if !unsafeSysctlRegex.MatchString(sysctl) {
+			errs = append(errs, field.Invalid(path.Child("allowedUnsafeSysctls").Index(i), sysctl, "should be a sysctl name or a sysctl pattern ending in *"))
+		}
+	}
+	if size := kubelet.ContainerLogMaxSizeMB; size != nil && *size < 1 {
+		errs = append(errs, field.Invalid(path.Child("containerLogMaxSizeMB"), *size, "should be at least 1"))
+	}
+	if files := kubelet.ContainerLogMaxFiles; files != nil && *files < 2 {
+		errs = append(errs, field.Invalid(path.Child("containerLogMaxFiles"), *files, "should be at least 2"))
+	}
+	if pids := kubelet.PodPidsLimit; pids != nil && *pids < -1 {
+		errs = append(errs, field.Invalid(path.Child("podPidsLimit"), *pids, "should be -1 or a non-negative number"))
+	}
+	return errs
+}
+
+// validateLinuxOSConfiguration checks the Linux OS configuration of KaitoNodeClass.
+func validateLinuxOSConfiguration(linux *v1alpha1.LinuxOSConfiguration, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if linux == nil {
+		return errs
+	}
+
+	errs = append(errs, validateEnums(path, []enumField{
+		{"transparentHugePageEnabled", linux.TransparentHugePageEnabled, []string{"always", "madvise", "never"}},
+		{"transparentHugePageDefrag", linux.TransparentHugePageDefrag, []string{"always", "defer", "defer+madvise", "madvise", "never"}},
+	})...)
+
+	if size := linux.SwapFileSizeMB; size != nil && *size < 1 {
+		errs = append(errs, field.Invalid(path.Child("swapFileSizeMB"), *size, "should be at least 1"))
+	}
+	names := lo.Keys(linux.Sysctls)
+	sort.Strings(names)
+	for _, name := range names {
+		set, ok := sysctls[name]
+		if !ok {
+			errs = append(errs, field.NotSupported(path.Child("sysctls").Key(name), name, supportedSysctls()))
+			continue
+		}
+		if err := set(&armcontainerservice.SysctlConfig{}, linux.Sysctls[name]); err != nil {
+			errs = append(errs, field.Invalid(path.Child("sysctls").Key(name), linux.Sysctls[name], err.Error()))
+		}
+	}
+	return errs
+}
+
+func supportedSysctls() []string {
+	names := lo.Keys(sysctls)
+	sort.Strings(names)
+	return names
+}
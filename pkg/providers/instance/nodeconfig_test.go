@@ -0,0 +1,188 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v4"
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestKubeletConfig(t *testing.T) {
+	assert.Nil(t, kubeletConfig(nil))
+	assert.Nil(t, kubeletConfig(&v1alpha1.KaitoNodeClass{}))
+
+	config := kubeletConfig(&v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{Kubelet: &v1alpha1.KubeletConfiguration{
+		CPUManagerPolicy:            "static",
+		CPUCFSQuota:                 lo.ToPtr(false),
+		CPUCFSQuotaPeriod:           &metav1.Duration{Duration: 200 * time.Millisecond},
+		ImageGCHighThresholdPercent: lo.ToPtr(int32(90)),
+		ImageGCLowThresholdPercent:  lo.ToPtr(int32(70)),
+		TopologyManagerPolicy:       "single-numa-node",
+		AllowedUnsafeSysctls:        []string{"kernel.msg*", "net.ipv4.route.min_pmtu"},
+		ContainerLogMaxSizeMB:       lo.ToPtr(int32(50)),
+		ContainerLogMaxFiles:        lo.ToPtr(int32(5)),
+		PodPidsLimit:                lo.ToPtr(int32(4096)),
+	}}})
+	assert.Equal(t, &armcontainerservice.KubeletConfig{
+		CPUManagerPolicy:      lo.ToPtr("static"),
+		CPUCfsQuota:           lo.ToPtr(false),
+		CPUCfsQuotaPeriod:     lo.ToPtr("200ms"),
+		ImageGcHighThreshold:  lo.ToPtr(int32(90)),
+		ImageGcLowThreshold:   lo.ToPtr(int32(70)),
+		TopologyManagerPolicy: lo.ToPtr("single-numa-node"),
+		AllowedUnsafeSysctls:  []*string{lo.ToPtr("kernel.msg*"), lo.ToPtr("net.ipv4.route.min_pmtu")},
+		ContainerLogMaxSizeMB: lo.ToPtr(int32(50)),
+		ContainerLogMaxFiles:  lo.ToPtr(int32(5)),
+		PodMaxPids:            lo.ToPtr(int32(4096)),
+	}, config)
+}
+
+func TestLinuxOSConfig(t *testing.T) {
+	testCases := []struct {
+		name          string
+		linux         *v1alpha1.LinuxOSConfiguration
+		expected      *armcontainerservice.LinuxOSConfig
+		expectedError string
+	}{
+		{
+			name: "nil when not specified",
+		},
+		{
+			name: "transparent hugepages and sysctls are converted",
+			linux: &v1alpha1.LinuxOSConfiguration{
+				TransparentHugePageEnabled: "madvise",
+				TransparentHugePageDefrag:  "defer+madvise",
+				SwapFileSizeMB:             lo.ToPtr(int32(1024)),
+				Sysctls: map[string]string{
+					"vm.max_map_count":             "262144",
+					"net.ipv4.tcp_tw_reuse":        "true",
+					"net.ipv4.ip_local_port_range": "32768 60999",
+				},
+			},
+			expected: &armcontainerservice.LinuxOSConfig{
+				TransparentHugePageEnabled: lo.ToPtr("madvise"),
+				TransparentHugePageDefrag:  lo.ToPtr("defer+madvise"),
+				SwapFileSizeMB:             lo.ToPtr(int32(1024)),
+				Sysctls: &armcontainerservice.SysctlConfig{
+					VMMaxMapCount:           lo.ToPtr(int32(262144)),
+					NetIPv4TCPTwReuse:       lo.ToPtr(true),
+					NetIPv4IPLocalPortRange: lo.ToPtr("32768 60999"),
+				},
+			},
+		},
+		{
+			name:          "fail with unsupported sysctl",
+			linux:         &v1alpha1.LinuxOSConfiguration{Sysctls: map[string]string{"vm.nr_hugepages": "1024"}},
+			expectedError: `sysctl "vm.nr_hugepages" is not supported`,
+		},
+		{
+			name:          "fail with invalid sysctl value",
+			linux:         &v1alpha1.LinuxOSConfiguration{Sysctls: map[string]string{"vm.swappiness": "high"}},
+			expectedError: `sysctl "vm.swappiness", value "high" should be a non-negative 32-bit integer`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config, err := linuxOSConfig(&v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{LinuxOSConfig: tc.linux}})
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, config)
+		})
+	}
+}
+
+func TestValidateNodeConfiguration(t *testing.T) {
+	testCases := []struct {
+		name           string
+		kubelet        *v1alpha1.KubeletConfiguration
+		linux          *v1alpha1.LinuxOSConfiguration
+		expectedFields []string
+	}{
+		{
+			name: "valid configuration",
+			kubelet: &v1alpha1.KubeletConfiguration{
+				TopologyManagerPolicy:       "best-effort",
+				ImageGCHighThresholdPercent: lo.ToPtr(int32(85)),
+				ImageGCLowThresholdPercent:  lo.ToPtr(int32(80)),
+				AllowedUnsafeSysctls:        []string{"kernel.shm*", "net.*"},
+				PodPidsLimit:                lo.ToPtr(int32(-1)),
+			},
+			linux: &v1alpha1.LinuxOSConfiguration{
+				TransparentHugePageEnabled: "always",
+				Sysctls:                    map[string]string{"fs.file-max": "1048576"},
+			},
+		},
+		{
+			name: "invalid kubelet configuration",
+			kubelet: &v1alpha1.KubeletConfiguration{
+				CPUManagerPolicy:            "dynamic",
+				TopologyManagerPolicy:       "numa",
+				CPUCFSQuotaPeriod:           &metav1.Duration{},
+				ImageGCHighThresholdPercent: lo.ToPtr(int32(70)),
+				ImageGCLowThresholdPercent:  lo.ToPtr(int32(80)),
+				AllowedUnsafeSysctls:        []string{"kernel.msg*", "Kernel Sem"},
+				ContainerLogMaxSizeMB:       lo.ToPtr(int32(0)),
+				ContainerLogMaxFiles:        lo.ToPtr(int32(1)),
+			},
+			expectedFields: []string{
+				"spec.kubelet.cpuManagerPolicy",
+				"spec.kubelet.topologyManagerPolicy",
+				"spec.kubelet.cpuCFSQuotaPeriod",
+				"spec.kubelet.imageGCLowThresholdPercent",
+				"spec.kubelet.allowedUnsafeSysctls[1]",
+				"spec.kubelet.containerLogMaxSizeMB",
+				"spec.kubelet.containerLogMaxFiles",
+			},
+		},
+		{
+			name: "invalid linux os configuration",
+			linux: &v1alpha1.LinuxOSConfiguration{
+				TransparentHugePageEnabled: "sometimes",
+				TransparentHugePageDefrag:  "defer+never",
+				SwapFileSizeMB:             lo.ToPtr(int32(0)),
+				Sysctls: map[string]string{
+					"vm.nr_hugepages":              "1024",
+					"net.ipv4.ip_local_port_range": "32768-60999",
+				},
+			},
+			expectedFields: []string{
+				"spec.linuxOSConfig.transparentHugePageEnabled",
+				"spec.linuxOSConfig.transparentHugePageDefrag",
+				"spec.linuxOSConfig.swapFileSizeMB",
+				"spec.linuxOSConfig.sysctls[vm.nr_hugepages]",
+				"spec.linuxOSConfig.sysctls[net.ipv4.ip_local_port_range]",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			errs := ValidateNodeClass(&v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{Kubelet: tc.kubelet, LinuxOSConfig: tc.linux}})
+			assert.ElementsMatch(t, tc.expectedFields, lo.Map(errs, func(err *field.Error, _ int) string { return err.Field }), errs.ToAggregate())
+		})
+	}
+}
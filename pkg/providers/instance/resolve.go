@@ -0,0 +1,91 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instance
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/providers/instancetype"
+	"github.com/samber/lo"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+	"sigs.k8s.io/karpenter/pkg/cloudprovider"
+	"sigs.k8s.io/karpenter/pkg/scheduling"
+)
+
+// AnnotationResolvedInstanceTypes records the vm sizes which the nodeclaim without instance type requirement is
+// resolved to, with their hourly prices, e.g. "Standard_NC48ads_A100_v4=7.346,Standard_ND96asr_v4=27.197". The vm sizes
+// are ordered from the cheapest, and the later ones are tried when the earlier ones are unavailable.
+const AnnotationResolvedInstanceTypes = "kaito.sh/resolved-instance-types"
+
+// resolveInstanceTypes resolves the GPU requirements and resource requests of nodeClaim to the matching vm sizes ordered
+// by price. The result is saved in nodeClaim annotations, so the following Create calls for nodeClaim try the same vm
+// sizes even though the SKUs are refreshed in between.
+func (p *Provider) resolveInstanceTypes(ctx context.Context, nodeClaim *karpenterv1.NodeClaim, nodeClass *v1alpha1.KaitoNodeClass) ([]string, error) {
+	if resolved := parseResolvedInstanceTypes(nodeClaim.Annotations[AnnotationResolvedInstanceTypes]); len(resolved) > 0 {
+		return resolved, nil
+	}
+	requirements := scheduling.NewNodeSelectorRequirementsWithMinValues(nodeClaim.Spec.Requirements...)
+	if !instancetype.HasGPURequest(requirements, nodeClaim.Spec.Resources.Requests) {
+		return nil, fmt.Errorf("nodeClaim spec has no requirement for instance type or gpus")
+	}
+
+	// the GPU resources of the SKUs are counted by the MIG instances when the GPUs are partitioned.
+	profile, err := gpuInstanceProfile(nodeClaim, nodeClass)
+	if err != nil {
+		return nil, err
+	}
+	// the SKUs which don't support the gpu settings of nodeClaim, e.g. the MIG instance profile, are skipped.
+	skus := lo.Filter(p.instanceTypeProvider.Resolve(ctx, requirements, nodeClaim.Spec.Resources.Requests, string(lo.FromPtr(profile))), func(sku instancetype.SKU, _ int) bool {
+		_, _, err := gpuSettings(nodeClaim, nodeClass, sku.Name)
+		return err == nil
+	})
+	if len(skus) == 0 {
+		return nil, cloudprovider.NewInsufficientCapacityError(fmt.Errorf("no gpu sku matches requirements %s and resource requests of nodeclaim(%s)",
+			requirements, nodeClaim.Name))
+	}
+
+	resolved := formatResolvedInstanceTypes(skus)
+	logging.FromContext(ctx).Infof("resolved nodeclaim(%s) with requirements %s to instance types %s, ordered by price", nodeClaim.Name, requirements, resolved)
+	stored := nodeClaim.DeepCopy()
+	nodeClaim.Annotations = lo.Assign(nodeClaim.Annotations, map[string]string{AnnotationResolvedInstanceTypes: resolved})
+	if err := p.kubeClient.Patch(ctx, nodeClaim, client.MergeFrom(stored)); err != nil {
+		return nil, fmt.Errorf("recording resolved instance types of nodeclaim(%s), %w", nodeClaim.Name, err)
+	}
+	return lo.Map(skus, func(sku instancetype.SKU, _ int) string { return sku.Name }), nil
+}
+
+func formatResolvedInstanceTypes(skus []instancetype.SKU) string {
+	return strings.Join(lo.Map(skus, func(sku instancetype.SKU, _ int) string {
+		return fmt.Sprintf("%s=%s", sku.Name, strconv.FormatFloat(sku.Price, 'f', -1, 64))
+	}), ",")
+}
+
+// parseResolvedInstanceTypes returns the vm sizes of AnnotationResolvedInstanceTypes in order.
+func parseResolvedInstanceTypes(resolved string) []string {
+	if resolved == "" {
+		return nil
+	}
+	return lo.Compact(lo.Map(strings.Split(resolved, ","), func(s string, _ int) string {
+		vmSize, _, _ := strings.Cut(s, "=")
+		return strings.TrimSpace(vmSize)
+	}))
+}
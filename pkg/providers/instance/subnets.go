@@ -0,0 +1,67 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instance
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+const subnetsAPIVersion = "2023-09-01"
+
+// SubnetsAPI checks the subnets which the agent pools are created in.
+type SubnetsAPI interface {
+	// Get returns nil when the subnet exists, the ARM response error is returned otherwise.
+	Get(ctx context.Context, subnetID *arm.ResourceID) error
+}
+
+// subnetsClient gets the subnets from Azure Resource Manager, armnetwork is not vendored and only the existence of
+// the subnet is used, so the response body is not decoded.
+type subnetsClient struct {
+	internal *arm.Client
+}
+
+// NewSubnetsClient creates the client of subnets API with the credential and options of the other ARM clients.
+func NewSubnetsClient(credential azcore.TokenCredential, options *arm.ClientOptions) (SubnetsAPI, error) {
+	cl, err := arm.NewClient("github.com/azure/gpu-provisioner/pkg/providers/instance", "v0.1.0", credential, options)
+	if err != nil {
+		return nil, err
+	}
+	return &subnetsClient{internal: cl}, nil
+}
+
+func (c *subnetsClient) Get(ctx context.Context, subnetID *arm.ResourceID) error {
+	req, err := runtime.NewRequest(ctx, http.MethodGet, runtime.JoinPaths(c.internal.Endpoint(), subnetID.String()))
+	if err != nil {
+		return err
+	}
+	qp := req.Raw().URL.Query()
+	qp.Set("api-version", subnetsAPIVersion)
+	req.Raw().URL.RawQuery = qp.Encode()
+	req.Raw().Header["Accept"] = []string{"application/json"}
+	resp, err := c.internal.Pipeline().Do(req)
+	if err != nil {
+		return err
+	}
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return runtime.NewResponseError(resp)
+	}
+	return nil
+}
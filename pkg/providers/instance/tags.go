@@ -0,0 +1,66 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instance
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/utils"
+	"github.com/samber/lo"
+	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+// NodePoolTag records the nodepool of nodeclaim on its agent pool, it's the tag of karpenter.sh/nodepool label.
+var NodePoolTag = TagKeyOfLabel(karpenterv1.NodePoolLabelKey)
+
+// TagOptions are the Azure tags added to agent pools for cost allocation, the tags flow to the underlying VMSS or VMs.
+type TagOptions struct {
+	// Tags are added to the agent pools of all nodeclaims.
+	Tags map[string]string
+	// NodeClaimLabels are the labels of nodeclaim which are added as tags, e.g. kaito.sh/workspace.
+	NodeClaimLabels []string
+}
+
+// TagKeyOfLabel converts a label key to a tag key, "/" is not allowed in tag keys so it's replaced by "_".
+func TagKeyOfLabel(label string) string {
+	return strings.ReplaceAll(label, "/", "_")
+}
+
+// agentPoolTags merges the tags of agent pool created for nodeClaim, the global tags are overridden by the tags of
+// nodeClass, which are overridden by the tags from nodeClaim labels. ReservedTags are only set by gpu-provisioner.
+func agentPoolTags(opts TagOptions, nodeClaim *karpenterv1.NodeClaim, nodeClass *v1alpha1.KaitoNodeClass, driver string) (map[string]*string, error) {
+	tags := lo.Assign(opts.Tags)
+	if nodeClass != nil {
+		tags = lo.Assign(tags, nodeClass.Spec.Tags)
+	}
+	for _, label := range opts.NodeClaimLabels {
+		if value, ok := nodeClaim.Labels[label]; ok {
+			tags[TagKeyOfLabel(label)] = value
+		}
+	}
+	tags = lo.OmitByKeys(tags, ReservedTags)
+
+	tags[NodeClaimNameTag] = nodeClaim.Name
+	if driver == v1alpha1.GPUDriverNone {
+		tags[SkipGPUDriverInstallTag] = "true"
+	}
+	if err := utils.ValidateTags(tags); err != nil {
+		return nil, fmt.Errorf("tags of nodeclaim(%s) are invalid, %w", nodeClaim.Name, err)
+	}
+	return lo.MapValues(tags, func(v string, _ string) *string { return lo.ToPtr(v) }), nil
+}
@@ -0,0 +1,102 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instance
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+func TestAgentPoolTags(t *testing.T) {
+	nodeClaim := &karpenterv1.NodeClaim{ObjectMeta: metav1.ObjectMeta{
+		Name: "nodeclaim-test",
+		Labels: map[string]string{
+			karpenterv1.NodePoolLabelKey:  "kaito",
+			"kaito.sh/workspace":          "falcon-7b",
+			"kaito.sh/workspacenamespace": "team-a",
+			"app":                         "inference",
+		},
+	}}
+	opts := TagOptions{
+		Tags:            map[string]string{"costcenter": "1234", "team": "platform"},
+		NodeClaimLabels: []string{karpenterv1.NodePoolLabelKey, "kaito.sh/workspace", "kaito.sh/workspacenamespace", "kaito.sh/ragengine"},
+	}
+
+	testCases := []struct {
+		name          string
+		opts          TagOptions
+		nodeClass     *v1alpha1.KaitoNodeClass
+		driver        string
+		expected      map[string]string
+		expectedError string
+	}{
+		{
+			name:     "only nodeclaim name tag without options",
+			expected: map[string]string{NodeClaimNameTag: "nodeclaim-test"},
+		},
+		{
+			name:      "global, nodeclass and nodeclaim label tags are merged",
+			opts:      opts,
+			nodeClass: &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{Tags: map[string]string{"team": "ml"}}},
+			driver:    v1alpha1.GPUDriverNone,
+			expected: map[string]string{
+				"costcenter":                  "1234",
+				"team":                        "ml",
+				"karpenter.sh_nodepool":       "kaito",
+				"kaito.sh_workspace":          "falcon-7b",
+				"kaito.sh_workspacenamespace": "team-a",
+				NodeClaimNameTag:              "nodeclaim-test",
+				SkipGPUDriverInstallTag:       "true",
+			},
+		},
+		{
+			name: "reserved tags can not be set by global tags",
+			opts: TagOptions{Tags: map[string]string{NodeClaimNameTag: "other", SkipGPUDriverInstallTag: "true", WarmPoolTag: "Standard_NC6s_v3"}},
+			expected: map[string]string{
+				NodeClaimNameTag: "nodeclaim-test",
+			},
+		},
+		{
+			name:          "fail when there are too many tags",
+			opts:          TagOptions{Tags: lo.SliceToMap(lo.Range(50), func(i int) (string, string) { return fmt.Sprintf("tag-%d", i), "" })},
+			expectedError: "at most 50 tags are allowed",
+		},
+		{
+			name:          "fail when the value of a tag is too long",
+			nodeClass:     &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{Tags: map[string]string{"team": strings.Repeat("a", 257)}}},
+			expectedError: `value of tag "team" is longer than 256 characters`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tags, err := agentPoolTags(tc.opts, nodeClaim, tc.nodeClass, tc.driver)
+			if tc.expectedError != "" {
+				assert.ErrorContains(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, lo.MapValues(tags, func(v *string, _ string) string { return lo.FromPtr(v) }))
+		})
+	}
+}
@@ -15,15 +15,43 @@ limitations under the License.
 
 package instance
 
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+)
+
 // Instance a struct to isolate weather vm or vmss
 type Instance struct {
-	Name         *string // agentPoolName or instance/vmName
-	State        *string
-	ID           *string
-	ImageID      *string
-	Type         *string
-	CapacityType *string
-	SubnetID     *string
-	Tags         map[string]*string
-	Labels       map[string]string
+	Name          *string // agentPoolName or instance/vmName
+	NodeClaimName *string // name of the nodeclaim which the instance is created for
+	State         *string
+	ID            *string
+	ImageID       *string
+	Type          *string
+	CapacityType  *string
+	SubnetID      *string
+	PodSubnetID   *string
+	Tags          map[string]*string
+	Labels        map[string]string
+	// NodeClassHash is the hash of the KaitoNodeClass which the instance is launched with, it's only set by Create.
+	NodeClassHash *string
+	// Capacity and Allocatable are the resources of the node, they're nil when the vm size is not a known GPU SKU.
+	Capacity    v1.ResourceList
+	Allocatable v1.ResourceList
+}
+
+// offering is the combination of vm size, capacity type and zone used for creating agent pool,
+// zone is empty when agent pool is not pinned to an availability zone.
+type offering struct {
+	vmSize       string
+	capacityType string
+	zone         string
+}
+
+func (o offering) String() string {
+	if o.zone == "" {
+		return fmt.Sprintf("%s/%s", o.vmSize, o.capacityType)
+	}
+	return fmt.Sprintf("%s/%s/%s", o.vmSize, o.capacityType, o.zone)
 }
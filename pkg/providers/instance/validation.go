@@ -0,0 +1,165 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instance
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v4"
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/providers/instancetype"
+	"github.com/azure/gpu-provisioner/pkg/utils"
+	"github.com/samber/lo"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+	"sigs.k8s.io/karpenter/pkg/scheduling"
+)
+
+var (
+	// ReservedTags are set by gpu-provisioner on the agent pools, so they can not be specified by KaitoNodeClass.
+	ReservedTags = []string{NodeClaimNameTag, WarmPoolTag, SkipGPUDriverInstallTag}
+
+	imageFamilies = []string{"Ubuntu", "AzureLinux"}
+)
+
+// ValidateNodeClaim checks the settings of nodeClaim which are consumed by Create, so an invalid nodeClaim is rejected
+// before any agent pool is created. nodeClass is the KaitoNodeClass referenced by nodeClaim, it's nil when nodeClaim
+// doesn't reference one.
+func ValidateNodeClaim(nodeClaim *karpenterv1.NodeClaim, nodeClass *v1alpha1.KaitoNodeClass) field.ErrorList {
+	errs := ValidateNodeClaimAnnotations(nodeClaim)
+
+	specPath := field.NewPath("spec")
+	// nodeClaim without instance type requirement is resolved to the SKUs matching its GPU requirements and requests.
+	if len(orderedInstanceTypes(nodeClaim)) == 0 && !instancetype.HasGPURequest(
+		scheduling.NewNodeSelectorRequirementsWithMinValues(nodeClaim.Spec.Requirements...), nodeClaim.Spec.Resources.Requests) {
+		errs = append(errs, field.Required(specPath.Child("requirements"),
+			fmt.Sprintf("a requirement for %s with operator In and at least one vm size, or a requirement for gpus(%s) or a gpu resource request is required",
+				"node.kubernetes.io/instance-type", strings.Join([]string{instancetype.LabelGPUCount, instancetype.LabelGPUMemoryGiB, instancetype.LabelGPUProduct}, ", "))))
+	}
+	if _, err := orderedZones(nodeClaim); err != nil {
+		errs = append(errs, field.Invalid(specPath.Child("requirements"), nodeClaim.Spec.Requirements, err.Error()))
+	}
+	// invalid gpu settings are reported with the annotations or by KaitoNodeClass validation, only their compatibility
+	// with the vm sizes is checked here.
+	_, profileErr := gpuInstanceProfile(nodeClaim, nodeClass)
+	_, driverErr := gpuDriver(nodeClaim, nodeClass)
+	if profileErr == nil && driverErr == nil {
+		for _, instanceType := range orderedInstanceTypes(nodeClaim) {
+			if _, _, err := gpuSettings(nodeClaim, nodeClass, instanceType); err != nil {
+				errs = append(errs, field.Invalid(specPath.Child("requirements"), instanceType, err.Error()))
+			}
+		}
+	}
+
+	storage := nodeClaim.Spec.Resources.Requests.Storage()
+	if storage.Value() <= 0 && (nodeClass == nil || nodeClass.Spec.OSDiskSizeGB == nil) {
+		errs = append(errs, field.Invalid(specPath.Child("resources", "requests", "storage"), storage.String(),
+			"storage request should be more than 0 when the KaitoNodeClass doesn't specify osDiskSizeGB"))
+	} else if storage.Value() > 0 && storage.Value()>>30 == 0 {
+		errs = append(errs, field.Invalid(specPath.Child("resources", "requests", "storage"), storage.String(),
+			"storage request should be at least 1Gi"))
+	}
+	return errs
+}
+
+// ValidateNodeClaimAnnotations checks the annotations of nodeClaim which configure the agent pool.
+func ValidateNodeClaimAnnotations(nodeClaim *karpenterv1.NodeClaim) field.ErrorList {
+	var errs field.ErrorList
+	annotationsPath := field.NewPath("metadata", "annotations")
+
+	if imageFamily, ok := nodeClaim.Annotations[LabelNodeImageFamily]; ok {
+		if !lo.ContainsBy(imageFamilies, func(f string) bool { return strings.EqualFold(f, imageFamily) }) {
+			errs = append(errs, field.NotSupported(annotationsPath.Key(LabelNodeImageFamily), imageFamily, imageFamilies))
+		}
+	}
+	if apName, ok := nodeClaim.Annotations[AnnotationAgentPoolName]; ok && !AgentPoolNameRegex.MatchString(apName) {
+		errs = append(errs, field.Invalid(annotationsPath.Key(AnnotationAgentPoolName), apName,
+			fmt.Sprintf("agent pool name should match %s", AgentPoolNameRegex)))
+	}
+	if profile, ok := nodeClaim.Annotations[LabelGPUInstanceProfile]; ok {
+		if _, err := gpuInstanceProfile(nodeClaim, nil); err != nil {
+			errs = append(errs, field.NotSupported(annotationsPath.Key(LabelGPUInstanceProfile), profile, armcontainerservice.PossibleGPUInstanceProfileValues()))
+		}
+	}
+	if driver, ok := nodeClaim.Annotations[AnnotationGPUDriver]; ok {
+		if _, err := gpuDriver(nodeClaim, nil); err != nil {
+			errs = append(errs, field.NotSupported(annotationsPath.Key(AnnotationGPUDriver), driver, gpuDrivers))
+		}
+	}
+	if _, _, err := spotSettings(nodeClaim); err != nil {
+		errs = append(errs, field.Invalid(annotationsPath, lo.PickByKeys(nodeClaim.Annotations, []string{AnnotationSpotEvictionPolicy, AnnotationSpotMaxPrice}), err.Error()))
+	}
+	return errs
+}
+
+// ValidateNodeClass checks the spec of KaitoNodeClass which doesn't depend on the cluster.
+func ValidateNodeClass(nodeClass *v1alpha1.KaitoNodeClass) field.ErrorList {
+	var errs field.ErrorList
+	specPath := field.NewPath("spec")
+	spec := nodeClass.Spec
+
+	errs = append(errs, validateEnums(specPath, []enumField{
+		{"agentPoolType", spec.AgentPoolType, []string{v1alpha1.AgentPoolTypeVirtualMachineScaleSets, v1alpha1.AgentPoolTypeVirtualMachines}},
+		{"osSKU", spec.OSSKU, imageFamilies},
+		{"osDiskType", spec.OSDiskType, []string{"Managed", "Ephemeral"}},
+		{"gpuDriver", spec.GPUDriver, gpuDrivers},
+		{"gpuInstanceProfile", spec.GPUInstanceProfile, lo.Map(armcontainerservice.PossibleGPUInstanceProfileValues(), func(p armcontainerservice.GPUInstanceProfile, _ int) string { return string(p) })},
+	})...)
+
+	if size := spec.OSDiskSizeGB; size != nil && (*size < 30 || *size > 2048) {
+		errs = append(errs, field.Invalid(specPath.Child("osDiskSizeGB"), *size, "should be between 30 and 2048"))
+	}
+	if maxPods := spec.MaxPods; maxPods != nil && (*maxPods < 10 || *maxPods > 250) {
+		errs = append(errs, field.Invalid(specPath.Child("maxPods"), *maxPods, "should be between 10 and 250"))
+	}
+	errs = append(errs, validateNetwork(spec, specPath)...)
+	if err := ValidateNodeClassTags(spec.Tags); err != nil {
+		errs = append(errs, field.Invalid(specPath.Child("tags"), spec.Tags, err.Error()))
+	}
+	errs = append(errs, validateKubeletConfiguration(spec.Kubelet, specPath.Child("kubelet"))...)
+	errs = append(errs, validateLinuxOSConfiguration(spec.LinuxOSConfig, specPath.Child("linuxOSConfig"))...)
+	return errs
+}
+
+// enumField is a string field whose value, when it's specified, should be one of the allowed values.
+type enumField struct {
+	name    string
+	value   string
+	allowed []string
+}
+
+func validateEnums(path *field.Path, enums []enumField) field.ErrorList {
+	var errs field.ErrorList
+	for _, e := range enums {
+		if e.value != "" && !lo.Contains(e.allowed, e.value) {
+			errs = append(errs, field.NotSupported(path.Child(e.name), e.value, e.allowed))
+		}
+	}
+	return errs
+}
+
+// ValidateNodeClassTags checks the tags of KaitoNodeClass against the limits of ARM and the tags reserved by
+// gpu-provisioner.
+func ValidateNodeClassTags(tags map[string]string) error {
+	if reserved, found := lo.Find(ReservedTags, func(tag string) bool {
+		_, ok := tags[tag]
+		return ok
+	}); found {
+		return fmt.Errorf("tag %q is reserved by gpu-provisioner", reserved)
+	}
+	return utils.ValidateTags(tags)
+}
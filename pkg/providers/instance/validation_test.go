@@ -0,0 +1,261 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instance
+
+import (
+	"testing"
+
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/fake"
+	"github.com/azure/gpu-provisioner/pkg/providers/instancetype"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+func TestValidateNodeClaim(t *testing.T) {
+	instanceTypeRequirement := v1.NodeSelectorRequirement{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"Standard_NC6s_v3"}}
+	testCases := []struct {
+		name           string
+		requirements   []v1.NodeSelectorRequirement
+		storage        string
+		annotations    map[string]string
+		nodeClass      *v1alpha1.KaitoNodeClass
+		expectedFields []string
+	}{
+		{
+			name:         "valid nodeclaim",
+			requirements: []v1.NodeSelectorRequirement{instanceTypeRequirement},
+			storage:      "30Gi",
+			annotations:  map[string]string{LabelNodeImageFamily: "azurelinux", AnnotationSpotMaxPrice: "0.5"},
+		},
+		{
+			name:           "nodeclaim without instance type requirement",
+			storage:        "30Gi",
+			expectedFields: []string{"spec.requirements"},
+		},
+		{
+			name: "nodeclaim with gpu requirements without instance type requirement",
+			requirements: []v1.NodeSelectorRequirement{
+				{Key: instancetype.LabelGPUCount, Operator: v1.NodeSelectorOpIn, Values: []string{"2"}},
+				{Key: instancetype.LabelGPUMemoryGiB, Operator: v1.NodeSelectorOpGt, Values: []string{"79"}},
+			},
+			storage: "30Gi",
+		},
+		{
+			name:           "nodeclaim with non-gpu requirement only",
+			requirements:   []v1.NodeSelectorRequirement{{Key: v1.LabelOSStable, Operator: v1.NodeSelectorOpIn, Values: []string{"linux"}}},
+			storage:        "30Gi",
+			expectedFields: []string{"spec.requirements"},
+		},
+		{
+			name:           "nodeclaim with zone requirement without values",
+			requirements:   []v1.NodeSelectorRequirement{instanceTypeRequirement, {Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpNotIn, Values: []string{"eastus-1"}}},
+			storage:        "30Gi",
+			expectedFields: []string{"spec.requirements"},
+		},
+		{
+			name:           "nodeclaim without storage request",
+			requirements:   []v1.NodeSelectorRequirement{instanceTypeRequirement},
+			expectedFields: []string{"spec.resources.requests.storage"},
+		},
+		{
+			name:           "nodeclaim with storage request less than 1Gi",
+			requirements:   []v1.NodeSelectorRequirement{instanceTypeRequirement},
+			storage:        "512Mi",
+			expectedFields: []string{"spec.resources.requests.storage"},
+		},
+		{
+			name:         "nodeclaim without storage request references nodeclass with os disk size",
+			requirements: []v1.NodeSelectorRequirement{instanceTypeRequirement},
+			nodeClass:    &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{OSDiskSizeGB: lo.ToPtr(int32(128))}},
+		},
+		{
+			name:           "nodeclaim with unknown image family",
+			requirements:   []v1.NodeSelectorRequirement{instanceTypeRequirement},
+			storage:        "30Gi",
+			annotations:    map[string]string{LabelNodeImageFamily: "Windows"},
+			expectedFields: []string{"metadata.annotations[kaito.sh/node-image-family]"},
+		},
+		{
+			name:           "nodeclaim with invalid agent pool name",
+			requirements:   []v1.NodeSelectorRequirement{instanceTypeRequirement},
+			storage:        "30Gi",
+			annotations:    map[string]string{AnnotationAgentPoolName: "Invalid-Name"},
+			expectedFields: []string{"metadata.annotations[kaito.sh/agentpool-name]"},
+		},
+		{
+			name:           "nodeclaim with invalid spot settings",
+			requirements:   []v1.NodeSelectorRequirement{instanceTypeRequirement},
+			storage:        "30Gi",
+			annotations:    map[string]string{AnnotationSpotEvictionPolicy: "Stop"},
+			expectedFields: []string{"metadata.annotations"},
+		},
+		{
+			name:         "nodeclaim with gpu instance profile for a100 vm size",
+			requirements: []v1.NodeSelectorRequirement{{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"Standard_NC24ads_A100_v4"}}},
+			storage:      "30Gi",
+			annotations:  map[string]string{LabelGPUInstanceProfile: "mig3g", AnnotationGPUDriver: "none"},
+		},
+		{
+			name:           "nodeclaim with unknown gpu settings",
+			requirements:   []v1.NodeSelectorRequirement{instanceTypeRequirement},
+			storage:        "30Gi",
+			annotations:    map[string]string{LabelGPUInstanceProfile: "MIG5g", AnnotationGPUDriver: "Skip"},
+			expectedFields: []string{"metadata.annotations[kaito.sh/gpu-instance-profile]", "metadata.annotations[kaito.sh/gpu-driver]"},
+		},
+		{
+			name:           "nodeclaim with gpu instance profile for vm size without mig support",
+			requirements:   []v1.NodeSelectorRequirement{instanceTypeRequirement},
+			storage:        "30Gi",
+			annotations:    map[string]string{LabelGPUInstanceProfile: "MIG1g"},
+			expectedFields: []string{"spec.requirements"},
+		},
+		{
+			name:           "nodeclass with gpu driver none for vm size without gpu",
+			requirements:   []v1.NodeSelectorRequirement{{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"Standard_D4s_v3"}}},
+			storage:        "30Gi",
+			nodeClass:      &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{GPUDriver: v1alpha1.GPUDriverNone}},
+			expectedFields: []string{"spec.requirements"},
+		},
+		{
+			name:           "all errors are reported",
+			annotations:    map[string]string{LabelNodeImageFamily: "Windows"},
+			expectedFields: []string{"metadata.annotations[kaito.sh/node-image-family]", "spec.requirements", "spec.resources.requests.storage"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resources := karpenterv1.ResourceRequirements{}
+			if tc.storage != "" {
+				resources.Requests = v1.ResourceList{v1.ResourceStorage: resource.MustParse(tc.storage)}
+			}
+			nodeClaim := fake.GetNodeClaimObj("kaito-workspace-0", map[string]string{}, []v1.Taint{}, resources, tc.requirements)
+			nodeClaim.Annotations = tc.annotations
+
+			errs := ValidateNodeClaim(nodeClaim, tc.nodeClass)
+			assert.ElementsMatch(t, tc.expectedFields, lo.Map(errs, func(err *field.Error, _ int) string { return err.Field }), errs.ToAggregate())
+		})
+	}
+}
+
+func TestValidateNodeClass(t *testing.T) {
+	testCases := []struct {
+		name           string
+		spec           v1alpha1.KaitoNodeClassSpec
+		expectedFields []string
+	}{
+		{
+			name: "empty spec is valid",
+		},
+		{
+			name: "valid spec",
+			spec: v1alpha1.KaitoNodeClassSpec{
+				AgentPoolType:      v1alpha1.AgentPoolTypeVirtualMachines,
+				OSSKU:              "AzureLinux",
+				OSDiskType:         "Ephemeral",
+				OSDiskSizeGB:       lo.ToPtr(int32(256)),
+				MaxPods:            lo.ToPtr(int32(110)),
+				VnetSubnetID:       lo.ToPtr("/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/gpu"),
+				Tags:               map[string]string{"team": "ml"},
+				GPUDriver:          v1alpha1.GPUDriverNone,
+				GPUInstanceProfile: "MIG2g",
+			},
+		},
+		{
+			name: "invalid enum values",
+			spec: v1alpha1.KaitoNodeClassSpec{
+				AgentPoolType:      "AvailabilitySet",
+				OSSKU:              "Windows2022",
+				OSDiskType:         "Premium",
+				GPUDriver:          "Skip",
+				GPUInstanceProfile: "MIG8g",
+			},
+			expectedFields: []string{"spec.agentPoolType", "spec.osSKU", "spec.osDiskType", "spec.gpuDriver", "spec.gpuInstanceProfile"},
+		},
+		{
+			name: "out of range numbers",
+			spec: v1alpha1.KaitoNodeClassSpec{
+				OSDiskSizeGB: lo.ToPtr(int32(16)),
+				MaxPods:      lo.ToPtr(int32(500)),
+			},
+			expectedFields: []string{"spec.osDiskSizeGB", "spec.maxPods"},
+		},
+		{
+			name:           "invalid subnet id",
+			spec:           v1alpha1.KaitoNodeClassSpec{VnetSubnetID: lo.ToPtr("subnet-1")},
+			expectedFields: []string{"spec.vnetSubnetID"},
+		},
+		{
+			name: "valid network settings",
+			spec: v1alpha1.KaitoNodeClassSpec{
+				VnetSubnetID: lo.ToPtr("/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/gpu"),
+				PodSubnetID:  lo.ToPtr("/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/pods"),
+				NetworkProfile: &v1alpha1.NetworkProfile{
+					ApplicationSecurityGroups: []string{"/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/applicationSecurityGroups/gpu"},
+					AllowedHostPorts:          []v1alpha1.PortRange{{PortStart: 8000, PortEnd: 8080, Protocol: "TCP"}, {PortStart: 53, PortEnd: 53, Protocol: "UDP"}},
+				},
+			},
+		},
+		{
+			name:           "pod subnet without vnet subnet",
+			spec:           v1alpha1.KaitoNodeClassSpec{PodSubnetID: lo.ToPtr("/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/pods")},
+			expectedFields: []string{"spec.vnetSubnetID"},
+		},
+		{
+			name: "pod subnet in another virtual network",
+			spec: v1alpha1.KaitoNodeClassSpec{
+				VnetSubnetID: lo.ToPtr("/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/gpu"),
+				PodSubnetID:  lo.ToPtr("/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/other/subnets/pods"),
+			},
+			expectedFields: []string{"spec.podSubnetID"},
+		},
+		{
+			name: "invalid network profile",
+			spec: v1alpha1.KaitoNodeClassSpec{
+				PodSubnetID: lo.ToPtr("pods"),
+				NetworkProfile: &v1alpha1.NetworkProfile{
+					ApplicationSecurityGroups: []string{"/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/networkSecurityGroups/gpu"},
+					AllowedHostPorts:          []v1alpha1.PortRange{{PortStart: 0, PortEnd: 80, Protocol: "TCP"}, {PortStart: 443, PortEnd: 80, Protocol: "ICMP"}},
+				},
+			},
+			expectedFields: []string{
+				"spec.podSubnetID",
+				"spec.vnetSubnetID",
+				"spec.networkProfile.applicationSecurityGroups[0]",
+				"spec.networkProfile.allowedHostPorts[0].portStart",
+				"spec.networkProfile.allowedHostPorts[1].portEnd",
+				"spec.networkProfile.allowedHostPorts[1].protocol",
+			},
+		},
+		{
+			name:           "reserved tag",
+			spec:           v1alpha1.KaitoNodeClassSpec{Tags: map[string]string{WarmPoolTag: "Standard_NC6s_v3"}},
+			expectedFields: []string{"spec.tags"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			errs := ValidateNodeClass(&v1alpha1.KaitoNodeClass{Spec: tc.spec})
+			assert.ElementsMatch(t, tc.expectedFields, lo.Map(errs, func(err *field.Error, _ int) string { return err.Field }), errs.ToAggregate())
+		})
+	}
+}
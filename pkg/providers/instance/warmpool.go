@@ -0,0 +1,308 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instance
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v4"
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/providers/instancetype"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/klog/v2"
+	"knative.dev/pkg/logging"
+	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+const (
+	// WarmPoolTag marks the warm standby agent pool which is not bound to any nodeclaim yet, the value is the vm size.
+	WarmPoolTag = "kaito-warm-pool"
+	// LabelWarmPool and the taint with the same key keep workloads away from the nodes of warm standby agent pools.
+	LabelWarmPool = "kaito.sh/warm-pool"
+	// warmPoolNamePrefix and warmPoolNameRandomLength are used for generating warm agent pool name.
+	warmPoolNamePrefix       = "w"
+	warmPoolNameRandomLength = 11
+)
+
+// isWarmAgentPool returns true when the agent pool is a warm standby agent pool which is not bound to any nodeclaim.
+func isWarmAgentPool(ap *armcontainerservice.AgentPool) bool {
+	return ap != nil && ap.Properties != nil && ap.Properties.Tags[WarmPoolTag] != nil
+}
+
+// ListWarmPools returns the warm standby agent pools which are not bound to any nodeclaim yet.
+func (p *Provider) ListWarmPools(ctx context.Context) ([]*Instance, error) {
+	apList, err := listAgentPools(ctx, p.azClient.agentPoolsClient, p.resourceGroup, p.clusterName)
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Listing agentpools failed, reason: %s, %v", ClassifyError(err).Reason, err)
+		return nil, err
+	}
+
+	return lo.FilterMap(apList, func(ap *armcontainerservice.AgentPool, _ int) (*Instance, bool) {
+		if !isWarmAgentPool(ap) {
+			return nil, false
+		}
+		return &Instance{
+			Name:  ap.Name,
+			ID:    ap.ID,
+			Type:  ap.Properties.Tags[WarmPoolTag],
+			State: ap.Properties.ProvisioningState,
+			Tags:  ap.Properties.Tags,
+			Labels: lo.MapValues(ap.Properties.NodeLabels, func(k *string, _ string) string {
+				return lo.FromPtr(k)
+			}),
+		}, true
+	}), nil
+}
+
+// CreateWarmPool starts creating a warm standby agent pool with vmSize and returns its name, it doesn't wait for the
+// creation to complete because the creating agent pool is listed by ListWarmPools.
+func (p *Provider) CreateWarmPool(ctx context.Context, vmSize string, osDiskSizeGB int32) (string, error) {
+	apName := warmPoolNamePrefix + rand.String(warmPoolNameRandomLength)
+	sku := p.sku(ctx, vmSize)
+	if sku != nil {
+		// the vm sizes of warm pools are configured case-insensitively, the name of SKU is used for the agent pool.
+		vmSize = sku.Name
+	}
+	klog.InfoS("Instance.CreateWarmPool", "agentpool name", apName, "vmSize", vmSize)
+
+	if _, err := beginCreateAgentPool(ctx, p.azClient.agentPoolsClient, p.resourceGroup, apName, p.clusterName,
+		newWarmAgentPoolObject(vmSize, sku, osDiskSizeGB, p.resolveAgentPoolType(nil)), ""); err != nil {
+		logging.FromContext(ctx).Errorf("Creating warm agentpool %q failed, reason: %s, %v", apName, ClassifyError(err).Reason, err)
+		return "", err
+	}
+	return apName, nil
+}
+
+// DeleteWarmPool deletes the warm standby agent pool, the agent pool is kept when it has been bound to a nodeclaim.
+func (p *Provider) DeleteWarmPool(ctx context.Context, apName string) error {
+	klog.InfoS("Instance.DeleteWarmPool", "agentpool name", apName)
+
+	// the warm agent pool should not be bound by Create during the deletion.
+	if !p.claimWarmPool(apName) {
+		return fmt.Errorf("warm agent pool %q is being bound to a nodeclaim", apName)
+	}
+	defer p.releaseWarmPool(apName)
+
+	ap, err := getAgentPool(ctx, p.azClient.agentPoolsClient, p.resourceGroup, p.clusterName, apName)
+	if err != nil {
+		return err
+	}
+	if !isWarmAgentPool(ap) {
+		return fmt.Errorf("agent pool %q is not a warm agent pool", apName)
+	}
+	if err := deleteAgentPool(ctx, p.azClient.agentPoolsClient, p.resourceGroup, p.clusterName, apName); err != nil {
+		logging.FromContext(ctx).Errorf("Deleting warm agentpool %q failed, reason: %s, %v", apName, ClassifyError(err).Reason, err)
+		return err
+	}
+	return nil
+}
+
+// bindWarmPool binds a warm agent pool which matches one of offerings to nodeClaim by relabelling it, so nodeClaim is
+// launched without waiting for agent pool creation. nil agent pool is returned when there is no matched warm agent pool.
+func (p *Provider) bindWarmPool(ctx context.Context, nodeClaim *karpenterv1.NodeClaim, nodeClass *v1alpha1.KaitoNodeClass, offerings []offering,
+	apType armcontainerservice.AgentPoolType) (*armcontainerservice.AgentPool, offering, error) {
+	if !lo.ContainsBy(offerings, func(o offering) bool { return p.warmPools[strings.ToLower(o.vmSize)] > 0 }) {
+		return nil, offering{}, nil
+	}
+
+	apList, err := listAgentPools(ctx, p.azClient.agentPoolsClient, p.resourceGroup, p.clusterName)
+	if err != nil {
+		return nil, offering{}, err
+	}
+
+	// the agent pool may have been bound to nodeClaim by previous Create call, which was interrupted before the
+	// relabelling completed, e.g. gpu-provisioner restarted.
+	if bound, found := lo.Find(apList, func(ap *armcontainerservice.AgentPool) bool {
+		return !isWarmAgentPool(ap) && ap.Properties != nil && lo.FromPtr(ap.Properties.Tags[NodeClaimNameTag]) == nodeClaim.Name &&
+			lo.FromPtr(ap.Name) != AgentPoolName(nodeClaim.Name)
+	}); found {
+		o := offering{vmSize: lo.FromPtr(bound.Properties.VMSize), capacityType: karpenterv1.CapacityTypeOnDemand}
+		if lo.FromPtr(bound.Properties.ProvisioningState) == "Succeeded" {
+			return bound, o, nil
+		}
+		ap, err := p.waitForAgentPool(ctx, lo.FromPtr(bound.Name))
+		if err != nil {
+			return nil, offering{}, fmt.Errorf("binding warm agent pool %q failed, %w", lo.FromPtr(bound.Name), err)
+		}
+		return ap, o, nil
+	}
+
+	for _, o := range offerings {
+		desired, err := newAgentPoolObject(o, p.sku(ctx, o.vmSize), nodeClaim, nodeClass, apType, p.tagOptions)
+		if err != nil {
+			return nil, offering{}, err
+		}
+		for _, warm := range apList {
+			if !warmPoolMatchesOffering(warm, o, desired) {
+				continue
+			}
+			apName := lo.FromPtr(warm.Name)
+			// the warm agent pool is claimed only while it's relabelled, so the Create calls of other nodeclaims are not
+			// blocked by the ARM requests.
+			if !p.claimWarmPool(apName) {
+				continue
+			}
+			// the agent pool is read again after it's claimed, because it may have been bound by another replica or
+			// the claim released by another Create call since it was listed.
+			current, err := getAgentPool(ctx, p.azClient.agentPoolsClient, p.resourceGroup, p.clusterName, apName)
+			if err != nil {
+				p.releaseWarmPool(apName)
+				return nil, offering{}, fmt.Errorf("getting warm agent pool %q, %w", apName, err)
+			}
+			if !warmPoolMatchesOffering(current, o, desired) {
+				p.releaseWarmPool(apName)
+				continue
+			}
+			ap, err := p.relabelWarmPool(ctx, nodeClaim, current, desired, o)
+			p.releaseWarmPool(apName)
+			if err != nil {
+				return nil, offering{}, err
+			}
+			return ap, o, nil
+		}
+	}
+	return nil, offering{}, nil
+}
+
+// relabelWarmPool updates the labels, taints and tags of the warm agent pool to desired and waits for the update.
+func (p *Provider) relabelWarmPool(ctx context.Context, nodeClaim *karpenterv1.NodeClaim, warm *armcontainerservice.AgentPool,
+	desired armcontainerservice.AgentPool, o offering) (*armcontainerservice.AgentPool, error) {
+	apName := lo.FromPtr(warm.Name)
+	logging.FromContext(ctx).Infof("binding warm agent pool %s (%s) to nodeclaim(%s)", apName, o, nodeClaim.Name)
+	poller, err := beginCreateAgentPool(ctx, p.azClient.agentPoolsClient, p.resourceGroup, apName, p.clusterName, relabelledAgentPoolObject(warm, desired), "")
+	if err != nil {
+		return nil, fmt.Errorf("agentPool.BeginCreateOrUpdate for warm agent pool %q failed: %w", apName, err)
+	}
+	res, err := poller.PollUntilDone(ctx, &runtime.PollUntilDoneOptions{Frequency: p.pollFrequency})
+	if err != nil {
+		return nil, fmt.Errorf("binding warm agent pool %q failed: %w", apName, err)
+	}
+	return &res.AgentPool, nil
+}
+
+// relabelledAgentPoolObject returns the agent pool object which updates the labels, taints and tags of the warm agent
+// pool to desired. the settings which can not be changed after creation are kept as is, and the read-only and runtime
+// states(provisioning state, power state, node image version) are not sent.
+func relabelledAgentPoolObject(warm *armcontainerservice.AgentPool, desired armcontainerservice.AgentPool) armcontainerservice.AgentPool {
+	actual := warm.Properties
+	return armcontainerservice.AgentPool{
+		Properties: &armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+			NodeLabels:          desired.Properties.NodeLabels,
+			NodeTaints:          desired.Properties.NodeTaints,
+			Tags:                desired.Properties.Tags,
+			Type:                actual.Type,
+			Mode:                actual.Mode,
+			VMSize:              actual.VMSize,
+			Count:               actual.Count,
+			OrchestratorVersion: actual.OrchestratorVersion,
+			OSType:              actual.OSType,
+			OSSKU:               actual.OSSKU,
+			OSDiskSizeGB:        actual.OSDiskSizeGB,
+			OSDiskType:          actual.OSDiskType,
+			ScaleSetPriority:    actual.ScaleSetPriority,
+			MaxPods:             actual.MaxPods,
+			VnetSubnetID:        actual.VnetSubnetID,
+			PodSubnetID:         actual.PodSubnetID,
+			EnableNodePublicIP:  actual.EnableNodePublicIP,
+		},
+	}
+}
+
+// claimWarmPool reserves the warm agent pool for binding or deletion, false is returned when it's reserved already.
+func (p *Provider) claimWarmPool(apName string) bool {
+	p.warmPoolMu.Lock()
+	defer p.warmPoolMu.Unlock()
+	if p.claimedWarmPools.Has(apName) {
+		return false
+	}
+	p.claimedWarmPools.Insert(apName)
+	return true
+}
+
+func (p *Provider) releaseWarmPool(apName string) {
+	p.warmPoolMu.Lock()
+	defer p.warmPoolMu.Unlock()
+	p.claimedWarmPools.Delete(apName)
+}
+
+// warmPoolMatchesOffering returns true when the warm agent pool is ready and able to launch nodeClaim with the offering,
+// desired is the agent pool which would be created for the offering. warm agent pools are regular priority and not
+// pinned to any availability zone, and the settings which can not be changed after creation should match desired.
+func warmPoolMatchesOffering(ap *armcontainerservice.AgentPool, o offering, desired armcontainerservice.AgentPool) bool {
+	if !isWarmAgentPool(ap) || lo.FromPtr(ap.Properties.ProvisioningState) != "Succeeded" {
+		return false
+	}
+	if o.capacityType != karpenterv1.CapacityTypeOnDemand || o.zone != "" {
+		return false
+	}
+	actual, want := ap.Properties, desired.Properties
+	if !strings.EqualFold(lo.FromPtr(actual.Tags[WarmPoolTag]), o.vmSize) || lo.FromPtr(actual.Type) != lo.FromPtr(want.Type) {
+		return false
+	}
+	if lo.FromPtr(actual.OSSKU) != lo.FromPtr(want.OSSKU) || lo.FromPtr(actual.OSDiskSizeGB) < lo.FromPtr(want.OSDiskSizeGB) {
+		return false
+	}
+	if want.OSDiskType != nil && lo.FromPtr(actual.OSDiskType) != *want.OSDiskType {
+		return false
+	}
+	if want.MaxPods != nil && lo.FromPtr(actual.MaxPods) != *want.MaxPods {
+		return false
+	}
+	if want.VnetSubnetID != nil && !strings.EqualFold(lo.FromPtr(actual.VnetSubnetID), *want.VnetSubnetID) {
+		return false
+	}
+	if want.PodSubnetID != nil && !strings.EqualFold(lo.FromPtr(actual.PodSubnetID), *want.PodSubnetID) {
+		return false
+	}
+	if lo.FromPtr(actual.EnableNodePublicIP) != lo.FromPtr(want.EnableNodePublicIP) {
+		return false
+	}
+	// the GPU driver, MIG partitions, kubelet, OS and network interface settings are set up when the node is
+	// provisioned, so they can not be changed by relabelling.
+	if want.Tags[SkipGPUDriverInstallTag] != nil || want.GpuInstanceProfile != nil || want.KubeletConfig != nil ||
+		want.LinuxOSConfig != nil || want.NetworkProfile != nil {
+		return false
+	}
+	return true
+}
+
+func newWarmAgentPoolObject(vmSize string, sku *instancetype.SKU, osDiskSizeGB int32, apType armcontainerservice.AgentPoolType) armcontainerservice.AgentPool {
+	ap := armcontainerservice.AgentPool{
+		Properties: &armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+			NodeLabels: lo.Assign(machineLabels(vmSize, sku), map[string]*string{
+				LabelWarmPool: lo.ToPtr(vmSize),
+			}),
+			NodeTaints:       []*string{lo.ToPtr(fmt.Sprintf("%s=true:%s", LabelWarmPool, v1.TaintEffectNoSchedule))},
+			Type:             lo.ToPtr(apType),
+			VMSize:           lo.ToPtr(vmSize),
+			OSType:           lo.ToPtr(armcontainerservice.OSTypeLinux),
+			OSSKU:            lo.ToPtr(armcontainerservice.OSSKUUbuntu),
+			Count:            lo.ToPtr(int32(1)),
+			OSDiskSizeGB:     lo.ToPtr(osDiskSizeGB),
+			ScaleSetPriority: lo.ToPtr(armcontainerservice.ScaleSetPriorityRegular),
+			Tags:             map[string]*string{WarmPoolTag: lo.ToPtr(vmSize)},
+		},
+	}
+	if apType == agentPoolTypeVirtualMachines {
+		ap.Properties.ScaleSetPriority = nil
+	}
+	return ap
+}
@@ -0,0 +1,383 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instance
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v4"
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/cache"
+	"github.com/azure/gpu-provisioner/pkg/fake"
+	"github.com/azure/gpu-provisioner/pkg/providers/instancetype"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+func TestCreateWithWarmPool(t *testing.T) {
+	testCases := []struct {
+		name               string
+		nodeClaimName      string
+		zones              []string
+		warmPools          map[string]int
+		agentPools         []*armcontainerservice.AgentPool
+		listErr            error
+		bindPolls          int
+		boundStates        []string
+		boundByOthers      []string
+		expectedBind       string
+		expectedCreate     bool
+		expectedAgentPool  string
+		expectedDiskSizeGB int32
+	}{
+		{
+			name:              "nodeclaim is bound to the matched warm agent pool",
+			nodeClaimName:     "kaito-workspace-0",
+			agentPools:        []*armcontainerservice.AgentPool{warmAgentPool("wabcdefghijk", "Standard_NC6s_v3", "Succeeded", 512)},
+			expectedBind:      "wabcdefghijk",
+			expectedAgentPool: "wabcdefghijk",
+		},
+		{
+			name:          "warm agent pool which is not ready is skipped",
+			nodeClaimName: "kaito-workspace-0",
+			agentPools: []*armcontainerservice.AgentPool{
+				warmAgentPool("wabcdefghijk", "Standard_NC6s_v3", "Creating", 512),
+				warmAgentPool("wbcdefghijkl", "Standard_NC6s_v3", "Succeeded", 512),
+			},
+			expectedBind:      "wbcdefghijkl",
+			expectedAgentPool: "wbcdefghijkl",
+		},
+		{
+			name:              "warm pool sizes are matched case-insensitively",
+			nodeClaimName:     "kaito-workspace-0",
+			warmPools:         map[string]int{"standard_nc6s_v3": 1},
+			agentPools:        []*armcontainerservice.AgentPool{warmAgentPool("wabcdefghijk", "Standard_NC6s_v3", "Succeeded", 512)},
+			expectedBind:      "wabcdefghijk",
+			expectedAgentPool: "wabcdefghijk",
+		},
+		{
+			name:              "Create waits for the warm agent pool being relabelled",
+			nodeClaimName:     "kaito-workspace-0",
+			agentPools:        []*armcontainerservice.AgentPool{warmAgentPool("wabcdefghijk", "Standard_NC6s_v3", "Succeeded", 512)},
+			bindPolls:         2,
+			expectedBind:      "wabcdefghijk",
+			expectedAgentPool: "wabcdefghijk",
+		},
+		{
+			name:          "warm agent pool being relabelled by previous Create call is waited for",
+			nodeClaimName: "kaito-workspace-0",
+			agentPools: func() []*armcontainerservice.AgentPool {
+				bound := warmAgentPool("wabcdefghijk", "Standard_NC6s_v3", "Updating", 512)
+				bound.Properties.Tags = map[string]*string{NodeClaimNameTag: lo.ToPtr("kaito-workspace-0")}
+				return []*armcontainerservice.AgentPool{bound}
+			}(),
+			boundStates:       []string{"Updating", "Succeeded"},
+			expectedAgentPool: "wabcdefghijk",
+		},
+		{
+			name:          "warm agent pool bound by another replica since it was listed is skipped",
+			nodeClaimName: "kaito-workspace-0",
+			agentPools: []*armcontainerservice.AgentPool{
+				warmAgentPool("wabcdefghijk", "Standard_NC6s_v3", "Succeeded", 512),
+				warmAgentPool("wbcdefghijkl", "Standard_NC6s_v3", "Succeeded", 512),
+			},
+			boundByOthers:     []string{"wabcdefghijk"},
+			expectedBind:      "wbcdefghijkl",
+			expectedAgentPool: "wbcdefghijkl",
+		},
+		{
+			name:              "agent pool is created when all warm agent pools are bound by other replicas",
+			nodeClaimName:     "kaito-workspace-0",
+			agentPools:        []*armcontainerservice.AgentPool{warmAgentPool("wabcdefghijk", "Standard_NC6s_v3", "Succeeded", 512)},
+			boundByOthers:     []string{"wabcdefghijk"},
+			expectedCreate:    true,
+			expectedAgentPool: AgentPoolName("kaito-workspace-0"),
+		},
+		{
+			name:              "warm agent pool with smaller os disk is skipped",
+			nodeClaimName:     "kaito-workspace-0",
+			agentPools:        []*armcontainerservice.AgentPool{warmAgentPool("wabcdefghijk", "Standard_NC6s_v3", "Succeeded", 16)},
+			expectedCreate:    true,
+			expectedAgentPool: AgentPoolName("kaito-workspace-0"),
+		},
+		{
+			name:              "warm agent pool is not bound to nodeclaim pinned to zones",
+			nodeClaimName:     "kaito-workspace-0",
+			zones:             []string{"eastus-1"},
+			agentPools:        []*armcontainerservice.AgentPool{warmAgentPool("wabcdefghijk", "Standard_NC6s_v3", "Succeeded", 512)},
+			expectedCreate:    true,
+			expectedAgentPool: AgentPoolName("kaito-workspace-0"),
+		},
+		{
+			name:          "warm agent pool bound by previous Create call is reused",
+			nodeClaimName: "kaito-workspace-0",
+			agentPools: func() []*armcontainerservice.AgentPool {
+				bound := warmAgentPool("wabcdefghijk", "Standard_NC6s_v3", "Succeeded", 512)
+				bound.Properties.Tags = map[string]*string{NodeClaimNameTag: lo.ToPtr("kaito-workspace-0")}
+				return []*armcontainerservice.AgentPool{bound, warmAgentPool("wbcdefghijkl", "Standard_NC6s_v3", "Succeeded", 512)}
+			}(),
+			expectedAgentPool: "wabcdefghijk",
+		},
+		{
+			name:              "agent pool is created when listing warm agent pools fails",
+			nodeClaimName:     "kaito-workspace-0",
+			listErr:           errors.New("failed to list"),
+			expectedCreate:    true,
+			expectedAgentPool: AgentPoolName("kaito-workspace-0"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			requirements := []v1.NodeSelectorRequirement{
+				{
+					Key:      "node.kubernetes.io/instance-type",
+					Operator: "In",
+					Values:   []string{"Standard_NC6s_v3"},
+				},
+			}
+			if len(tc.zones) != 0 {
+				requirements = append(requirements, v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: "In", Values: tc.zones})
+			}
+			nodeClaim := fake.GetNodeClaimObj(tc.nodeClaimName, map[string]string{"test": "test"}, []v1.Taint{},
+				karpenterv1.ResourceRequirements{Requests: v1.ResourceList{
+					v1.ResourceStorage: lo.FromPtr(resource.NewQuantity(30*1024*1024*1024, resource.DecimalSI)),
+				}}, requirements)
+
+			agentPoolMocks := fake.NewMockAgentPoolsAPI(mockCtrl)
+			agentPoolMocks.EXPECT().NewListPager(gomock.Any(), gomock.Any(), gomock.Any()).Return(runtime.NewPager(runtime.PagingHandler[armcontainerservice.AgentPoolsClientListResponse]{
+				More: func(page armcontainerservice.AgentPoolsClientListResponse) bool {
+					return false
+				},
+				Fetcher: func(ctx context.Context, page *armcontainerservice.AgentPoolsClientListResponse) (armcontainerservice.AgentPoolsClientListResponse, error) {
+					return armcontainerservice.AgentPoolsClientListResponse{
+						AgentPoolListResult: armcontainerservice.AgentPoolListResult{Value: tc.agentPools},
+					}, tc.listErr
+				},
+			}))
+			if tc.expectedBind != "" {
+				warm, _ := lo.Find(tc.agentPools, func(ap *armcontainerservice.AgentPool) bool { return lo.FromPtr(ap.Name) == tc.expectedBind })
+				agentPoolMocks.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any(), tc.expectedBind, gomock.Any()).
+					Return(armcontainerservice.AgentPoolsClientGetResponse{AgentPool: *warm}, nil)
+				matchBound := gomock.Cond(func(x any) bool {
+					props := x.(armcontainerservice.AgentPool).Properties
+					// the read-only and runtime states of the warm agent pool are not sent.
+					return lo.FromPtr(props.Tags[NodeClaimNameTag]) == tc.nodeClaimName && props.Tags[WarmPoolTag] == nil &&
+						props.NodeLabels[LabelWarmPool] == nil && lo.FromPtr(props.NodeLabels["kaito.sh/workspace"]) == "none" &&
+						len(props.NodeTaints) == 0 && lo.FromPtr(props.VMSize) == "Standard_NC6s_v3" && lo.FromPtr(props.OSDiskSizeGB) == 512 &&
+						props.ProvisioningState == nil && props.PowerState == nil && props.NodeImageVersion == nil
+				})
+				agentPoolMocks.EXPECT().BeginCreateOrUpdate(gomock.Any(), gomock.Any(), gomock.Any(), tc.expectedBind, matchBound, gomock.Any()).
+					Return(createPoller(t, mockCtrl, tc.expectedBind, tc.bindPolls), nil)
+			}
+			for _, name := range tc.boundByOthers {
+				bound := *warmAgentPool(name, "Standard_NC6s_v3", "Updating", 512)
+				bound.Properties.Tags = map[string]*string{NodeClaimNameTag: lo.ToPtr("kaito-workspace-1")}
+				agentPoolMocks.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any(), name, gomock.Any()).
+					Return(armcontainerservice.AgentPoolsClientGetResponse{AgentPool: bound}, nil)
+			}
+			for _, state := range tc.boundStates {
+				bound := *tc.agentPools[0]
+				bound.Properties = lo.ToPtr(*bound.Properties)
+				bound.Properties.ProvisioningState = lo.ToPtr(state)
+				agentPoolMocks.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any(), lo.FromPtr(bound.Name), gomock.Any()).
+					Return(armcontainerservice.AgentPoolsClientGetResponse{AgentPool: bound}, nil)
+			}
+			if tc.expectedCreate {
+				agentPoolMocks.EXPECT().BeginCreateOrUpdate(gomock.Any(), gomock.Any(), gomock.Any(), AgentPoolName(tc.nodeClaimName), gomock.Any(), gomock.Any()).
+					Return(createPoller(t, mockCtrl, AgentPoolName(tc.nodeClaimName), 0), nil)
+			}
+
+			mockK8sClient := fake.NewClient().WithKaitoNodeClass(fake.GetKaitoNodeClassObj())
+			mockK8sClient.CreateMapWithType(&v1.NodeList{})[client.ObjectKeyFromObject(&ReadyNode)] = &ReadyNode
+			mockK8sClient.On("List", mock.IsType(context.Background()), mock.IsType(&v1.NodeList{}), mock.Anything).Return(nil)
+
+			warmPools := lo.Ternary(tc.warmPools != nil, tc.warmPools, map[string]int{"Standard_NC6s_v3": 1})
+			p := NewProvider(NewAZClientFromAPI(agentPoolMocks), mockK8sClient, "testRG", "testCluster", v1alpha1.AgentPoolTypeVirtualMachineScaleSets,
+				warmPools, TagOptions{}, instancetype.NewProvider("", nil, 0.4, cache.NewUnavailableOfferings()))
+			p.pollFrequency = time.Millisecond
+
+			instance, err := p.Create(context.Background(), nodeClaim)
+			assert.NoError(t, err)
+			// the warm agent pool is not claimed after it's bound.
+			assert.Empty(t, p.claimedWarmPools)
+			assert.Equal(t, tc.expectedAgentPool, lo.FromPtr(instance.Name))
+			assert.Equal(t, "Standard_NC6s_v3", lo.FromPtr(instance.Type))
+		})
+	}
+}
+
+func TestDeleteClaimedWarmPool(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// no ARM request is sent for the warm agent pool which is being bound to a nodeclaim.
+	p := createTestProvider(fake.NewMockAgentPoolsAPI(mockCtrl), fake.NewClient())
+	assert.True(t, p.claimWarmPool("wabcdefghijk"))
+	assert.False(t, p.claimWarmPool("wabcdefghijk"))
+	assert.ErrorContains(t, p.DeleteWarmPool(context.Background(), "wabcdefghijk"), "is being bound to a nodeclaim")
+
+	p.releaseWarmPool("wabcdefghijk")
+	assert.True(t, p.claimWarmPool("wabcdefghijk"))
+}
+
+func TestWarmPoolMatchesOffering(t *testing.T) {
+	nodeClaim := fake.GetNodeClaimObj("kaito-workspace-0", map[string]string{"test": "test"}, []v1.Taint{},
+		karpenterv1.ResourceRequirements{Requests: v1.ResourceList{
+			v1.ResourceStorage: lo.FromPtr(resource.NewQuantity(30*1024*1024*1024, resource.DecimalSI)),
+		}}, nil)
+	defaultOffering := offering{vmSize: "Standard_NC6s_v3", capacityType: karpenterv1.CapacityTypeOnDemand}
+
+	testCases := []struct {
+		name      string
+		offering  offering
+		nodeClass *v1alpha1.KaitoNodeClass
+		expected  bool
+	}{
+		{
+			name:     "warm agent pool matches nodeclaim without nodeclass",
+			expected: true,
+		},
+		{
+			name:      "warm agent pool matches nodeclass with default settings",
+			nodeClass: &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{Tags: map[string]string{"team": "ml"}}},
+			expected:  true,
+		},
+		{
+			name:      "warm agent pool doesn't match nodeclass with another os sku",
+			nodeClass: &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{OSSKU: "AzureLinux"}},
+		},
+		{
+			name:      "warm agent pool doesn't match nodeclass with max pods",
+			nodeClass: &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{MaxPods: lo.ToPtr(int32(50))}},
+		},
+		{
+			name:      "warm agent pool doesn't match nodeclass with vnet subnet",
+			nodeClass: &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{VnetSubnetID: lo.ToPtr("/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/gpu")}},
+		},
+		{
+			name: "warm agent pool doesn't match nodeclass with pod subnet",
+			nodeClass: &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{
+				PodSubnetID: lo.ToPtr("/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/pods"),
+			}},
+		},
+		{
+			name: "warm agent pool doesn't match nodeclass with network profile",
+			nodeClass: &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{
+				NetworkProfile: &v1alpha1.NetworkProfile{AllowedHostPorts: []v1alpha1.PortRange{{PortStart: 8000, PortEnd: 8000, Protocol: "TCP"}}},
+			}},
+		},
+		{
+			name:      "warm agent pool doesn't match nodeclass with node public ip",
+			nodeClass: &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{EnableNodePublicIP: lo.ToPtr(true)}},
+		},
+		{
+			name:      "warm agent pool doesn't match nodeclass which skips gpu driver installation",
+			nodeClass: &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{GPUDriver: v1alpha1.GPUDriverNone}},
+		},
+		{
+			name:      "warm agent pool doesn't match nodeclass with gpu instance profile",
+			offering:  offering{vmSize: "Standard_NC24ads_A100_v4", capacityType: karpenterv1.CapacityTypeOnDemand},
+			nodeClass: &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{GPUInstanceProfile: "MIG1g"}},
+		},
+		{
+			name: "warm agent pool doesn't match nodeclass with kubelet configuration",
+			nodeClass: &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{
+				Kubelet: &v1alpha1.KubeletConfiguration{TopologyManagerPolicy: "single-numa-node"},
+			}},
+		},
+		{
+			name: "warm agent pool doesn't match nodeclass with linux os configuration",
+			nodeClass: &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{
+				LinuxOSConfig: &v1alpha1.LinuxOSConfiguration{Sysctls: map[string]string{"vm.max_map_count": "262144"}},
+			}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			o := lo.Ternary(tc.offering.vmSize != "", tc.offering, defaultOffering)
+			desired, err := newAgentPoolObject(o, nil, nodeClaim, tc.nodeClass, armcontainerservice.AgentPoolTypeVirtualMachineScaleSets, TagOptions{})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, warmPoolMatchesOffering(warmAgentPool("wabcdefghijk", o.vmSize, "Succeeded", 512), o, desired))
+		})
+	}
+}
+
+func TestNewWarmAgentPoolObject(t *testing.T) {
+	ap := newWarmAgentPoolObject("Standard_NC6s_v3", nil, 256, armcontainerservice.AgentPoolTypeVirtualMachineScaleSets)
+	assert.Equal(t, "Standard_NC6s_v3", lo.FromPtr(ap.Properties.VMSize))
+	assert.Equal(t, int32(256), lo.FromPtr(ap.Properties.OSDiskSizeGB))
+	assert.Equal(t, "Standard_NC6s_v3", lo.FromPtr(ap.Properties.Tags[WarmPoolTag]))
+	assert.Equal(t, "Standard_NC6s_v3", lo.FromPtr(ap.Properties.NodeLabels[LabelWarmPool]))
+	assert.Equal(t, "gpu", lo.FromPtr(ap.Properties.NodeLabels[LabelMachineType]))
+	assert.Equal(t, []*string{lo.ToPtr("kaito.sh/warm-pool=true:NoSchedule")}, ap.Properties.NodeTaints)
+	assert.Equal(t, armcontainerservice.ScaleSetPriorityRegular, lo.FromPtr(ap.Properties.ScaleSetPriority))
+	assert.True(t, isWarmAgentPool(&ap))
+
+	ap = newWarmAgentPoolObject("Standard_NC24ads_A100_v4", &instancetype.SKU{GPU: instancetype.GPU{Product: "A100", Count: 1}}, 256, armcontainerservice.AgentPoolTypeVirtualMachineScaleSets)
+	assert.Equal(t, "gpu", lo.FromPtr(ap.Properties.NodeLabels[LabelMachineType]))
+	assert.Equal(t, "A100", lo.FromPtr(ap.Properties.NodeLabels[instancetype.LabelGPUProduct]))
+	assert.Equal(t, "Standard_NC24ads_A100_v4", lo.FromPtr(ap.Properties.NodeLabels[LabelWarmPool]))
+
+	ap = newWarmAgentPoolObject("Standard_NC6s_v3", nil, 256, agentPoolTypeVirtualMachines)
+	assert.Equal(t, agentPoolTypeVirtualMachines, lo.FromPtr(ap.Properties.Type))
+	assert.Nil(t, ap.Properties.ScaleSetPriority)
+}
+
+func warmAgentPool(name, vmSize, state string, diskSizeGB int32) *armcontainerservice.AgentPool {
+	ap := newWarmAgentPoolObject(vmSize, nil, diskSizeGB, armcontainerservice.AgentPoolTypeVirtualMachineScaleSets)
+	ap.Name = lo.ToPtr(name)
+	ap.Properties.ProvisioningState = lo.ToPtr(state)
+	ap.Properties.PowerState = &armcontainerservice.PowerState{Code: lo.ToPtr(armcontainerservice.CodeRunning)}
+	ap.Properties.NodeImageVersion = lo.ToPtr("AKSUbuntu-2204gen2containerd-202501.12.0")
+	return &ap
+}
+
+// createPoller returns the poller of the agent pool creation or update which is completed after polls.
+func createPoller(t *testing.T, mockCtrl *gomock.Controller, apName string, polls int) *runtime.Poller[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse] {
+	mockHandler := fake.NewMockPollingHandler[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse](mockCtrl)
+	polled := 0
+	mockHandler.EXPECT().Done().DoAndReturn(func() bool { return polled >= polls }).AnyTimes()
+	mockHandler.EXPECT().Poll(gomock.Any()).DoAndReturn(func(context.Context) (*http.Response, error) {
+		polled++
+		return &http.Response{StatusCode: http.StatusAccepted, Body: http.NoBody}, nil
+	}).Times(polls)
+	mockHandler.EXPECT().Result(gomock.Any(), gomock.Any()).Return(nil)
+
+	ap := GetAgentPoolObjWithName(apName, "", "Standard_NC6s_v3")
+	p, err := runtime.NewPoller(&http.Response{StatusCode: http.StatusAccepted, Body: http.NoBody}, runtime.NewPipeline("", "", runtime.PipelineOptions{}, nil),
+		&runtime.NewPollerOptions[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse]{
+			Handler:  mockHandler,
+			Response: &armcontainerservice.AgentPoolsClientCreateOrUpdateResponse{AgentPool: ap},
+		})
+	assert.NoError(t, err)
+	return p
+}
@@ -0,0 +1,164 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instancetype
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/karpenter/pkg/scheduling"
+)
+
+const (
+	// LabelGPUVendor, LabelGPUProduct, LabelGPUCount and LabelGPUMemoryGiB describe the GPUs of a SKU, the memory is
+	// the memory of each GPU.
+	LabelGPUVendor    = "kaito.sh/gpu-vendor"
+	LabelGPUProduct   = "kaito.sh/gpu-product"
+	LabelGPUCount     = "kaito.sh/gpu-count"
+	LabelGPUMemoryGiB = "kaito.sh/gpu-memory-gib"
+	// LabelGPUNVLink is true when the GPUs of a SKU are interconnected with NVLink.
+	LabelGPUNVLink = "kaito.sh/gpu-nvlink"
+	// LabelInfiniBand and LabelAcceleratedNetworking describe the network capabilities of a SKU, InfiniBand is
+	// reported as RDMA by Resource SKUs API.
+	LabelInfiniBand            = "kaito.sh/infiniband"
+	LabelAcceleratedNetworking = "kaito.sh/accelerated-networking"
+
+	GPUVendorNVIDIA = "nvidia"
+	GPUVendorAMD    = "amd"
+)
+
+// gpuResources are the extended resources which the device plugins of GPU vendors register on the nodes.
+var gpuResources = map[string]v1.ResourceName{
+	GPUVendorNVIDIA: "nvidia.com/gpu",
+	GPUVendorAMD:    "amd.com/gpu",
+}
+
+// migInstancesPerGPU is the number of GPU instances which each GPU is partitioned into by the Multi-Instance GPU
+// profile, AKS registers every GPU instance as a GPU resource of the node with the single MIG strategy.
+// https://learn.microsoft.com/en-us/azure/aks/gpu-multi-instance
+var migInstancesPerGPU = map[string]int64{
+	"mig1g": 7,
+	"mig2g": 3,
+	"mig3g": 2,
+	"mig4g": 1,
+	"mig7g": 1,
+}
+
+// gpuLabelKeys are the labels which describe the GPUs of a SKU.
+var gpuLabelKeys = []string{LabelGPUVendor, LabelGPUProduct, LabelGPUCount, LabelGPUMemoryGiB, LabelGPUNVLink}
+
+// skusJSON is the offline catalog of the GPU SKUs supported by AKS. The prices are the pay-as-you-go Linux prices in
+// USD per hour of East US, they're only used for ordering the SKUs.
+//
+//go:embed skus.json
+var skusJSON []byte
+
+// SKU is an Azure VM size with GPUs.
+type SKU struct {
+	Name                  string  `json:"name"`
+	Family                string  `json:"family"`
+	VCPUs                 int64   `json:"vCPUs"`
+	MemoryGiB             float64 `json:"memoryGiB"`
+	GPU                   GPU     `json:"gpu"`
+	AcceleratedNetworking bool    `json:"acceleratedNetworking"`
+	InfiniBand            bool    `json:"infiniBand"`
+	// Price is the on-demand price per hour, it's 0 for the SKUs which are only known from Resource SKUs API.
+	Price float64 `json:"price"`
+	// Zones are the availability zones of the SKU in the location, e.g. "1". They're only known after the SKUs are
+	// refreshed from Resource SKUs API, the SKU is not pinned to any zone when it's empty.
+	Zones []string `json:"-"`
+}
+
+// GPU describes the GPUs of a SKU, MemoryGiB is the memory of each GPU.
+type GPU struct {
+	Vendor    string `json:"vendor"`
+	Product   string `json:"product"`
+	Count     int64  `json:"count"`
+	MemoryGiB int64  `json:"memoryGiB"`
+	NVLink    bool   `json:"nvLink"`
+}
+
+// Resource returns the extended resource of the GPUs, empty name is returned when the vendor is unknown.
+func (g GPU) Resource() v1.ResourceName {
+	return gpuResources[g.Vendor]
+}
+
+// Devices returns the number of GPU resources registered on the node, which is the number of GPU instances when the
+// GPUs are partitioned by the Multi-Instance GPU profile, gpuInstanceProfile is empty when they're not partitioned.
+func (g GPU) Devices(gpuInstanceProfile string) int64 {
+	if instances, ok := migInstancesPerGPU[strings.ToLower(gpuInstanceProfile)]; ok {
+		return g.Count * instances
+	}
+	return g.Count
+}
+
+// HasGPURequest returns true when requirements constrain the GPU labels or requests contain GPU resources, such
+// requirements can be resolved to the SKUs by Provider.Resolve.
+func HasGPURequest(requirements scheduling.Requirements, requests v1.ResourceList) bool {
+	if lo.ContainsBy(gpuLabelKeys, requirements.Has) {
+		return true
+	}
+	return lo.ContainsBy(lo.Values(gpuResources), func(name v1.ResourceName) bool {
+		quantity, ok := requests[name]
+		return ok && !quantity.IsZero()
+	})
+}
+
+// Labels returns the labels describing the GPUs and the network capabilities of sku, the labels of unknown GPU details
+// are omitted.
+func (s SKU) Labels() map[string]string {
+	labels := map[string]string{
+		LabelInfiniBand:            strconv.FormatBool(s.InfiniBand),
+		LabelAcceleratedNetworking: strconv.FormatBool(s.AcceleratedNetworking),
+	}
+	if s.GPU.Count > 0 {
+		labels[LabelGPUCount] = strconv.FormatInt(s.GPU.Count, 10)
+	}
+	if s.GPU.Vendor != "" {
+		labels[LabelGPUVendor] = s.GPU.Vendor
+	}
+	if s.GPU.Product != "" {
+		labels[LabelGPUProduct] = s.GPU.Product
+		// NVLink is only known for the SKUs of the catalog.
+		labels[LabelGPUNVLink] = strconv.FormatBool(s.GPU.NVLink)
+	}
+	if s.GPU.MemoryGiB > 0 {
+		labels[LabelGPUMemoryGiB] = strconv.FormatInt(s.GPU.MemoryGiB, 10)
+	}
+	return labels
+}
+
+// offlineSKUs are the SKUs of the embedded catalog, they should not be modified.
+var offlineSKUs = lo.Must(parseSKUs(skusJSON))
+
+// parseSKUs parses the SKU catalog, the SKUs are keyed by the lower case vm size because vm size is case-insensitive
+// in ARM.
+func parseSKUs(data []byte) (map[string]SKU, error) {
+	var skus []SKU
+	if err := json.Unmarshal(data, &skus); err != nil {
+		return nil, fmt.Errorf("parsing sku catalog, %w", err)
+	}
+	return lo.KeyBy(skus, func(s SKU) string { return skuKey(s.Name) }), nil
+}
+
+func skuKey(vmSize string) string {
+	return strings.ToLower(vmSize)
+}
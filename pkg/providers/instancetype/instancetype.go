@@ -0,0 +1,264 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instancetype
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/cache"
+	gocache "github.com/patrickmn/go-cache"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"knative.dev/pkg/logging"
+	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+	"sigs.k8s.io/karpenter/pkg/cloudprovider"
+	"sigs.k8s.io/karpenter/pkg/scheduling"
+	"sigs.k8s.io/karpenter/pkg/utils/resources"
+)
+
+const (
+	// SKUCacheTTL is the time before the SKUs refreshed from Resource SKUs API are refreshed again.
+	SKUCacheTTL = 12 * time.Hour
+	// skuRefreshRetryInterval is the time before a failed refresh is retried, the embedded catalog is used until then.
+	skuRefreshRetryInterval = 5 * time.Minute
+
+	// defaultMaxPods is the max pods of AKS nodes with kubenet or Azure CNI overlay, it's used when the KaitoNodeClass
+	// doesn't specify maxPods.
+	defaultMaxPods = 110
+
+	skusCacheKey = "skus"
+)
+
+// Provider serves the GPU SKUs as karpenter instance types. The SKUs come from the embedded catalog, and they're
+// refreshed from Resource SKUs API periodically when the dynamic SKU cache is enabled.
+type Provider struct {
+	location string
+	// skuClient is nil when the dynamic SKU cache is disabled.
+	skuClient ResourceSKUsAPI
+	// spotPriceFactor estimates the spot price from the on-demand price, since the catalog only has on-demand prices.
+	// Azure discounts the spot VMs by up to 90% and the discount varies over time, the estimate only makes the spot
+	// offerings cheaper than the on-demand ones of the same SKU when karpenter orders the offerings.
+	spotPriceFactor float64
+	// unavailableOfferings is shared with the instance provider, which marks the offerings failed with capacity errors.
+	unavailableOfferings *cache.UnavailableOfferings
+
+	// mu prevents the SKUs from being refreshed concurrently.
+	mu   sync.Mutex
+	skus *gocache.Cache
+}
+
+func NewProvider(location string, skuClient ResourceSKUsAPI, spotPriceFactor float64, unavailableOfferings *cache.UnavailableOfferings) *Provider {
+	return &Provider{
+		location:             strings.ToLower(location),
+		skuClient:            skuClient,
+		spotPriceFactor:      spotPriceFactor,
+		unavailableOfferings: unavailableOfferings,
+		skus:                 gocache.New(SKUCacheTTL, time.Hour),
+	}
+}
+
+// UnavailableOfferings returns the offerings which failed recently with capacity errors.
+func (p *Provider) UnavailableOfferings() *cache.UnavailableOfferings {
+	return p.unavailableOfferings
+}
+
+// Get returns the GPU SKU of vmSize, false is returned when vmSize is not a known GPU SKU.
+func (p *Provider) Get(ctx context.Context, vmSize string) (SKU, bool) {
+	sku, ok := p.getSKUs(ctx)[skuKey(vmSize)]
+	return sku, ok
+}
+
+// List returns the instance types of the GPU SKUs sorted by name, the max pods and os disk size of nodeClass are used
+// for the pods and ephemeral storage capacity when they're specified. nodeClass is nil for the nodepools which don't
+// reference a KaitoNodeClass.
+func (p *Provider) List(ctx context.Context, nodeClass *v1alpha1.KaitoNodeClass) []*cloudprovider.InstanceType {
+	var maxPods, osDiskSizeGB int64
+	var gpuInstanceProfile string
+	if nodeClass != nil {
+		maxPods = int64(lo.FromPtr(nodeClass.Spec.MaxPods))
+		osDiskSizeGB = int64(lo.FromPtr(nodeClass.Spec.OSDiskSizeGB))
+		gpuInstanceProfile = nodeClass.Spec.GPUInstanceProfile
+	}
+	skus := lo.Values(p.getSKUs(ctx))
+	sort.Slice(skus, func(i, j int) bool { return skus[i].Name < skus[j].Name })
+	return lo.Map(skus, func(sku SKU, _ int) *cloudprovider.InstanceType {
+		return p.newInstanceType(sku, maxPods, osDiskSizeGB, gpuInstanceProfile)
+	})
+}
+
+// NodeResources returns the capacity and allocatable resources of the node launched with vmSize, the allocatable
+// resources exclude the kube-reserved resources and eviction thresholds of AKS. maxPods and osDiskSizeGB are 0 when
+// they're unknown, then the default max pods is used and the ephemeral storage is omitted. gpuInstanceProfile is the
+// MIG profile of the GPUs, it's empty when they're not partitioned. false is returned when vmSize is not a known GPU SKU.
+func (p *Provider) NodeResources(ctx context.Context, vmSize string, maxPods, osDiskSizeGB int64, gpuInstanceProfile string) (v1.ResourceList, v1.ResourceList, bool) {
+	sku, ok := p.Get(ctx, vmSize)
+	if !ok {
+		return nil, nil, false
+	}
+	instanceType := p.newInstanceType(sku, maxPods, osDiskSizeGB, gpuInstanceProfile)
+	return instanceType.Capacity, instanceType.Allocatable(), true
+}
+
+// Resolve returns the SKUs matching requirements and requests, it's used for launching the nodeclaims which request
+// GPUs without naming the vm sizes. A SKU matches when its labels intersect requirements, at least one of its offerings
+// is compatible with requirements, and its capacity fits requests except the storage. The SKUs are ordered by price from
+// the cheapest and then by name, the SKUs without price are ordered last, so the same requirements are always resolved
+// in the same order. gpuInstanceProfile is the MIG profile which the GPUs are partitioned with, it's empty when they're
+// not partitioned.
+func (p *Provider) Resolve(ctx context.Context, requirements scheduling.Requirements, requests v1.ResourceList, gpuInstanceProfile string) []SKU {
+	requests = lo.OmitByKeys(requests, []v1.ResourceName{v1.ResourceStorage, v1.ResourceEphemeralStorage})
+	var skus []SKU
+	for _, sku := range p.getSKUs(ctx) {
+		instanceType := p.newInstanceType(sku, 0, 0, gpuInstanceProfile)
+		if instanceType.Requirements.Intersects(requirements) != nil || !instanceType.Offerings.HasCompatible(requirements) ||
+			!resources.Fits(requests, instanceType.Capacity) {
+			continue
+		}
+		// undefined keys are ignored by Intersects, but the SKU whose GPU details are unknown can't satisfy them.
+		if lo.ContainsBy(gpuLabelKeys, func(key string) bool {
+			return requirements.Has(key) && requirements.Get(key).Operator() != v1.NodeSelectorOpDoesNotExist && !instanceType.Requirements.Has(key)
+		}) {
+			continue
+		}
+		skus = append(skus, sku)
+	}
+	sort.Slice(skus, func(i, j int) bool {
+		if (skus[i].Price > 0) != (skus[j].Price > 0) {
+			return skus[i].Price > 0
+		}
+		if skus[i].Price != skus[j].Price {
+			return skus[i].Price < skus[j].Price
+		}
+		return skus[i].Name < skus[j].Name
+	})
+	return skus
+}
+
+// getSKUs returns the cached SKUs, they're refreshed from Resource SKUs API when the cache is expired. The embedded
+// catalog is returned when the dynamic SKU cache is disabled or the refresh fails.
+func (p *Provider) getSKUs(ctx context.Context) map[string]SKU {
+	if p.skuClient == nil {
+		return offlineSKUs
+	}
+	if skus, found := p.skus.Get(skusCacheKey); found {
+		return skus.(map[string]SKU)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if skus, found := p.skus.Get(skusCacheKey); found {
+		return skus.(map[string]SKU)
+	}
+
+	resourceSKUs, err := p.skuClient.List(ctx, p.location)
+	if err != nil {
+		logging.FromContext(ctx).Warnf("refreshing skus of %s from resource skus api failed, serving the embedded catalog which isn't filtered by region and retrying in %s, %v",
+			p.location, skuRefreshRetryInterval, err)
+		p.skus.Set(skusCacheKey, offlineSKUs, skuRefreshRetryInterval)
+		return offlineSKUs
+	}
+	skus := mergeResourceSKUs(offlineSKUs, resourceSKUs, p.location)
+	logging.FromContext(ctx).Debugf("refreshed %d gpu skus of %s from resource skus api", len(skus), p.location)
+	p.skus.SetDefault(skusCacheKey, skus)
+	return skus
+}
+
+// newInstanceType returns the instance type of sku, maxPods and osDiskSizeGB are 0 when they're unknown, and
+// gpuInstanceProfile is empty when the GPUs are not partitioned.
+func (p *Provider) newInstanceType(sku SKU, maxPods, osDiskSizeGB int64, gpuInstanceProfile string) *cloudprovider.InstanceType {
+	if maxPods <= 0 {
+		maxPods = defaultMaxPods
+	}
+	zones := lo.Map(sku.Zones, func(zone string, _ int) string { return p.zoneLabel(zone) })
+	capacityTypes := []string{karpenterv1.CapacityTypeSpot, karpenterv1.CapacityTypeOnDemand}
+	requirements := scheduling.NewRequirements(
+		scheduling.NewRequirement(v1.LabelInstanceTypeStable, v1.NodeSelectorOpIn, sku.Name),
+		scheduling.NewRequirement(v1.LabelArchStable, v1.NodeSelectorOpIn, karpenterv1.ArchitectureAmd64),
+		scheduling.NewRequirement(v1.LabelOSStable, v1.NodeSelectorOpIn, string(v1.Linux)),
+		scheduling.NewRequirement(v1.LabelWindowsBuild, v1.NodeSelectorOpDoesNotExist),
+		scheduling.NewRequirement(karpenterv1.CapacityTypeLabelKey, v1.NodeSelectorOpIn, capacityTypes...),
+	)
+	if p.location != "" {
+		requirements.Add(scheduling.NewRequirement(v1.LabelTopologyRegion, v1.NodeSelectorOpIn, p.location))
+	}
+	if len(zones) > 0 {
+		requirements.Add(scheduling.NewRequirement(v1.LabelTopologyZone, v1.NodeSelectorOpIn, zones...))
+	}
+	for key, value := range sku.Labels() {
+		requirements.Add(scheduling.NewRequirement(key, v1.NodeSelectorOpIn, value))
+	}
+
+	// the offering without zone means the agent pool is not pinned to any zone.
+	offeringZones := lo.Ternary(len(zones) > 0, zones, []string{""})
+	var offerings cloudprovider.Offerings
+	for _, capacityType := range capacityTypes {
+		for _, zone := range offeringZones {
+			offeringRequirements := scheduling.NewRequirements(scheduling.NewRequirement(karpenterv1.CapacityTypeLabelKey, v1.NodeSelectorOpIn, capacityType))
+			if zone != "" {
+				offeringRequirements.Add(scheduling.NewRequirement(v1.LabelTopologyZone, v1.NodeSelectorOpIn, zone))
+			}
+			offerings = append(offerings, &cloudprovider.Offering{
+				Requirements: offeringRequirements,
+				Price:        p.offeringPrice(sku, capacityType),
+				Available:    p.unavailableOfferings == nil || !p.unavailableOfferings.IsUnavailable(sku.Name, zone, capacityType),
+			})
+		}
+	}
+
+	return &cloudprovider.InstanceType{
+		Name:         sku.Name,
+		Requirements: requirements,
+		Offerings:    offerings,
+		Capacity:     capacity(sku, maxPods, osDiskSizeGB, gpuInstanceProfile),
+		Overhead:     overhead(sku, maxPods, osDiskSizeGB),
+	}
+}
+
+// offeringPrice returns the hourly price of sku for capacityType, the spot price is estimated by spotPriceFactor.
+func (p *Provider) offeringPrice(sku SKU, capacityType string) float64 {
+	if capacityType == karpenterv1.CapacityTypeSpot {
+		return sku.Price * p.spotPriceFactor
+	}
+	return sku.Price
+}
+
+// capacity returns the resources of sku, the GPUs are registered by the device plugin of the vendor and the ephemeral
+// storage is the os disk.
+func capacity(sku SKU, maxPods, osDiskSizeGB int64, gpuInstanceProfile string) v1.ResourceList {
+	resources := v1.ResourceList{
+		v1.ResourceCPU:    *resource.NewQuantity(sku.VCPUs, resource.DecimalSI),
+		v1.ResourceMemory: *resource.NewQuantity(int64(sku.MemoryGiB*1024)*1024*1024, resource.BinarySI),
+		v1.ResourcePods:   *resource.NewQuantity(maxPods, resource.DecimalSI),
+	}
+	if gpu := sku.GPU.Resource(); gpu != "" && sku.GPU.Count > 0 {
+		resources[gpu] = *resource.NewQuantity(sku.GPU.Devices(gpuInstanceProfile), resource.DecimalSI)
+	}
+	if osDiskSizeGB > 0 {
+		resources[v1.ResourceEphemeralStorage] = *resource.NewQuantity(osDiskSizeGB<<30, resource.BinarySI)
+	}
+	return resources
+}
+
+// zoneLabel converts the availability zone such as "1" to the zone label value "eastus-1" of the nodes.
+func (p *Provider) zoneLabel(zone string) string {
+	return fmt.Sprintf("%s-%s", p.location, zone)
+}
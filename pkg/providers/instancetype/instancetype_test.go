@@ -0,0 +1,341 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instancetype
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/cache"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+	"sigs.k8s.io/karpenter/pkg/cloudprovider"
+	"sigs.k8s.io/karpenter/pkg/scheduling"
+)
+
+type fakeResourceSKUsAPI struct {
+	skus  []ResourceSKU
+	err   error
+	calls int
+}
+
+func (f *fakeResourceSKUsAPI) List(_ context.Context, _ string) ([]ResourceSKU, error) {
+	f.calls++
+	return f.skus, f.err
+}
+
+func resourceSKU(name string, gpus string, zones []string, restrictions ...ResourceSKURestriction) ResourceSKU {
+	return ResourceSKU{
+		Name:         name,
+		ResourceType: "virtualMachines",
+		LocationInfo: []ResourceSKULocationInfo{{Location: "EastUS", Zones: zones}},
+		Capabilities: []ResourceSKUCapability{
+			{Name: "GPUs", Value: gpus},
+			{Name: "vCPUs", Value: "24"},
+			{Name: "MemoryGB", Value: "220"},
+			{Name: "AcceleratedNetworkingEnabled", Value: "True"},
+			{Name: "RdmaEnabled", Value: "False"},
+		},
+		Restrictions: restrictions,
+	}
+}
+
+func findInstanceType(instanceTypes []*cloudprovider.InstanceType, name string) *cloudprovider.InstanceType {
+	instanceType, _ := lo.Find(instanceTypes, func(it *cloudprovider.InstanceType) bool { return it.Name == name })
+	return instanceType
+}
+
+func quantities(resources v1.ResourceList) map[v1.ResourceName]string {
+	return lo.MapValues(resources, func(q resource.Quantity, _ v1.ResourceName) string { return q.String() })
+}
+
+func TestOfflineCatalog(t *testing.T) {
+	assert.NotEmpty(t, offlineSKUs)
+	for key, sku := range offlineSKUs {
+		assert.Equal(t, skuKey(sku.Name), key)
+		assert.Positive(t, sku.VCPUs, sku.Name)
+		assert.Positive(t, sku.MemoryGiB, sku.Name)
+		assert.Positive(t, sku.GPU.Count, sku.Name)
+		assert.Positive(t, sku.GPU.MemoryGiB, sku.Name)
+		assert.Positive(t, sku.Price, sku.Name)
+		assert.NotEmpty(t, sku.GPU.Product, sku.Name)
+		assert.NotEmpty(t, sku.GPU.Resource(), sku.Name)
+	}
+}
+
+func TestSKULabels(t *testing.T) {
+	sku, ok := offlineSKUs[skuKey("Standard_ND96isr_H100_v5")]
+	if !assert.True(t, ok) {
+		t.FailNow()
+	}
+	assert.Equal(t, map[string]string{
+		LabelGPUVendor:             GPUVendorNVIDIA,
+		LabelGPUProduct:            "H100",
+		LabelGPUCount:              "8",
+		LabelGPUMemoryGiB:          "80",
+		LabelGPUNVLink:             "true",
+		LabelInfiniBand:            "true",
+		LabelAcceleratedNetworking: "true",
+	}, sku.Labels())
+
+	// the GPU details which are not known from Resource SKUs API are omitted.
+	assert.Equal(t, map[string]string{
+		LabelGPUCount:              "1",
+		LabelInfiniBand:            "false",
+		LabelAcceleratedNetworking: "false",
+	}, SKU{Name: "Standard_NC24ads_B200_v6", GPU: GPU{Count: 1}}.Labels())
+}
+
+func TestList(t *testing.T) {
+	unavailableOfferings := cache.NewUnavailableOfferings()
+	unavailableOfferings.MarkUnavailable(context.Background(), "SkuNotAvailable", "Standard_NC96ads_A100_v4", "", karpenterv1.CapacityTypeSpot)
+	p := NewProvider("EastUS", nil, 0.25, unavailableOfferings)
+
+	instanceTypes := p.List(context.Background(), &v1alpha1.KaitoNodeClass{Spec: v1alpha1.KaitoNodeClassSpec{MaxPods: lo.ToPtr(int32(50))}})
+	assert.Len(t, instanceTypes, len(offlineSKUs))
+	assert.IsIncreasing(t, lo.Map(instanceTypes, func(it *cloudprovider.InstanceType, _ int) string { return it.Name }))
+
+	instanceType := findInstanceType(instanceTypes, "Standard_NC96ads_A100_v4")
+	if !assert.NotNil(t, instanceType) {
+		t.FailNow()
+	}
+	assert.Equal(t, map[v1.ResourceName]string{
+		v1.ResourceCPU:    "96",
+		v1.ResourceMemory: "880Gi",
+		v1.ResourcePods:   "50",
+		"nvidia.com/gpu":  "4",
+	}, quantities(instanceType.Capacity))
+	for key, value := range map[string]string{
+		v1.LabelInstanceTypeStable: "Standard_NC96ads_A100_v4",
+		v1.LabelArchStable:         karpenterv1.ArchitectureAmd64,
+		v1.LabelOSStable:           string(v1.Linux),
+		v1.LabelTopologyRegion:     "eastus",
+		LabelGPUVendor:             GPUVendorNVIDIA,
+		LabelGPUProduct:            "A100",
+		LabelGPUCount:              "4",
+		LabelGPUMemoryGiB:          "80",
+		LabelGPUNVLink:             "false",
+		LabelInfiniBand:            "false",
+		LabelAcceleratedNetworking: "true",
+	} {
+		assert.True(t, instanceType.Requirements.Get(key).Has(value), "%s=%s", key, value)
+	}
+	assert.False(t, instanceType.Requirements.Has(v1.LabelTopologyZone))
+
+	// the zones are not known offline, so there is an offering for each capacity type.
+	assert.Len(t, instanceType.Offerings, 2)
+	for _, o := range instanceType.Offerings {
+		assert.InDelta(t, lo.Ternary(o.CapacityType() == karpenterv1.CapacityTypeSpot, 14.692*0.25, 14.692), o.Price, 1e-9)
+		assert.Equal(t, o.CapacityType() == karpenterv1.CapacityTypeOnDemand, o.Available)
+	}
+	pods := findInstanceType(p.List(context.Background(), nil), "Standard_NC96ads_A100_v4").Capacity[v1.ResourcePods]
+	assert.Equal(t, "110", pods.String())
+}
+
+func TestListWithDynamicSKUCache(t *testing.T) {
+	zoneRestriction := ResourceSKURestriction{
+		Type: "Zone", ReasonCode: restrictionReasonNotAvailableForSubscription,
+		RestrictionInfo: ResourceSKURestrictionInfo{Locations: []string{"eastus"}, Zones: []string{"3"}},
+	}
+	locationRestriction := ResourceSKURestriction{
+		Type: "Location", ReasonCode: restrictionReasonNotAvailableForSubscription,
+		RestrictionInfo: ResourceSKURestrictionInfo{Locations: []string{"eastus"}},
+	}
+	skuClient := &fakeResourceSKUsAPI{skus: []ResourceSKU{
+		resourceSKU("standard_nc24ads_a100_v4", "1", []string{"1", "2", "3"}, zoneRestriction),
+		resourceSKU("Standard_ND96asr_v4", "8", []string{"1"}, locationRestriction),
+		resourceSKU("Standard_NC24ads_B200_v6", "1", nil),
+		resourceSKU("Standard_D4s_v3", "0", []string{"1"}),
+		{Name: "Standard_NC24ads_A100_v4", ResourceType: "disks"},
+	}}
+	p := NewProvider("eastus", skuClient, 0.4, cache.NewUnavailableOfferings())
+
+	instanceTypes := p.List(context.Background(), nil)
+	assert.Equal(t, []string{"Standard_NC24ads_A100_v4", "Standard_NC24ads_B200_v6"},
+		lo.Map(instanceTypes, func(it *cloudprovider.InstanceType, _ int) string { return it.Name }))
+
+	a100 := instanceTypes[0]
+	assert.ElementsMatch(t, []string{"eastus-1", "eastus-2"}, a100.Requirements.Get(v1.LabelTopologyZone).Values())
+	assert.Len(t, a100.Offerings, 4)
+	assert.True(t, a100.Requirements.Get(LabelGPUProduct).Has("A100"))
+
+	// the gpu sku which is not in the catalog has no gpu model and price.
+	b200 := instanceTypes[1]
+	assert.False(t, b200.Requirements.Has(LabelGPUProduct))
+	assert.False(t, b200.Requirements.Has(LabelGPUVendor))
+	assert.False(t, b200.Requirements.Has(LabelGPUNVLink))
+	assert.True(t, b200.Requirements.Get(LabelAcceleratedNetworking).Has("true"))
+	assert.True(t, b200.Requirements.Get(LabelGPUCount).Has("1"))
+	assert.Equal(t, 0.0, b200.Offerings[0].Price)
+	_, found := b200.Capacity["nvidia.com/gpu"]
+	assert.False(t, found)
+
+	sku, ok := p.Get(context.Background(), "STANDARD_NC24ADS_A100_V4")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"1", "2"}, sku.Zones)
+	_, ok = p.Get(context.Background(), "Standard_ND96asr_v4")
+	assert.False(t, ok)
+	assert.Equal(t, 1, skuClient.calls, "the refreshed skus should be cached")
+}
+
+func TestListWithDynamicSKUCacheFailure(t *testing.T) {
+	skuClient := &fakeResourceSKUsAPI{err: fmt.Errorf("throttled")}
+	p := NewProvider("eastus", skuClient, 0.4, cache.NewUnavailableOfferings())
+
+	assert.Len(t, p.List(context.Background(), nil), len(offlineSKUs))
+	assert.Len(t, p.List(context.Background(), nil), len(offlineSKUs))
+	assert.Equal(t, 1, skuClient.calls, "the failed refresh should not be retried immediately")
+}
+
+func TestResolve(t *testing.T) {
+	testCases := []struct {
+		name         string
+		requirements []v1.NodeSelectorRequirement
+		requests     v1.ResourceList
+		expected     []string
+	}{
+		{
+			name:         "gpu resource request and per-gpu memory",
+			requirements: []v1.NodeSelectorRequirement{{Key: LabelGPUMemoryGiB, Operator: v1.NodeSelectorOpGt, Values: []string{"79"}}},
+			requests:     v1.ResourceList{"nvidia.com/gpu": resource.MustParse("2"), v1.ResourceStorage: resource.MustParse("100Gi")},
+			expected: []string{"Standard_NC48ads_A100_v4", "Standard_NC80adis_H100_v5", "Standard_NC96ads_A100_v4",
+				"Standard_ND96amsr_A100_v4", "Standard_ND96isr_H100_v5"},
+		},
+		{
+			name: "gpu count and product requirements",
+			requirements: []v1.NodeSelectorRequirement{
+				{Key: LabelGPUCount, Operator: v1.NodeSelectorOpIn, Values: []string{"8"}},
+				{Key: LabelGPUProduct, Operator: v1.NodeSelectorOpIn, Values: []string{"H100"}},
+			},
+			expected: []string{"Standard_ND96isr_H100_v5"},
+		},
+		{
+			name: "requirements on other labels are honored",
+			requirements: []v1.NodeSelectorRequirement{
+				{Key: LabelGPUCount, Operator: v1.NodeSelectorOpGt, Values: []string{"1"}},
+				{Key: LabelGPUProduct, Operator: v1.NodeSelectorOpIn, Values: []string{"A10", "V100"}},
+				{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpNotIn, Values: []string{"Standard_NC12s_v3"}},
+				{Key: karpenterv1.CapacityTypeLabelKey, Operator: v1.NodeSelectorOpIn, Values: []string{karpenterv1.CapacityTypeSpot}},
+				{Key: "kaito.sh/workspace", Operator: v1.NodeSelectorOpIn, Values: []string{"falcon-7b"}},
+			},
+			expected: []string{"Standard_NV72ads_A10_v5", "Standard_NC24s_v3", "Standard_NC24rs_v3", "Standard_ND40rs_v2"},
+		},
+		{
+			name:         "no sku matches",
+			requirements: []v1.NodeSelectorRequirement{{Key: LabelGPUProduct, Operator: v1.NodeSelectorOpIn, Values: []string{"MI300X"}}},
+			expected:     []string{},
+		},
+	}
+
+	p := NewProvider("eastus", nil, 0.4, cache.NewUnavailableOfferings())
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			skus := p.Resolve(context.Background(), scheduling.NewNodeSelectorRequirements(tc.requirements...), tc.requests, "")
+			assert.Equal(t, tc.expected, lo.Map(skus, func(sku SKU, _ int) string { return sku.Name }))
+		})
+	}
+}
+
+func TestResolveWithDynamicSKUCache(t *testing.T) {
+	skuClient := &fakeResourceSKUsAPI{skus: []ResourceSKU{
+		resourceSKU("Standard_NC24ads_B200_v6", "1", []string{"1"}),
+		resourceSKU("Standard_NC24ads_A100_v4", "1", []string{"1", "3"}),
+		resourceSKU("Standard_NC40ads_H100_v5", "1", []string{"2"}),
+	}}
+	p := NewProvider("eastus", skuClient, 0.4, cache.NewUnavailableOfferings())
+	names := func(skus []SKU) []string { return lo.Map(skus, func(sku SKU, _ int) string { return sku.Name }) }
+
+	// the sku without price is ordered last.
+	countRequirement := scheduling.NewRequirement(LabelGPUCount, v1.NodeSelectorOpIn, "1")
+	assert.Equal(t, []string{"Standard_NC24ads_A100_v4", "Standard_NC40ads_H100_v5", "Standard_NC24ads_B200_v6"},
+		names(p.Resolve(context.Background(), scheduling.NewRequirements(countRequirement), nil, "")))
+	// the sku whose gpu product is unknown doesn't satisfy the product requirement.
+	assert.Equal(t, []string{"Standard_NC24ads_A100_v4", "Standard_NC40ads_H100_v5"},
+		names(p.Resolve(context.Background(), scheduling.NewRequirements(countRequirement, scheduling.NewRequirement(LabelGPUProduct, v1.NodeSelectorOpExists)), nil, "")))
+	// the GPUs partitioned by MIG satisfy the requests of more GPU resources than the physical GPUs.
+	assert.Equal(t, []string{"Standard_NC24ads_A100_v4", "Standard_NC40ads_H100_v5"},
+		names(p.Resolve(context.Background(), scheduling.NewRequirements(countRequirement, scheduling.NewRequirement(LabelGPUProduct, v1.NodeSelectorOpExists)),
+			v1.ResourceList{"nvidia.com/gpu": resource.MustParse("3")}, "MIG2g")))
+	// the sku which isn't offered in the required zone is skipped.
+	assert.Equal(t, []string{"Standard_NC40ads_H100_v5"},
+		names(p.Resolve(context.Background(), scheduling.NewRequirements(countRequirement, scheduling.NewRequirement(v1.LabelTopologyZone, v1.NodeSelectorOpIn, "eastus-2"),
+			scheduling.NewRequirement(LabelGPUVendor, v1.NodeSelectorOpIn, GPUVendorNVIDIA)), nil, "")))
+}
+
+func TestHasGPURequest(t *testing.T) {
+	assert.True(t, HasGPURequest(scheduling.NewRequirements(scheduling.NewRequirement(LabelGPUProduct, v1.NodeSelectorOpIn, "A100")), nil))
+	assert.True(t, HasGPURequest(scheduling.NewRequirements(), v1.ResourceList{"amd.com/gpu": resource.MustParse("1")}))
+	assert.False(t, HasGPURequest(scheduling.NewRequirements(scheduling.NewRequirement(v1.LabelOSStable, v1.NodeSelectorOpIn, "linux")),
+		v1.ResourceList{v1.ResourceStorage: resource.MustParse("30Gi"), "nvidia.com/gpu": resource.MustParse("0")}))
+}
+
+func TestNodeResources(t *testing.T) {
+	p := NewProvider("eastus", nil, 0.4, cache.NewUnavailableOfferings())
+
+	capacity, allocatable, ok := p.NodeResources(context.Background(), "standard_nc24ads_a100_v4", 30, 128, "")
+	if !assert.True(t, ok) {
+		t.FailNow()
+	}
+	assert.Equal(t, map[v1.ResourceName]string{
+		v1.ResourceCPU:              "24",
+		v1.ResourceMemory:           "220Gi",
+		v1.ResourcePods:             "30",
+		v1.ResourceEphemeralStorage: "128Gi",
+		"nvidia.com/gpu":            "1",
+	}, quantities(capacity))
+	// kube-reserved cpu is 340m, memory is 20Mi * 30 pods + 50Mi, and the eviction thresholds are 100Mi memory and
+	// 10% of the os disk.
+	assert.Equal(t, map[v1.ResourceName]string{
+		v1.ResourceCPU:              "23660m",
+		v1.ResourceMemory:           "224530Mi",
+		v1.ResourcePods:             "30",
+		v1.ResourceEphemeralStorage: "123695058125",
+		"nvidia.com/gpu":            "1",
+	}, quantities(allocatable))
+
+	// the default max pods is used and the ephemeral storage is omitted when they're unknown.
+	capacity, _, ok = p.NodeResources(context.Background(), "Standard_NC24ads_A100_v4", 0, 0, "")
+	assert.True(t, ok)
+	assert.Equal(t, "110", lo.ToPtr(capacity[v1.ResourcePods]).String())
+	assert.NotContains(t, capacity, v1.ResourceEphemeralStorage)
+
+	// every MIG instance of the partitioned GPUs is a GPU resource, e.g. 7 MIG1g instances for each of 8 A100 GPUs.
+	capacity, allocatable, ok = p.NodeResources(context.Background(), "Standard_ND96asr_v4", 0, 0, "MIG1g")
+	assert.True(t, ok)
+	assert.Equal(t, "56", lo.ToPtr(capacity["nvidia.com/gpu"]).String())
+	assert.Equal(t, "56", lo.ToPtr(allocatable["nvidia.com/gpu"]).String())
+	capacity, _, _ = p.NodeResources(context.Background(), "Standard_ND96asr_v4", 0, 0, "MIG3g")
+	assert.Equal(t, "16", lo.ToPtr(capacity["nvidia.com/gpu"]).String())
+
+	_, _, ok = p.NodeResources(context.Background(), "Standard_D4s_v3", 30, 128, "")
+	assert.False(t, ok)
+}
+
+func TestOverhead(t *testing.T) {
+	for vcpus, expected := range map[int64]int64{1: 60, 2: 100, 4: 140, 8: 180, 16: 260, 32: 420, 64: 740} {
+		assert.Equal(t, expected, kubeReservedCPU(vcpus), "%d vcpus", vcpus)
+	}
+
+	// the memory reserved for kubelet is capped at 25% of the node memory.
+	o := overhead(SKU{VCPUs: 2, MemoryGiB: 2}, 110, 0)
+	assert.Equal(t, "512Mi", lo.ToPtr(o.KubeReserved[v1.ResourceMemory]).String())
+	assert.NotContains(t, o.EvictionThreshold, v1.ResourceEphemeralStorage)
+}
@@ -0,0 +1,69 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instancetype
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/karpenter/pkg/cloudprovider"
+)
+
+// The resource reservations of AKS nodes, Kubernetes 1.29 and later.
+// https://learn.microsoft.com/en-us/azure/aks/node-resource-reservations
+const (
+	// the memory reserved for kubelet is 20Mi per pod plus 50Mi, and at most 25% of the node memory.
+	kubeReservedMemoryPerPod  = 20 * 1024 * 1024
+	kubeReservedMemoryBase    = 50 * 1024 * 1024
+	kubeReservedMemoryMaxRate = 0.25
+
+	// evictionMemoryAvailable is the hard eviction threshold memory.available<100Mi.
+	evictionMemoryAvailable = 100 * 1024 * 1024
+	// evictionNodeFSAvailablePercent is the hard eviction threshold nodefs.available<10%.
+	evictionNodeFSAvailablePercent = 10
+)
+
+// overhead returns the resources reserved by AKS on the node of sku, they're subtracted from the capacity for the
+// allocatable resources.
+func overhead(sku SKU, maxPods int64, osDiskSizeGB int64) *cloudprovider.InstanceTypeOverhead {
+	memory := int64(sku.MemoryGiB*1024) * 1024 * 1024
+	evictionThreshold := v1.ResourceList{
+		v1.ResourceMemory: *resource.NewQuantity(evictionMemoryAvailable, resource.BinarySI),
+	}
+	if osDiskSizeGB > 0 {
+		evictionThreshold[v1.ResourceEphemeralStorage] = *resource.NewQuantity(osDiskSizeGB<<30*evictionNodeFSAvailablePercent/100, resource.BinarySI)
+	}
+	return &cloudprovider.InstanceTypeOverhead{
+		KubeReserved: v1.ResourceList{
+			v1.ResourceCPU:    *resource.NewMilliQuantity(kubeReservedCPU(sku.VCPUs), resource.DecimalSI),
+			v1.ResourceMemory: *resource.NewQuantity(min(kubeReservedMemoryPerPod*maxPods+kubeReservedMemoryBase, int64(float64(memory)*kubeReservedMemoryMaxRate)), resource.BinarySI),
+		},
+		SystemReserved:    v1.ResourceList{},
+		EvictionThreshold: evictionThreshold,
+	}
+}
+
+// kubeReservedCPU returns the milli cores reserved for kubelet on the node with vcpus, e.g. 60m for 1 core, 100m for
+// 2 cores, 140m for 4 cores, and 10m more for each core beyond 4 cores.
+func kubeReservedCPU(vcpus int64) int64 {
+	switch {
+	case vcpus <= 1:
+		return 60
+	case vcpus < 4:
+		return 100 + 20*(vcpus-2)
+	default:
+		return 140 + 10*(vcpus-4)
+	}
+}
@@ -0,0 +1,186 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instancetype
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/samber/lo"
+)
+
+const (
+	resourceSKUsAPIVersion = "2021-07-01"
+	// restrictionReasonNotAvailableForSubscription means the SKU or its zones can't be used by the subscription.
+	restrictionReasonNotAvailableForSubscription = "NotAvailableForSubscription"
+)
+
+// ResourceSKUsAPI lists the compute resource SKUs available in a location.
+type ResourceSKUsAPI interface {
+	List(ctx context.Context, location string) ([]ResourceSKU, error)
+}
+
+// ResourceSKU is the subset of compute resource SKU returned by Resource SKUs API which is used by the SKU catalog.
+// armcompute is not vendored, so the response is decoded into these types.
+type ResourceSKU struct {
+	Name         string                    `json:"name"`
+	ResourceType string                    `json:"resourceType"`
+	Family       string                    `json:"family"`
+	LocationInfo []ResourceSKULocationInfo `json:"locationInfo"`
+	Capabilities []ResourceSKUCapability   `json:"capabilities"`
+	Restrictions []ResourceSKURestriction  `json:"restrictions"`
+}
+
+type ResourceSKULocationInfo struct {
+	Location string   `json:"location"`
+	Zones    []string `json:"zones"`
+}
+
+type ResourceSKUCapability struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type ResourceSKURestriction struct {
+	// Type is Location or Zone.
+	Type            string                     `json:"type"`
+	ReasonCode      string                     `json:"reasonCode"`
+	RestrictionInfo ResourceSKURestrictionInfo `json:"restrictionInfo"`
+}
+
+type ResourceSKURestrictionInfo struct {
+	Locations []string `json:"locations"`
+	Zones     []string `json:"zones"`
+}
+
+type resourceSKUListResult struct {
+	Value    []ResourceSKU `json:"value"`
+	NextLink string        `json:"nextLink"`
+}
+
+type resourceSKUsClient struct {
+	subscriptionID string
+	internal       *arm.Client
+}
+
+// NewResourceSKUsClient creates the client of Resource SKUs API with the credential and options of the other ARM
+// clients.
+func NewResourceSKUsClient(subscriptionID string, credential azcore.TokenCredential, options *arm.ClientOptions) (ResourceSKUsAPI, error) {
+	cl, err := arm.NewClient("github.com/azure/gpu-provisioner/pkg/providers/instancetype", "v0.1.0", credential, options)
+	if err != nil {
+		return nil, err
+	}
+	return &resourceSKUsClient{subscriptionID: subscriptionID, internal: cl}, nil
+}
+
+// List returns the compute resource SKUs in location, all pages are returned.
+func (c *resourceSKUsClient) List(ctx context.Context, location string) ([]ResourceSKU, error) {
+	urlPath := fmt.Sprintf("/subscriptions/%s/providers/Microsoft.Compute/skus", url.PathEscape(c.subscriptionID))
+	next := runtime.JoinPaths(c.internal.Endpoint(), urlPath)
+	var skus []ResourceSKU
+	for first := true; next != ""; first = false {
+		req, err := runtime.NewRequest(ctx, http.MethodGet, next)
+		if err != nil {
+			return nil, err
+		}
+		if first {
+			qp := req.Raw().URL.Query()
+			qp.Set("api-version", resourceSKUsAPIVersion)
+			qp.Set("$filter", fmt.Sprintf("location eq '%s'", location))
+			req.Raw().URL.RawQuery = qp.Encode()
+		}
+		req.Raw().Header["Accept"] = []string{"application/json"}
+		resp, err := c.internal.Pipeline().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if !runtime.HasStatusCode(resp, http.StatusOK) {
+			return nil, runtime.NewResponseError(resp)
+		}
+		result := resourceSKUListResult{}
+		if err := runtime.UnmarshalAsJSON(resp, &result); err != nil {
+			return nil, err
+		}
+		skus = append(skus, result.Value...)
+		next = result.NextLink
+	}
+	return skus, nil
+}
+
+// mergeResourceSKUs returns the GPU SKUs of the catalog which are available to the subscription in location. The
+// capabilities and zones of the SKUs are taken from resourceSKUs, and the GPU SKUs which are not in the catalog are
+// added without GPU model and price.
+func mergeResourceSKUs(catalog map[string]SKU, resourceSKUs []ResourceSKU, location string) map[string]SKU {
+	merged := map[string]SKU{}
+	for _, r := range resourceSKUs {
+		if r.ResourceType != "virtualMachines" {
+			continue
+		}
+		capabilities := lo.SliceToMap(r.Capabilities, func(c ResourceSKUCapability) (string, string) { return c.Name, c.Value })
+		gpus, _ := strconv.ParseInt(capabilities["GPUs"], 10, 64)
+		if gpus <= 0 {
+			continue
+		}
+		locationInfo, found := lo.Find(r.LocationInfo, func(l ResourceSKULocationInfo) bool { return strings.EqualFold(l.Location, location) })
+		if !found || isRestricted(r, location) {
+			continue
+		}
+
+		sku, ok := catalog[skuKey(r.Name)]
+		if !ok {
+			sku = SKU{Name: r.Name, Family: r.Family}
+		}
+		sku.GPU.Count = gpus
+		if vcpus, err := strconv.ParseInt(capabilities["vCPUs"], 10, 64); err == nil {
+			sku.VCPUs = vcpus
+		}
+		if memory, err := strconv.ParseFloat(capabilities["MemoryGB"], 64); err == nil {
+			sku.MemoryGiB = memory
+		}
+		sku.AcceleratedNetworking = strings.EqualFold(capabilities["AcceleratedNetworkingEnabled"], "True")
+		sku.InfiniBand = strings.EqualFold(capabilities["RdmaEnabled"], "True")
+		sku.Zones = lo.Without(locationInfo.Zones, restrictedZones(r, location)...)
+		merged[skuKey(r.Name)] = sku
+	}
+	return merged
+}
+
+// isRestricted returns true when the SKU is not available to the subscription in location.
+func isRestricted(r ResourceSKU, location string) bool {
+	return lo.ContainsBy(r.Restrictions, func(restriction ResourceSKURestriction) bool {
+		return restriction.Type == "Location" && restriction.ReasonCode == restrictionReasonNotAvailableForSubscription &&
+			lo.ContainsBy(restriction.RestrictionInfo.Locations, func(l string) bool { return strings.EqualFold(l, location) })
+	})
+}
+
+// restrictedZones returns the zones of location where the SKU is not available to the subscription.
+func restrictedZones(r ResourceSKU, location string) []string {
+	var zones []string
+	for _, restriction := range r.Restrictions {
+		if restriction.Type == "Zone" && restriction.ReasonCode == restrictionReasonNotAvailableForSubscription &&
+			lo.ContainsBy(restriction.RestrictionInfo.Locations, func(l string) bool { return strings.EqualFold(l, location) }) {
+			zones = append(zones, restriction.RestrictionInfo.Zones...)
+		}
+	}
+	return zones
+}
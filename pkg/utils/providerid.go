@@ -0,0 +1,76 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// vmss node: azure:///subscriptions/<sub>/resourceGroups/<rg>/providers/Microsoft.Compute/virtualMachineScaleSets/aks-<pool>-<hash>-vmss/virtualMachines/<instance>
+	// standalone vm node of VirtualMachines agent pool or availability set: azure:///subscriptions/<sub>/resourceGroups/<rg>/providers/Microsoft.Compute/virtualMachines/aks-<pool>-<hash>-<suffix>
+	providerIDRegex = regexp.MustCompile(`(?i)^azure:///subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft\.Compute/(?:virtualMachineScaleSets/([^/]+)/virtualMachines/([^/]+)|virtualMachines/([^/]+))$`)
+)
+
+// ProviderID is the structured provider id of an AKS node.
+type ProviderID struct {
+	SubscriptionID string
+	// ResourceGroup is the node resource group of the cluster.
+	ResourceGroup string
+	// ScaleSetName and InstanceID are set for the node backed by a vmss instance.
+	ScaleSetName string
+	InstanceID   string
+	// VMName is set for the node backed by a standalone vm, which is created by VirtualMachines agent pool
+	// or in an availability set.
+	VMName string
+	// AgentPoolName is parsed from the vmss or vm name which is in the format aks-<agentpool>-<hash>-<suffix>.
+	AgentPoolName string
+}
+
+// IsScaleSetVM returns true when the node is backed by a vmss instance.
+func (p *ProviderID) IsScaleSetVM() bool {
+	return p.ScaleSetName != ""
+}
+
+// ParseProviderID parses the provider id of vmss, standalone vm and availability set nodes case-insensitively.
+func ParseProviderID(id string) (*ProviderID, error) {
+	matches := providerIDRegex.FindStringSubmatch(strings.TrimSpace(id))
+	if matches == nil {
+		return nil, fmt.Errorf("provider id %q is not a valid azure vmss or vm provider id", id)
+	}
+
+	parsed := &ProviderID{
+		SubscriptionID: matches[1],
+		ResourceGroup:  matches[2],
+		ScaleSetName:   matches[3],
+		InstanceID:     matches[4],
+		VMName:         matches[5],
+	}
+	resourceName := parsed.VMName
+	if parsed.IsScaleSetVM() {
+		resourceName = parsed.ScaleSetName
+	}
+
+	// agent pool name only contains lowercase letters and numbers, so it's the second token of the resource name
+	tokens := strings.Split(resourceName, "-")
+	if len(tokens) < 3 || !strings.EqualFold(tokens[0], "aks") || tokens[1] == "" {
+		return nil, fmt.Errorf("cannot parse agentpool name from %q of provider id %q", resourceName, id)
+	}
+	parsed.AgentPoolName = strings.ToLower(tokens[1])
+	return parsed, nil
+}
@@ -0,0 +1,102 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProviderID(t *testing.T) {
+	testCases := []struct {
+		name          string
+		id            string
+		expected      *ProviderID
+		expectedError bool
+	}{
+		{
+			name: "vmss node",
+			id:   "azure:///subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/nodeRG/providers/Microsoft.Compute/virtualMachineScaleSets/aks-agentpool0-20562481-vmss/virtualMachines/0",
+			expected: &ProviderID{
+				SubscriptionID: "00000000-0000-0000-0000-000000000000",
+				ResourceGroup:  "nodeRG",
+				ScaleSetName:   "aks-agentpool0-20562481-vmss",
+				InstanceID:     "0",
+				AgentPoolName:  "agentpool0",
+			},
+		},
+		{
+			name: "vmss node with different casing",
+			id:   "azure:///subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/MC_rg_cluster_eastus/providers/microsoft.compute/virtualmachinescalesets/AKS-GPUPOOL-20562481-VMSS/virtualmachines/12",
+			expected: &ProviderID{
+				SubscriptionID: "00000000-0000-0000-0000-000000000000",
+				ResourceGroup:  "MC_rg_cluster_eastus",
+				ScaleSetName:   "AKS-GPUPOOL-20562481-VMSS",
+				InstanceID:     "12",
+				AgentPoolName:  "gpupool",
+			},
+		},
+		{
+			name: "standalone vm node of VirtualMachines agent pool",
+			id:   "azure:///subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/nodeRG/providers/Microsoft.Compute/virtualMachines/aks-ws0a1b2c3d4e-12345678-vm0",
+			expected: &ProviderID{
+				SubscriptionID: "00000000-0000-0000-0000-000000000000",
+				ResourceGroup:  "nodeRG",
+				VMName:         "aks-ws0a1b2c3d4e-12345678-vm0",
+				AgentPoolName:  "ws0a1b2c3d4e",
+			},
+		},
+		{
+			name: "availability set node",
+			id:   "azure:///subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/nodeRG/providers/Microsoft.Compute/virtualMachines/aks-nodepool1-12345678-0",
+			expected: &ProviderID{
+				SubscriptionID: "00000000-0000-0000-0000-000000000000",
+				ResourceGroup:  "nodeRG",
+				VMName:         "aks-nodepool1-12345678-0",
+				AgentPoolName:  "nodepool1",
+			},
+		},
+		{
+			name:          "vmss without instance",
+			id:            "azure:///subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/nodeRG/providers/Microsoft.Compute/virtualMachineScaleSets/virtualMachines/0",
+			expectedError: true,
+		},
+		{
+			name:          "vm name is not created by aks",
+			id:            "azure:///subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/nodeRG/providers/Microsoft.Compute/virtualMachines/myvm",
+			expectedError: true,
+		},
+		{
+			name:          "not an azure provider id",
+			id:            "aws:///us-west-2a/i-0123456789abcdef0",
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			parsed, err := ParseProviderID(tc.id)
+			if tc.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, parsed)
+			assert.Equal(t, tc.expected.ScaleSetName != "", parsed.IsScaleSetVM())
+		})
+	}
+}
@@ -0,0 +1,59 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+)
+
+var (
+	subnetResourceType                   = arm.NewResourceType("Microsoft.Network", "virtualNetworks/subnets")
+	applicationSecurityGroupResourceType = arm.NewResourceType("Microsoft.Network", "applicationSecurityGroups")
+)
+
+// ParseSubnetID parses the ARM ID of a virtual network subnet, such as
+// /subscriptions/<sub>/resourceGroups/<rg>/providers/Microsoft.Network/virtualNetworks/<vnet>/subnets/<subnet>.
+func ParseSubnetID(id string) (*arm.ResourceID, error) {
+	return parseResourceID("subnet", id, subnetResourceType)
+}
+
+// ParseApplicationSecurityGroupID parses the ARM ID of an application security group, such as
+// /subscriptions/<sub>/resourceGroups/<rg>/providers/Microsoft.Network/applicationSecurityGroups/<asg>.
+func ParseApplicationSecurityGroupID(id string) (*arm.ResourceID, error) {
+	return parseResourceID("application security group", id, applicationSecurityGroupResourceType)
+}
+
+// InSameVirtualNetwork returns true when both subnets belong to the same virtual network.
+func InSameVirtualNetwork(subnet, other *arm.ResourceID) bool {
+	return strings.EqualFold(subnet.Parent.String(), other.Parent.String())
+}
+
+func parseResourceID(kind, id string, resourceType arm.ResourceType) (*arm.ResourceID, error) {
+	parsed, err := arm.ParseResourceID(id)
+	if err != nil {
+		return nil, fmt.Errorf("%s id %q is not a valid ARM resource id, %w", kind, id, err)
+	}
+	if !strings.EqualFold(parsed.ResourceType.String(), resourceType.String()) {
+		return nil, fmt.Errorf("%s id %q is a %s resource, expected %s", kind, id, parsed.ResourceType, resourceType)
+	}
+	if parsed.SubscriptionID == "" || parsed.ResourceGroupName == "" {
+		return nil, fmt.Errorf("%s id %q should contain subscription and resource group", kind, id)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,97 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSubnetID(t *testing.T) {
+	testCases := []struct {
+		name           string
+		id             string
+		expectedVNet   string
+		expectedSubnet string
+		expectedError  bool
+	}{
+		{
+			name:           "valid subnet id",
+			id:             "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/gpu",
+			expectedVNet:   "vnet",
+			expectedSubnet: "gpu",
+		},
+		{
+			name:           "valid subnet id with different casing",
+			id:             "/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/rg/providers/microsoft.network/virtualnetworks/vnet/subnets/gpu",
+			expectedVNet:   "vnet",
+			expectedSubnet: "gpu",
+		},
+		{
+			name:          "virtual network id",
+			id:            "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet",
+			expectedError: true,
+		},
+		{
+			name:          "not an ARM id",
+			id:            "gpu-subnet",
+			expectedError: true,
+		},
+		{
+			name:          "empty id",
+			id:            "",
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			parsed, err := ParseSubnetID(tc.id)
+			if tc.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedSubnet, parsed.Name)
+			assert.Equal(t, tc.expectedVNet, parsed.Parent.Name)
+		})
+	}
+}
+
+func TestParseApplicationSecurityGroupID(t *testing.T) {
+	parsed, err := ParseApplicationSecurityGroupID("/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/applicationSecurityGroups/gpu-asg")
+	assert.NoError(t, err)
+	assert.Equal(t, "gpu-asg", parsed.Name)
+
+	_, err = ParseApplicationSecurityGroupID("/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/networkSecurityGroups/gpu-nsg")
+	assert.ErrorContains(t, err, "expected Microsoft.Network/applicationSecurityGroups")
+
+	_, err = ParseApplicationSecurityGroupID("gpu-asg")
+	assert.Error(t, err)
+}
+
+func TestInSameVirtualNetwork(t *testing.T) {
+	nodeSubnet, err := ParseSubnetID("/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/nodes")
+	assert.NoError(t, err)
+	podSubnet, err := ParseSubnetID("/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/RG/providers/Microsoft.Network/virtualNetworks/VNET/subnets/pods")
+	assert.NoError(t, err)
+	otherSubnet, err := ParseSubnetID("/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/other/subnets/pods")
+	assert.NoError(t, err)
+
+	assert.True(t, InSameVirtualNetwork(nodeSubnet, podSubnet))
+	assert.False(t, InSameVirtualNetwork(nodeSubnet, otherSubnet))
+}
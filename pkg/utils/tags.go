@@ -0,0 +1,73 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// https://learn.microsoft.com/en-us/azure/azure-resource-manager/management/tag-resources#limitations
+const (
+	MaxTagCount        = 50
+	MaxTagKeyLength    = 512
+	MaxTagValueLength  = 256
+	tagKeyInvalidChars = `<>%&\?/`
+)
+
+var reservedTagKeyPrefixes = []string{"microsoft", "azure", "windows"}
+
+// ValidateTags checks tags against the limits of Azure Resource Manager, the keys are checked in sorted order so the
+// error is deterministic.
+func ValidateTags(tags map[string]string) error {
+	if len(tags) > MaxTagCount {
+		return fmt.Errorf("%d tags are specified, at most %d tags are allowed", len(tags), MaxTagCount)
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := ValidateTag(k, tags[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateTag checks a single tag against the limits of Azure Resource Manager.
+func ValidateTag(key, value string) error {
+	if key == "" {
+		return fmt.Errorf("tag key should not be empty")
+	}
+	if len(key) > MaxTagKeyLength {
+		return fmt.Errorf("tag key %q is longer than %d characters", key, MaxTagKeyLength)
+	}
+	if strings.ContainsAny(key, tagKeyInvalidChars) {
+		return fmt.Errorf("tag key %q should not contain any of %q", key, tagKeyInvalidChars)
+	}
+	for _, prefix := range reservedTagKeyPrefixes {
+		if strings.HasPrefix(strings.ToLower(key), prefix) {
+			return fmt.Errorf("tag key %q should not start with the reserved prefix %q", key, prefix)
+		}
+	}
+	if len(value) > MaxTagValueLength {
+		return fmt.Errorf("value of tag %q is longer than %d characters", key, MaxTagValueLength)
+	}
+	return nil
+}
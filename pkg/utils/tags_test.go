@@ -0,0 +1,83 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTags(t *testing.T) {
+	tooMany := map[string]string{}
+	for i := 0; i <= MaxTagCount; i++ {
+		tooMany[fmt.Sprintf("tag%d", i)] = "value"
+	}
+
+	testCases := []struct {
+		name          string
+		tags          map[string]string
+		expectedError string
+	}{
+		{
+			name: "valid tags",
+			tags: map[string]string{"team": "ml", "cost-center": "1234", "empty": ""},
+		},
+		{
+			name:          "too many tags",
+			tags:          tooMany,
+			expectedError: "51 tags are specified, at most 50 tags are allowed",
+		},
+		{
+			name:          "empty key",
+			tags:          map[string]string{"": "value"},
+			expectedError: "tag key should not be empty",
+		},
+		{
+			name:          "key is too long",
+			tags:          map[string]string{strings.Repeat("k", MaxTagKeyLength+1): "value"},
+			expectedError: "is longer than 512 characters",
+		},
+		{
+			name:          "key contains invalid characters",
+			tags:          map[string]string{"team/ml": "value"},
+			expectedError: `tag key "team/ml" should not contain any of`,
+		},
+		{
+			name:          "key starts with reserved prefix",
+			tags:          map[string]string{"Microsoft.Owner": "value"},
+			expectedError: `tag key "Microsoft.Owner" should not start with the reserved prefix "microsoft"`,
+		},
+		{
+			name:          "value is too long",
+			tags:          map[string]string{"team": strings.Repeat("v", MaxTagValueLength+1)},
+			expectedError: `value of tag "team" is longer than 256 characters`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateTags(tc.tags)
+			if tc.expectedError == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tc.expectedError)
+		})
+	}
+}
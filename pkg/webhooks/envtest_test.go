@@ -0,0 +1,184 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/apis/v1beta1"
+	"github.com/azure/gpu-provisioner/pkg/providers/instance"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+// TestWebhooksWithEnvtest runs the webhooks behind a real API server, it requires the envtest binaries which are
+// installed by setup-envtest, e.g. KUBEBUILDER_ASSETS=$(setup-envtest use -p path) go test ./pkg/webhooks/...
+func TestWebhooksWithEnvtest(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set, skipping the envtest based webhook test")
+	}
+
+	// karpenter registers its types into the client-go scheme when the package is imported
+	scheme := clientgoscheme.Scheme
+	if !assert.NoError(t, v1alpha1.SchemeBuilder.AddToScheme(scheme)) || !assert.NoError(t, v1beta1.SchemeBuilder.AddToScheme(scheme)) {
+		t.FailNow()
+	}
+
+	env := &envtest.Environment{
+		CRDDirectoryPaths: []string{
+			filepath.Join("..", "..", "charts", "gpu-provisioner", "crds"),
+			filepath.Join("..", "apis", "crds"),
+		},
+		ErrorIfCRDPathMissing: true,
+		WebhookInstallOptions: envtest.WebhookInstallOptions{
+			ValidatingWebhooks: []*admissionregistrationv1.ValidatingWebhookConfiguration{validatingWebhookConfiguration()},
+		},
+	}
+	cfg, err := env.Start()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer func() { assert.NoError(t, env.Stop()) }()
+
+	kubeClient, err := client.New(cfg, client.Options{Scheme: scheme})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := webhook.NewServer(webhook.Options{
+		Host:    env.WebhookInstallOptions.LocalServingHost,
+		Port:    env.WebhookInstallOptions.LocalServingPort,
+		CertDir: env.WebhookInstallOptions.LocalServingCertDir,
+	})
+	Register(server, scheme, kubeClient)
+	go func() { _ = server.Start(ctx) }()
+	assert.Eventually(t, func() bool {
+		return server.StartedChecker()(&http.Request{}) == nil
+	}, 10*time.Second, 100*time.Millisecond)
+
+	t.Run("invalid KaitoNodeClass is rejected", func(t *testing.T) {
+		nodeClass := &v1alpha1.KaitoNodeClass{ObjectMeta: metav1.ObjectMeta{Name: "invalid"}, Spec: v1alpha1.KaitoNodeClassSpec{
+			Tags: map[string]string{instance.NodeClaimNameTag: "other"},
+		}}
+		err := kubeClient.Create(ctx, nodeClass)
+		assert.True(t, apierrors.IsInvalid(err), "expected an Invalid error, got %v", err)
+		assert.ErrorContains(t, err, `tag "kaito-nodeclaim" is reserved by gpu-provisioner`)
+	})
+
+	t.Run("nodeclaim referencing KaitoNodeClass with os disk size is allowed", func(t *testing.T) {
+		nodeClass := &v1alpha1.KaitoNodeClass{ObjectMeta: metav1.ObjectMeta{Name: "default"}, Spec: v1alpha1.KaitoNodeClassSpec{
+			OSDiskSizeGB: lo.ToPtr(int32(128)),
+		}}
+		if !assert.NoError(t, kubeClient.Create(ctx, nodeClass)) {
+			t.FailNow()
+		}
+
+		nodeClaim := newNodeClaim("kaito-workspace-0", "", nil, &karpenterv1.NodeClassReference{Group: v1alpha1.Group, Kind: "KaitoNodeClass", Name: "default"})
+		assert.NoError(t, kubeClient.Create(ctx, nodeClaim))
+	})
+
+	t.Run("KaitoNodeClass created at v1beta1 is converted to v1alpha1", func(t *testing.T) {
+		nodeClass := &v1beta1.KaitoNodeClass{ObjectMeta: metav1.ObjectMeta{Name: "converted"}, Spec: v1beta1.KaitoNodeClassSpec{
+			OSDiskSizeGB: lo.ToPtr(int32(256)),
+			Tags:         map[string]string{"team": "kaito"},
+		}}
+		if !assert.NoError(t, kubeClient.Create(ctx, nodeClass)) {
+			t.FailNow()
+		}
+
+		converted := &v1alpha1.KaitoNodeClass{}
+		assert.NoError(t, kubeClient.Get(ctx, client.ObjectKeyFromObject(nodeClass), converted))
+		assert.Equal(t, lo.ToPtr(int32(256)), converted.Spec.OSDiskSizeGB)
+		assert.Equal(t, map[string]string{"team": "kaito"}, converted.Spec.Tags)
+	})
+
+	t.Run("invalid KaitoNodeClass created at v1beta1 is rejected", func(t *testing.T) {
+		nodeClass := &v1beta1.KaitoNodeClass{ObjectMeta: metav1.ObjectMeta{Name: "invalid-v1beta1"}, Spec: v1beta1.KaitoNodeClassSpec{
+			Tags: map[string]string{instance.NodeClaimNameTag: "other"},
+		}}
+		err := kubeClient.Create(ctx, nodeClass)
+		assert.True(t, apierrors.IsInvalid(err), "expected an Invalid error, got %v", err)
+	})
+
+	t.Run("KaitoNodeClass violating the CRD schema is rejected", func(t *testing.T) {
+		nodeClass := &v1alpha1.KaitoNodeClass{ObjectMeta: metav1.ObjectMeta{Name: "windows"}, Spec: v1alpha1.KaitoNodeClassSpec{
+			OSSKU: "Windows",
+		}}
+		err := kubeClient.Create(ctx, nodeClass)
+		assert.True(t, apierrors.IsInvalid(err), "expected an Invalid error, got %v", err)
+		assert.ErrorContains(t, err, `spec.osSKU: Unsupported value: "Windows"`)
+	})
+
+	t.Run("invalid nodeclaim is rejected", func(t *testing.T) {
+		nodeClaim := newNodeClaim("kaito-workspace-1", "30Gi", map[string]string{instance.LabelNodeImageFamily: "Windows"}, nil)
+		err := kubeClient.Create(ctx, nodeClaim)
+		assert.True(t, apierrors.IsInvalid(err), "expected an Invalid error, got %v", err)
+		assert.ErrorContains(t, err, `Unsupported value: "Windows"`)
+	})
+}
+
+func validatingWebhookConfiguration() *admissionregistrationv1.ValidatingWebhookConfiguration {
+	// the webhooks match the ones in the chart.
+	webhook := func(name, path string, rule admissionregistrationv1.Rule, failurePolicy admissionregistrationv1.FailurePolicyType,
+		operations ...admissionregistrationv1.OperationType) admissionregistrationv1.ValidatingWebhook {
+		return admissionregistrationv1.ValidatingWebhook{
+			Name:                    name,
+			AdmissionReviewVersions: []string{"v1"},
+			SideEffects:             lo.ToPtr(admissionregistrationv1.SideEffectClassNone),
+			FailurePolicy:           lo.ToPtr(failurePolicy),
+			MatchPolicy:             lo.ToPtr(admissionregistrationv1.Exact),
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{
+				Service: &admissionregistrationv1.ServiceReference{Name: "gpu-provisioner", Namespace: "default", Path: lo.ToPtr(path)},
+			},
+			Rules: []admissionregistrationv1.RuleWithOperations{{
+				Operations: operations,
+				Rule:       rule,
+			}},
+		}
+	}
+	nodeClaimRule := admissionregistrationv1.Rule{APIGroups: []string{"karpenter.sh"}, APIVersions: []string{"v1"}, Resources: []string{"nodeclaims"}}
+	return &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "validation.webhook.gpu-provisioner"},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{
+			webhook("validation.nodeclaims.karpenter.sh", NodeClaimValidationPath, nodeClaimRule,
+				admissionregistrationv1.Fail, admissionregistrationv1.Create),
+			webhook("validation.update.nodeclaims.karpenter.sh", NodeClaimValidationPath, nodeClaimRule,
+				admissionregistrationv1.Ignore, admissionregistrationv1.Update),
+			webhook("validation.kaitonodeclasses.kaito.sh", KaitoNodeClassValidationPath, admissionregistrationv1.Rule{
+				APIGroups: []string{v1alpha1.Group}, APIVersions: []string{"v1alpha1"}, Resources: []string{"kaitonodeclasses"},
+			}, admissionregistrationv1.Fail, admissionregistrationv1.Create, admissionregistrationv1.Update),
+			webhook("validation.v1beta1.kaitonodeclasses.kaito.sh", KaitoNodeClassV1Beta1ValidationPath, admissionregistrationv1.Rule{
+				APIGroups: []string{v1beta1.Group}, APIVersions: []string{"v1beta1"}, Resources: []string{"kaitonodeclasses"},
+			}, admissionregistrationv1.Fail, admissionregistrationv1.Create, admissionregistrationv1.Update),
+		},
+	}
+}
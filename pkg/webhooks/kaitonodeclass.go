@@ -0,0 +1,62 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/apis/v1beta1"
+	"github.com/azure/gpu-provisioner/pkg/providers/instance"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// KaitoNodeClassValidator rejects the KaitoNodeClasses with invalid spec. the settings depending on the cluster, such
+// as the OS SKU supported by the kubernetes version, are validated by the nodeclass status controller. The
+// KaitoNodeClasses of v1beta1 are converted to v1alpha1 and validated the same way.
+type KaitoNodeClassValidator struct{}
+
+var _ admission.CustomValidator = &KaitoNodeClassValidator{}
+
+func NewKaitoNodeClassValidator() *KaitoNodeClassValidator {
+	return &KaitoNodeClassValidator{}
+}
+
+func (v *KaitoNodeClassValidator) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	var nodeClass *v1alpha1.KaitoNodeClass
+	switch o := obj.(type) {
+	case *v1alpha1.KaitoNodeClass:
+		nodeClass = o
+	case *v1beta1.KaitoNodeClass:
+		nodeClass = &v1alpha1.KaitoNodeClass{}
+		if err := nodeClass.ConvertFrom(o); err != nil {
+			return nil, fmt.Errorf("converting kaitonodeclass(%s) from v1beta1, %w", o.Name, err)
+		}
+	default:
+		return nil, fmt.Errorf("expected a KaitoNodeClass but got %T", obj)
+	}
+	return nil, invalid(v1alpha1.SchemeGroupVersion.WithKind("KaitoNodeClass").GroupKind(), nodeClass.Name, instance.ValidateNodeClass(nodeClass))
+}
+
+func (v *KaitoNodeClassValidator) ValidateUpdate(ctx context.Context, _, newObj runtime.Object) (admission.Warnings, error) {
+	return v.ValidateCreate(ctx, newObj)
+}
+
+func (v *KaitoNodeClassValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
@@ -0,0 +1,104 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/providers/instance"
+	"github.com/samber/lo"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/karpenter/pkg/apis"
+	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+var nodeClaimKind = schema.GroupKind{Group: apis.Group, Kind: "NodeClaim"}
+
+// NodeClaimValidator rejects the NodeClaims which can not be launched by instance provider.
+type NodeClaimValidator struct {
+	kubeClient client.Client
+}
+
+var _ admission.CustomValidator = &NodeClaimValidator{}
+
+func NewNodeClaimValidator(kubeClient client.Client) *NodeClaimValidator {
+	return &NodeClaimValidator{kubeClient: kubeClient}
+}
+
+func (v *NodeClaimValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	nodeClaim, ok := obj.(*karpenterv1.NodeClaim)
+	if !ok {
+		return nil, fmt.Errorf("expected a NodeClaim but got %T", obj)
+	}
+	nodeClass, err := v.getNodeClass(ctx, nodeClaim)
+	if err != nil {
+		return nil, err
+	}
+	return nil, invalid(nodeClaimKind, nodeClaim.Name, instance.ValidateNodeClaim(nodeClaim, nodeClass))
+}
+
+// ValidateUpdate only checks the annotations when they are changed, because the spec of NodeClaim is immutable and
+// karpenter should still be able to update the NodeClaims created before the webhook is enabled.
+func (v *NodeClaimValidator) ValidateUpdate(_ context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldNodeClaim, ok := oldObj.(*karpenterv1.NodeClaim)
+	if !ok {
+		return nil, fmt.Errorf("expected a NodeClaim but got %T", oldObj)
+	}
+	nodeClaim, ok := newObj.(*karpenterv1.NodeClaim)
+	if !ok {
+		return nil, fmt.Errorf("expected a NodeClaim but got %T", newObj)
+	}
+	if lo.ElementsMatch(lo.Entries(oldNodeClaim.Annotations), lo.Entries(nodeClaim.Annotations)) {
+		return nil, nil
+	}
+	return nil, invalid(nodeClaimKind, nodeClaim.Name, instance.ValidateNodeClaimAnnotations(nodeClaim))
+}
+
+func (v *NodeClaimValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// getNodeClass returns the KaitoNodeClass referenced by nodeClaim, nil is returned when nodeClaim doesn't reference
+// a KaitoNodeClass or it's not created yet.
+func (v *NodeClaimValidator) getNodeClass(ctx context.Context, nodeClaim *karpenterv1.NodeClaim) (*v1alpha1.KaitoNodeClass, error) {
+	ref := nodeClaim.Spec.NodeClassRef
+	if ref == nil || ref.Name == "" || ref.Group != v1alpha1.Group || ref.Kind != "KaitoNodeClass" {
+		return nil, nil
+	}
+	nodeClass := &v1alpha1.KaitoNodeClass{}
+	if err := v.kubeClient.Get(ctx, client.ObjectKey{Name: ref.Name}, nodeClass); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getting kaitonodeclass %q, %w", ref.Name, err)
+	}
+	return nodeClass, nil
+}
+
+// invalid converts errs to an Invalid status error of the object, nil is returned when errs is empty.
+func invalid(kind schema.GroupKind, name string, errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(kind, name, errs)
+}
@@ -0,0 +1,105 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/apis/v1beta1"
+	"github.com/azure/gpu-provisioner/pkg/utils"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/conversion"
+	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+const (
+	// NodeClaimValidationPath and the KaitoNodeClass validation paths are the paths of validating webhooks, they
+	// should be the same as the ones in ValidatingWebhookConfiguration. Each served version of KaitoNodeClass has its
+	// own path.
+	NodeClaimValidationPath             = "/validate-karpenter-sh-v1-nodeclaim"
+	KaitoNodeClassValidationPath        = "/validate-kaito-sh-v1alpha1-kaitonodeclass"
+	KaitoNodeClassV1Beta1ValidationPath = "/validate-kaito-sh-v1beta1-kaitonodeclass"
+	// ConversionPath is the path of the conversion webhook of KaitoNodeClass, it should be the same as the one in
+	// spec.conversion.webhook of the KaitoNodeClass CRD.
+	ConversionPath = "/convert"
+
+	defaultWebhookPort    = 9443
+	defaultWebhookCertDir = "/tmp/k8s-webhook-server/serving-certs"
+)
+
+// Options configure the webhook server served from the controller.
+type Options struct {
+	// Disabled turns off the webhook server, it's read from DISABLE_WEBHOOK.
+	Disabled bool
+	// Port is the port which the webhook server listens on, it's read from WEBHOOK_PORT.
+	Port int
+	// CertDir is the directory containing tls.crt and tls.key of the webhook server, it's read from WEBHOOK_CERT_DIR.
+	CertDir string
+}
+
+// NewOptionsFromEnv reads Options from environment variables, the webhook server is disabled by default because it
+// requires a serving certificate.
+func NewOptionsFromEnv() (Options, error) {
+	opts := Options{
+		Disabled: utils.WithDefaultBool("DISABLE_WEBHOOK", true),
+		Port:     defaultWebhookPort,
+		CertDir:  defaultWebhookCertDir,
+	}
+	if port, ok := os.LookupEnv("WEBHOOK_PORT"); ok && port != "" {
+		parsed, err := strconv.Atoi(port)
+		if err != nil || parsed <= 0 || parsed > 65535 {
+			return Options{}, fmt.Errorf("WEBHOOK_PORT(%s) should be a valid port number", port)
+		}
+		opts.Port = parsed
+	}
+	if certDir, ok := os.LookupEnv("WEBHOOK_CERT_DIR"); ok && certDir != "" {
+		opts.CertDir = certDir
+	}
+	return opts, nil
+}
+
+// Setup starts the webhook server with the manager and registers the webhooks of NodeClaim and KaitoNodeClass,
+// nothing is done when the webhook server is disabled.
+func Setup(mgr manager.Manager, opts Options) error {
+	if opts.Disabled {
+		return nil
+	}
+	server := webhook.NewServer(webhook.Options{
+		Port:    opts.Port,
+		CertDir: opts.CertDir,
+	})
+	if err := mgr.Add(server); err != nil {
+		return fmt.Errorf("adding webhook server to manager, %w", err)
+	}
+	Register(server, mgr.GetScheme(), mgr.GetClient())
+	return nil
+}
+
+// Register registers the validating webhooks and the KaitoNodeClass conversion webhook to server. The conversion
+// webhook and the validating webhook of v1beta1 decode the objects with scheme, so v1beta1 should be added to scheme.
+func Register(server webhook.Server, scheme *runtime.Scheme, kubeClient client.Client) {
+	server.Register(NodeClaimValidationPath, admission.WithCustomValidator(scheme, &karpenterv1.NodeClaim{}, NewNodeClaimValidator(kubeClient)))
+	server.Register(KaitoNodeClassValidationPath, admission.WithCustomValidator(scheme, &v1alpha1.KaitoNodeClass{}, NewKaitoNodeClassValidator()))
+	server.Register(KaitoNodeClassV1Beta1ValidationPath, admission.WithCustomValidator(scheme, &v1beta1.KaitoNodeClass{}, NewKaitoNodeClassValidator()))
+	server.Register(ConversionPath, conversion.NewWebhookHandler(scheme))
+}
@@ -0,0 +1,161 @@
+/*
+       Copyright (c) Microsoft Corporation.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/azure/gpu-provisioner/pkg/apis/v1alpha1"
+	"github.com/azure/gpu-provisioner/pkg/apis/v1beta1"
+	"github.com/azure/gpu-provisioner/pkg/providers/instance"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	karpenterv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+func newNodeClaim(name string, storage string, annotations map[string]string, nodeClassRef *karpenterv1.NodeClassReference) *karpenterv1.NodeClaim {
+	nodeClaim := &karpenterv1.NodeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations},
+		Spec: karpenterv1.NodeClaimSpec{
+			Requirements: []karpenterv1.NodeSelectorRequirementWithMinValues{
+				{NodeSelectorRequirement: v1.NodeSelectorRequirement{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"Standard_NC6s_v3"}}},
+			},
+			NodeClassRef: nodeClassRef,
+		},
+	}
+	if nodeClaim.Spec.NodeClassRef == nil {
+		nodeClaim.Spec.NodeClassRef = &karpenterv1.NodeClassReference{Group: "karpenter.azure.com", Kind: "AKSNodeClass", Name: "default"}
+	}
+	if storage != "" {
+		nodeClaim.Spec.Resources.Requests = v1.ResourceList{v1.ResourceStorage: resource.MustParse(storage)}
+	}
+	return nodeClaim
+}
+
+func TestNodeClaimValidatorValidateCreate(t *testing.T) {
+	kaitoNodeClassRef := &karpenterv1.NodeClassReference{Group: v1alpha1.Group, Kind: "KaitoNodeClass", Name: "default"}
+	testCases := []struct {
+		name            string
+		nodeClaim       *karpenterv1.NodeClaim
+		nodeClass       *v1alpha1.KaitoNodeClass
+		expectedMessage string
+	}{
+		{
+			name:      "valid nodeclaim is allowed",
+			nodeClaim: newNodeClaim("kaito-workspace-0", "30Gi", nil, nil),
+		},
+		{
+			name:            "nodeclaim with unknown image family is rejected",
+			nodeClaim:       newNodeClaim("kaito-workspace-0", "30Gi", map[string]string{instance.LabelNodeImageFamily: "Windows"}, nil),
+			expectedMessage: `metadata.annotations[kaito.sh/node-image-family]: Unsupported value: "Windows"`,
+		},
+		{
+			name:            "nodeclaim without storage request is rejected",
+			nodeClaim:       newNodeClaim("kaito-workspace-0", "", nil, nil),
+			expectedMessage: "spec.resources.requests.storage",
+		},
+		{
+			name:      "nodeclaim without storage request is allowed when the referenced nodeclass specifies os disk size",
+			nodeClaim: newNodeClaim("kaito-workspace-0", "", nil, kaitoNodeClassRef),
+			nodeClass: &v1alpha1.KaitoNodeClass{ObjectMeta: metav1.ObjectMeta{Name: "default"}, Spec: v1alpha1.KaitoNodeClassSpec{OSDiskSizeGB: lo.ToPtr(int32(128))}},
+		},
+		{
+			name:            "nodeclaim without storage request is rejected when the referenced nodeclass is not found",
+			nodeClaim:       newNodeClaim("kaito-workspace-0", "", nil, kaitoNodeClassRef),
+			expectedMessage: "spec.resources.requests.storage",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			assert.NoError(t, v1alpha1.SchemeBuilder.AddToScheme(scheme))
+			builder := fake.NewClientBuilder().WithScheme(scheme)
+			if tc.nodeClass != nil {
+				builder = builder.WithObjects(tc.nodeClass)
+			}
+
+			_, err := NewNodeClaimValidator(builder.Build()).ValidateCreate(context.Background(), tc.nodeClaim)
+			if tc.expectedMessage == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.True(t, apierrors.IsInvalid(err), "expected an Invalid error, got %v", err)
+			assert.ErrorContains(t, err, tc.expectedMessage)
+		})
+	}
+}
+
+func TestNodeClaimValidatorValidateUpdate(t *testing.T) {
+	validator := NewNodeClaimValidator(fake.NewClientBuilder().Build())
+	invalid := newNodeClaim("kaito-workspace-0", "", map[string]string{instance.LabelNodeImageFamily: "Windows"}, nil)
+
+	// karpenter is able to update the nodeclaim created before the webhook is enabled.
+	updated := invalid.DeepCopy()
+	updated.Labels = map[string]string{"kaito.sh/workspace": "ws"}
+	_, err := validator.ValidateUpdate(context.Background(), invalid, updated)
+	assert.NoError(t, err)
+
+	updated = invalid.DeepCopy()
+	updated.Annotations[instance.AnnotationSpotMaxPrice] = "free"
+	_, err = validator.ValidateUpdate(context.Background(), invalid, updated)
+	assert.ErrorContains(t, err, "spot max price(free)")
+
+	_, err = validator.ValidateDelete(context.Background(), invalid)
+	assert.NoError(t, err)
+}
+
+func TestKaitoNodeClassValidator(t *testing.T) {
+	validator := NewKaitoNodeClassValidator()
+	valid := &v1alpha1.KaitoNodeClass{ObjectMeta: metav1.ObjectMeta{Name: "default"}, Spec: v1alpha1.KaitoNodeClassSpec{OSSKU: "AzureLinux"}}
+	invalid := &v1alpha1.KaitoNodeClass{ObjectMeta: metav1.ObjectMeta{Name: "default"}, Spec: v1alpha1.KaitoNodeClassSpec{MaxPods: lo.ToPtr(int32(1))}}
+
+	_, err := validator.ValidateCreate(context.Background(), valid)
+	assert.NoError(t, err)
+	_, err = validator.ValidateCreate(context.Background(), invalid)
+	assert.True(t, apierrors.IsInvalid(err))
+	assert.ErrorContains(t, err, `KaitoNodeClass.kaito.sh "default" is invalid: spec.maxPods`)
+	_, err = validator.ValidateUpdate(context.Background(), valid, invalid)
+	assert.ErrorContains(t, err, "spec.maxPods")
+	_, err = validator.ValidateCreate(context.Background(), &v1beta1.KaitoNodeClass{ObjectMeta: metav1.ObjectMeta{Name: "default"}, Spec: v1beta1.KaitoNodeClassSpec{MaxPods: lo.ToPtr(int32(1))}})
+	assert.ErrorContains(t, err, "spec.maxPods")
+	_, err = validator.ValidateCreate(context.Background(), &karpenterv1.NodeClaim{})
+	assert.ErrorContains(t, err, "expected a KaitoNodeClass")
+}
+
+func TestNewOptionsFromEnv(t *testing.T) {
+	opts, err := NewOptionsFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, Options{Disabled: true, Port: defaultWebhookPort, CertDir: defaultWebhookCertDir}, opts)
+
+	t.Setenv("DISABLE_WEBHOOK", "false")
+	t.Setenv("WEBHOOK_PORT", "8443")
+	t.Setenv("WEBHOOK_CERT_DIR", "/etc/webhook/certs")
+	opts, err = NewOptionsFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, Options{Disabled: false, Port: 8443, CertDir: "/etc/webhook/certs"}, opts)
+
+	t.Setenv("WEBHOOK_PORT", "port")
+	_, err = NewOptionsFromEnv()
+	assert.Error(t, err)
+}